@@ -16,19 +16,31 @@ package config
 
 import (
 	"flag"
-	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/utility"
 	"github.com/scionproto/scion/go/lib/addr"
 	"github.com/sec51/goconf"
 )
 
+// OIDCProvider is an OpenID Connect provider users can log in with
+type OIDCProvider struct {
+	ID           string // used in the redirect URI and to link identities
+	Name         string // shown on the login page
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // requested in addition to "openid"
+}
+
+// OIDCProviders are listed in oidc.providers and configured in a section [oidc_<ID>] each
+var OIDCProviders []OIDCProvider
+
 // Settings are specified in conf/development.conf
 var (
 	// address the service listens on
@@ -44,11 +56,11 @@ var (
 	EmailAdmins            = goconf.AppConf.Strings("email.admin_emails")
 	CaptchaSecretKey       = goconf.AppConf.String("captcha.secret_key")
 	CaptchaSiteKey         = goconf.AppConf.String("captcha.site_key")
-	SessionPath            = goconf.AppConf.String("session.path")
+	SessionLifetime        = goconf.AppConf.DefaultInt("session.lifetime_days", 30)
 	SessionEncryptionKey   = goconf.AppConf.String("session.encryption_key")
 	SessionVerificationKey = goconf.AppConf.String("session.verification_key")
 	LogFile                = goconf.AppConf.String("log.file")
-	LogDebugMode, _        = goconf.AppConf.Bool("log.debug_mode")
+	LogLevel               = logLevel()
 	PackageDirectory       = goconf.AppConf.DefaultString("directory.package_directory",
 		filepath.Join(os.Getenv("HOME"), "scionLabConfigs"))
 	ISDLocationMapping           = goconf.AppConf.String("directory.isd_location_map")
@@ -65,9 +77,11 @@ var (
 	ASesPerUser, _               = goconf.AppConf.Int("ases_per_user")
 	ASesPerAdmin, _              = goconf.AppConf.Int("ases_per_admin")
 	SigningASes                  = make(map[addr.ISD]addr.AS) // map[ISD]=signing_as
-	MTU, _                       = goconf.AppConf.Int("mtu")
+	MTU                          = goconf.AppConf.DefaultInt("mtu", 1472)
+	Bandwidth                    = goconf.AppConf.DefaultInt("bandwidth", 1000)
 	BRStartPort                  uint16
 	BRInternalStartPort          uint16
+	ServiceStartPort             uint16
 
 	// Virtual Credit system
 	VirtualCreditEnable, _       = goconf.AppConf.Bool("virtualCredit.enable")
@@ -80,6 +94,51 @@ var (
 	HeartbeatPeriod, _ = goconf.AppConf.Int("heartbeat.period")
 	HeartbeatLimit, _  = goconf.AppConf.Int("heartbeat.limit")
 
+	// AS certificate renewal: check period in hours and how many days before expiry to renew
+	CertRenewalPeriod = goconf.AppConf.DefaultInt("cert_renewal.period", 24)
+	CertRenewalMargin = goconf.AppConf.DefaultInt("cert_renewal.margin", 30)
+
+	// Image used by the docker-compose packages of user ASes
+	DockerImage = goconf.AppConf.DefaultString("package.docker_image", "scionlab/scion:latest")
+	// Number of configuration versions kept per user AS, which can be inspected and rolled back to
+	PackageVersions = goconf.AppConf.DefaultInt("package.versions", 5)
+
+	// Storage of the packages, certificate caches and VPN keys: "local" keeps them below
+	// PackageDirectory, "s3" in a bucket of an S3-compatible object store, which can be shared
+	// by several coordinator instances
+	StorageType        = goconf.AppConf.DefaultString("storage.type", "local")
+	StorageS3Endpoint  = goconf.AppConf.String("storage.s3_endpoint")
+	StorageS3Region    = goconf.AppConf.DefaultString("storage.s3_region", "us-east-1")
+	StorageS3Bucket    = goconf.AppConf.String("storage.s3_bucket")
+	StorageS3AccessKey = goconf.AppConf.String("storage.s3_access_key")
+	StorageS3SecretKey = goconf.AppConf.String("storage.s3_secret_key")
+
+	// VPN client certificates: validity in days and size of the RSA keys
+	VPNCertValidity = goconf.AppConf.DefaultInt("vpn.cert_validity", 730)
+	VPNKeySize      = goconf.AppConf.DefaultInt("vpn.key_size", 4096)
+	// Validity in days of the CRL served to the APs, which have to fetch it again before it expires
+	VPNCRLValidity = goconf.AppConf.DefaultInt("vpn.crl_validity", 7)
+	// Hours a released VPN address is held for its AS before it is given to another AS
+	VPNAddressHold = goconf.AppConf.DefaultInt("vpn.address_hold", 24)
+
+	// Hours the previous account secret stays valid after a rotation
+	AccountSecretOverlap = goconf.AppConf.DefaultInt("account.secret_overlap", 72)
+
+	// Failed logins of a user before an exponential back-off starts, failed logins after which
+	// the user is locked out, and the duration of the lockout in minutes
+	LoginFreeAttempts    = goconf.AppConf.DefaultInt("login.free_attempts", 3)
+	LoginLockoutAttempts = goconf.AppConf.DefaultInt("login.lockout_attempts", 10)
+	LoginLockoutMinutes  = goconf.AppConf.DefaultInt("login.lockout_minutes", 30)
+	// The same for the failed logins from a source address, which may be shared by many users
+	LoginIPFreeAttempts    = goconf.AppConf.DefaultInt("login.ip_free_attempts", 10)
+	LoginIPLockoutAttempts = goconf.AppConf.DefaultInt("login.ip_lockout_attempts", 50)
+
+	// Whether admins only obtain their privileges after enabling two-factor authentication
+	TwoFactorRequiredForAdmins = goconf.AppConf.DefaultBool("two_factor.require_admins", true)
+
+	// Longest validity in days of personal access tokens
+	AccessTokenMaxValidity = goconf.AppConf.DefaultInt("access_token.max_validity", 365)
+
 	GrafanaURL   = goconf.AppConf.String("grafana.url")
 	TutorialsURL = goconf.AppConf.String("tutorials.url")
 
@@ -92,25 +151,49 @@ var (
 	TestingCoordinatorBranch = goconf.AppConf.String("testing_coordinator.branch")
 )
 
+// logLevel returns the level set in log.level, or debug if the deprecated log.debug_mode is set
+func logLevel() logger.Level {
+	name := goconf.AppConf.String("log.level")
+	if name == "" {
+		if debug, _ := goconf.AppConf.Bool("log.debug_mode"); debug {
+			return logger.LevelDebug
+		}
+		return logger.LevelInfo
+	}
+	level, err := logger.ParseLevel(name)
+	if err != nil {
+		logger.Fatalf("Error reading log.level: %v", err)
+	}
+	return level
+}
+
 func init() {
+	logger.SetLevel(LogLevel)
+	if LogFile != "" {
+		logFile, err := os.OpenFile(LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			logger.Fatalf("Error opening the log file %v: %v", LogFile, err)
+		}
+		logger.SetOutput(logFile)
+	}
+
 	sp := goconf.AppConf.DefaultInt("br_bind_start_port", 50000)
 	BRStartPort = uint16(sp) // Ports are only 16 bits
 	sp = goconf.AppConf.DefaultInt("br_internal_start_port", 31046)
 	BRInternalStartPort = uint16(sp) // Ports are only 16 bits
+	sp = goconf.AppConf.DefaultInt("service_start_port", 31041)
+	ServiceStartPort = uint16(sp) // Ports are only 16 bits
 	signingMap, err := goconf.AppConf.GetSection("signing_ases")
 	if err != nil {
-		fmt.Println("Error reading configuration for signing_ases:", err)
-		os.Exit(1)
+		logger.Fatalf("Error reading configuration for signing_ases: %v", err)
 	}
 	for k, v := range signingMap {
 		ki, err := strconv.Atoi(k)
 		if err != nil {
-			fmt.Println("Error parsing section signing_ases:", err)
-			os.Exit(1)
+			logger.Fatalf("Error parsing section signing_ases: %v", err)
 		}
 		if ki < 1 || ki > addr.MaxISD {
-			fmt.Println("Invalid value for ISD: ", k)
-			os.Exit(1)
+			logger.Fatalf("Invalid value for ISD: %v", k)
 		}
 
 		var asID addr.AS
@@ -118,26 +201,55 @@ func init() {
 		if err != nil {
 			asID, err = addr.ASFromString(v)
 			if err != nil {
-				fmt.Println("Error parsing section signing_ases:", err)
-				os.Exit(1)
+				logger.Fatalf("Error parsing section signing_ases: %v", err)
 			}
 		} else {
 			asID = addr.AS(vi)
 		}
 		SigningASes[addr.ISD(ki)] = asID
 	}
+	for _, id := range goconf.AppConf.Strings("oidc.providers") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		section, err := goconf.AppConf.GetSection("oidc_" + id)
+		if err != nil {
+			logger.Fatalf("Error reading configuration of OpenID Connect provider %v: %v", id, err)
+		}
+		p := OIDCProvider{
+			ID:           id,
+			Name:         section["name"],
+			Issuer:       section["issuer"],
+			ClientID:     section["client_id"],
+			ClientSecret: section["client_secret"],
+			Scopes:       strings.Fields(section["scopes"]),
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			logger.Fatalf("OpenID Connect provider %v needs an issuer and a client_id", id)
+		}
+		if p.Name == "" {
+			p.Name = id
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"email", "profile"}
+		}
+		OIDCProviders = append(OIDCProviders, p)
+	}
+
 	auxInt, err := goconf.AppConf.Int64("base_as_id")
 	if err != nil {
 		auxString := goconf.AppConf.String("base_as_id")
 		BaseASID, err = addr.ASFromString(auxString)
 		if err != nil {
 			BaseASID = addr.AS(utility.ScionlabUserASOffsetAddr)
-			log.Printf("Config: not a valid AS id: '%v'. Using %v as base instead.", auxString, BaseASID.String())
+			logger.Warnf("Config: not a valid AS id: '%v'. Using %v as base instead.", auxString,
+				BaseASID.String())
 		}
 	} else {
 		BaseASID = addr.AS(auxInt)
 	}
-	fmt.Println("Base AS ID: ", BaseASID.String())
+	logger.Infof("Base AS ID: %v", BaseASID.String())
 
 	// we don't validate the email addresses, we just trim them in case they had leading/trailing spaces
 	for i, admin := range EmailAdmins {
@@ -151,8 +263,7 @@ func init() {
 		spath = filepath.Dir(spath)
 		err = os.Chdir(spath)
 		if err != nil {
-			fmt.Printf("Error in test chdir to %v: %v", spath, err)
-			os.Exit(1)
+			logger.Fatalf("Error in test chdir to %v: %v", spath, err)
 		}
 	}
 }
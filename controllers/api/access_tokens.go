@@ -0,0 +1,180 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+)
+
+const maxAccessTokenNameLength = 64
+
+// accessTokenInfo describes a personal access token on the account page; the token itself is
+// only set right after its creation
+type accessTokenInfo struct {
+	ID       uint64
+	Name     string
+	Prefix   string
+	Scopes   []string
+	Expires  time.Time
+	LastUsed *time.Time `json:",omitempty"`
+	Created  time.Time
+	Token    string `json:",omitempty"`
+}
+
+func newAccessTokenInfo(t *models.AccessToken) accessTokenInfo {
+	info := accessTokenInfo{
+		ID:      t.ID,
+		Name:    t.Name,
+		Prefix:  t.Prefix,
+		Scopes:  t.ScopeList(),
+		Expires: t.Expires,
+		Created: t.Created,
+	}
+	if !t.LastUsed.IsZero() {
+		lastUsed := t.LastUsed
+		info.LastUsed = &lastUsed
+	}
+	return info
+}
+
+type accessTokenRequest struct {
+	Name         string
+	Scopes       []string
+	ValidityDays int
+}
+
+// validate checks the request, removing duplicate scopes
+func (req *accessTokenRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAccessTokenNameLength {
+		return fmt.Errorf("the name must have between 1 and %v characters",
+			maxAccessTokenNameLength)
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("the token needs at least one scope")
+	}
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, s := range req.Scopes {
+		if !models.ValidAccessTokenScope(s) {
+			return fmt.Errorf("unknown scope %q", s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	req.Scopes = scopes
+	if req.ValidityDays < 1 || req.ValidityDays > config.AccessTokenMaxValidity {
+		return fmt.Errorf("the validity must be between 1 and %v days",
+			config.AccessTokenMaxValidity)
+	}
+	return nil
+}
+
+// AccessTokens lists the personal access tokens of the logged-in user
+func (c *UserController) AccessTokens(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	tokens, err := models.FindAccessTokensByUserEmail(userSession.Email)
+	if err != nil {
+		log.Errorf("Error looking up the access tokens of %v: %v", userSession.Email, err)
+		c.Error500(w, err, "Error looking up the access tokens")
+		return
+	}
+	infos := []accessTokenInfo{}
+	for i := range tokens {
+		infos = append(infos, newAccessTokenInfo(&tokens[i]))
+	}
+	c.JSON(map[string]interface{}{"tokens": infos, "scopes": models.AccessTokenScopes,
+		"maxValidityDays": config.AccessTokenMaxValidity}, w, r)
+}
+
+// CreateAccessToken creates a personal access token for the logged-in user. The token is only
+// returned in the response to this request.
+func (c *UserController) CreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	var req accessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.BadRequest(w, err, err.Error())
+		return
+	}
+	t, token, err := models.NewAccessToken(userSession.Email, req.Name, req.Scopes,
+		time.Duration(req.ValidityDays)*24*time.Hour)
+	if err != nil {
+		log.Errorf("Error creating an access token for %v: %v", userSession.Email, err)
+		c.Error500(w, err, "Error creating the access token")
+		return
+	}
+	log.Infof("Created access token %v (%v) for %v", t.ID, t.Prefix, userSession.Email)
+	info := newAccessTokenInfo(t)
+	info.Token = token
+	c.JSON(info, w, r)
+}
+
+// RevokeAccessToken deletes a personal access token of the logged-in user
+func (c *UserController) RevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		c.BadRequest(w, err, "Invalid token ID")
+		return
+	}
+	t, err := models.FindAccessTokenByIDAndUserEmail(id, userSession.Email)
+	if err != nil {
+		c.NotFound(w, err, "Access token not found")
+		return
+	}
+	if err = t.Delete(); err != nil {
+		log.Errorf("Error revoking access token %v of %v: %v", id, userSession.Email, err)
+		c.Error500(w, err, "Error revoking the access token")
+		return
+	}
+	log.Infof("Revoked access token %v (%v) of %v", t.ID, t.Prefix, userSession.Email)
+	c.JSON(struct{}{}, w, r)
+}
@@ -0,0 +1,415 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/email"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+)
+
+type memberInfo struct {
+	Email      string
+	Role       string
+	Registered bool // registered with the account, always an owner
+}
+
+type accountInvitationInfo struct {
+	ID        uint64
+	Account   string
+	Email     string
+	Role      string
+	InvitedBy string
+	Created   time.Time
+}
+
+// membershipInfo describes an account the user is a member of. The members and invitations are
+// only listed to owners.
+type membershipInfo struct {
+	ID          uint64
+	Name        string
+	Role        string
+	Registered  bool // the user is registered with the account
+	Members     []memberInfo
+	Invitations []accountInvitationInfo
+}
+
+type accountsData struct {
+	Email       string // of the logged-in user
+	Accounts    []membershipInfo
+	Invitations []accountInvitationInfo // pending invitations of the user
+}
+
+type memberRequest struct {
+	Email string
+	Role  string
+}
+
+// accountMemberInvitationMailData fills the template account_member_invitation.html
+type accountMemberInvitationMailData struct {
+	FirstName   string
+	LastName    string
+	HostAddress string
+	InvitedBy   string
+	Account     string
+	Role        string
+}
+
+func newAccountInvitationInfo(i *models.AccountInvitation) accountInvitationInfo {
+	return accountInvitationInfo{
+		ID:        i.ID,
+		Account:   i.Account.Name,
+		Email:     i.UserEmail(),
+		Role:      i.Role,
+		InvitedBy: i.InvitedBy,
+		Created:   i.Created,
+	}
+}
+
+// newMembershipInfo describes the account; the members and invitations are only listed if
+// role is owner
+func newMembershipInfo(a *models.Account, role string, registered bool) (membershipInfo,
+	error) {
+	info := membershipInfo{
+		ID:          a.ID,
+		Name:        a.Name,
+		Role:        role,
+		Registered:  registered,
+		Members:     []memberInfo{},
+		Invitations: []accountInvitationInfo{},
+	}
+	if role != models.MemberOwner {
+		return info, nil
+	}
+	emails, err := a.UserEmails()
+	if err != nil {
+		return info, err
+	}
+	for _, e := range emails {
+		info.Members = append(info.Members, memberInfo{
+			Email:      e,
+			Role:       models.MemberOwner,
+			Registered: true,
+		})
+	}
+	members, err := models.FindAccountMembers(a)
+	if err != nil {
+		return info, err
+	}
+	for _, m := range members {
+		info.Members = append(info.Members, memberInfo{Email: m.UserEmail(), Role: m.Role})
+	}
+	invitations, err := models.FindAccountInvitationsByAccount(a)
+	if err != nil {
+		return info, err
+	}
+	for i := range invitations {
+		info.Invitations = append(info.Invitations, newAccountInvitationInfo(&invitations[i]))
+	}
+	return info, nil
+}
+
+// Accounts lists the accounts the logged-in user is a member of, with their members if the user
+// owns them, and the pending invitations of the user
+func (c *UserController) Accounts(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	data, err := userAccountsData(userSession.Email)
+	if err != nil {
+		log.Errorf("Error looking up the accounts of %v: %v", userSession.Email, err)
+		c.Error500(w, err, "Error looking up the accounts")
+		return
+	}
+	c.JSON(data, w, r)
+}
+
+func userAccountsData(userEmail string) (accountsData, error) {
+	data := accountsData{
+		Email:       userEmail,
+		Accounts:    []membershipInfo{},
+		Invitations: []accountInvitationInfo{},
+	}
+	u, err := models.FindUserByEmail(userEmail)
+	if err != nil {
+		return data, err
+	}
+	home, err := newMembershipInfo(u.Account, models.MemberOwner, true)
+	if err != nil {
+		return data, err
+	}
+	data.Accounts = append(data.Accounts, home)
+	memberships, err := models.FindAccountMembershipsByUserEmail(userEmail)
+	if err != nil {
+		return data, err
+	}
+	for _, m := range memberships {
+		info, err := newMembershipInfo(m.Account, m.Role, false)
+		if err != nil {
+			return data, err
+		}
+		data.Accounts = append(data.Accounts, info)
+	}
+	invitations, err := models.FindAccountInvitationsByUserEmail(userEmail)
+	if err != nil {
+		return data, err
+	}
+	for i := range invitations {
+		data.Invitations = append(data.Invitations, newAccountInvitationInfo(&invitations[i]))
+	}
+	return data, nil
+}
+
+// memberAccount returns the account in the URL, the email address of the logged-in user and
+// their role in the account. If the user is not a member, it responds with an error and returns
+// nil.
+func (c *UserController) memberAccount(w http.ResponseWriter, r *http.Request) (*models.Account,
+	string, string) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return nil, "", ""
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["account"], 10, 64)
+	if err != nil {
+		c.BadRequest(w, err, "Invalid account ID")
+		return nil, "", ""
+	}
+	a, err := models.FindAccountByID(id)
+	if err != nil {
+		c.NotFound(w, err, "Account not found")
+		return nil, "", ""
+	}
+	u, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		c.Error500(w, err, "Error looking up the user")
+		return nil, "", ""
+	}
+	role, err := u.MemberRole(a)
+	if err != nil {
+		log.Errorf("Error looking up the role of %v in account %v: %v", userSession.Email,
+			a.Name, err)
+		c.Error500(w, err, "Error looking up your role in the account")
+		return nil, "", ""
+	}
+	if role == "" {
+		c.NotFound(w, nil, "Account not found")
+		return nil, "", ""
+	}
+	return a, userSession.Email, role
+}
+
+// InviteMember invites an existing user into the account in the URL
+func (c *UserController) InviteMember(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	a, userEmail, role := c.memberAccount(w, r)
+	if a == nil {
+		return
+	}
+	if role != models.MemberOwner {
+		c.Forbidden(w, nil, "Only owners can manage the members of the account")
+		return
+	}
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	if !models.ValidMemberRole(req.Role) {
+		c.BadRequest(w, nil, "Invalid role %v", req.Role)
+		return
+	}
+	i, err := models.InviteAccountMember(a, req.Email, req.Role, userEmail)
+	switch err {
+	case nil:
+	case orm.ErrNoRows:
+		c.NotFound(w, err, "There is no user with the email address %v", req.Email)
+		return
+	case models.ErrAlreadyMember:
+		c.BadRequest(w, err, err.Error())
+		return
+	default:
+		log.Errorf("Error inviting %v into account %v: %v", req.Email, a.Name, err)
+		c.Error500(w, err, "Error inviting the user")
+		return
+	}
+	log.Infof("%v invited %v into account %v as %v", userEmail, req.Email, a.Name, req.Role)
+	data := accountMemberInvitationMailData{
+		FirstName:   i.User.FirstName,
+		LastName:    i.User.LastName,
+		HostAddress: config.HTTPHostAddress,
+		InvitedBy:   userEmail,
+		Account:     a.Name,
+		Role:        req.Role,
+	}
+	if err := email.ConstructFromTemplateAndSend(r.Context(), "account_member_invitation.html",
+		"[SCIONLab] Invitation to join the account "+a.Name, data, "account-invitation",
+		req.Email, false); err != nil {
+		log.Errorf("Error sending the invitation into account %v to %v: %v", a.Name,
+			req.Email, err)
+	}
+	c.JSON(newAccountInvitationInfo(i), w, r)
+}
+
+// SetMemberRole changes the role of a member of the account in the URL
+func (c *UserController) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	a, userEmail, role := c.memberAccount(w, r)
+	if a == nil {
+		return
+	}
+	if role != models.MemberOwner {
+		c.Forbidden(w, nil, "Only owners can manage the members of the account")
+		return
+	}
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	if !models.ValidMemberRole(req.Role) {
+		c.BadRequest(w, nil, "Invalid role %v", req.Role)
+		return
+	}
+	memberEmail := mux.Vars(r)["email"]
+	m, err := models.FindAccountMember(a, memberEmail)
+	if err != nil {
+		c.NotFound(w, err, "%v is not an invited member of the account", memberEmail)
+		return
+	}
+	if err := m.SetRole(req.Role); err != nil {
+		log.Errorf("Error changing the role of %v in account %v: %v", memberEmail, a.Name, err)
+		c.Error500(w, err, "Error changing the role")
+		return
+	}
+	log.Infof("%v changed the role of %v in account %v to %v", userEmail, memberEmail, a.Name,
+		req.Role)
+	c.JSON(memberInfo{Email: memberEmail, Role: req.Role}, w, r)
+}
+
+// RemoveMember removes a member from the account in the URL. Owners remove any invited member,
+// other members only themselves.
+func (c *UserController) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	a, userEmail, role := c.memberAccount(w, r)
+	if a == nil {
+		return
+	}
+	memberEmail := mux.Vars(r)["email"]
+	if memberEmail != userEmail && role != models.MemberOwner {
+		c.Forbidden(w, nil, "Only owners can remove other members")
+		return
+	}
+	m, err := models.FindAccountMember(a, memberEmail)
+	if err != nil {
+		c.NotFound(w, err, "%v is not an invited member of the account", memberEmail)
+		return
+	}
+	if err := m.Delete(); err != nil {
+		log.Errorf("Error removing %v from account %v: %v", memberEmail, a.Name, err)
+		c.Error500(w, err, "Error removing the member")
+		return
+	}
+	log.Infof("%v removed %v from account %v", userEmail, memberEmail, a.Name)
+	c.JSON(struct{}{}, w, r)
+}
+
+// invitation returns the invitation in the URL if the logged-in user is the invited user or, if
+// owners is set, an owner of the account. Otherwise it responds with an error and returns nil.
+func (c *UserController) invitation(w http.ResponseWriter, r *http.Request,
+	owners bool) (*models.AccountInvitation, string) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return nil, ""
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		c.BadRequest(w, err, "Invalid invitation ID")
+		return nil, ""
+	}
+	i, err := models.FindAccountInvitationByID(id)
+	if err != nil {
+		c.NotFound(w, err, "Invitation not found")
+		return nil, ""
+	}
+	if i.UserEmail() == userSession.Email {
+		return i, userSession.Email
+	}
+	if owners {
+		u, err := models.FindUserByEmail(userSession.Email)
+		if err != nil {
+			c.Error500(w, err, "Error looking up the user")
+			return nil, ""
+		}
+		role, err := u.MemberRole(i.Account)
+		if err == nil && role == models.MemberOwner {
+			return i, userSession.Email
+		}
+	}
+	c.NotFound(w, nil, "Invitation not found")
+	return nil, ""
+}
+
+// AcceptInvitation makes the logged-in user a member of the account they were invited into
+func (c *UserController) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	i, userEmail := c.invitation(w, r, false)
+	if i == nil {
+		return
+	}
+	if _, err := i.Accept(); err != nil {
+		log.Errorf("Error accepting invitation %v of %v: %v", i.ID, userEmail, err)
+		c.Error500(w, err, "Error accepting the invitation")
+		return
+	}
+	log.Infof("%v joined account %v as %v", userEmail, i.Account.Name, i.Role)
+	c.JSON(struct{}{}, w, r)
+}
+
+// DeleteInvitation declines an invitation of the logged-in user, or cancels an invitation into
+// an account owned by the user
+func (c *UserController) DeleteInvitation(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	i, userEmail := c.invitation(w, r, true)
+	if i == nil {
+		return
+	}
+	if err := i.Delete(); err != nil {
+		log.Errorf("Error deleting invitation %v: %v", i.ID, err)
+		c.Error500(w, err, "Error deleting the invitation")
+		return
+	}
+	log.Infof("%v deleted the invitation of %v into account %v", userEmail, i.UserEmail(),
+		i.Account.Name)
+	c.JSON(struct{}{}, w, r)
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+)
+
+// secretRotationResult is returned by the end-points rotating an account secret
+type secretRotationResult struct {
+	Account        accountData
+	PreviousExpiry time.Time // until when the previous secret is still accepted
+	UpdatedASes    []string  // ASes whose configuration carries the new secret
+}
+
+// rotateAccountSecret replaces the secret of the account and increases the configuration version
+// of its ASes with a configuration, so that they obtain the new secret through GetASData while
+// the previous one is still valid. The secret is rotated even if some configurations cannot be
+// regenerated; those ASes are reported in the error.
+func rotateAccountSecret(ctx context.Context, a *models.Account) (*secretRotationResult, error) {
+	log := logger.FromContext(ctx)
+	overlap := time.Duration(config.AccountSecretOverlap) * time.Hour
+	if err := a.RotateSecret(overlap); err != nil {
+		return nil, err
+	}
+	log.Infof("Rotated the secret of account %v", a.AccountID)
+	res := &secretRotationResult{
+		Account:        accountData{AccountID: a.AccountID, AccountSecret: a.Secret},
+		PreviousExpiry: a.PreviousSecretExpires,
+		UpdatedASes:    []string{},
+	}
+	ases, err := models.FindSCIONLabASesByAccount(a)
+	if err != nil {
+		return res, fmt.Errorf("error looking up the ASes of account %v: %v", a.AccountID, err)
+	}
+	var failed []string
+	for i := range ases {
+		as := &ases[i]
+		// infrastructure ASes are configured manually; inactive and removed ASes have no
+		// configuration
+		if as.Type == models.Infrastructure || as.Status == models.Inactive ||
+			as.Status == models.Remove || as.Status == models.Removed {
+			continue
+		}
+		as.ConfVersion++
+		if err := computeNewGenFolder(ctx, as); err != nil {
+			log.Errorf("Error regenerating the configuration of AS %v: %v", as.IAString(), err)
+			failed = append(failed, as.IAString())
+			continue
+		}
+		if err := as.Update(); err != nil {
+			log.Errorf("Error updating AS %v: %v", as.IAString(), err)
+			failed = append(failed, as.IAString())
+			continue
+		}
+		res.UpdatedASes = append(res.UpdatedASes, as.IAString())
+	}
+	if len(failed) > 0 {
+		return res, fmt.Errorf("the configuration of the ASes %v could not be updated",
+			strings.Join(failed, ", "))
+	}
+	return res, nil
+}
+
+// RotateSecret replaces the account secret of the logged-in user
+func (c *UserController) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	account, err := models.FindAccountByUserEmail(userSession.Email)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Error500(w, err, "Error looking up the account")
+		return
+	}
+	res, err := rotateAccountSecret(r.Context(), account)
+	if err != nil {
+		log.Errorf("Error rotating the secret of account %v for %v: %v", account.AccountID,
+			userSession.Email, err)
+		c.Error500(w, err, "Error rotating the account secret: "+err.Error())
+		return
+	}
+	c.JSON(res, w, r)
+}
+
+// RotateAccountSecret replaces the secret of any account, e.g. after it leaked
+func (c AdminController) RotateAccountSecret(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	accountID := mux.Vars(r)["account_id"]
+	account, err := models.FindAccountByAccountID(accountID)
+	if err != nil {
+		c.NotFound(w, err, "Account not found")
+		return
+	}
+	res, err := rotateAccountSecret(r.Context(), account)
+	if err != nil {
+		log.Errorf("Error rotating the secret of account %v: %v", accountID, err)
+		c.Error500(w, err, "Error rotating the account secret: "+err.Error())
+		return
+	}
+	c.JSON(res, w, r)
+}
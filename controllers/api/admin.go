@@ -15,16 +15,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/netsec-ethz/scion-coord/config"
 	"github.com/netsec-ethz/scion-coord/controllers"
 	"github.com/netsec-ethz/scion-coord/controllers/middleware"
 	"github.com/netsec-ethz/scion-coord/email"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/vpnpool"
 )
 
 type AdminController struct {
@@ -54,9 +58,10 @@ type invitationsData []invitationInfo
 var invitationsTemplate = "invitation.html"
 
 func (c AdminController) AdminInformation(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	user, account, err := populateUserData(r)
 	if err != nil {
-		log.Printf("Error authenticating user: %v", err)
+		log.Errorf("Error authenticating user: %v", err)
 		c.Forbidden(w, err, "Error authenticating user")
 		return
 	}
@@ -74,7 +79,8 @@ func (c AdminController) AdminInformation(w http.ResponseWriter, r *http.Request
 	return
 }
 
-func preregisterAndSendInvitation(userSession *models.Session, invitation *invitationInfo) error {
+func preregisterAndSendInvitation(ctx context.Context, userSession *models.Session,
+	invitation *invitationInfo) error {
 	// register the user without password
 	account := invitation.Email // use the user's email as a unique account
 	user, err := models.RegisterUser(account, invitation.Organisation,
@@ -105,7 +111,7 @@ func preregisterAndSendInvitation(userSession *models.Session, invitation *invit
 		UUID:             user.VerificationUUID,
 	}
 
-	email.ConstructFromTemplateAndSend(
+	email.ConstructFromTemplateAndSend(ctx,
 		"invitation.html",
 		"[SCIONLab] Invitation to join the SCION network",
 		data,
@@ -117,6 +123,7 @@ func preregisterAndSendInvitation(userSession *models.Session, invitation *invit
 }
 
 func (c AdminController) SendInvitationEmails(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 
 	// parse the JSON coming from the client
 	decoder := json.NewDecoder(r.Body)
@@ -124,23 +131,23 @@ func (c AdminController) SendInvitationEmails(w http.ResponseWriter, r *http.Req
 
 	// check if the parsing succeeded
 	if err := decoder.Decode(&invitations); err != nil {
-		log.Printf("Error decoding json data for email invitations: %v", err)
+		log.Errorf("Error decoding json data for email invitations: %v", err)
 		c.Error500(w, err, "Error decoding json data for email invitations")
 		return
 	}
 
 	session, userSession, err := middleware.GetUserSession(r)
 	if session == nil || err != nil {
-		log.Printf("No user session found: %v", err)
+		log.Infof("No user session found: %v", err)
 		c.Forbidden(w, err, "No user session found")
 	}
 
 	var errorEmails []string
 	var errors []string
 	for _, invitation := range invitations {
-		err := preregisterAndSendInvitation(userSession, &invitation)
+		err := preregisterAndSendInvitation(r.Context(), userSession, &invitation)
 		if err != nil {
-			log.Printf("Error sending invitation email to %v: %v", invitation.Email, err)
+			log.Errorf("Error sending invitation email to %v: %v", invitation.Email, err)
 			errorEmails = append(errorEmails, invitation.Email)
 			errors = append(errors, controllers.Verbosity(err, "Could not send email to user %v", invitation.Email))
 		} else {
@@ -156,3 +163,134 @@ func (c AdminController) SendInvitationEmails(w http.ResponseWriter, r *http.Req
 		return
 	}
 }
+
+type certExpirationInfo struct {
+	IA          string
+	Label       string
+	UserEmail   string
+	CertVersion uint64
+	Expires     time.Time
+	DaysLeft    int
+}
+
+// CertificateExpirations returns the certificate expiration of all ASes, the ones expiring first
+// at the beginning
+func (c AdminController) CertificateExpirations(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	ases, err := models.FindSCIONLabASesWithCert()
+	if err != nil {
+		log.Errorf("Error looking up AS certificates: %v", err)
+		c.Error500(w, err, "Error looking up AS certificates")
+		return
+	}
+	infos := []certExpirationInfo{}
+	for _, as := range ases {
+		infos = append(infos, certExpirationInfo{
+			IA:          as.IAString(),
+			Label:       as.Label,
+			UserEmail:   as.UserEmail,
+			CertVersion: as.CertVersion,
+			Expires:     as.CertExpires,
+			DaysLeft:    int(time.Until(as.CertExpires).Hours() / 24),
+		})
+	}
+	c.JSON(infos, w, r)
+}
+
+type vpnPoolInfo struct {
+	IA      string
+	Label   string
+	HasVPN  bool
+	VPNType string
+	Usage   *vpnpool.Usage `json:",omitempty"`
+	Error   string         `json:",omitempty"` // set if the pool of the AP is misconfigured
+}
+
+// VPNPools returns the usage of the VPN address pool of each AP
+func (c AdminController) VPNPools(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	aps, err := models.FindAllAttachmentPoints()
+	if err != nil {
+		log.Errorf("Error looking up AttachmentPoints: %v", err)
+		c.Error500(w, err, "Error looking up AttachmentPoints")
+		return
+	}
+	now := time.Now().UTC()
+	infos := []vpnPoolInfo{}
+	for _, ap := range aps {
+		info := vpnPoolInfo{
+			IA:      ap.AS.IAString(),
+			Label:   ap.AS.Label,
+			HasVPN:  ap.HasVPN,
+			VPNType: models.VPNTypeOrDefault(ap.VPNType),
+		}
+		pool, err := ap.VPNPool()
+		if err != nil {
+			info.Error = err.Error()
+		} else {
+			usage := pool.Usage(now)
+			info.Usage = &usage
+		}
+		infos = append(infos, info)
+	}
+	c.JSON(infos, w, r)
+}
+
+type lockedUser struct {
+	Email       string
+	LockedUntil time.Time
+}
+
+// LockedUsers lists the users locked out after failed logins
+func (c AdminController) LockedUsers(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	locked, err := models.FindLockedUsers(time.Now())
+	if err != nil {
+		log.Errorf("Error looking up locked users: %v", err)
+		c.Error500(w, err, "Error looking up locked users")
+		return
+	}
+	users := []lockedUser{}
+	for email, until := range locked {
+		users = append(users, lockedUser{Email: email, LockedUntil: until})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	c.JSON(users, w, r)
+}
+
+type unlockRequest struct {
+	Email string // user to unlock, optional
+	IP    string // source address to unlock, optional
+}
+
+// UnlockUser lifts the lockout of a user or a source address after failed logins
+func (c AdminController) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	var req unlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	if req.Email == "" && req.IP == "" {
+		c.BadRequest(w, nil, "Email or IP required")
+		return
+	}
+	if req.Email != "" {
+		u, err := models.FindUserByEmail(req.Email)
+		if err != nil {
+			c.NotFound(w, err, "User not found")
+			return
+		}
+		if err := u.Unlock(); err != nil {
+			log.Errorf("Error unlocking user %v: %v", req.Email, err)
+			c.Error500(w, err, "Error unlocking the user")
+			return
+		}
+		log.Infof("Unlocked user %v", req.Email)
+	}
+	if req.IP != "" {
+		ipLoginThrottle.Reset(req.IP)
+		log.Infof("Unlocked logins from %v", req.IP)
+	}
+	c.JSON(struct{}{}, w, r)
+}
@@ -17,13 +17,13 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/astaxie/beego/orm"
 	"github.com/gorilla/mux"
 	"github.com/netsec-ethz/scion-coord/controllers"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
 	"github.com/scionproto/scion/go/lib/addr"
 )
@@ -84,15 +84,8 @@ type ConnReply struct {
 }
 
 func FindAccountByRequest(r *http.Request) (*models.Account, error) {
-	accountID := mux.Vars(r)["account_id"]
-
-	// get the account from the accountID and secret
-	if accountID == "" {
-		accountID = r.URL.Query().Get("account_id")
-	}
-
 	// find the account belonging to the request
-	return models.FindAccountByAccountID(accountID)
+	return models.FindAccountByAccountID(requestAccountID(r))
 }
 
 func ValidateAccountOwnsIA(account *models.Account, ia string) (bool, error) {
@@ -128,21 +121,22 @@ func (c *ASInfoController) Exists(w http.ResponseWriter, r *http.Request) {
 // and ensure the account owns the concerned ISD-AS
 func (c *ASInfoController) findAndValidateAccount(w http.ResponseWriter, r *http.Request,
 	ia string) (*models.Account, error) {
+	log := logger.FromContext(r.Context())
 
 	account, err := FindAccountByRequest(r)
 	if err != nil {
-		log.Printf("Error finding account. AccountID: %v, Request: %v: %v", mux.Vars(r)["account_id"], r, err)
+		log.Errorf("Error finding account. AccountID: %v, Request: %v: %v", requestAccountID(r), r, err)
 		c.BadRequest(w, err, "Error finding account")
 		return nil, err
 	}
 	owns, err := ValidateAccountOwnsIA(account, ia)
 	if err != nil {
-		log.Printf("Error validating account %v owns ISD-AS %v: %v", account, ia, err)
+		log.Errorf("Error validating account %v owns ISD-AS %v: %v", account, ia, err)
 		c.Error500(w, err, "Error validating account %v owns ISD-AS %v", account, ia)
 		return nil, err
 	}
 	if !owns {
-		log.Printf("Account %v and AS %v do not match.", account, ia)
+		log.Warnf("Account %v and AS %v do not match.", account, ia)
 		c.Forbidden(w, err, "Account %v and AS %v do not match.", account, ia)
 		return nil, err
 	}
@@ -150,17 +144,18 @@ func (c *ASInfoController) findAndValidateAccount(w http.ResponseWriter, r *http
 }
 
 func (c *ASInfoController) UploadJoinRequest(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	var request JoinRequest
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&request); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", r.Body, err)
+		log.Errorf("Error decoding JSON: %v, %v", r.Body, err)
 		c.BadRequest(w, err, "Error decoding JSON")
 		return
 	}
 	// find the account belonging to the request
 	account, err := FindAccountByRequest(r)
 	if err != nil {
-		log.Printf("Error finding account for request: %v: %v", request, err)
+		log.Errorf("Error finding account for request: %v: %v", request, err)
 		c.Error500(w, err, "Error finding account for request")
 		return
 	}
@@ -172,7 +167,7 @@ func (c *ASInfoController) UploadJoinRequest(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	if len(coreASes) == 0 {
-		log.Printf("ISD %v not found or no core ASes exist for this ISD. Account: %v",
+		log.Warnf("ISD %v not found or no core ASes exist for this ISD. Account: %v",
 			isdToJoin, account)
 		c.Error500(w, err, "ISD not found or no core ASes exist for this ISD")
 		return
@@ -184,7 +179,7 @@ func (c *ASInfoController) UploadJoinRequest(w http.ResponseWriter, r *http.Requ
 		Info:          request.Info,
 		ISDToJoin:     request.ISDToJoin,
 		JoinAsACoreAS: request.JoinAsACoreAS,
-		RequesterID:   mux.Vars(r)["account_id"],
+		RequesterID:   account.AccountID,
 		RespondIA:     coreAS.String(),
 		SigPubKey:     request.SigPubKey,
 		EncPubKey:     request.EncPubKey,
@@ -192,26 +187,27 @@ func (c *ASInfoController) UploadJoinRequest(w http.ResponseWriter, r *http.Requ
 	}
 	// insert into the join_requests table in the database
 	if err := joinRequest.Insert(); err != nil {
-		log.Printf("Error inserting join request for core AS %v: %v", coreAS, err)
+		log.Errorf("Error inserting join request for core AS %v: %v", coreAS, err)
 		c.Error500(w, err, "Error inserting join request")
 		return
 	}
-	log.Printf("Join request successfully received. ISDToJoin: %v Account: %v "+
+	log.Infof("Join request successfully received. ISDToJoin: %v Account: %v "+
 		"RequesterID: %v", isdToJoin, account, joinRequest.RequesterID)
 	fmt.Fprintln(w, "{}")
 }
 
 func (c *ASInfoController) UploadJoinReply(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	var reply JoinReply
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&reply); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", r.Body, err)
+		log.Errorf("Error decoding JSON: %v, %v", r.Body, err)
 		c.BadRequest(w, err, "Error decoding JSON")
 		return
 	}
 	account, err := models.FindAccountByAccountID(reply.RequesterID)
 	if err != nil {
-		log.Printf("Error finding account by AccountID. AccountID: %v, Request ID: %v ISD-AS: %v, %v",
+		log.Errorf("Error finding account by AccountID. AccountID: %v, Request ID: %v ISD-AS: %v, %v",
 			reply.RequesterID, reply.RequestID, reply.RespondIA, err)
 		return
 	}
@@ -227,7 +223,7 @@ func (c *ASInfoController) UploadJoinReply(w http.ResponseWriter, r *http.Reques
 		TRC:                  reply.TRC,
 	}
 	if err := joinReply.Insert(); err != nil {
-		log.Printf("Error inserting join reply. Account: %v Request ID: %v ISD-AS: %v, %v",
+		log.Errorf("Error inserting join reply. Account: %v Request ID: %v ISD-AS: %v, %v",
 			account, joinReply.RequestID, reply.RespondIA, err)
 		c.Error500(w, err, "Error inserting join reply")
 		return
@@ -235,24 +231,24 @@ func (c *ASInfoController) UploadJoinReply(w http.ResponseWriter, r *http.Reques
 	// Change the join request's status to approved/rejected.
 	joinRequest, err := models.FindJoinRequest(account.AccountID, joinReply.RequestID)
 	if err != nil {
-		log.Printf("Error finding join req. Account: %v Request ID: %v ISD-AS: %v, %v",
+		log.Errorf("Error finding join req. Account: %v Request ID: %v ISD-AS: %v, %v",
 			account, joinReply.RequestID, reply.RespondIA, err)
 		c.Error500(w, err, "Error finding join request")
 		return
 	}
 	joinRequest.Status = reply.Status
 	if err := joinRequest.Update(); err != nil {
-		log.Printf("Error updating join req. Account: %v Request ID: %v ISD-AS: %v, %v",
+		log.Errorf("Error updating join req. Account: %v Request ID: %v ISD-AS: %v, %v",
 			account, joinReply.RequestID, reply.RespondIA, err)
 		c.Error500(w, err, "Error updating join request")
 		return
 	}
-	log.Printf("Received a join reply. Account: %v Request ID: %v ISD-AS: %v Status: %v",
+	log.Infof("Received a join reply. Account: %v Request ID: %v ISD-AS: %v Status: %v",
 		account, joinReply.RequestID, reply.RespondIA, reply.Status)
 	if reply.Status == models.Approved {
 		ia, err := addr.IAFromString(joinReply.JoiningIA)
 		if err != nil {
-			log.Printf("Error parsing ISD-AS %v, %v ", joinReply.JoiningIA, err)
+			log.Errorf("Error parsing ISD-AS %v, %v ", joinReply.JoiningIA, err)
 			c.Error500(w, err, "Error parsing ISD-AS")
 			return
 		}
@@ -264,18 +260,19 @@ func (c *ASInfoController) UploadJoinReply(w http.ResponseWriter, r *http.Reques
 			Created: time.Now().UTC(),
 		}
 		if dbErr := newAS.Insert(); dbErr != nil {
-			log.Printf("Error inserting new AS: %v Account: %v Request ID: %v, %v",
+			log.Errorf("Error inserting new AS: %v Account: %v Request ID: %v, %v",
 				newAS.String(), account, reply.RequestID, err)
 			c.Error500(w, dbErr, "Error inserting new AS")
 			return
 		}
-		log.Printf("New AS successfully created. Account: %v Request ID: %v new AS: %v",
+		log.Infof("New AS successfully created. Account: %v Request ID: %v new AS: %v",
 			account, reply.RequestID, reply.JoiningIA)
 	}
 	fmt.Fprintln(w, "{}")
 }
 
 func (c *ASInfoController) PollJoinReply(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	var request struct {
 		RequestId uint64 `json:"request_id"`
 	}
@@ -286,18 +283,18 @@ func (c *ASInfoController) PollJoinReply(w http.ResponseWriter, r *http.Request)
 	}
 	account, err := FindAccountByRequest(r)
 	if err != nil {
-		log.Printf("Error finding account for request: %v: %v", request.RequestId, err)
+		log.Errorf("Error finding account for request: %v: %v", request.RequestId, err)
 		c.BadRequest(w, err, "Error finding account for request")
 		return
 	}
 	joinReply, err := models.FindJoinReply(account.AccountID, request.RequestId)
 	if err == orm.ErrNoRows {
-		log.Printf("No join reply for Account: %v Request ID: %v", account,
+		log.Infof("No join reply for Account: %v Request ID: %v", account,
 			request.RequestId)
 		fmt.Fprintln(w, "{}")
 		return
 	} else if err != nil {
-		log.Printf("Error during join reply lookup. Account: %v Request ID: %v, %v",
+		log.Errorf("Error during join reply lookup. Account: %v Request ID: %v, %v",
 			account, request.RequestId, err)
 		c.Error500(w, err, "Error during join reply lookup")
 		return
@@ -314,7 +311,7 @@ func (c *ASInfoController) PollJoinReply(w http.ResponseWriter, r *http.Request)
 	}
 	b, err := json.Marshal(reply)
 	if err != nil {
-		log.Printf("Error marshaling JSON for account: %v request: %v new AS: %v, %v",
+		log.Errorf("Error marshaling JSON for account: %v request: %v new AS: %v, %v",
 			account, request.RequestId, joinReply.JoiningIA, err)
 		c.Error500(w, err, "Error during JSON marshaling")
 		return
@@ -323,10 +320,11 @@ func (c *ASInfoController) PollJoinReply(w http.ResponseWriter, r *http.Request)
 }
 
 func (c *ASInfoController) UploadConnRequest(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	var cr ConnRequest
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&cr); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", r.Body, err)
+		log.Errorf("Error decoding JSON: %v, %v", r.Body, err)
 		c.BadRequest(w, err, "Error decoding JSON")
 		return
 	}
@@ -361,29 +359,30 @@ func (c *ASInfoController) UploadConnRequest(w http.ResponseWriter, r *http.Requ
 		Status:               models.Pending,
 	}
 	if err := connRequest.Insert(); err != nil {
-		log.Printf("Error inserting connection request. Account %v AS %v: %v", account,
+		log.Errorf("Error inserting connection request. Account %v AS %v: %v", account,
 			cr.RequestIA, err)
 		c.Error500(w, err, "Error inserting connection request")
 		// The credits will be granted in foresight and must be removed in case of an error (now)
 		c.rollBackCreditUpdate(w, r, &cr)
 		return
 	}
-	log.Printf("Connection Request Successfully Received: %v Request ID: %v",
+	log.Infof("Connection Request Successfully Received: %v Request ID: %v",
 		account, cr.RequestID)
 	fmt.Fprintln(w, "{}")
 }
 
 func (c *ASInfoController) UploadConnReply(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	var reply ConnReply
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&reply); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", r.Body, err)
+		log.Errorf("Error decoding JSON: %v, %v", r.Body, err)
 		c.BadRequest(w, err, "Error decoding JSON")
 		return
 	}
 	as, err := models.FindASInfoByIA(reply.RequestIA)
 	if err != nil {
-		log.Printf("Error finding the RequestIA. Request ID: %v RequestIA: %v, RespondIA: %v, %v",
+		log.Errorf("Error finding the RequestIA. Request ID: %v RequestIA: %v, RespondIA: %v, %v",
 			reply.RequestID, reply.RequestIA, reply.RespondIA, err)
 		c.Error500(w, err, "Error finding the RequestIA")
 		return
@@ -403,7 +402,7 @@ func (c *ASInfoController) UploadConnReply(w http.ResponseWriter, r *http.Reques
 		Bandwidth:   reply.Bandwidth,
 	}
 	if err := connReply.Insert(); err != nil {
-		log.Printf("Error inserting Connection Reply. Request ID: %v Account: %v AS: %v: %v",
+		log.Errorf("Error inserting Connection Reply. Request ID: %v Account: %v AS: %v: %v",
 			reply.RequestID, account, reply.RespondIA, err)
 		c.BadRequest(w, err, "Error inserting connection reply")
 		return
@@ -411,14 +410,14 @@ func (c *ASInfoController) UploadConnReply(w http.ResponseWriter, r *http.Reques
 	// Change the connection request's status to approved/rejected.
 	cr, err := models.FindConnRequest(account, reply.RequestID)
 	if err != nil {
-		log.Printf("Error finding conn req. Account: %v Request ID: %v ISD-AS: %v, %v",
+		log.Errorf("Error finding conn req. Account: %v Request ID: %v ISD-AS: %v, %v",
 			account, reply.RequestID, reply.RespondIA, err)
 		c.Error500(w, err, "Error finding connection request")
 		return
 	}
 	cr.Status = reply.Status
 	if err := cr.Update(); err != nil {
-		log.Printf("Error updating conn req. Account: %v Request ID: %v ISD-AS: %v, %v",
+		log.Errorf("Error updating conn req. Account: %v Request ID: %v ISD-AS: %v, %v",
 			account, reply.RequestID, reply.RespondIA, err)
 		c.Error500(w, err, "Error updating connection request")
 		return
@@ -432,7 +431,7 @@ func (c *ASInfoController) UploadConnReply(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	log.Printf("Connection Reply Successfully Received. Account: %v Request ID: %v "+
+	log.Infof("Connection Reply Successfully Received. Account: %v Request ID: %v "+
 		"Requesting AS: %v Replying AS: %v Status: %v", account, reply.RequestID, reply.RequestIA,
 		reply.RespondIA, reply.Status)
 	fmt.Fprintln(w, "{}")
@@ -510,12 +509,13 @@ func (c *ASInfoController) prepConnReplies(in []models.ConnReply) []ConnReply {
 
 // API end-point to query outstanding requests/events for an AS
 func (c *ASInfoController) PollEvents(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	var req struct {
 		IA string
 	}
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&req); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", r.Body, err)
+		log.Errorf("Error decoding JSON: %v, %v", r.Body, err)
 		c.BadRequest(w, err, "Error decoding JSON")
 		return
 	}
@@ -528,21 +528,21 @@ func (c *ASInfoController) PollEvents(w http.ResponseWriter, r *http.Request) {
 	}
 	joinRequests, err := models.FindOpenJoinRequestsByIA(ia)
 	if err != nil {
-		log.Printf("Error while retrieving open join requests. Account: %v, ISD-AS: %v",
+		log.Errorf("Error while retrieving open join requests. Account: %v, ISD-AS: %v",
 			account, ia)
 		c.BadRequest(w, err, "Error while retrieving open join requests")
 		return
 	}
 	connRequests, err := models.FindOpenConnRequestsByRespondIA(ia)
 	if err != nil {
-		log.Printf("Error while retrieving connection requests. Account: %v, ISD-AS: %v",
+		log.Errorf("Error while retrieving connection requests. Account: %v, ISD-AS: %v",
 			account, ia)
 		c.BadRequest(w, err, "Error while retrieving connection Requests")
 		return
 	}
 	connReplies, err := models.FindConnRepliesByRequestIA(ia)
 	if err != nil {
-		log.Printf("Error while retrieving connection replies. Account: %v, ISD-AS: %v",
+		log.Errorf("Error while retrieving connection replies. Account: %v, ISD-AS: %v",
 			account, ia)
 		c.BadRequest(w, err, "Error while retrieving connection replies")
 		return
@@ -559,7 +559,7 @@ func (c *ASInfoController) PollEvents(w http.ResponseWriter, r *http.Request) {
 
 	b, err := json.Marshal(resp)
 	if err != nil {
-		log.Printf("Error during JSON marshaling. Account: %v, ISD-AS: %v, %v", account, ia,
+		log.Errorf("Error during JSON marshaling. Account: %v, ISD-AS: %v, %v", account, ia,
 			err)
 		c.Error500(w, err, "Error during JSON marshaling")
 		return
@@ -582,12 +582,13 @@ func (c *ASInfoController) prepASListResponse(in []models.ASInfo) []string {
 // API end-point to serve the list of ASes available for an AS to connect to.
 // Responds back with a list of ASes in the ISD that the AS belongs to.
 func (c *ASInfoController) ListASes(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	var req struct {
 		IA string
 	}
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&req); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", r.Body, err)
+		log.Errorf("Error decoding JSON: %v, %v", r.Body, err)
 		c.BadRequest(w, err, "Error decoding JSON")
 		return
 	}
@@ -598,13 +599,13 @@ func (c *ASInfoController) ListASes(w http.ResponseWriter, r *http.Request) {
 	}
 	ia, err := addr.IAFromString(req.IA)
 	if err != nil {
-		log.Printf("Error parsing ISD-AS %v, %v ", req.IA, err)
+		log.Errorf("Error parsing ISD-AS %v, %v ", req.IA, err)
 		c.Error500(w, err, "Error parsing ISD-AS")
 		return
 	}
 	ases, err := models.FindASInfosByISD(ia.I)
 	if err != nil {
-		log.Printf("Error while retrieving list of ASes. Account: %v, ISD-AS: %v", account,
+		log.Errorf("Error while retrieving list of ASes. Account: %v, ISD-AS: %v", account,
 			req.IA)
 		c.BadRequest(w, err, "Error while retrieving list of ASes")
 		return
@@ -612,7 +613,7 @@ func (c *ASInfoController) ListASes(w http.ResponseWriter, r *http.Request) {
 	resp := c.prepASListResponse(ases)
 	b, err := json.Marshal(resp)
 	if err != nil {
-		log.Printf("Error during JSON marshaling. Account: %v, ISD-AS: %v, %v", account, req.IA,
+		log.Errorf("Error during JSON marshaling. Account: %v, ISD-AS: %v, %v", account, req.IA,
 			err)
 		c.Error500(w, err, "Error during JSON marshaling")
 		return
@@ -0,0 +1,241 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+)
+
+// asQuotaInfo is the number of ASes counted against the quota of a user and the quota
+type asQuotaInfo struct {
+	Used   int // the ASes of the account for a quota of the account, else those of the user
+	Max    int
+	Source string // models.QuotaSourceUser, QuotaSourceAccount or QuotaSourceDefault
+}
+
+// asQuotaEntry is a quota set for a user or an account, as listed on the admin page
+type asQuotaEntry struct {
+	User         string `json:",omitempty"`
+	AccountID    string `json:",omitempty"`
+	Organisation string `json:",omitempty"`
+	MaxASes      int
+	SetBy        string
+	Updated      time.Time
+}
+
+type setASQuotaRequest struct {
+	MaxASes int
+}
+
+// userASQuota returns the quota of the user with the email and how many ASes count against it
+func userASQuota(userEmail string) (asQuotaInfo, error) {
+	u, err := models.FindUserByEmail(userEmail)
+	if err != nil {
+		return asQuotaInfo{}, err
+	}
+	maxASes, source, err := u.MaxASes()
+	if err != nil {
+		return asQuotaInfo{}, err
+	}
+	ases, err := u.QuotaASes(source)
+	if err != nil {
+		return asQuotaInfo{}, err
+	}
+	return asQuotaInfo{Used: len(ases), Max: maxASes, Source: source}, nil
+}
+
+// ASQuotas lists the quotas set for users and accounts
+func (c AdminController) ASQuotas(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	quotas, err := models.FindASQuotas()
+	if err != nil {
+		log.Errorf("Error looking up the AS quotas: %v", err)
+		c.Error500(w, err, "Error looking up the AS quotas")
+		return
+	}
+	entries := []asQuotaEntry{}
+	for _, q := range quotas {
+		entry := asQuotaEntry{MaxASes: q.MaxASes, SetBy: q.SetBy, Updated: q.Updated}
+		if q.User != nil {
+			entry.User = q.User.Email
+		}
+		if q.Account != nil {
+			entry.AccountID = q.Account.AccountID
+			entry.Organisation = q.Account.Organisation
+		}
+		entries = append(entries, entry)
+	}
+	c.JSON(entries, w, r)
+}
+
+// SetUserASQuota sets the number of ASes a user can have, overriding the quota of the account
+// and the global default
+func (c AdminController) SetUserASQuota(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	admin, err := actingAdmin(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	var req setASQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	u, err := models.FindUserByEmail(mux.Vars(r)["email"])
+	if err != nil {
+		c.NotFound(w, err, "User not found")
+		return
+	}
+	if _, err := u.SetASQuota(req.MaxASes, admin); err != nil {
+		if err == models.ErrNegativeQuota {
+			c.BadRequest(w, err, "The quota must not be negative")
+			return
+		}
+		log.Errorf("Error setting the AS quota of %v: %v", u.Email, err)
+		c.Error500(w, err, "Error setting the AS quota")
+		return
+	}
+	details := fmt.Sprintf("%d ASes", req.MaxASes)
+	if err := models.RecordAdminAction(admin, u, models.ActionSetQuota, details); err != nil {
+		log.Errorf("Error recording that %v set the AS quota of %v: %v", admin, u.Email, err)
+	}
+	log.Infof("%v set the AS quota of %v to %v", admin, u.Email, req.MaxASes)
+	c.writeUserASQuota(w, r, u.Email)
+}
+
+// ResetUserASQuota removes the quota set for a user, so that the quota of the account or the
+// global default applies again
+func (c AdminController) ResetUserASQuota(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	admin, err := actingAdmin(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	u, err := models.FindUserByEmail(mux.Vars(r)["email"])
+	if err != nil {
+		c.NotFound(w, err, "User not found")
+		return
+	}
+	reset, err := u.ResetASQuota()
+	if err != nil {
+		log.Errorf("Error resetting the AS quota of %v: %v", u.Email, err)
+		c.Error500(w, err, "Error resetting the AS quota")
+		return
+	}
+	if reset {
+		if err := models.RecordAdminAction(admin, u, models.ActionResetQuota, ""); err != nil {
+			log.Errorf("Error recording that %v reset the AS quota of %v: %v", admin, u.Email,
+				err)
+		}
+		log.Infof("%v reset the AS quota of %v", admin, u.Email)
+	}
+	c.writeUserASQuota(w, r, u.Email)
+}
+
+// writeUserASQuota responds with the quota now applying to the user
+func (c AdminController) writeUserASQuota(w http.ResponseWriter, r *http.Request,
+	userEmail string) {
+	log := logger.FromContext(r.Context())
+	quota, err := userASQuota(userEmail)
+	if err != nil {
+		log.Errorf("Error looking up the AS quota of %v: %v", userEmail, err)
+		c.Error500(w, err, "Error looking up the AS quota")
+		return
+	}
+	c.JSON(quota, w, r)
+}
+
+// SetAccountASQuota sets the number of ASes the users of an account can have together,
+// overriding the global default
+func (c AdminController) SetAccountASQuota(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	admin, err := actingAdmin(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	var req setASQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	accountID := mux.Vars(r)["account_id"]
+	account, err := models.FindAccountByAccountID(accountID)
+	if err != nil {
+		c.NotFound(w, err, "Account not found")
+		return
+	}
+	if _, err := account.SetASQuota(req.MaxASes, admin); err != nil {
+		if err == models.ErrNegativeQuota {
+			c.BadRequest(w, err, "The quota must not be negative")
+			return
+		}
+		log.Errorf("Error setting the AS quota of account %v: %v", accountID, err)
+		c.Error500(w, err, "Error setting the AS quota")
+		return
+	}
+	details := fmt.Sprintf("%d ASes", req.MaxASes)
+	if err := models.RecordAdminAccountAction(admin, account, models.ActionSetQuota,
+		details); err != nil {
+		log.Errorf("Error recording that %v set the AS quota of account %v: %v", admin,
+			accountID, err)
+	}
+	log.Infof("%v set the AS quota of account %v to %v", admin, accountID, req.MaxASes)
+	c.JSON(struct{}{}, w, r)
+}
+
+// ResetAccountASQuota removes the quota set for an account
+func (c AdminController) ResetAccountASQuota(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	admin, err := actingAdmin(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	accountID := mux.Vars(r)["account_id"]
+	account, err := models.FindAccountByAccountID(accountID)
+	if err != nil {
+		c.NotFound(w, err, "Account not found")
+		return
+	}
+	reset, err := account.ResetASQuota()
+	if err != nil {
+		log.Errorf("Error resetting the AS quota of account %v: %v", accountID, err)
+		c.Error500(w, err, "Error resetting the AS quota")
+		return
+	}
+	if reset {
+		if err := models.RecordAdminAccountAction(admin, account, models.ActionResetQuota,
+			""); err != nil {
+			log.Errorf("Error recording that %v reset the AS quota of account %v: %v", admin,
+				accountID, err)
+		}
+		log.Infof("%v reset the AS quota of account %v", admin, accountID)
+	}
+	c.JSON(struct{}{}, w, r)
+}
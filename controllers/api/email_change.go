@@ -0,0 +1,390 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/email"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+)
+
+// time the user has to confirm the new email address
+const emailChangeValidity = 24 * time.Hour
+
+type emailChangeRequest struct {
+	Password string
+	NewEmail string
+}
+
+// emailChangedMailData fills the email template email_changed.html and the confirmation page
+type emailChangedMailData struct {
+	FirstName   string
+	LastName    string
+	HostAddress string
+	OldEmail    string
+	NewEmail    string
+}
+
+// artifactMove is an artifact, or a directory of artifacts, stored under a key derived from the
+// email address of the contact of an AS
+type artifactMove struct {
+	from, to string
+	dir      bool
+}
+
+// emailArtifactMoves returns the artifacts of the ASes and of the SCION box of the user which
+// have to be moved when the email address changes: the packages, the WireGuard keys, the
+// configuration versions and the certificate caches. The OpenVPN keys are not moved, as the
+// address is part of the common name of the certificate; they are issued again instead.
+func emailArtifactMoves(ases []models.SCIONLabAS, oldEmail, newEmail string) []artifactMove {
+	var moves []artifactMove
+	for i := range ases {
+		if ases[i].Type == models.Infrastructure {
+			continue
+		}
+		moves = append(moves, asArtifactMoves(&ases[i], newEmail)...)
+	}
+	return append(moves, distinctMoves(artifactMove{from: boxPackageKey(oldEmail),
+		to: boxPackageKey(newEmail)})...)
+}
+
+// asArtifactMoves returns the artifacts of the AS which have to be moved when the email address
+// of its contact changes to newEmail, see emailArtifactMoves
+func asArtifactMoves(as *models.SCIONLabAS, newEmail string) []artifactMove {
+	moved := *as
+	moved.UserEmail = newEmail
+	// the public WireGuard key is moved first, so a private key always has its public key
+	return distinctMoves(
+		artifactMove{from: packageKey(as.UserEmail, as.ISD, as.ASID),
+			to: packageKey(newEmail, as.ISD, as.ASID)},
+		artifactMove{from: wireGuardPublicKeyKey(as.UserEmail, as.ASID),
+			to: wireGuardPublicKeyKey(newEmail, as.ASID)},
+		artifactMove{from: wireGuardPrivateKeyKey(as.UserEmail, as.ASID),
+			to: wireGuardPrivateKeyKey(newEmail, as.ASID)},
+		artifactMove{from: packageVersionsKey(as), to: packageVersionsKey(&moved), dir: true},
+		artifactMove{from: certCacheKey(as), to: certCacheKey(&moved), dir: true},
+	)
+}
+
+// distinctMoves leaves out the artifacts whose key does not change: addresses differing only in
+// characters replaced by storage.Join share their keys
+func distinctMoves(moves ...artifactMove) []artifactMove {
+	var distinct []artifactMove
+	for _, m := range moves {
+		if m.from != m.to {
+			distinct = append(distinct, m)
+		}
+	}
+	return distinct
+}
+
+// copyArtifacts copies the artifacts to their new keys. If copying fails, the copies made so
+// far are deleted again.
+func copyArtifacts(ctx context.Context, moves []artifactMove) error {
+	log := logger.FromContext(ctx)
+	var copied []string
+	var err error
+	for _, m := range moves {
+		if m.dir {
+			var keys []string
+			keys, err = storage.CopyAll(Artifacts, m.from, m.to)
+			copied = append(copied, keys...)
+		} else if err = storage.Copy(Artifacts, m.from, m.to); err == nil {
+			copied = append(copied, m.to)
+		} else if err == storage.ErrNotExist {
+			err = nil
+		}
+		if err != nil {
+			err = fmt.Errorf("error copying %v: %v", m.from, err)
+			break
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	for _, key := range copied {
+		if errDelete := Artifacts.Delete(key); errDelete != nil {
+			log.Errorf("Error deleting the copy %v: %v", key, errDelete)
+		}
+	}
+	return err
+}
+
+// deleteArtifactCopies deletes the copies made by copyArtifacts when the artifacts are not moved
+// after all
+func deleteArtifactCopies(moves []artifactMove) {
+	for _, m := range moves {
+		if m.dir {
+			storage.DeleteAll(Artifacts, m.to)
+		} else {
+			Artifacts.Delete(m.to)
+		}
+	}
+}
+
+// deleteMovedArtifacts deletes the artifacts under their previous keys. Errors are only logged,
+// the artifacts are not used anymore.
+func deleteMovedArtifacts(ctx context.Context, moves []artifactMove) {
+	log := logger.FromContext(ctx)
+	for _, m := range moves {
+		var err error
+		if m.dir {
+			err = storage.DeleteAll(Artifacts, m.from)
+		} else {
+			err = Artifacts.Delete(m.from)
+		}
+		if err != nil {
+			log.Errorf("Error deleting %v after moving it to %v: %v", m.from, m.to, err)
+		}
+	}
+}
+
+// changeUserEmail changes the email address of the user to the requested one. The artifacts
+// stored under keys derived from the address are copied before the database is updated, so
+// that a failure leaves the user unchanged. The OpenVPN certificates issued for the previous
+// address are revoked, and the configuration version of the configured ASes is increased, so
+// that they obtain a certificate for the new address with their next update. It returns the
+// previous address; the ASes whose configuration could not be regenerated are reported in the
+// error, the address is changed nonetheless.
+func changeUserEmail(ctx context.Context, userEmail string) (string, error) {
+	log := logger.FromContext(ctx)
+	u, err := models.FindUserByEmail(userEmail)
+	if err != nil {
+		return "", err
+	}
+	if !u.EmailChangePending() {
+		return "", models.ErrNoEmailChange
+	}
+	newEmail := u.NewEmail
+	ases, err := models.FindSCIONLabASesByUserEmail(userEmail)
+	if err != nil {
+		return "", fmt.Errorf("error looking up the ASes of %v: %v", userEmail, err)
+	}
+	moves := emailArtifactMoves(ases, userEmail, newEmail)
+	if err := copyArtifacts(ctx, moves); err != nil {
+		return "", err
+	}
+	if _, err := u.ChangeEmail(); err != nil {
+		deleteArtifactCopies(moves)
+		return "", err
+	}
+	log.Infof("Changed the email address of user %v from %v to %v", u.ID, userEmail, newEmail)
+	deleteMovedArtifacts(ctx, moves)
+	if err := os.RemoveAll(userPackagePath(userEmail)); err != nil {
+		log.Errorf("Error removing the SCION box package of %v: %v", userEmail, err)
+	}
+
+	var failed []string
+	for i := range ases {
+		as := &ases[i]
+		if as.Type == models.Infrastructure {
+			continue
+		}
+		if err := cleanVPNKeys(ctx, userEmail, as.ASID); err != nil {
+			failed = append(failed, as.IAString())
+			continue
+		}
+		packagePath := filepath.Join(PackagePath, UserPackageName(userEmail, as.ISD, as.ASID))
+		if err := os.RemoveAll(packagePath); err != nil {
+			log.Errorf("Error removing %v: %v", packagePath, err)
+		}
+		as.UserEmail = newEmail
+		// inactive and removed ASes have no configuration
+		if as.Status == models.Inactive || as.Status == models.Removed {
+			continue
+		}
+		as.ConfVersion++
+		if err := computeNewGenFolder(ctx, as); err != nil {
+			log.Errorf("Error regenerating the configuration of AS %v: %v", as.IAString(), err)
+			failed = append(failed, as.IAString())
+			continue
+		}
+		if err := as.Update(); err != nil {
+			log.Errorf("Error updating AS %v: %v", as.IAString(), err)
+			failed = append(failed, as.IAString())
+		}
+	}
+	if len(failed) > 0 {
+		return userEmail, fmt.Errorf("the configuration of the ASes %v could not be updated",
+			strings.Join(failed, ", "))
+	}
+	return userEmail, nil
+}
+
+// RequestEmailChange sends a link confirming the change of the email address of the logged-in
+// user to the new address. The address is changed once the link is opened.
+func (c *UserController) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	var req emailChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	dbUser, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		log.Warnf("User %v not found in database: %v", userSession.Email, err)
+		c.Forbidden(w, err, "Error authenticating user")
+		return
+	}
+	// users created at their first login with an OpenID Connect provider may have no password
+	if !dbUser.PasswordInvalid {
+		if err := dbUser.CheckPassword(req.Password); err != nil {
+			log.Warnf("Incorrect password for user %v", dbUser.Email)
+			c.Forbidden(w, err, "Incorrect password")
+			return
+		}
+	}
+	newEmail := strings.TrimSpace(req.NewEmail)
+	if addr, err := mail.ParseAddress(newEmail); err != nil || addr.Address != newEmail {
+		c.BadRequest(w, err, "Please enter a valid email address")
+		return
+	}
+	if newEmail == dbUser.Email {
+		c.BadRequest(w, nil, "This is already your email address")
+		return
+	}
+
+	link, err := dbUser.RequestEmailChange(newEmail, emailChangeValidity)
+	if err == models.ErrEmailTaken {
+		c.BadRequest(w, err, "The email address is already registered")
+		return
+	}
+	if err == models.ErrAccountNameTaken {
+		log.Warnf("Cannot rename the account of %v to %v: %v", dbUser.Email, newEmail, err)
+		c.BadRequest(w, err, "An account is already named after the email address, please "+
+			"contact the SCIONLab administrators")
+		return
+	}
+	if err != nil {
+		log.Errorf("Error requesting the email change of %v: %v", dbUser.Email, err)
+		c.Error500(w, err, "Error changing the email address")
+		return
+	}
+	data := email.MailData{
+		FirstName:        dbUser.FirstName,
+		LastName:         dbUser.LastName,
+		HostAddress:      config.HTTPHostAddress,
+		VerificationUUID: link,
+	}
+	if err := email.ConstructFromTemplateAndSend(r.Context(), "email_change.html",
+		"[SCIONLab] Confirm your new email address for SCIONLab Coordination Service", data,
+		"email-change", newEmail, false); err != nil {
+		log.Errorf("Error sending the email change link to %v: %v", newEmail, err)
+		c.Error500(w, err, "Error sending the confirmation email")
+		return
+	}
+	log.Infof("User %v requested to change the email address to %v", dbUser.Email, newEmail)
+	fmt.Fprintf(w, "We sent a link to %v, please open it within %v hours to confirm the change.\n",
+		newEmail, int(emailChangeValidity.Hours()))
+}
+
+// ConfirmEmailChange changes the email address of the user who requested the change with the
+// link. The user is logged out of all sessions and the previous address is notified.
+func (c *RegistrationController) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	u, err := models.FindUserByEmailChangeUUID(mux.Vars(r)["uuid"])
+	if err != nil || !u.EmailChangePending() {
+		c.BadRequest(w, nil, "The link is invalid or expired, please request the change again")
+		return
+	}
+	if u.DeletionPending() {
+		c.BadRequest(w, nil, "Your account is being deleted")
+		return
+	}
+	// the attachment points identify the VPN clients of the ASes with pending changes by the
+	// previous address
+	ases, err := models.FindSCIONLabASesByUserEmail(u.Email)
+	if err != nil {
+		log.Errorf("Error looking up the ASes of %v: %v", u.Email, err)
+		c.Error500(w, err, "Error changing the email address")
+		return
+	}
+	for _, as := range ases {
+		if as.Status == models.Create || as.Status == models.Update ||
+			as.Status == models.Remove {
+			c.BadRequest(w, nil, "Your AS %v has pending changes, please open the link again "+
+				"in a few minutes", as.IAString())
+			return
+		}
+	}
+
+	data := emailChangedMailData{
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		HostAddress: config.HTTPHostAddress,
+		OldEmail:    u.Email,
+		NewEmail:    u.NewEmail,
+	}
+	oldEmail, err := changeUserEmail(r.Context(), u.Email)
+	if oldEmail == "" {
+		switch err {
+		case models.ErrEmailTaken:
+			c.BadRequest(w, err, "The email address %v is already registered", data.NewEmail)
+		case models.ErrAccountNameTaken:
+			log.Warnf("Cannot rename the account of %v to %v: %v", u.Email, data.NewEmail, err)
+			c.BadRequest(w, err, "An account is already named after the email address %v, "+
+				"please contact the SCIONLab administrators", data.NewEmail)
+		case models.ErrNoEmailChange:
+			c.BadRequest(w, err, "The link is invalid or expired, please request the change again")
+		default:
+			log.Errorf("Error changing the email address of %v: %v", u.Email, err)
+			c.Error500(w, err, "Error changing the email address")
+		}
+		return
+	}
+	if err != nil {
+		// the address is changed, the configuration is regenerated when the ASes fetch it
+		log.Errorf("Error updating the ASes of %v after changing the email address: %v",
+			data.NewEmail, err)
+	}
+	// the sessions refer to the user by the previous address
+	if _, err := models.DeleteWebSessionsByUserEmail(data.NewEmail, ""); err != nil {
+		log.Errorf("Error revoking the sessions of %v: %v", data.NewEmail, err)
+	}
+	if err := email.ConstructFromTemplateAndSend(r.Context(), "email_changed.html",
+		"[SCIONLab] Your email address was changed", data, "email-changed", data.OldEmail,
+		false); err != nil {
+		log.Errorf("Error notifying %v about the email change: %v", data.OldEmail, err)
+	}
+
+	t, err := template.ParseFiles("templates/layout.html", "templates/email_changed.html")
+	if err != nil {
+		log.Errorf("Error parsing HTML files: %v", err)
+		c.Error500(w, err, "Error parsing HTML files")
+		return
+	}
+	c.Render(t, data, w, r)
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+)
+
+func TestMoveEmailArtifacts(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "email_change")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	oldArtifacts := Artifacts
+	Artifacts = storage.NewLocal(tmp)
+	defer func() { Artifacts = oldArtifacts }()
+
+	as := models.SCIONLabAS{UserEmail: "old@example.com", ISD: 1, ASID: 0xffaa00010001,
+		Type: models.Dedicated}
+	oldKeys := []string{
+		packageKey(as.UserEmail, as.ISD, as.ASID),
+		wireGuardPublicKeyKey(as.UserEmail, as.ASID),
+		wireGuardPrivateKeyKey(as.UserEmail, as.ASID),
+		packageVersionKey(&as, 1),
+		certCacheKey(&as) + "/V1/certs/a.crt",
+	}
+	for _, key := range oldKeys {
+		if err = storage.PutBytes(Artifacts, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	infrastructure := models.SCIONLabAS{UserEmail: as.UserEmail, ISD: 1, ASID: 0xffaa00001101,
+		Type: models.Infrastructure}
+	moves := emailArtifactMoves([]models.SCIONLabAS{as, infrastructure}, as.UserEmail,
+		"new@example.com")
+	// the SCION box package is moved as well, the infrastructure AS has no artifacts
+	if len(moves) != 6 {
+		t.Fatalf("unexpected moves %v", moves)
+	}
+	if err = copyArtifacts(context.Background(), moves); err != nil {
+		t.Fatal(err)
+	}
+	deleteMovedArtifacts(context.Background(), moves)
+
+	moved := as
+	moved.UserEmail = "new@example.com"
+	newKeys := []string{
+		packageKey(moved.UserEmail, as.ISD, as.ASID),
+		wireGuardPublicKeyKey(moved.UserEmail, as.ASID),
+		wireGuardPrivateKeyKey(moved.UserEmail, as.ASID),
+		packageVersionKey(&moved, 1),
+		certCacheKey(&moved) + "/V1/certs/a.crt",
+	}
+	for i, key := range newKeys {
+		content, err := storage.ReadAll(Artifacts, key)
+		if err != nil || string(content) != oldKeys[i] {
+			t.Errorf("%v: unexpected content %q: %v", key, content, err)
+		}
+		if exists, err := storage.Exists(Artifacts, oldKeys[i]); exists || err != nil {
+			t.Errorf("%v should have been deleted: %v, %v", oldKeys[i], exists, err)
+		}
+	}
+
+	// addresses mapped to the same keys are not moved
+	if moves := emailArtifactMoves(nil, "a!b@example.com", "a?b@example.com"); len(moves) != 0 {
+		t.Errorf("unexpected moves %v", moves)
+	}
+}
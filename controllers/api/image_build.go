@@ -16,17 +16,17 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"mime/multipart"
 	"net/http"
-	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/utility"
 
 	"github.com/gorilla/mux"
@@ -35,6 +35,7 @@ import (
 	"github.com/netsec-ethz/scion-coord/controllers"
 	"github.com/netsec-ethz/scion-coord/controllers/middleware"
 	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
 )
 
 var httpClient = &http.Client{Timeout: 10 * time.Second}
@@ -171,12 +172,13 @@ func (s *SCIONImgBuildController) GetAvailableDevices(w http.ResponseWriter, r *
 }
 
 func (s *SCIONImgBuildController) GenerateImage(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Got request to generate image!")
+	log := logger.FromContext(r.Context())
+	log.Infof("Got request to generate image!")
 
 	// Get user session
 	_, uSess, err := middleware.GetUserSession(r)
 	if err != nil {
-		log.Printf("Error getting the user session: %v", err)
+		log.Errorf("Error getting the user session: %v", err)
 		s.Forbidden(w, err, "Error getting the user session")
 		return
 	}
@@ -186,33 +188,36 @@ func (s *SCIONImgBuildController) GenerateImage(w http.ResponseWriter, r *http.R
 	asIDStr := vars["as_id"]
 	asID, err := utility.ASIDFromString(asIDStr)
 	if err != nil {
-		log.Println(err.Error())
+		log.Errorf("%v", err)
 		s.BadRequest(w, err, "Bad Format")
 		return
 	}
-	as, err := models.FindSCIONLabASByUserEmailAndASID(uSess.Email, asID)
-	if err != nil || as.Status == models.Inactive || as.Status == models.Remove {
-		log.Printf("No active configuration found for user %v with asId %v\n", uSess.Email, asID)
+	as := memberAS(r.Context(), s.HTTPController, w, uSess.Email, asID, models.MemberMaintainer)
+	if as == nil {
+		return
+	}
+	if as.Status == models.Inactive || as.Status == models.Remove {
+		log.Infof("No active configuration found for user %v with asId %v\n", uSess.Email, asID)
 		s.BadRequest(w, nil, "No active configuration found for user %v",
 			uSess.Email)
 		return
 	}
 
 	if as.Type != models.Dedicated {
-		log.Printf("Configuration for selected AS is not made for dedicated system\n")
+		log.Infof("Configuration for selected AS is not made for dedicated system\n")
 		s.BadRequest(w, nil, "You must reconfigure your AS to use dedicated system configuration")
 		return
 	}
 
-	fileName := UserPackageName(uSess.Email, as.ISD, as.ASID) + ".tar.gz"
-	filePath := filepath.Join(PackagePath, fileName)
+	fileName := UserPackageName(as.UserEmail, as.ISD, as.ASID) + ".tar.gz"
+	fileKey := packageKey(as.UserEmail, as.ISD, as.ASID)
 
 	// Get build request
 	var bRequest buildRequest
 	decoder := json.NewDecoder(r.Body)
 
 	if err := decoder.Decode(&bRequest); err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		s.Error500(w, err, "Error decoding build request JSON")
 		return
 	}
@@ -224,8 +229,8 @@ func (s *SCIONImgBuildController) GenerateImage(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	if err := startBuildJob(fileName, filePath, bRequest, buildJobs); err != nil {
-		log.Println(err)
+	if err := startBuildJob(r.Context(), fileName, fileKey, bRequest, buildJobs); err != nil {
+		log.Errorf("%v", err)
 		//TODO: Update last build time in isRateLimited() function atomically so we can avoid race condition
 		s.Error500(w, err, "Error running build job")
 		return
@@ -236,9 +241,10 @@ func (s *SCIONImgBuildController) GenerateImage(w http.ResponseWriter, r *http.R
 	fmt.Fprintln(w, message)
 }
 
-func startBuildJob(configFileName, configFilePath string, bRequest buildRequest, buildJobs *userJobs) error {
+func startBuildJob(ctx context.Context, configFileName, configFileKey string, bRequest buildRequest, buildJobs *userJobs) error {
+	log := logger.FromContext(ctx)
 
-	data, err := ioutil.ReadFile(configFilePath)
+	data, err := storage.ReadAll(Artifacts, configFileKey)
 	if err != nil {
 		return fmt.Errorf("error reading configuration file [%s] %v", configFileName, err)
 	}
@@ -255,7 +261,7 @@ func startBuildJob(configFileName, configFilePath string, bRequest buildRequest,
 
 	url := config.IMGBuilderAddressInternal + "/create/" + bRequest.ImageName
 	req, err := http.NewRequest(http.MethodPost, url, body)
-	log.Printf("Sending request to: %s", url)
+	log.Infof("Sending request to: %s", url)
 	req.Header.Add("Content-Type", writer.FormDataContentType())
 	if err != nil {
 		return err
@@ -291,11 +297,12 @@ func startBuildJob(configFileName, configFilePath string, bRequest buildRequest,
 }
 
 func (s *SCIONImgBuildController) GetUserImages(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Requesting user images")
+	log := logger.FromContext(r.Context())
+	log.Infof("Requesting user images")
 
 	_, userSession, err := middleware.GetUserSession(r)
 	if err != nil {
-		log.Printf("Error getting the user session: %v", err)
+		log.Errorf("Error getting the user session: %v", err)
 		s.Forbidden(w, err, "Error getting the user session")
 		return
 	}
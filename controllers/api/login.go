@@ -15,13 +15,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/netsec-ethz/scion-coord/config"
 	"github.com/netsec-ethz/scion-coord/controllers"
 	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/email"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/throttle"
 )
 
 const (
@@ -33,6 +40,57 @@ type LoginController struct {
 	controllers.HTTPController
 }
 
+const (
+	// time to enter the second factor after the password
+	twoFactorLoginTimeout = 5 * time.Minute
+	// wrong second factors accepted before the password has to be entered again
+	maxTwoFactorAttempts = 5
+)
+
+// ipLoginThrottle tracks the failed logins per source address, so that guessing the passwords of
+// many users is slowed down as well
+var ipLoginThrottle = throttle.NewTracker(throttle.Policy{
+	FreeAttempts:    config.LoginIPFreeAttempts,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutAttempts: config.LoginIPLockoutAttempts,
+	Lockout:         time.Duration(config.LoginLockoutMinutes) * time.Minute,
+	Window:          time.Hour,
+})
+
+// accountLockedMailData fills the template account_locked.html
+type accountLockedMailData struct {
+	FirstName   string
+	LastName    string
+	HostAddress string
+	LockedUntil string
+	Attempts    int
+}
+
+// notifyLockout informs the user that their account was locked out after failed logins
+func notifyLockout(ctx context.Context, firstName, lastName, userEmail string, until time.Time) {
+	log := logger.FromContext(ctx)
+	data := accountLockedMailData{
+		FirstName:   firstName,
+		LastName:    lastName,
+		HostAddress: config.HTTPHostAddress,
+		LockedUntil: until.UTC().Format("2006-01-02 15:04 MST"),
+		Attempts:    config.LoginLockoutAttempts,
+	}
+	if err := email.ConstructFromTemplateAndSend(ctx, "account_locked.html",
+		"[SCIONLab] Your account was locked after failed logins", data, "account-locked",
+		userEmail, false); err != nil {
+		log.Errorf("Error notifying %v about the lockout: %v", userEmail, err)
+	}
+}
+
+// tooManyAttempts responds that the client has to wait before the next login attempt
+func (c *LoginController) tooManyAttempts(w http.ResponseWriter, r *http.Request,
+	wait time.Duration, desc string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.Error(w, r, nil, http.StatusTooManyRequests, desc)
+}
+
 type user struct {
 	Email        string
 	Password     string
@@ -41,15 +99,61 @@ type user struct {
 	IsAdmin      bool
 	Account      string
 	Organisation string
+	// the password was correct, the login has to be completed with LoginSecondFactor
+	TwoFactorRequired bool `json:",omitempty"`
+	// the admin privileges are only granted after enabling two-factor authentication
+	TwoFactorEnrolmentRequired bool `json:",omitempty"`
+	// permissions granted through roles, which decide about the sections of the admin page
+	Permissions []string
+	// the requested new email address, until the change is confirmed
+	PendingEmail string `json:",omitempty"`
+}
+
+type secondFactorRequest struct {
+	Code string
+}
+
+// completeLogin marks the session as logged in for the user with the email and returns the
+// data of the user for the front end
+func completeLogin(userSession *models.Session, email string) (user, error) {
+	dbUser, err := models.FindUserByEmail(email)
+	if err != nil {
+		return user{}, err
+	}
+	userSession.UserID = dbUser.ID
+	userSession.Email = dbUser.Email
+	userSession.HasLoggedIn = true
+	userSession.IsAdmin = dbUser.HasAdminPrivileges()
+	userSession.First = dbUser.FirstName
+	userSession.Last = dbUser.LastName
+	userSession.Organisation = dbUser.Account.Organisation
+	userSession.TwoFactorPending = false
+	userSession.TwoFactorAttempts = 0
+
+	grants, err := dbUser.Grants()
+	if err != nil {
+		return user{}, err
+	}
+
+	return user{
+		Email:                      dbUser.Email,
+		FirstName:                  dbUser.FirstName,
+		LastName:                   dbUser.LastName,
+		IsAdmin:                    userSession.IsAdmin,
+		Organisation:               dbUser.Account.Organisation,
+		TwoFactorEnrolmentRequired: dbUser.IsAdmin && !userSession.IsAdmin,
+		Permissions:                grants.Permissions(),
+	}, nil
 }
 
 func (c *LoginController) Logout(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	// get the current user session if present.
 	// if not then, abort
 	session, userSession, err := middleware.GetUserSession(r)
 
 	if err != nil || userSession == nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Forbidden(w, err, "Error getting user session")
 		return
 	}
@@ -66,13 +170,14 @@ func (c *LoginController) Logout(w http.ResponseWriter, r *http.Request) {
 
 // This method is used to validate username and password
 func (c *LoginController) Login(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 
 	// get the current user session if present.
 	// if not then, abort
 	session, userSession, err := middleware.GetUserSession(r)
 
 	if err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Forbidden(w, err, "Error getting user session")
 		return
 	}
@@ -106,7 +211,15 @@ func (c *LoginController) Login(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	ip := middleware.SourceIP(r)
+	if wait, _ := ipLoginThrottle.Wait(ip, time.Now()); wait > 0 {
+		log.Warnf("Login of %v from %v throttled for %v", email, ip, wait)
+		c.tooManyAttempts(w, r, wait, "Too many failed logins from your address, please try again later")
+		return
+	}
+
 	authFailed := func(err error) {
+		ipLoginThrottle.Fail(ip, time.Now())
 		c.Forbidden(w, err, "Authentication failed for user %v", email)
 	}
 
@@ -115,61 +228,183 @@ func (c *LoginController) Login(w http.ResponseWriter, r *http.Request) {
 	// otherwise redirect to the home page
 	dbUser, err := models.FindUserByEmail(email)
 	if err != nil || dbUser == nil {
-		log.Printf("User %v not found in database: %v", email, err)
+		log.Warnf("User %v not found in database: %v", email, err)
 		authFailed(err)
 		return
 	}
 
 	// if stored password is invalid due to reset or pre-approved registration
 	if dbUser.PasswordInvalid {
-		log.Printf("Password is not set for user %v.", dbUser.Email)
+		log.Warnf("Password is not set for user %v.", dbUser.Email)
 		authFailed(nil)
 		return
 	}
 
 	// if the authentication fails
 	if err := dbUser.Authenticate(password); err != nil {
-		log.Printf("Authentication failed for user %v: %v", dbUser.Email, err)
+		log.Warnf("Authentication failed for user %v: %v", dbUser.Email, err)
+		if throttled, ok := err.(*models.LoginThrottledError); ok {
+			if throttled.NewLock {
+				ipLoginThrottle.Fail(ip, time.Now())
+				notifyLockout(r.Context(), dbUser.FirstName, dbUser.LastName, dbUser.Email,
+					dbUser.LockedUntil)
+			}
+			c.tooManyAttempts(w, r, throttled.Wait,
+				"Too many failed logins, please try again later")
+			return
+		}
 		authFailed(err)
 		return
 	}
 
-	// otherwise just continue, because the authentication succeeded
-	// TODO: rotate the session
-	userSession.Email = dbUser.Email
-	userSession.HasLoggedIn = true
-	userSession.IsAdmin = dbUser.IsAdmin
-	userSession.First = dbUser.FirstName
-	userSession.Last = dbUser.LastName
-	userSession.Organisation = dbUser.Account.Organisation
+	// with two-factor authentication, the login is completed by LoginSecondFactor
+	if dbUser.TOTPEnabled {
+		userSession.Email = dbUser.Email
+		userSession.HasLoggedIn = false
+		userSession.IsAdmin = false
+		userSession.TwoFactorPending = true
+		userSession.TwoFactorStarted = time.Now()
+		userSession.TwoFactorAttempts = 0
+		session.Values[middleware.ScionSessionName] = userSession
+		if err := session.Save(r, w); err != nil {
+			log.Errorf("Error while saving the session: %v", err)
+			c.Error500(w, err, "Error while saving the session")
+			return
+		}
+		// clean up the password
+		user.Password = ""
+		user.TwoFactorRequired = true
+		c.JSON(&user, w, r)
+		return
+	}
 
-	// fill in the properties of the struct to return to the front end app
-	user.FirstName = dbUser.FirstName
-	user.LastName = dbUser.LastName
-	user.IsAdmin = dbUser.IsAdmin
-	user.Organisation = dbUser.Account.Organisation
+	// otherwise just continue, because the authentication succeeded
+	if err := dbUser.ResetFailedLogins(); err != nil {
+		log.Errorf("Error resetting the failed logins of %v: %v", dbUser.Email, err)
+	}
 
-	// clean up the password
-	user.Password = ""
+	// a new session key prevents that a key obtained before the login can be used
+	if err := middleware.RotateSession(session); err != nil {
+		log.Errorf("Error rotating the session: %v", err)
+		c.Error500(w, err, "Error while saving the session")
+		return
+	}
+	loggedIn, err := completeLogin(userSession, dbUser.Email)
+	if err != nil {
+		log.Errorf("Error loading user %v: %v", dbUser.Email, err)
+		c.Error500(w, err, "Error loading the user")
+		return
+	}
 
 	// set the session value
 	session.Values[middleware.ScionSessionName] = userSession
 
 	// save the session status
 	if err := session.Save(r, w); err != nil {
-		log.Printf("Error while saving the session: %v", err)
+		log.Errorf("Error while saving the session: %v", err)
 		c.Error500(w, err, "Error while saving the session")
 		return
 	}
 
-	// if the user session is valid and the user is logged in, then continue, otherwise redirect to the home page
-	if userSession != nil && userSession.HasLoggedIn {
-		// the session is valid, therefore continue
-		c.JSON(&user, w, r)
-	} else {
-		log.Println("Authentication error")
-		c.Forbidden(w, nil, "Authentication error")
+	c.JSON(&loggedIn, w, r)
+}
+
+// LoginSecondFactor completes a login of a user with two-factor authentication after the
+// password was accepted by Login. It takes a TOTP code or a recovery code.
+func (c *LoginController) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	session, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
 		return
 	}
+	if !userSession.TwoFactorPending {
+		c.Forbidden(w, nil, "No login waiting for a second factor")
+		return
+	}
+
+	saveSession := func() bool {
+		session.Values[middleware.ScionSessionName] = userSession
+		if err := session.Save(r, w); err != nil {
+			log.Errorf("Error while saving the session: %v", err)
+			c.Error500(w, err, "Error while saving the session")
+			return false
+		}
+		return true
+	}
 
+	if time.Since(userSession.TwoFactorStarted) > twoFactorLoginTimeout ||
+		userSession.TwoFactorAttempts >= maxTwoFactorAttempts {
+		log.Warnf("Second factor of user %v not entered in time", userSession.Email)
+		userSession.TwoFactorPending = false
+		if saveSession() {
+			c.Forbidden(w, nil, "The login expired, please enter your password again")
+		}
+		return
+	}
+
+	var req secondFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+
+	dbUser, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		log.Warnf("User %v not found in database: %v", userSession.Email, err)
+		c.Forbidden(w, err, "Authentication failed")
+		return
+	}
+	// the pending login is not revoked with the sessions of the user
+	if dbUser.Disabled {
+		log.Warnf("Second factor of disabled user %v rejected", dbUser.Email)
+		c.Forbidden(w, models.ErrUserDisabled, "Authentication failed")
+		return
+	}
+	now := time.Now()
+	if err := dbUser.CheckLoginThrottle(now); err != nil {
+		userSession.TwoFactorPending = false
+		if saveSession() {
+			c.tooManyAttempts(w, r, err.(*models.LoginThrottledError).Wait,
+				"Too many failed logins, please try again later")
+		}
+		return
+	}
+	if err := dbUser.CheckSecondFactor(req.Code, now); err != nil {
+		log.Warnf("Second factor of user %v rejected: %v", userSession.Email, err)
+		ipLoginThrottle.Fail(middleware.SourceIP(r), now)
+		userSession.TwoFactorAttempts++
+		if lockErr := dbUser.RecordFailedLogin(now); lockErr != nil {
+			log.Warnf("Failed second factor of user %v: %v", userSession.Email, lockErr)
+			if throttled, ok := lockErr.(*models.LoginThrottledError); ok && throttled.NewLock {
+				notifyLockout(r.Context(), dbUser.FirstName, dbUser.LastName, dbUser.Email,
+					dbUser.LockedUntil)
+				userSession.TwoFactorPending = false
+			}
+		}
+		if saveSession() {
+			c.Forbidden(w, err, "Invalid authentication code")
+		}
+		return
+	}
+	if err := dbUser.ResetFailedLogins(); err != nil {
+		log.Errorf("Error resetting the failed logins of %v: %v", dbUser.Email, err)
+	}
+
+	// a new session key prevents that a key obtained before the login can be used
+	if err := middleware.RotateSession(session); err != nil {
+		log.Errorf("Error rotating the session: %v", err)
+		c.Error500(w, err, "Error while saving the session")
+		return
+	}
+	loggedIn, err := completeLogin(userSession, dbUser.Email)
+	if err != nil {
+		log.Errorf("Error loading user %v: %v", dbUser.Email, err)
+		c.Error500(w, err, "Error loading the user")
+		return
+	}
+	if saveSession() {
+		c.JSON(&loggedIn, w, r)
+	}
 }
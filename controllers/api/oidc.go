@@ -0,0 +1,252 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/oidc"
+)
+
+// time the user has to log in at the provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// oidcProviders are the configured OpenID Connect providers by ID
+var oidcProviders = make(map[string]*oidc.Provider)
+
+func init() {
+	for _, p := range config.OIDCProviders {
+		oidcProviders[p.ID] = &oidc.Provider{
+			ID:           p.ID,
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			Scopes:       p.Scopes,
+			Client:       &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+}
+
+// oidcRedirectURI is the redirect URI registered with the provider
+func oidcRedirectURI(provider string) string {
+	return config.HTTPHostAddress + "/api/login/oidc/" + url.PathEscape(provider) + "/callback"
+}
+
+// oidcProviderInfo is a provider shown on the login page
+type oidcProviderInfo struct {
+	ID   string
+	Name string
+}
+
+// OIDCProviders lists the OpenID Connect providers users can log in with
+func (c *LoginController) OIDCProviders(w http.ResponseWriter, r *http.Request) {
+	providers := []oidcProviderInfo{}
+	for _, p := range config.OIDCProviders {
+		providers = append(providers, oidcProviderInfo{ID: p.ID, Name: p.Name})
+	}
+	c.JSON(providers, w, r)
+}
+
+// oidcLoginFailed sends the user back to the login page, which shows the message
+func oidcLoginFailed(w http.ResponseWriter, r *http.Request, message string) {
+	http.Redirect(w, r, "/#/login?error="+url.QueryEscape(message), http.StatusFound)
+}
+
+// OIDCLogin redirects the user to the authorization endpoint of the provider. The state, the
+// nonce and the PKCE code verifier of the request are kept in the session for OIDCCallback.
+func (c *LoginController) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	provider, ok := oidcProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		c.NotFound(w, nil, "Unknown provider")
+		return
+	}
+	session, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		if values[i], err = oidc.RandomString(32); err != nil {
+			c.Error500(w, err, "Error generating the login request")
+			return
+		}
+	}
+	authURL, err := provider.AuthCodeURL(oidcRedirectURI(provider.ID), values[0], values[1],
+		values[2])
+	if err != nil {
+		log.Errorf("Error preparing the login with %v: %v", provider.ID, err)
+		oidcLoginFailed(w, r, "The login provider is not available, please try again later")
+		return
+	}
+
+	userSession.OIDCProvider = provider.ID
+	userSession.OIDCState = values[0]
+	userSession.OIDCNonce = values[1]
+	userSession.OIDCVerifier = values[2]
+	userSession.OIDCStarted = time.Now()
+	session.Values[middleware.ScionSessionName] = userSession
+	if err := session.Save(r, w); err != nil {
+		log.Errorf("Error while saving the session: %v", err)
+		c.Error500(w, err, "Error while saving the session")
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes the login after the provider redirected the user back. The user is
+// found through the linked identity or the verified email address, or created; with two-factor
+// authentication enabled, the login is completed by LoginSecondFactor.
+func (c *LoginController) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	provider, ok := oidcProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		c.NotFound(w, nil, "Unknown provider")
+		return
+	}
+	session, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+
+	// the login request can only be completed once
+	state, nonce, verifier := userSession.OIDCState, userSession.OIDCNonce,
+		userSession.OIDCVerifier
+	expected := userSession.OIDCProvider == provider.ID && state != "" &&
+		time.Since(userSession.OIDCStarted) < oidcLoginTimeout
+	userSession.OIDCProvider = ""
+	userSession.OIDCState = ""
+	userSession.OIDCNonce = ""
+	userSession.OIDCVerifier = ""
+	session.Values[middleware.ScionSessionName] = userSession
+	if err := session.Save(r, w); err != nil {
+		log.Errorf("Error while saving the session: %v", err)
+		c.Error500(w, err, "Error while saving the session")
+		return
+	}
+
+	q := r.URL.Query()
+	if !expected || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+		log.Warnf("Unexpected login response of %v", provider.ID)
+		oidcLoginFailed(w, r, "The login expired, please try again")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		log.Warnf("Login with %v failed: %v %v", provider.ID, e, q.Get("error_description"))
+		oidcLoginFailed(w, r, "The login was not completed at "+provider.Name)
+		return
+	}
+
+	ip := middleware.SourceIP(r)
+	now := time.Now()
+	if wait, _ := ipLoginThrottle.Wait(ip, now); wait > 0 {
+		log.Warnf("Login with %v from %v throttled for %v", provider.ID, ip, wait)
+		oidcLoginFailed(w, r, "Too many failed logins from your address, please try again later")
+		return
+	}
+	claims, err := provider.Exchange(q.Get("code"), oidcRedirectURI(provider.ID), verifier, nonce,
+		now)
+	if err != nil {
+		log.Errorf("Error completing the login with %v: %v", provider.ID, err)
+		ipLoginThrottle.Fail(ip, now)
+		oidcLoginFailed(w, r, "The login with "+provider.Name+" failed")
+		return
+	}
+	// the email is used as account name and organisation of new users, as the providers do
+	// not tell the organisation
+	dbUser, created, err := models.OIDCLogin(provider.ID, claims.Subject, claims.Email,
+		bool(claims.EmailVerified), claims.GivenName, claims.FamilyName, claims.Email)
+	if err != nil {
+		log.Warnf("Login of %v with %v rejected: %v", claims.Subject, provider.ID, err)
+		if err == models.ErrEmailNotVerified {
+			oidcLoginFailed(w, r, provider.Name+" did not confirm your email address")
+		} else {
+			oidcLoginFailed(w, r, "The login with "+provider.Name+" failed")
+		}
+		return
+	}
+	if created {
+		log.Infof("Created user %v at the first login with %v", dbUser.Email, provider.ID)
+	}
+	if dbUser.DeletionPending() {
+		oidcLoginFailed(w, r, "Your account is being deleted")
+		return
+	}
+	if dbUser.Disabled {
+		oidcLoginFailed(w, r, "Your login has been disabled, please contact the administrators")
+		return
+	}
+	if err := dbUser.CheckLoginThrottle(now); err != nil {
+		oidcLoginFailed(w, r, "Too many failed logins, please try again later")
+		return
+	}
+
+	// with two-factor authentication, the login is completed by LoginSecondFactor
+	if dbUser.TOTPEnabled {
+		userSession.Email = dbUser.Email
+		userSession.HasLoggedIn = false
+		userSession.IsAdmin = false
+		userSession.TwoFactorPending = true
+		userSession.TwoFactorStarted = now
+		userSession.TwoFactorAttempts = 0
+		session.Values[middleware.ScionSessionName] = userSession
+		if err := session.Save(r, w); err != nil {
+			log.Errorf("Error while saving the session: %v", err)
+			c.Error500(w, err, "Error while saving the session")
+			return
+		}
+		http.Redirect(w, r, "/#/login?twoFactor=1", http.StatusFound)
+		return
+	}
+
+	// a new session key prevents that a key obtained before the login can be used
+	if err := middleware.RotateSession(session); err != nil {
+		log.Errorf("Error rotating the session: %v", err)
+		c.Error500(w, err, "Error while saving the session")
+		return
+	}
+	loggedIn, err := completeLogin(userSession, dbUser.Email)
+	if err != nil {
+		log.Errorf("Error loading user %v: %v", dbUser.Email, err)
+		c.Error500(w, err, "Error loading the user")
+		return
+	}
+	session.Values[middleware.ScionSessionName] = userSession
+	if err := session.Save(r, w); err != nil {
+		log.Errorf("Error while saving the session: %v", err)
+		c.Error500(w, err, "Error while saving the session")
+		return
+	}
+	if loggedIn.TwoFactorEnrolmentRequired {
+		// admins have to enable two-factor authentication first
+		http.Redirect(w, r, "/#/account", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/#/user", http.StatusFound)
+}
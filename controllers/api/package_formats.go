@@ -0,0 +1,238 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/utility"
+)
+
+var templatesPath = filepath.Join(scionCoordPath, "templates")
+
+// Names of the package formats
+const (
+	VagrantFormat       = "vagrant"
+	DedicatedFormat     = "dedicated"
+	DockerComposeFormat = "docker-compose"
+	SystemdFormat       = "systemd"
+)
+
+// PackageFormat determines which files, besides the gen folder and the VPN configuration,
+// are shipped in the configuration package of a user AS.
+type PackageFormat interface {
+	// Name identifies the format; it is stored in models.SCIONLabAS.PackageFormat
+	Name() string
+	// Supports tells whether the format can be used for ASes of the given type
+	Supports(asType uint8) bool
+	// AddFiles writes the files of the format to the package directory of the AS
+	AddFiles(asInfo *SCIONLabASInfo) error
+}
+
+var packageFormats = make(map[string]PackageFormat)
+
+// default format of each AS type, used if the AS has none stored
+var defaultPackageFormats = map[uint8]string{
+	models.VM:        VagrantFormat,
+	models.Dedicated: DedicatedFormat,
+}
+
+func registerPackageFormat(f PackageFormat) {
+	packageFormats[f.Name()] = f
+}
+
+func init() {
+	registerPackageFormat(vagrantFormat{})
+	registerPackageFormat(dedicatedFormat{})
+	registerPackageFormat(dockerComposeFormat{})
+	registerPackageFormat(systemdFormat{})
+}
+
+// GetPackageFormat returns the format with the given name, or the default format of the AS
+// type if the name is empty. It fails if the format cannot be used for this AS type.
+func GetPackageFormat(name string, asType uint8) (PackageFormat, error) {
+	if name == "" {
+		name = defaultPackageFormats[asType]
+	}
+	f, ok := packageFormats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown package format \"%v\"", name)
+	}
+	if !f.Supports(asType) {
+		return nil, fmt.Errorf("the package format \"%v\" is not available for ASes of type %v",
+			name, asType)
+	}
+	return f, nil
+}
+
+// PackageFormatNames returns the sorted names of the formats available for the AS type
+func PackageFormatNames(asType uint8) []string {
+	var names []string
+	for name, f := range packageFormats {
+		if f.Supports(asType) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// copyFiles copies the named files from srcDir to dstDir
+func copyFiles(srcDir, dstDir string, names ...string) error {
+	for _, name := range names {
+		src := filepath.Join(srcDir, name)
+		dst := filepath.Join(dstDir, name)
+		if err := utility.CopyFile(src, dst); err != nil {
+			return fmt.Errorf("failed to copy %v to %v: %v", src, dst, err)
+		}
+	}
+	return nil
+}
+
+// vagrantFormat ships a Vagrantfile which sets up a VM running the AS
+type vagrantFormat struct{}
+
+func (vagrantFormat) Name() string {
+	return VagrantFormat
+}
+
+func (vagrantFormat) Supports(asType uint8) bool {
+	return asType == models.VM
+}
+
+func (vagrantFormat) AddFiles(asInfo *SCIONLabASInfo) error {
+	userPackagePath := asInfo.UserPackagePath()
+	objects, err := filepath.Glob(filepath.Join(vagrantPath, "*"))
+	if err != nil {
+		return fmt.Errorf("failed to read directory contents. Path: %v, %v", vagrantPath, err)
+	}
+	var names []string
+	for _, obj := range objects {
+		if info, err := os.Stat(obj); err == nil && !info.IsDir() {
+			names = append(names, filepath.Base(obj))
+		}
+	}
+	if err = copyFiles(vagrantPath, userPackagePath, names...); err != nil {
+		return fmt.Errorf("failed to copy files for user %v: %v", asInfo.LocalAS.UserEmail, err)
+	}
+	portForwarding := ""
+	if !asInfo.IsVPN {
+		portForwarding = fmt.Sprintf("config.vm.network \"forwarded_port\", "+
+			"guest: %[1]v, host: %[1]v, protocol: \"udp\"", asInfo.LocalPort)
+	}
+	data := struct {
+		ASID           string
+		PortForwarding string
+		BRPort         uint16
+	}{
+		ASID:           asInfo.LocalAS.ASID.FileFmt(),
+		PortForwarding: portForwarding,
+		BRPort:         asInfo.LocalAS.ServicePortBase() + 1,
+	}
+	return utility.FillTemplateAndSave(filepath.Join(templatesPath, "Vagrantfile.tmpl"),
+		data, filepath.Join(userPackagePath, "Vagrantfile"))
+}
+
+// dedicatedFormat ships only a README; SCION has to be installed manually
+type dedicatedFormat struct{}
+
+func (dedicatedFormat) Name() string {
+	return DedicatedFormat
+}
+
+func (dedicatedFormat) Supports(asType uint8) bool {
+	return asType == models.Dedicated
+}
+
+func (dedicatedFormat) AddFiles(asInfo *SCIONLabASInfo) error {
+	return copyFiles(filepath.Join(auxFilesPath, "dedicated_box"), asInfo.UserPackagePath(),
+		"README.md")
+}
+
+// dockerComposeFormat ships a docker-compose file running the AS in a container
+type dockerComposeFormat struct{}
+
+func (dockerComposeFormat) Name() string {
+	return DockerComposeFormat
+}
+
+func (dockerComposeFormat) Supports(asType uint8) bool {
+	return asType == models.Dedicated
+}
+
+func (dockerComposeFormat) AddFiles(asInfo *SCIONLabASInfo) error {
+	userPackagePath := asInfo.UserPackagePath()
+	data := struct {
+		IA          string
+		ASID        string
+		Image       string
+		IsVPN       bool
+		IsWireGuard bool
+	}{
+		IA:          asInfo.LocalAS.IAString(),
+		ASID:        asInfo.LocalAS.ASID.FileFmt(),
+		Image:       config.DockerImage,
+		IsVPN:       asInfo.IsVPN,
+		IsWireGuard: asInfo.VPNType == models.WireGuard,
+	}
+	if err := utility.FillTemplateAndSave(filepath.Join(templatesPath, "docker-compose.yml.tmpl"),
+		data, filepath.Join(userPackagePath, "docker-compose.yml")); err != nil {
+		return err
+	}
+	return copyFiles(filepath.Join(auxFilesPath, "docker_bundle"), userPackagePath, "README.md")
+}
+
+// systemdFormat ships the systemd units and a script installing SCION on an existing host
+type systemdFormat struct{}
+
+func (systemdFormat) Name() string {
+	return SystemdFormat
+}
+
+func (systemdFormat) Supports(asType uint8) bool {
+	return asType == models.Dedicated
+}
+
+func (systemdFormat) AddFiles(asInfo *SCIONLabASInfo) error {
+	userPackagePath := asInfo.UserPackagePath()
+	// the units are the same as the ones installed in the VM
+	if err := copyFiles(vagrantPath, userPackagePath, "scion.service", "scion-viz.service",
+		"scionupgrade.service", "scionupgrade.timer", "scionupgrade.sh"); err != nil {
+		return fmt.Errorf("failed to copy files for user %v: %v", asInfo.LocalAS.UserEmail, err)
+	}
+	installScript := filepath.Join(userPackagePath, "install.sh")
+	data := struct {
+		IA          string
+		IsVPN       bool
+		IsWireGuard bool
+	}{
+		IA:          asInfo.LocalAS.IAString(),
+		IsVPN:       asInfo.IsVPN,
+		IsWireGuard: asInfo.VPNType == models.WireGuard,
+	}
+	if err := utility.FillTemplateAndSave(filepath.Join(templatesPath, "systemd_install.sh.tmpl"),
+		data, installScript); err != nil {
+		return err
+	}
+	if err := os.Chmod(installScript, 0755); err != nil {
+		return fmt.Errorf("failed to make %v executable: %v", installScript, err)
+	}
+	return copyFiles(filepath.Join(auxFilesPath, "systemd_bundle"), userPackagePath, "README.md")
+}
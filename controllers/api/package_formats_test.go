@@ -0,0 +1,192 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/netsec-ethz/scion-coord/models"
+)
+
+// preparePackageDir points PackagePath to a temporary directory and creates a package
+// directory for the AS containing a gen folder and, for VPN setups, the VPN configuration.
+func preparePackageDir(t *testing.T, asInfo *SCIONLabASInfo) func() {
+	tmp, err := ioutil.TempDir("", "package_formats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPackagePath := PackagePath
+	PackagePath = tmp
+	genPath := filepath.Join(asInfo.UserPackagePath(), "gen")
+	if err = os.MkdirAll(genPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(genPath, "account_id"), []byte("id"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if asInfo.IsVPN {
+		err = ioutil.WriteFile(filepath.Join(asInfo.UserPackagePath(), "client.conf"), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		PackagePath = oldPackagePath
+		os.RemoveAll(tmp)
+	}
+}
+
+// packageTree returns the sorted relative paths of the files in the package directory
+func packageTree(t *testing.T, asInfo *SCIONLabASInfo) []string {
+	root := asInfo.UserPackagePath()
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		files = append(files, rel)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func readPackageFile(t *testing.T, asInfo *SCIONLabASInfo, name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(asInfo.UserPackagePath(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestGetPackageFormat(t *testing.T) {
+	cases := []struct {
+		name     string
+		asType   uint8
+		expected string
+		fails    bool
+	}{
+		{"", models.VM, VagrantFormat, false},
+		{"", models.Dedicated, DedicatedFormat, false},
+		{SystemdFormat, models.Dedicated, SystemdFormat, false},
+		{DockerComposeFormat, models.Dedicated, DockerComposeFormat, false},
+		{VagrantFormat, models.Dedicated, "", true},
+		{DockerComposeFormat, models.VM, "", true},
+		{"", models.Box, "", true},
+		{"zip", models.Dedicated, "", true},
+	}
+	for _, c := range cases {
+		f, err := GetPackageFormat(c.name, c.asType)
+		if c.fails {
+			if err == nil {
+				t.Errorf("GetPackageFormat(%q, %v) should fail, got %v", c.name, c.asType,
+					f.Name())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("GetPackageFormat(%q, %v) failed: %v", c.name, c.asType, err)
+		} else if f.Name() != c.expected {
+			t.Errorf("GetPackageFormat(%q, %v) returned %v, expected %v", c.name, c.asType,
+				f.Name(), c.expected)
+		}
+	}
+}
+
+func TestPackageFormatFiles(t *testing.T) {
+	cases := []struct {
+		format   string
+		asType   uint8
+		isVPN    bool
+		expected []string
+	}{
+		{VagrantFormat, models.VM, false, []string{"README.md", "Vagrantfile", "gen/account_id",
+			"run.sh", "scion-viz.service", "scion.service", "scionupgrade.service",
+			"scionupgrade.sh", "scionupgrade.timer"}},
+		{VagrantFormat, models.VM, true, []string{"README.md", "Vagrantfile", "client.conf",
+			"gen/account_id", "run.sh", "scion-viz.service", "scion.service",
+			"scionupgrade.service", "scionupgrade.sh", "scionupgrade.timer"}},
+		{DedicatedFormat, models.Dedicated, false, []string{"README.md", "gen/account_id"}},
+		{DockerComposeFormat, models.Dedicated, false, []string{"README.md",
+			"docker-compose.yml", "gen/account_id"}},
+		{DockerComposeFormat, models.Dedicated, true, []string{"README.md", "client.conf",
+			"docker-compose.yml", "gen/account_id"}},
+		{SystemdFormat, models.Dedicated, false, []string{"README.md", "gen/account_id",
+			"install.sh", "scion-viz.service", "scion.service", "scionupgrade.service",
+			"scionupgrade.sh", "scionupgrade.timer"}},
+	}
+	for _, c := range cases {
+		asInfo := &SCIONLabASInfo{
+			IsVPN:     c.isVPN,
+			LocalPort: 50000,
+			LocalAS: &models.SCIONLabAS{
+				UserEmail:     "user@example.com",
+				ISD:           1,
+				ASID:          0xffaa00010001,
+				Type:          c.asType,
+				PackageFormat: c.format,
+			},
+		}
+		cleanup := preparePackageDir(t, asInfo)
+		f, err := GetPackageFormat(c.format, c.asType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.AddFiles(asInfo); err != nil {
+			t.Errorf("%v (VPN: %v): AddFiles failed: %v", c.format, c.isVPN, err)
+			cleanup()
+			continue
+		}
+		files := packageTree(t, asInfo)
+		if !reflect.DeepEqual(files, c.expected) {
+			t.Errorf("%v (VPN: %v): package contains %v, expected %v", c.format, c.isVPN,
+				files, c.expected)
+		}
+		switch c.format {
+		case VagrantFormat:
+			vagrantfile := readPackageFile(t, asInfo, "Vagrantfile")
+			if !strings.Contains(vagrantfile, "SCIONLabVM-ffaa_1_1") {
+				t.Errorf("Vagrantfile does not name the VM after the AS:\n%s", vagrantfile)
+			}
+			if forwarded := strings.Contains(vagrantfile, "guest: 50000"); forwarded == c.isVPN {
+				t.Errorf("Vagrantfile (VPN: %v) forwards the BR port: %v", c.isVPN, forwarded)
+			}
+		case DockerComposeFormat:
+			compose := readPackageFile(t, asInfo, "docker-compose.yml")
+			if mounted := strings.Contains(compose, "./client.conf:"); mounted != c.isVPN {
+				t.Errorf("docker-compose.yml (VPN: %v) mounts client.conf: %v", c.isVPN, mounted)
+			}
+		case SystemdFormat:
+			info, err := os.Stat(filepath.Join(asInfo.UserPackagePath(), "install.sh"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode()&0100 == 0 {
+				t.Errorf("install.sh is not executable: %v", info.Mode())
+			}
+		}
+		cleanup()
+	}
+}
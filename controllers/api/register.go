@@ -15,10 +15,10 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"strings"
 
@@ -27,6 +27,7 @@ import (
 	"github.com/netsec-ethz/scion-coord/config"
 	"github.com/netsec-ethz/scion-coord/controllers"
 	"github.com/netsec-ethz/scion-coord/email"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
 )
 
@@ -86,10 +87,11 @@ func (r *registrationRequest) isValid() error {
 
 // Method used to reset password and send user an email
 func (c *RegistrationController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 
 	// parse the form value
 	if err := r.ParseForm(); err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Error500(w, err, "Parsing form values failed.")
 		return
 	}
@@ -97,22 +99,26 @@ func (c *RegistrationController) ResetPassword(w http.ResponseWriter, r *http.Re
 	displayedError := "Error resetting password"
 
 	userEmail := r.FormValue("userEmail")
-	u, err := models.FindUserByEmail(userEmail)
-	if err != nil {
-		log.Println(err)
+	if err := resetPassword(r.Context(), userEmail); err != nil {
+		log.Errorf("Error resetting the password of user %v: %v", userEmail, err)
 		c.BadRequest(w, err, displayedError)
 		return
 	}
 
+	return
+}
+
+// resetPassword invalidates the password of the user and sends a link to set a new one
+func resetPassword(ctx context.Context, userEmail string) error {
+	u, err := models.FindUserByEmail(userEmail)
+	if err != nil {
+		return err
+	}
 	if err = u.ResetUUID(); err != nil {
-		log.Printf("Error resetting UUID for user %v: %v", u.Email, err)
-		c.BadRequest(w, err, displayedError)
-		return
+		return fmt.Errorf("error resetting UUID: %v", err)
 	}
 	if err = u.UpdatePassword(""); err != nil {
-		log.Printf("Error resetting password for user %v: %v", u.Email, err)
-		c.BadRequest(w, err, displayedError)
-		return
+		return fmt.Errorf("error resetting password: %v", err)
 	}
 	data := email.MailData{
 		FirstName:        u.FirstName,
@@ -120,23 +126,21 @@ func (c *RegistrationController) ResetPassword(w http.ResponseWriter, r *http.Re
 		HostAddress:      config.HTTPHostAddress,
 		VerificationUUID: u.VerificationUUID,
 	}
-	if err = email.ConstructFromTemplateAndSend(
+	if err = email.ConstructFromTemplateAndSend(ctx,
 		"password_reset.html",
 		"[SCIONLab] Password reset",
 		data,
 		"password-reset",
 		userEmail,
 		false); err != nil {
-		log.Printf("Error sending password-reset email to user %v: %v", u.Email, err)
-		c.BadRequest(w, err, displayedError)
-		return
+		return fmt.Errorf("error sending password-reset email: %v", err)
 	}
-
-	return
+	return nil
 }
 
 // Method used to set password after pre-approved registration or password reset
 func (c *RegistrationController) SetPassword(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 
 	// parse the JSON coming from the client
 	var passRequest passwordRequest
@@ -144,13 +148,13 @@ func (c *RegistrationController) SetPassword(w http.ResponseWriter, r *http.Requ
 
 	// check if the parsing succeeded
 	if err := decoder.Decode(&passRequest); err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Error500(w, err, "Error parsing form values failed")
 		return
 	}
 
 	if err := passwordsAreValid(passRequest.Password, passRequest.PasswordConfirmation); err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Error500(w, err, "Password invalid")
 		return
 	}
@@ -159,7 +163,7 @@ func (c *RegistrationController) SetPassword(w http.ResponseWriter, r *http.Requ
 	user, err := models.FindUserByVerificationUUID(passRequest.UUID)
 
 	if err != nil {
-		log.Printf("Error setting password: %v is not a valid UUID", passRequest.UUID)
+		log.Errorf("Error setting password: %v is not a valid UUID", passRequest.UUID)
 		c.BadRequest(w, nil, "Error verifying email address: %v is not a valid user identifier", passRequest.UUID)
 		return
 	}
@@ -170,7 +174,7 @@ func (c *RegistrationController) SetPassword(w http.ResponseWriter, r *http.Requ
 	}
 
 	if err := user.UpdatePassword(passRequest.Password); err != nil {
-		log.Printf("Error updating the password in the database: %v", err)
+		log.Errorf("Error updating the password in the database: %v", err)
 		c.Error500(w, err, "Error updating the password in the database")
 		return
 	}
@@ -181,6 +185,7 @@ func (c *RegistrationController) SetPassword(w http.ResponseWriter, r *http.Requ
 
 // Method used to validate email address
 func (c *RegistrationController) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 
 	//retrieve submitted uuid
 	uuid := mux.Vars(r)["uuid"]
@@ -189,17 +194,17 @@ func (c *RegistrationController) VerifyEmail(w http.ResponseWriter, r *http.Requ
 	u, err := models.FindUserByVerificationUUID(uuid)
 
 	if err != nil {
-		log.Printf("Error verifying email address: %v is not a valid UUID.", uuid)
+		log.Errorf("Error verifying email address: %v is not a valid UUID.", uuid)
 		c.BadRequest(w, nil, "Error verifying email address: %v is not a valid user identifier", uuid)
 		return
 	}
 
 	if u.Verified {
-		log.Printf("User %v is already verified.", u.Email)
+		log.Infof("User %v is already verified.", u.Email)
 	} else {
 		// update user
 		if err := u.UpdateVerified(true); err != nil {
-			log.Printf("Error verifying email address for user %v: %v.", u.Email, err)
+			log.Errorf("Error verifying email address for user %v: %v.", u.Email, err)
 			// TODO: Pass the user a unique error ID which links to the specific error and allows for debugging
 			c.Error500(w, nil, "Error verifying email address for user %v", u.Email)
 			return
@@ -210,7 +215,7 @@ func (c *RegistrationController) VerifyEmail(w http.ResponseWriter, r *http.Requ
 	// load validation page
 	t, err := template.ParseFiles("templates/layout.html", "templates/verified.html")
 	if err != nil {
-		log.Printf("Error parsing HTML files: %v", err)
+		log.Errorf("Error parsing HTML files: %v", err)
 		c.Error500(w, err, "Error parsing HTML files")
 		return
 	}
@@ -220,6 +225,7 @@ func (c *RegistrationController) VerifyEmail(w http.ResponseWriter, r *http.Requ
 
 // This method is used to register a new account via the standard form
 func (c *RegistrationController) Register(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 
 	// parse the JSON coming from the client
 	var regRequest registrationRequest
@@ -227,14 +233,14 @@ func (c *RegistrationController) Register(w http.ResponseWriter, r *http.Request
 
 	// check if the parsing succeeded
 	if err := decoder.Decode(&regRequest); err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Error500(w, err, "Error decoding JSON")
 		return
 	}
 
 	// validate the data
 	if err := regRequest.isValid(); err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Error500(w, err, "Invalid form data")
 		return
 	}
@@ -245,7 +251,7 @@ func (c *RegistrationController) Register(w http.ResponseWriter, r *http.Request
 		regRequest.Email, regRequest.Password, regRequest.First, regRequest.Last)
 
 	if err != nil {
-		log.Printf("Error registering the user: %v", err)
+		log.Errorf("Error registering the user: %v", err)
 		c.Error500(w, err, "Error registering the user")
 		return
 	} else {
@@ -253,8 +259,8 @@ func (c *RegistrationController) Register(w http.ResponseWriter, r *http.Request
 	}
 
 	// Send email address confirmation link
-	if err := sendVerificationEmail(user.ID); err != nil {
-		log.Printf("Error sending verification email: %v", err)
+	if err := sendVerificationEmail(r.Context(), user.ID); err != nil {
+		log.Errorf("Error sending verification email: %v", err)
 		c.Error500(w, err, "Error sending verification email")
 	}
 
@@ -265,6 +271,7 @@ func (c *RegistrationController) LoadCaptchaSiteKey(w http.ResponseWriter, r *ht
 }
 
 func (c *RegistrationController) ResendActivationLink(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 
 	user, err := models.FindUserByEmail(r.PostFormValue("email"))
 	if err != nil {
@@ -277,8 +284,8 @@ func (c *RegistrationController) ResendActivationLink(w http.ResponseWriter, r *
 		return
 	}
 
-	if err := sendVerificationEmail(user.ID); err != nil {
-		log.Printf("Error sending verification email: %v", err)
+	if err := sendVerificationEmail(r.Context(), user.ID); err != nil {
+		log.Errorf("Error sending verification email: %v", err)
 		c.Error500(w, err, "Error sending verification email")
 		return
 	}
@@ -288,7 +295,7 @@ func (c *RegistrationController) ResendActivationLink(w http.ResponseWriter, r *
 }
 
 // Function which sends verification emails to newly registered users
-func sendVerificationEmail(userID uint64) error {
+func sendVerificationEmail(ctx context.Context, userID uint64) error {
 
 	user, err := models.FindUserByID(fmt.Sprintf("%v", userID))
 	if err != nil {
@@ -302,7 +309,7 @@ func sendVerificationEmail(userID uint64) error {
 		VerificationUUID: user.VerificationUUID,
 	}
 
-	if err := email.ConstructFromTemplateAndSend(
+	if err := email.ConstructFromTemplateAndSend(ctx,
 		"verification.html",
 		"[SCIONLab] Verify your email address for SCIONLab Coordination Service",
 		data,
@@ -0,0 +1,166 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/scionproto/scion/go/lib/addr"
+)
+
+type roleInfo struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+type roleAssignmentInfo struct {
+	ID      uint64
+	Email   string
+	Role    string
+	ISD     addr.ISD // 0 if not limited to an ISD
+	Created time.Time
+}
+
+type rolesData struct {
+	Roles       []roleInfo
+	Assignments []roleAssignmentInfo
+	Permissions []string
+}
+
+type roleRequest struct {
+	Email string
+	Role  string
+	ISD   addr.ISD
+}
+
+// Roles lists the roles with their permissions and the role assignments
+func (c AdminController) Roles(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	roles, err := models.FindRoles()
+	if err != nil {
+		log.Errorf("Error looking up the roles: %v", err)
+		c.Error500(w, err, "Error looking up the roles")
+		return
+	}
+	assignments, err := models.FindRoleAssignments()
+	if err != nil {
+		log.Errorf("Error looking up the role assignments: %v", err)
+		c.Error500(w, err, "Error looking up the role assignments")
+		return
+	}
+	data := rolesData{
+		Roles:       []roleInfo{},
+		Assignments: []roleAssignmentInfo{},
+		Permissions: models.AllPermissions,
+	}
+	for i := range roles {
+		data.Roles = append(data.Roles, roleInfo{
+			Name:        roles[i].Name,
+			Description: roles[i].Description,
+			Permissions: roles[i].PermissionList(),
+		})
+	}
+	for _, a := range assignments {
+		data.Assignments = append(data.Assignments, roleAssignmentInfo{
+			ID:      a.ID,
+			Email:   a.UserEmail(),
+			Role:    a.Role.Name,
+			ISD:     a.ISD,
+			Created: a.Created,
+		})
+	}
+	c.JSON(data, w, r)
+}
+
+// AssignRole gives a role to a user, optionally limited to an ISD
+func (c AdminController) AssignRole(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	a, err := models.AssignRole(req.Email, req.Role, req.ISD)
+	switch err {
+	case nil:
+	case orm.ErrNoRows:
+		c.NotFound(w, err, "Unknown user or role")
+		return
+	case models.ErrRoleAssigned:
+		c.BadRequest(w, err, err.Error())
+		return
+	default:
+		log.Errorf("Error assigning role %v to %v: %v", req.Role, req.Email, err)
+		c.Error500(w, err, "Error assigning the role")
+		return
+	}
+	log.Infof("%v assigned role %v in ISD %v to %v", userSession.Email, req.Role, req.ISD,
+		req.Email)
+	c.JSON(roleAssignmentInfo{
+		ID:      a.ID,
+		Email:   req.Email,
+		Role:    req.Role,
+		ISD:     a.ISD,
+		Created: a.Created,
+	}, w, r)
+}
+
+// RevokeRole deletes a role assignment
+func (c AdminController) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		c.BadRequest(w, err, "Invalid role assignment ID")
+		return
+	}
+	a, err := models.FindRoleAssignmentByID(id)
+	if err != nil {
+		c.NotFound(w, err, "Role assignment not found")
+		return
+	}
+	if a.UserEmail() == userSession.Email && a.Role.Name == models.RoleAdmin {
+		c.BadRequest(w, nil, "You cannot revoke your own admin role")
+		return
+	}
+	if err := a.Delete(); err != nil {
+		log.Errorf("Error revoking role assignment %v: %v", id, err)
+		c.Error500(w, err, "Error revoking the role")
+		return
+	}
+	log.Infof("%v revoked role %v in ISD %v of %v", userSession.Email, a.Role.Name, a.ISD,
+		a.UserEmail())
+	c.JSON(struct{}{}, w, r)
+}
@@ -15,10 +15,10 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"os"
@@ -32,7 +32,10 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/netsec-ethz/scion-coord/config"
 	"github.com/netsec-ethz/scion-coord/controllers"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
 	"github.com/netsec-ethz/scion-coord/utility"
 	"github.com/netsec-ethz/scion-coord/utility/geolocation"
 	"github.com/netsec-ethz/scion-coord/utility/topologyAlgorithm"
@@ -62,17 +65,18 @@ const BASE_ASID_FOR_SCIONBOX = utility.ScionlabUserASOffsetAddr + 0x000000F00000
 //			ISD_ID: 1,
 //		    }
 func (s *SCIONBoxController) InitializeBox(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	// Parse the arguments
 	_, internalIP, externalIP, mac, openPorts, startPort, err := s.parseRequest(r)
 	if err != nil {
-		log.Printf("Error parsing parameters and source IP: %v", err)
+		log.Errorf("Error parsing parameters and source IP: %v", err)
 		s.Error500(w, err, "Error parsing parameters and source IP")
 		return
 	}
 	// Retrieve the SCIONBox information
 	sb, err := models.FindSCIONBoxByMAC(mac)
 	if err != nil {
-		log.Printf("Error retrieving the box info: %v, %v", mac, err)
+		log.Errorf("Error retrieving the box info: %v, %v", mac, err)
 		s.BadRequest(w, err, "Error retrieving the box info")
 		return
 	}
@@ -82,12 +86,12 @@ func (s *SCIONBoxController) InitializeBox(w http.ResponseWriter, r *http.Reques
 	sb.InternalIP = internalIP
 	sb.Update()
 	if err != nil {
-		log.Printf("Error updating the box info: %v, %v", openPorts, err)
+		log.Errorf("Error updating the box info: %v, %v", openPorts, err)
 		s.Error500(w, err, "Error updating the box info")
 		return
 	}
 	if openPorts == 0 {
-		log.Printf("no Free UDP ports for Border Routers !: %v, %v", openPorts, err)
+		log.Warnf("no Free UDP ports for Border Routers !: %v, %v", openPorts, err)
 		s.BadRequest(w, fmt.Errorf("no open UDP ports"), "no open UDP ports")
 		return
 	}
@@ -97,7 +101,7 @@ func (s *SCIONBoxController) InitializeBox(w http.ResponseWriter, r *http.Reques
 		if err == orm.ErrNoRows {
 			s.initializeNewBox(sb, externalIP, mac, w, r)
 		} else {
-			log.Printf("Error retrieving ScionlabAS info: %v, %v", mac, err)
+			log.Errorf("Error retrieving ScionlabAS info: %v, %v", mac, err)
 			s.Error500(w, err, "Error retrieving ScionlabAS info")
 		}
 	} else {
@@ -108,11 +112,12 @@ func (s *SCIONBoxController) InitializeBox(w http.ResponseWriter, r *http.Reques
 // Checks if the Box needs an update
 func (s *SCIONBoxController) initializeNewBox(sb *models.SCIONBox, ip string, mac string,
 	w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	// Create the Usercredential path
 	//os.Mkdir(UserPackagePath(sb.UserEmail), 0777)
 	// Check if the box needs an update
 	if sb.UpdateRequired {
-		log.Printf("Shipped box needs an update !: %v, %v", mac, sb.UserEmail)
+		log.Infof("Shipped box needs an update !: %v, %v", mac, sb.UserEmail)
 		// TODO Update the box !
 		sb.UpdateRequired = false
 		sb.Update()
@@ -124,31 +129,32 @@ func (s *SCIONBoxController) initializeNewBox(sb *models.SCIONBox, ip string, ma
 // Run through the steps required to connect a previously connected Box.
 func (s *SCIONBoxController) initializeOldBox(sb *models.SCIONBox, slas *models.SCIONLabAS,
 	ip string, mac string, w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	BoxStatus := slas.Status
 	if BoxStatus == models.Update {
-		log.Printf("Box that needs to be updated has requested an init box!: %v, %v",
+		log.Infof("Box that needs to be updated has requested an init box!: %v, %v",
 			mac, BoxStatus)
 		slas.Status = models.Inactive
 		slas.Update()
 		// TODO Update the box !
 	} else {
-		log.Printf("Previously connected Box needs a gen folder!: %v, %v", mac, sb.UserEmail)
+		log.Infof("Previously connected Box needs a gen folder!: %v, %v", mac, sb.UserEmail)
 		// Check if connection has changed
 		// If the IP address is still the same simply serve the gen folder again,
 		// Otherwise disconnect the old box and connect the box like a new box.
 		if slas.PublicIP == ip {
 			// Generate necessary files and send them to the Box
 			os.RemoveAll(userPackagePath(slas.UserEmail))
-			os.Remove(filepath.Join(BoxPackagePath, slas.UserEmail+".tar.gz"))
-			if err := s.generateGen(slas); err != nil {
-				log.Printf("Error generating gen folder: %v", err)
+			Artifacts.Delete(boxPackageKey(slas.UserEmail))
+			if err := s.generateGen(r.Context(), slas); err != nil {
+				log.Errorf("Error generating gen folder: %v", err)
 				s.Error500(w, err, "Error generating gen folder")
 				return
 			}
 			s.serveGen(slas.UserEmail, w, r)
 		} else {
 			if err := s.disconnectBox(sb, slas, false); err != nil {
-				log.Printf("Error disconnecting box, %v, sourceIP: %v, macAddress %v",
+				log.Errorf("Error disconnecting box, %v, sourceIP: %v, macAddress %v",
 					err, ip, mac)
 				s.Error500(w, err, "Error disconnecting box")
 				return
@@ -169,10 +175,11 @@ type InitRequest struct {
 // {IPAddress: 'string', MacAddress: 'string', OpenPorts: int, StartPort: int}
 func (s *SCIONBoxController) parseRequest(r *http.Request) (isNAT bool, internalIP string,
 	externalIP string, macAddress string, openPorts, startPort uint16, err error) {
+	log := logger.FromContext(r.Context())
 	var request InitRequest
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&request); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", r.Body, err)
+		log.Errorf("Error decoding JSON: %v, %v", r.Body, err)
 		return false, "", "", "", 0, 0, err
 	}
 	macAddress = request.MacAddress
@@ -190,7 +197,7 @@ func (s *SCIONBoxController) parseRequest(r *http.Request) (isNAT bool, internal
 	// parse the Connection results
 	openPorts = request.OpenPorts
 	startPort = request.StartPort
-	log.Printf("isNAT: %t, internalIP: %v, externalIP: %v, Connections: %v", isNAT, internalIP,
+	log.Infof("isNAT: %t, internalIP: %v, externalIP: %v, Connections: %v", isNAT, internalIP,
 		externalIP, openPorts)
 	return isNAT, internalIP, externalIP, macAddress, openPorts, startPort, nil
 }
@@ -207,10 +214,11 @@ type initReply struct {
 // Sends a list of potential neighbors and credentials to the SCION-Box.
 func (s *SCIONBoxController) sendPotentialNeighbors(sb *models.SCIONBox, ip string, mac string,
 	w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	// run ip geolocation
-	pns, isd, err := s.getPotentialNeighbors(ip, mac)
+	pns, isd, err := s.getPotentialNeighbors(r.Context(), ip, mac)
 	if err != nil {
-		log.Printf("Error looking for potential neighbors, %v, sourceIP: %v, macAddress %v",
+		log.Errorf("Error looking for potential neighbors, %v, sourceIP: %v, macAddress %v",
 			err, ip, mac)
 		s.Error500(w, err, "Error looking for potential neighbors")
 		return
@@ -218,7 +226,7 @@ func (s *SCIONBoxController) sendPotentialNeighbors(sb *models.SCIONBox, ip stri
 	// update the SCIONBox database
 	sb.ISD = isd
 	if err := sb.Update(); err != nil {
-		log.Printf("Error updating scionbox database, %v", err)
+		log.Errorf("Error updating scionbox database, %v", err)
 		s.Error500(w, err, "Error updating scionbox database")
 		return
 	}
@@ -233,20 +241,21 @@ func (s *SCIONBoxController) sendPotentialNeighbors(sb *models.SCIONBox, ip stri
 		ISDID:              isd,
 	}
 	s.JSON(reply, w, r)
-	log.Printf("Sending pot neighbor %v", reply)
+	log.Infof("Sending pot neighbor %v", reply)
 }
 
 // Returns a list of potential Neighbors: active attachment point SCIONLabAses in the same ISD
 // Also returns the assigned ISD
-func (s *SCIONBoxController) getPotentialNeighbors(ip string,
+func (s *SCIONBoxController) getPotentialNeighbors(ctx context.Context, ip string,
 	mac string) ([]topologyAlgorithm.Neighbor, addr.ISD, error) {
+	log := logger.FromContext(ctx)
 	// run IP geolocation
 	var potentialNeighbors []topologyAlgorithm.Neighbor
 	country, continent, err := geolocation.IPGeolocation(ip)
 	if err != nil {
 		return potentialNeighbors, 0, err
 	}
-	log.Printf("New Box is in %s, %s,", continent, country)
+	log.Infof("New Box is in %s, %s,", continent, country)
 	// check in which ISD the box is.
 	isd, err := geolocation.Location2ISD(country, continent)
 	if err != nil {
@@ -298,25 +307,26 @@ type ConnectQuery struct {
 // Runs the topology algorithm to choose Neighbors,
 // Updates the database, generates necessary files and sends them to the Box
 func (s *SCIONBoxController) ConnectNewBox(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	// Parse the request
 	var req ConnectQuery
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&req); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", r.Body, err)
+		log.Errorf("Error decoding JSON: %v, %v", r.Body, err)
 		s.Error500(w, err, "Error decoding JSON")
 		return
 	}
 	// Retrive scionbox object using email
 	sb, err := models.FindSCIONBoxByEMail(req.UserEmail)
 	if err != nil {
-		log.Printf("Error looking for Scionbox, %v, %v", err, req.UserEmail)
+		log.Errorf("Error looking for Scionbox, %v, %v", err, req.UserEmail)
 		s.Error500(w, err, "Error looking for Scionbox")
 		return
 	}
 	// Choose the neigbhbors of the box
 	neighbors := topologyAlgorithm.ChooseNeighbors(req.Neighbors, sb.OpenPorts)
 	if len(neighbors) == 0 {
-		log.Printf("Error no Neighbors for ScionBox, %v, ", req.UserEmail)
+		log.Errorf("Error no Neighbors for ScionBox, %v, ", req.UserEmail)
 		s.BadRequest(w, nil, "no potential Neighbors!")
 		return
 	}
@@ -324,17 +334,17 @@ func (s *SCIONBoxController) ConnectNewBox(w http.ResponseWriter, r *http.Reques
 	externalIP := req.IP
 	isd := sb.ISD
 	// Update the Database with the new ScionLabAS
-	slas, err := s.updateDBnewSB(sb, neighbors, isd, externalIP)
+	slas, err := s.updateDBnewSB(r.Context(), sb, neighbors, isd, externalIP)
 	if err != nil {
-		log.Printf("Error Updating the Database, %v", err)
+		log.Errorf("Error Updating the Database, %v", err)
 		s.Error500(w, err, "Error Updating the Database")
 		return
 	}
 	// Generate necessary files and send them to the Box
 	os.RemoveAll(userPackagePath(slas.UserEmail))
-	os.Remove(filepath.Join(BoxPackagePath, slas.UserEmail+".tar.gz"))
-	if err := s.generateGen(slas); err != nil {
-		log.Printf("Error generating gen folder, %v", err)
+	Artifacts.Delete(boxPackageKey(slas.UserEmail))
+	if err := s.generateGen(r.Context(), slas); err != nil {
+		log.Errorf("Error generating gen folder, %v", err)
 		s.Error500(w, err, "Error generating gen folder")
 		return
 	}
@@ -342,8 +352,9 @@ func (s *SCIONBoxController) ConnectNewBox(w http.ResponseWriter, r *http.Reques
 }
 
 // this function inserts a new SCIONBox into the database
-func (s *SCIONBoxController) updateDBnewSB(sb *models.SCIONBox,
+func (s *SCIONBoxController) updateDBnewSB(ctx context.Context, sb *models.SCIONBox,
 	neighbors []topologyAlgorithm.Neighbor, isd addr.ISD, ip string) (*models.SCIONLabAS, error) {
+	log := logger.FromContext(ctx)
 	as, err := s.getNewSCIONBoxASID(isd)
 	if err != nil {
 		return nil, fmt.Errorf("error looking for new AS-ID %v: %v", sb.UserEmail, err)
@@ -372,7 +383,7 @@ func (s *SCIONBoxController) updateDBnewSB(sb *models.SCIONBox,
 		return nil, fmt.Errorf("error inserting new SCIONLabAS info. User: %v, %v", newSlas, err)
 	}
 	// Start the goroutine which updates the status
-	go s.checkHBStatus(isd, as)
+	go s.checkHBStatus(ctx, isd, as)
 	// Update the Box information
 	sb.AS = as
 	if err = sb.Update(); err != nil {
@@ -382,7 +393,7 @@ func (s *SCIONBoxController) updateDBnewSB(sb *models.SCIONBox,
 	for i, neighbor := range neighbors {
 		nbSlas, err := models.FindSCIONLabASByIAInt(neighbor.ISD, neighbor.AS)
 		if err != nil {
-			log.Printf("Neighbor Slas not found %v ", err)
+			log.Warnf("Neighbor Slas not found %v ", err)
 			continue
 		}
 		acceptID := s.findLowestBRId(nbSlas)
@@ -407,36 +418,35 @@ func (s *SCIONBoxController) updateDBnewSB(sb *models.SCIONBox,
 }
 
 // Generate the gen folder
-func (s *SCIONBoxController) generateGen(slas *models.SCIONLabAS) error {
-	if err := s.generateTopologyFile(slas); err != nil {
-		log.Printf("Error generating topology File: %v", err)
+func (s *SCIONBoxController) generateGen(ctx context.Context, slas *models.SCIONLabAS) error {
+	log := logger.FromContext(ctx)
+	if err := s.generateTopologyFile(ctx, slas); err != nil {
+		log.Errorf("Error generating topology File: %v", err)
 		return err
 	}
-	if err := s.generateGenFolder(slas); err != nil {
-		log.Printf("Error generating gen Folder: %v", err)
+	if err := s.generateGenFolder(ctx, slas); err != nil {
+		log.Errorf("Error generating gen Folder: %v", err)
 		return err
 	}
-	if err := s.generateCredentialsFile(slas); err != nil {
-		log.Printf("Error generating credentials file: %v", err)
+	if err := s.generateCredentialsFile(ctx, slas); err != nil {
+		log.Errorf("Error generating credentials file: %v", err)
 		return err
 	}
 	return nil
 }
 
 func (s *SCIONBoxController) serveGen(userMail string, w http.ResponseWriter, r *http.Request) {
-	if err := s.packageGenFolder(userMail); err != nil {
-		log.Printf("Error packaging gen folder: %v", err)
+	log := logger.FromContext(r.Context())
+	if err := s.packageGenFolder(r.Context(), userMail); err != nil {
+		log.Errorf("Error packaging gen folder: %v", err)
 		s.Error500(w, err, "Error packaging gen folder")
 		return
 	}
-	// Wait to make sure a previous version is not served
-	time.Sleep(time.Second)
 	// serve the packaged gen folder to the box
 	fileName := userMail + ".tar.gz"
-	filePath := filepath.Join(BoxPackagePath, fileName)
-	data, err := ioutil.ReadFile(filePath)
+	data, err := storage.ReadAll(Artifacts, boxPackageKey(userMail))
 	if err != nil {
-		log.Printf("Error reading tar file: %v", err)
+		log.Errorf("Error reading tar file: %v", err)
 		s.Error500(w, err, "Error reading tar file")
 		return
 	}
@@ -499,8 +509,10 @@ func (s *SCIONBoxController) topologyFile(slas *models.SCIONLabAS) string {
 // Generates the topology file for the SCIONLabAS. It uses the template file
 // simple_box_config_topo.tmpl under templates folder in order to populate and generate the
 // JSON file.
-func (s *SCIONBoxController) generateTopologyFile(slas *models.SCIONLabAS) error {
-	log.Printf("Generating topology file for SCIONLab Box")
+func (s *SCIONBoxController) generateTopologyFile(ctx context.Context,
+	slas *models.SCIONLabAS) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Generating topology file for SCIONLab Box")
 	sb, err := models.FindSCIONBoxByIAint(slas.ISD, slas.ASID)
 	if err != nil {
 		return fmt.Errorf("error looking for SCIONBox. User: %v, %v",
@@ -532,12 +544,15 @@ func (s *SCIONBoxController) generateTopologyFile(slas *models.SCIONLabAS) error
 		LINK_TYPE    string
 		BR_PORT      string
 		MTU          string
+		BANDWIDTH    string
+		OVERLAY      string
 	}
 	type Topo struct {
 		ISD_ID   string
 		AS_ID    string
 		IP       string
 		IP_LOCAL string
+		OVERLAY  string
 		BRs      []BR
 	}
 	var borderrouters []BR
@@ -548,7 +563,7 @@ func (s *SCIONBoxController) generateTopologyFile(slas *models.SCIONLabAS) error
 	}
 	brs = models.OnlyCurrentConnections(brs)
 	for i, br := range brs {
-		log.Printf("adding BR objects in topology generation")
+		log.Infof("adding BR objects in topology generation")
 		ia := addr.IA{
 			I: br.NeighborISD,
 			A: br.NeighborAS,
@@ -570,7 +585,9 @@ func (s *SCIONBoxController) generateTopologyFile(slas *models.SCIONLabAS) error
 			ID:           fmt.Sprintf("%v", br.BRID),
 			LINK_TYPE:    linktype,
 			BR_PORT:      strconv.Itoa(int(config.BRInternalStartPort) + i),
-			MTU:          strconv.Itoa(config.MTU),
+			MTU:          strconv.Itoa(int(br.LinkMTU())),
+			BANDWIDTH:    strconv.FormatUint(br.LinkBandwidth(), 10),
+			OVERLAY:      utility.OverlayType(slas.PublicIP),
 		}
 		// if last neighbor do not add the comma to the end
 		if i == len(brs)-1 {
@@ -586,6 +603,7 @@ func (s *SCIONBoxController) generateTopologyFile(slas *models.SCIONLabAS) error
 		BRs:      borderrouters,
 		IP:       slas.PublicIP,
 		IP_LOCAL: sb.InternalIP,
+		OVERLAY:  utility.OverlayType(sb.InternalIP),
 	}
 	if err = t.Execute(f, topo); err != nil {
 		return fmt.Errorf("error executing topology template file. User: %v, %v",
@@ -595,8 +613,10 @@ func (s *SCIONBoxController) generateTopologyFile(slas *models.SCIONLabAS) error
 	return nil
 }
 
-func (s *SCIONBoxController) generateCredentialsFile(slas *models.SCIONLabAS) error {
-	log.Printf("Generating credentials file for SCIONBox")
+func (s *SCIONBoxController) generateCredentialsFile(ctx context.Context,
+	slas *models.SCIONLabAS) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Generating credentials file for SCIONBox")
 	t, err := template.ParseFiles("templates/box_credentials.tmpl")
 	if err != nil {
 		return fmt.Errorf("error parsing credentials template config for user %v: %v",
@@ -638,13 +658,14 @@ func (s *SCIONBoxController) generateCredentialsFile(slas *models.SCIONLabAS) er
 // Creates the local gen folder of the SCIONLabAS . It calls a Python wrapper script
 // located under the python directory. The script uses SCION's and SCION-WEB's library
 // functions in order to generate the certificate, AS keys etc.
-func (s *SCIONBoxController) generateGenFolder(slas *models.SCIONLabAS) error {
-	log.Printf("Creating gen folder for SCIONBox")
+func (s *SCIONBoxController) generateGenFolder(ctx context.Context, slas *models.SCIONLabAS) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Creating gen folder for SCIONBox")
 	asID := strconv.FormatInt(int64(slas.ASID), 10)
 	isdID := strconv.FormatInt(int64(slas.ISD), 10)
 	userEmail := slas.UserEmail
 	CoreCredentialsPath := ISDCoreCredentialsPath(isdID)
-	log.Printf("Calling create local gen. ISD-ID: %v, AS-ID: %v, UserEmail: %v", isdID, asID,
+	log.Infof("Calling create local gen. ISD-ID: %v, AS-ID: %v, UserEmail: %v", isdID, asID,
 		userEmail)
 	cmd := exec.Command("python3", localGenPath,
 		"--topo_file="+s.topologyFile(slas),
@@ -666,20 +687,25 @@ func (s *SCIONBoxController) generateGenFolder(slas *models.SCIONLabAS) error {
 	// read stdout and stderr
 	stdOutput, _ := ioutil.ReadAll(cmdOut)
 	errOutput, _ := ioutil.ReadAll(cmdErr)
-	fmt.Printf("STDOUT generateLocalGen: %s\n", stdOutput)
-	fmt.Printf("ERROUT generateLocalGen: %s\n", errOutput)
+	log.With("stdout", string(stdOutput)).With("stderr", string(errOutput)).
+		Debugf("Output of generate local gen for %v", userEmail)
+	if len(errOutput) != 0 {
+		log.Warnf("Generate local gen reported errors for %v: %s", userEmail, errOutput)
+	}
 	return nil
 }
 
-// Packages the gen folder and credential file
-func (s *SCIONBoxController) packageGenFolder(userEmail string) error {
-	log.Printf("Packaging gen Folder")
-	cmd := exec.Command("tar", "zcvf", userEmail+".tar.gz", userEmail)
+// Packages the gen folder and credential file and stores the tarball
+func (s *SCIONBoxController) packageGenFolder(ctx context.Context, userEmail string) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Packaging gen Folder")
+	cmd := exec.Command("tar", "zcf", "-", userEmail)
 	cmd.Dir = BoxPackagePath
-	if err := cmd.Start(); err != nil {
+	tarball, err := cmd.Output()
+	if err != nil {
 		return fmt.Errorf("failed to create SCIONLabAS tarball. User: %v, %v", userEmail, err)
 	}
-	return nil
+	return storage.PutBytes(Artifacts, boxPackageKey(userEmail), tarball)
 }
 
 // Updates the relevant database tables related to removing a SCION Box from the network.
@@ -751,22 +777,23 @@ type ResponseIA struct {
 // Heartbeat function
 // TODO Receive some status information about the box (reachibility of neighbors ? )
 func (s *SCIONBoxController) HeartBeatFunction(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	// get the account tied to the box
 	// Parse the received info
 	var req HeartBeatQuery
-	log.Printf("new HB Query")
+	log.Infof("new HB Query")
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&req); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", r.Body, err)
+		log.Errorf("Error decoding JSON: %v, %v", r.Body, err)
 		s.Error500(w, err, "Error decoding JSON")
 		return
 	}
-	vars := mux.Vars(r)
-	accountID := vars["account_id"]
-	secret := vars["secret"]
+	accountID := requestAccountID(r)
+	secret := mux.Vars(r)["secret"]
+	token := middleware.RequestAccessToken(r)
 	ip, err := s.getSourceIP(r)
 	if err != nil {
-		log.Printf("error retrieving source IP: %v", accountID)
+		log.Errorf("error retrieving source IP: %v", accountID)
 		s.Error500(w, err, "Error retrieving source IP")
 		return
 	}
@@ -779,7 +806,7 @@ func (s *SCIONBoxController) HeartBeatFunction(w http.ResponseWriter, r *http.Re
 				// no row found AS is not a SCIONLabAS
 				continue
 			} else {
-				log.Printf("no SCIONLabAS found in HB, %v %v", req, err)
+				log.Warnf("no SCIONLabAS found in HB, %v %v", req, err)
 				s.Error500(w, err, "no SCIONLabAS found in HB")
 				return
 			}
@@ -787,13 +814,14 @@ func (s *SCIONBoxController) HeartBeatFunction(w http.ResponseWriter, r *http.Re
 		// check if IA belongs to credentials
 		u, err := models.FindUserByEmail(slas.UserEmail)
 		if err != nil {
-			log.Printf("Error looking for user: %v", err)
+			log.Errorf("Error looking for user: %v", err)
 			s.Error500(w, err, "Error looking for user")
 			return
 		}
 		account := u.Account
-		if accountID != account.AccountID || secret != account.Secret {
-			log.Printf("HB requested for user with not associated IA, %v, %v", req, slas.UserEmail)
+		// requests authenticated with an access token carry no secret
+		if accountID != account.AccountID || (token == nil && !account.CheckSecret(secret)) {
+			log.Warnf("HB requested for user with not associated IA, %v, %v", req, slas.UserEmail)
 			s.BadRequest(w, err, "HB requested for user with not associated IA")
 			return
 		}
@@ -806,7 +834,7 @@ func (s *SCIONBoxController) HeartBeatFunction(w http.ResponseWriter, r *http.Re
 		}
 		needGen, err = s.HBCheckIP(slas, ip, ia, r)
 		if err != nil {
-			log.Printf("Error running IP checks in HB: %v,", err)
+			log.Errorf("Error running IP checks in HB: %v,", err)
 			s.Error500(w, err, "Error running IP check in HB")
 			return
 		}
@@ -817,10 +845,10 @@ func (s *SCIONBoxController) HeartBeatFunction(w http.ResponseWriter, r *http.Re
 		// TODO generate updated gen folder for all ASes
 		// Remove old gen folders/ packages
 		os.RemoveAll(userPackagePath(slasList[0].UserEmail))
-		os.Remove(filepath.Join(BoxPackagePath, slasList[0].UserEmail+".tar.gz"))
+		Artifacts.Delete(boxPackageKey(slasList[0].UserEmail))
 		for _, slas := range slasList {
 			// Generate necessary files and send them to the Bo
-			if err := s.generateGen(slas); err != nil {
+			if err := s.generateGen(r.Context(), slas); err != nil {
 				s.Error500(w, err, "Error generating gen folder")
 				return
 			}
@@ -830,15 +858,15 @@ func (s *SCIONBoxController) HeartBeatFunction(w http.ResponseWriter, r *http.Re
 		var iaList []ResponseIA
 		for _, slas := range slasList {
 			cns, err := slas.GetConnectionInfo()
-			log.Printf("Got Connection Info")
+			log.Infof("Got Connection Info")
 			if err != nil {
-				log.Printf("Error retrieving connections: %v", err)
+				log.Errorf("Error retrieving connections: %v", err)
 				s.Error500(w, err, "Error retrieving connections")
 				return
 			}
 			slas.Status = models.Active
 			if err := slas.Update(); err != nil {
-				log.Printf("Error updating slas %v", err)
+				log.Errorf("Error updating slas %v", err)
 				s.Error500(w, err, "Error updating slas")
 				return
 			}
@@ -863,7 +891,7 @@ func (s *SCIONBoxController) HBCheckIP(slas *models.SCIONLabAS, ip string, ia IA
 	var needGen = false
 	if utility.IPCompare(ip, slas.PublicIP) != 0 {
 		// The IP address of the Box has changed update the DB
-		if err := s.HBChangedIP(slas, ip); err != nil {
+		if err := s.HBChangedIP(r.Context(), slas, ip); err != nil {
 			return false, fmt.Errorf("error updating the Box Connectons with changed IP: %v",
 				err)
 		}
@@ -880,7 +908,9 @@ func (s *SCIONBoxController) HBCheckIP(slas *models.SCIONLabAS, ip string, ia IA
 }
 
 // IP address of the Box has changed --> Update the Database
-func (s *SCIONBoxController) HBChangedIP(slas *models.SCIONLabAS, ip string) error {
+func (s *SCIONBoxController) HBChangedIP(ctx context.Context, slas *models.SCIONLabAS,
+	ip string) error {
+	log := logger.FromContext(ctx)
 	// Update the ScionLabAS database
 	slas.PublicIP = ip
 	if err := slas.Update(); err != nil {
@@ -889,7 +919,7 @@ func (s *SCIONBoxController) HBChangedIP(slas *models.SCIONLabAS, ip string) err
 	}
 	// Update the Connection database
 	cns, err := slas.GetConnectionInfo()
-	log.Printf("Connections: %v", cns)
+	log.Infof("Connections: %v", cns)
 	if err != nil {
 		return fmt.Errorf("error retrieving Box Connections: %v",
 			err)
@@ -958,7 +988,8 @@ func findCnInNbs(cn models.ConnectionInfo, neighbors []CurrentCn) bool {
 
 // goroutine that periodically checks the time between the time the SLAS called the Heartbeat API
 // if the time is 10 times the HeartbeatPeriod, the SLAS' status is set to Inactive
-func (s *SCIONBoxController) checkHBStatus(isd addr.ISD, As addr.AS) {
+func (s *SCIONBoxController) checkHBStatus(ctx context.Context, isd addr.ISD, As addr.AS) {
+	log := logger.FromContext(ctx)
 	time.Sleep(HeartBeatPeriod * time.Second)
 	for true {
 		slas, err := models.FindSCIONLabASByIAInt(isd, As)
@@ -975,7 +1006,7 @@ func (s *SCIONBoxController) checkHBStatus(isd addr.ISD, As addr.AS) {
 		delta := time.Now().Sub(slas.Updated)
 		if delta.Seconds() > float64(HeartBeatLimit*HeartBeatPeriod) {
 			if slas.Status != models.Inactive {
-				log.Printf("AS Status set to inactive, AS: %v, Time since last HB: %v", slas, delta)
+				log.Infof("AS Status set to inactive, AS: %v, Time since last HB: %v", slas, delta)
 				slas.Status = models.Inactive
 				slas.Update()
 			}
@@ -15,12 +15,13 @@
 package api
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -39,7 +40,9 @@ import (
 	"github.com/netsec-ethz/scion-coord/controllers"
 	"github.com/netsec-ethz/scion-coord/controllers/middleware"
 	"github.com/netsec-ethz/scion-coord/email"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
 	"github.com/netsec-ethz/scion-coord/utility"
 	"github.com/scionproto/scion/go/lib/addr"
 	"github.com/scionproto/scion/go/lib/crypto"
@@ -65,6 +68,7 @@ var (
 	EasyRSAPath     = filepath.Join(PackagePath, "easy-rsa")
 	RSAKeyPath      = filepath.Join(EasyRSAPath, "keys")
 	CACertPath      = filepath.Join(RSAKeyPath, "ca.crt")
+	CAKeyPath       = filepath.Join(RSAKeyPath, "ca.key")
 	HeartBeatPeriod = time.Duration(config.HeartbeatPeriod)
 	HeartBeatLimit  = time.Duration(config.HeartbeatLimit)
 )
@@ -105,8 +109,11 @@ type SCIONLabASController struct {
 type SCIONLabASInfo struct {
 	IsNewConnection bool               // denotes whether this is a new user.
 	IsVPN           bool               // denotes whether this is a VPN setup
+	VPNType         string             // models.OpenVPN or models.WireGuard for VPN setups
 	VPNServerIP     string             // IP of the VPN server
 	VPNServerPort   uint16             // Port of the VPN server
+	VPNServerKey    string             // WireGuard public key of the VPN server
+	WGPublicKey     string             // WireGuard public key of the AS, set with its configuration
 	IP              string             // the public IP address of the SCIONLab AS
 	LocalPort       uint16             // The port of the border router on the user side
 	OldAP           string             // the previous SCIONLab AP to which the AS was connected
@@ -114,21 +121,39 @@ type SCIONLabASInfo struct {
 	RemoteIP        string             // the IP address of the SCIONLab AP it connects to
 	RemoteBRID      uint16             // ID of the border router in the SCIONLab AP
 	RemotePort      uint16             // Port of the BR in the SCIONLab AP
+	LinkMTU         uint16             // MTU of the link to the AP; 0 means default
+	Bandwidth       uint64             // Bandwidth of the link to the AP; 0 means default
+	CertVersion     uint64             // if not 0, a new AS certificate with this version is issued
 	LocalAS         *models.SCIONLabAS // if exists, the DB object that belongs to this AS
 	RemoteAS        *models.SCIONLabAS // the AP this AS connects to
 }
 
 type SCIONLabRequest struct {
-	ASID      addr.AS `json:"asID"`
-	UserEmail string  `json:"userEmail"`
-	IsVPN     bool    `json:"isVPN"`
-	IP        string  `json:"ip"`
-	ServerIA  string  `json:"serverIA"`
-	Label     string  `json:"label"`
-	Type      uint8   `json:"type"`
-	Port      uint16  `json:"port"`
+	ASID          addr.AS `json:"asID"`
+	UserEmail     string  `json:"userEmail"`
+	IsVPN         bool    `json:"isVPN"`
+	VPNType       string  `json:"vpnType"` // optional, the VPN of the AP is used by default
+	IP            string  `json:"ip"`
+	ServerIA      string  `json:"serverIA"`
+	Label         string  `json:"label"`
+	Type          uint8   `json:"type"`
+	Port          uint16  `json:"port"`
+	MTU           uint16  `json:"mtu"`           // optional, MTU inside the AS
+	LinkMTU       uint16  `json:"linkMTU"`       // optional, MTU of the link to the AP
+	Bandwidth     uint64  `json:"bandwidth"`     // optional, bandwidth of the link to the AP
+	ServicePort   uint16  `json:"servicePort"`   // optional, first port of the internal services
+	PackageFormat string  `json:"packageFormat"` // optional, see PackageFormat
 }
 
+const (
+	// minMTU is the smallest MTU accepted for ASes and links, the minimum MTU of IPv6
+	minMTU = 1280
+	// numServicePorts is the number of consecutive ports used by the internal services of an AS
+	numServicePorts = 5
+	// brCtrlPort is the control port of the border router, which is not configurable
+	brCtrlPort = 30042
+)
+
 type remappingError struct {
 	err          error
 	notifyAdmins bool
@@ -140,25 +165,27 @@ func newMappingError(notifyAdmins bool, format string, params ...interface{}) *r
 func (e *remappingError) Error() string {
 	return e.err.Error()
 }
-func (e *remappingError) LogAndNotifyAppropriately(w http.ResponseWriter, format string, params ...interface{}) {
+func (e *remappingError) LogAndNotifyAppropriately(ctx context.Context, w http.ResponseWriter, format string, params ...interface{}) {
 	if e.notifyAdmins {
-		logAndSendErrorAndNotifyAdmins(w, format, params...)
+		logAndSendErrorAndNotifyAdmins(ctx, w, format, params...)
 	} else {
-		logAndSendError(w, format, params...)
+		logAndSendError(ctx, w, format, params...)
 	}
 }
 
 // BadRequestAndLog writes a HTTP 400 error with the message and error, and prints the same in the server log
-func (s *SCIONLabASController) BadRequestAndLog(w http.ResponseWriter, err error, desc string, a ...interface{}) {
+func (s *SCIONLabASController) BadRequestAndLog(ctx context.Context, w http.ResponseWriter, err error, desc string, a ...interface{}) {
+	log := logger.FromContext(ctx)
 	msg := controllers.Verbosity(err, desc, a...)
 	s.BadRequest(w, nil, msg)
-	log.Print(msg)
+	log.Errorf("%v", msg)
 }
 
-func sendAlreadyCompressedFile(w http.ResponseWriter, filePath, fileNameInClient string) error {
-	data, err := ioutil.ReadFile(filePath)
+// sendAlreadyCompressedFile sends the stored artifact with the key as a gzip file
+func sendAlreadyCompressedFile(w http.ResponseWriter, key, fileNameInClient string) error {
+	data, err := storage.ReadAll(Artifacts, key)
 	if err != nil {
-		return fmt.Errorf("Error reading the file %v: %v", filePath, err)
+		return fmt.Errorf("Error reading the artifact %v: %v", key, err)
 	}
 	w.Header().Set("Content-Type", "application/gzip")
 	w.Header().Set("Content-Disposition", "attachment; filename="+fileNameInClient)
@@ -167,11 +194,22 @@ func sendAlreadyCompressedFile(w http.ResponseWriter, filePath, fileNameInClient
 	return nil
 }
 
-// List of all ASes belonging to the account
+// requestAccountID returns the account the request is authenticated for: the account of the
+// personal access token, or the account_id of the legacy scheme
+func requestAccountID(r *http.Request) string {
+	if t := middleware.RequestAccessToken(r); t != nil {
+		return t.AccountID()
+	}
+	if accountID := mux.Vars(r)["account_id"]; accountID != "" {
+		return accountID
+	}
+	return r.URL.Query().Get("account_id")
+}
+
+// List of all ASes belonging to the account, and of the APs in the ISDs the request may manage,
+// see models.PermAPManage
 func ownedASes(r *http.Request) (map[string]struct{}, error) {
-	vars := mux.Vars(r)
-	accountID := vars["account_id"]
-	asesList, err := models.FindSCIONLabASesByAccountID(accountID)
+	asesList, err := models.FindSCIONLabASesByAccountID(requestAccountID(r))
 	if err != nil {
 		return nil, err
 	}
@@ -179,6 +217,18 @@ func ownedASes(r *http.Request) (map[string]struct{}, error) {
 	for _, as := range asesList {
 		ases[as] = struct{}{}
 	}
+	grants := middleware.RequestGrants(r)
+	if grants.Has(models.PermAPManage) {
+		aps, err := models.FindAllAttachmentPoints()
+		if err != nil {
+			return nil, err
+		}
+		for _, ap := range aps {
+			if grants.HasInISD(models.PermAPManage, ap.AS.ISD) {
+				ases[ap.AS.IAString()] = struct{}{}
+			}
+		}
+	}
 	return ases, nil
 }
 
@@ -203,26 +253,26 @@ func checkAuthorization(r *http.Request, ia string) (addr.IA, error) {
 
 // This generates a new AS for the user if they do not have too many already
 func (s *SCIONLabASController) GenerateNewSCIONLabAS(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	_, uSess, err := middleware.GetUserSession(r)
 	if err != nil {
-		log.Printf("Error getting the user session: %v", err)
+		log.Errorf("Error getting the user session: %v", err)
 		s.Forbidden(w, err, "Error getting the user session")
 		return
 	}
-	ases, err := models.FindSCIONLabASesByUserEmail(uSess.Email)
+	quota, err := userASQuota(uSess.Email)
 	if err != nil {
-		log.Printf("Error looking up current SCIONLabASes for %v: %v", uSess.Email, err)
+		log.Errorf("Error looking up the AS quota of %v: %v", uSess.Email, err)
 		s.Error500(w, err, "Error looking up current SCIONLabASes")
 		return
 	}
-	maxASes := config.MaxASes(uSess.IsAdmin)
-	if len(ases) >= maxASes {
-		s.Forbidden(w, nil, "You can currently only create %v ASes", maxASes)
+	if quota.Used >= quota.Max {
+		s.Forbidden(w, nil, "You can currently only create %v ASes", quota.Max)
 		return
 	}
 	asID, err := s.getNewSCIONLabASID()
 	if err != nil {
-		log.Printf("Error generating new ASID for %v: %v", uSess.Email, err)
+		log.Errorf("Error generating new ASID for %v: %v", uSess.Email, err)
 		s.Error500(w, err, "Error generating new ASID")
 		return
 	}
@@ -236,7 +286,7 @@ func (s *SCIONLabASController) GenerateNewSCIONLabAS(w http.ResponseWriter, r *h
 		Branch:      config.TestingCoordinatorBranch,
 	}
 	if err := newAS.Insert(); err != nil {
-		log.Printf("Error inserting new AS for %v: %v", uSess.Email, err)
+		log.Errorf("Error inserting new AS for %v: %v", uSess.Email, err)
 		s.Error500(w, err, "Error inserting new AS into database")
 		return
 	}
@@ -245,37 +295,40 @@ func (s *SCIONLabASController) GenerateNewSCIONLabAS(w http.ResponseWriter, r *h
 	return
 }
 
-func generateGenForAS(asInfo *SCIONLabASInfo) error {
+func generateGenForAS(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	log := logger.FromContext(ctx)
 	var err error
 	// Generate topology file
-	if err = generateTopologyFile(asInfo); err != nil {
+	if err = generateTopologyFile(ctx, asInfo); err != nil {
 		return fmt.Errorf("Error generating topology file: %v", err)
 	}
 	// Generate local gen
-	if err = generateLocalGen(asInfo); err != nil {
+	if err = generateLocalGen(ctx, asInfo); err != nil {
 		return fmt.Errorf("Error generating local config: %v", err)
 	}
 	// preserve certificates (don't use new ones if we had certs already)
-	if err = preserveCerts(asInfo); err != nil {
+	if err = preserveCerts(ctx, asInfo); err != nil {
 		return fmt.Errorf("Error reusing existing certificates: %v", err)
 	}
+	if err = recordCertExpiration(ctx, asInfo.LocalAS); err != nil {
+		// not fatal, but the AS won't be considered for automatic renewal
+		log.Errorf("Error reading the certificate expiration of AS %v: %v",
+			asInfo.LocalAS.IAString(), err)
+	}
 
 	// Generate VPN config if this is a VPN setup
 	if asInfo.IsVPN {
-		if err = generateVPNConfig(asInfo); err != nil {
+		if err = generateVPNConfig(ctx, asInfo); err != nil {
 			return fmt.Errorf("Error generating VPN config: %v", err)
 		}
 	}
-	if err = addAuxiliaryFiles(asInfo); err != nil {
-		return fmt.Errorf("Error adding auxiliary files to the package: %v", err)
-	}
 	// Add account id and secret to gen directory
-	err = createUserLoginConfiguration(asInfo)
+	err = createUserLoginConfiguration(ctx, asInfo)
 	if err != nil {
 		return fmt.Errorf("Error generating user credential files: %v", err)
 	}
 	// Package the SCIONLab AS configuration
-	err = packageConfiguration(asInfo)
+	err = packageConfiguration(ctx, asInfo)
 	if err != nil {
 		return fmt.Errorf("Error packaging SCIONLabAS configuration: %v", err)
 	}
@@ -285,22 +338,30 @@ func generateGenForAS(asInfo *SCIONLabASInfo) error {
 // The main handler function to generates a SCIONLab AS for the given user.
 // If successful, the front-end will initiate the downloading of the tarball.
 func (s *SCIONLabASController) ConfigureSCIONLabAS(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	// Parse the arguments
 	slReq, err := s.parseRequestParameters(r)
 	if err != nil {
-		s.BadRequestAndLog(w, err, "Error parsing the parameters")
+		s.BadRequestAndLog(r.Context(), w, err, "Error parsing the parameters")
 		return
 	}
+	as := memberAS(r.Context(), s.HTTPController, w, slReq.UserEmail, slReq.ASID,
+		models.MemberMaintainer)
+	if as == nil {
+		return
+	}
+	// the files of the AS are stored under the email address of its contact
+	slReq.UserEmail = as.UserEmail
 	// check if there is already a create or update in progress
 	if err := s.canConfigure(slReq.UserEmail, slReq.ASID); err != nil {
-		log.Printf("Error checking pending create or update for user %v: %v", slReq.UserEmail, err)
+		log.Errorf("Error checking pending create or update for user %v: %v", slReq.UserEmail, err)
 		s.Error500(w, err, "Error checking pending create or update")
 		return
 	}
 	// Target SCIONLab ISD and AS to connect to is determined by config file
-	asInfo, err := s.getSCIONLabASInfo(slReq)
+	asInfo, err := s.getSCIONLabASInfo(r.Context(), slReq)
 	if err != nil {
-		log.Printf("Error getting SCIONLabASInfo: %v", err)
+		log.Errorf("Error getting SCIONLabASInfo: %v", err)
 		s.Error500(w, err, "Error getting SCIONLabASInfo")
 		return
 	}
@@ -308,16 +369,16 @@ func (s *SCIONLabASController) ConfigureSCIONLabAS(w http.ResponseWriter, r *htt
 	// Remove all existing files from UserPackagePath
 	os.RemoveAll(asInfo.UserPackagePath() + "/")
 	// generate the gen folder:
-	err = generateGenForAS(asInfo)
+	err = generateGenForAS(r.Context(), asInfo)
 	if err != nil {
-		log.Print(err)
+		log.Errorf("%v", err)
 		s.Error500(w, err, "Error generating the configuration")
 		return
 	}
 
 	// Persist the relevant data into the DB
-	if err = s.updateDB(asInfo); err != nil {
-		log.Printf("Error updating DB tables: %v", err)
+	if err = s.updateDB(r.Context(), asInfo); err != nil {
+		log.Errorf("Error updating DB tables: %v", err)
 		s.Error500(w, err, "Error updating DB tables")
 		return
 	}
@@ -330,10 +391,11 @@ func (s *SCIONLabASController) ConfigureSCIONLabAS(w http.ResponseWriter, r *htt
 // Parses the JSON payload of the request and checks if it is valid
 func (s *SCIONLabASController) parseRequestParameters(r *http.Request) (
 	slReq SCIONLabRequest, err error) {
+	log := logger.FromContext(r.Context())
 	// Get user session
 	_, uSess, err := middleware.GetUserSession(r)
 	if err != nil {
-		log.Printf("Error getting the user session: %v", err)
+		log.Errorf("Error getting the user session: %v", err)
 		return
 	}
 	// parse the JSON coming from the client
@@ -360,9 +422,107 @@ func (s *SCIONLabASController) parseRequestParameters(r *http.Request) (
 		err = fmt.Errorf("IP address cannot be empty for non-VPN setup. User: %v", slReq.UserEmail)
 		return
 	}
+	if slReq.IsVPN && slReq.VPNType != "" && slReq.VPNType != models.OpenVPN &&
+		slReq.VPNType != models.WireGuard {
+		err = fmt.Errorf("invalid VPN type %v", slReq.VPNType)
+		return
+	}
+	if err = slReq.validateIP(); err != nil {
+		return
+	}
+	err = slReq.validateLinkParameters()
 	return
 }
 
+// validateLinkParameters checks the optional MTU and port settings of the request. The limits
+// imposed by the AP are checked in getSCIONLabASInfo.
+func (slReq *SCIONLabRequest) validateLinkParameters() error {
+	if slReq.MTU != 0 && slReq.MTU < minMTU {
+		return fmt.Errorf("the MTU of the AS must be at least %v", minMTU)
+	}
+	if slReq.LinkMTU != 0 && slReq.LinkMTU < minMTU {
+		return fmt.Errorf("the MTU of the link must be at least %v", minMTU)
+	}
+	if slReq.ServicePort != 0 &&
+		(slReq.ServicePort < 1024 || int(slReq.ServicePort)+numServicePorts-1 > 65535) {
+		return fmt.Errorf("the service ports must be in the range 1024-65535")
+	}
+	if slReq.ServicePort != 0 && inServicePorts(brCtrlPort, slReq.ServicePort) {
+		return fmt.Errorf("the service ports must not include the control port %v of the "+
+			"border router", brCtrlPort)
+	}
+	if slReq.Port == brCtrlPort {
+		return fmt.Errorf("the port %v is the control port of the border router", brCtrlPort)
+	}
+	return nil
+}
+
+// inServicePorts returns whether the port is one of the ports of the internal services starting
+// at base
+func inServicePorts(port, base uint16) bool {
+	return port >= base && int(port) < int(base)+numServicePorts
+}
+
+// linkWithinLimits returns the MTU and bandwidth of the link requested to the AP, or an error if
+// they exceed the limits of the AP. If none are given, the defaults are used, unless the AP
+// imposes lower limits.
+func (slReq *SCIONLabRequest) linkWithinLimits(ap *models.AttachmentPoint) (uint16, uint64,
+	error) {
+	linkMTU, bandwidth := slReq.LinkMTU, slReq.Bandwidth
+	if linkMTU > ap.LinkMTULimit() {
+		return 0, 0, fmt.Errorf("the AttachmentPoint accepts a link MTU of at most %v",
+			ap.LinkMTULimit())
+	}
+	if linkMTU == 0 && models.MTUOrDefault(0) > ap.LinkMTULimit() {
+		linkMTU = ap.LinkMTULimit()
+	}
+	if bandwidth > ap.BandwidthLimit() {
+		return 0, 0, fmt.Errorf("the AttachmentPoint accepts a link bandwidth of at most %v",
+			ap.BandwidthLimit())
+	}
+	if bandwidth == 0 && models.BandwidthOrDefault(0) > ap.BandwidthLimit() {
+		bandwidth = ap.BandwidthLimit()
+	}
+	return linkMTU, bandwidth, nil
+}
+
+// validateIP checks that the public IP of the request, if given, is a valid IPv4 or IPv6 address
+// and stores it in its canonical form
+func (slReq *SCIONLabRequest) validateIP() error {
+	if slReq.IP == "" {
+		return nil
+	}
+	ip, err := utility.NormalizeIP(slReq.IP)
+	if err != nil {
+		return err
+	}
+	if !net.ParseIP(ip).IsGlobalUnicast() {
+		return fmt.Errorf("%v is not a valid public IP address", slReq.IP)
+	}
+	slReq.IP = ip
+	return nil
+}
+
+// memberAS returns the AS with the AS ID if the user with the email address has at least the
+// member role min in the account owning it. Otherwise it responds with an error and returns nil.
+func memberAS(ctx context.Context, c controllers.HTTPController, w http.ResponseWriter,
+	userEmail string, asID addr.AS, min string) *models.SCIONLabAS {
+	log := logger.FromContext(ctx)
+	as, err := models.FindSCIONLabASForUser(userEmail, asID, min)
+	switch err {
+	case nil:
+		return as
+	case orm.ErrNoRows:
+		c.NotFound(w, err, "AS %v not found", asID)
+	case models.ErrMemberRole:
+		c.Forbidden(w, err, err.Error())
+	default:
+		log.Errorf("Error looking up AS %v for user %v: %v", asID, userEmail, err)
+		c.Error500(w, err, "Error looking up the AS")
+	}
+	return nil
+}
+
 // Check if the user's AS is already in the process of being created or updated.
 func (s *SCIONLabASController) canConfigure(userEmail string, asID addr.AS) error {
 	as, err := models.FindSCIONLabASByUserEmailAndASID(userEmail, asID)
@@ -399,10 +559,12 @@ func (s *SCIONLabASController) checkRequest(slReq SCIONLabRequest) error {
 
 // Populates and returns a SCIONLabASInfo struct, which contains the necessary information
 // to create the SCIONLab AS configuration.
-func (s *SCIONLabASController) getSCIONLabASInfo(slReq SCIONLabRequest) (*SCIONLabASInfo, error) {
+func (s *SCIONLabASController) getSCIONLabASInfo(ctx context.Context,
+	slReq SCIONLabRequest) (*SCIONLabASInfo, error) {
+	log := logger.FromContext(ctx)
 	newConnection := true
 	var brID, vpnPort uint16
-	var ip, remoteIP, vpnIP, oldAP string
+	var ip, remoteIP, vpnIP, vpnType, oldAP string
 	var cn models.ConnectionInfo
 	// See if this user already has an AS
 	as, err := models.FindSCIONLabASByUserEmailAndASID(slReq.UserEmail, slReq.ASID)
@@ -438,17 +600,26 @@ func (s *SCIONLabASController) getSCIONLabASInfo(slReq SCIONLabRequest) (*SCIONL
 
 	// Different settings depending on whether it is a VPN or standard setup
 	if slReq.IsVPN {
-		if !remoteAS.AP.HasVPN {
-			return nil, errors.New("the AttachmentPoint does not have an openVPN server running")
+		vpnType = slReq.VPNType
+		if vpnType == "" {
+			vpnType = models.VPNTypeOrDefault(remoteAS.AP.VPNType)
+		}
+		if !remoteAS.AP.HasVPNType(vpnType) {
+			return nil, fmt.Errorf("the AttachmentPoint does not have a %v server running",
+				vpnType)
 		}
+		// an existing VPN connection keeps its address if it is still part of the AP's pool
+		var previousIP string
 		if !newConnection && cn.IsVPN {
-			ip = cn.LocalIP
-		} else {
-			ip, err = remoteAS.GetFreeVPNIP()
-			if err != nil {
-				return nil, err
-			}
-			log.Printf("New VPN IP to be assigned to user %v: %v", slReq.UserEmail, ip)
+			previousIP = cn.LocalIP
+		}
+		ip, err = remoteAS.AP.AllocateVPNIP(as, previousIP)
+		if err != nil {
+			return nil, fmt.Errorf("error assigning a VPN address at AttachmentPoint %v: %v",
+				slReq.ServerIA, err)
+		}
+		if ip != previousIP {
+			log.Infof("New VPN IP to be assigned to user %v: %v", slReq.UserEmail, ip)
 		}
 		remoteIP = remoteAS.AP.VPNIP
 		vpnIP = remoteAS.PublicIP
@@ -456,7 +627,11 @@ func (s *SCIONLabASController) getSCIONLabASInfo(slReq SCIONLabRequest) (*SCIONL
 	} else {
 		ip = slReq.IP
 		remoteIP = remoteAS.PublicIP
-		log.Printf("IP address of AttachementPoint = %v", remoteIP)
+		log.Infof("IP address of AttachementPoint = %v", remoteIP)
+		if utility.IPFamily(ip) != utility.IPFamily(remoteIP) {
+			return nil, fmt.Errorf("the AttachmentPoint %v cannot be reached over %v",
+				slReq.ServerIA, utility.IPFamily(ip))
+		}
 	}
 
 	if int(brID) < config.ReservedBRsInfrastructure {
@@ -464,13 +639,34 @@ func (s *SCIONLabASController) getSCIONLabASInfo(slReq SCIONLabRequest) (*SCIONL
 		if err != nil {
 			return nil, err
 		}
-		log.Printf("New BR ID to be assigned to user %v: %v", slReq.UserEmail, brID)
+		log.Infof("New BR ID to be assigned to user %v: %v", slReq.UserEmail, brID)
+	}
+
+	linkMTU, bandwidth, err := slReq.linkWithinLimits(remoteAS.AP)
+	if err != nil {
+		return nil, err
 	}
 
 	if slReq.Port > 0 {
 		as.StartPort = slReq.Port
 	}
+	as.MTU = slReq.MTU
+	as.ServicePort = slReq.ServicePort
+	base := as.ServicePortBase()
+	if inServicePorts(as.StartPort, base) {
+		return nil, fmt.Errorf("the port %v is already used by the internal services of the AS",
+			as.StartPort)
+	}
+	if inServicePorts(brCtrlPort, base) || as.StartPort == brCtrlPort {
+		return nil, fmt.Errorf("the port %v is already used by the control port of the "+
+			"border router", brCtrlPort)
+	}
+	format, err := GetPackageFormat(slReq.PackageFormat, slReq.Type)
+	if err != nil {
+		return nil, err
+	}
 	as.Type = slReq.Type
+	as.PackageFormat = format.Name()
 	if as.Status == models.Inactive {
 		as.Status = models.Create
 	} else {
@@ -483,6 +679,7 @@ func (s *SCIONLabASController) getSCIONLabASInfo(slReq SCIONLabRequest) (*SCIONL
 	return &SCIONLabASInfo{
 		IsNewConnection: newConnection,
 		IsVPN:           slReq.IsVPN,
+		VPNType:         vpnType,
 		RemoteIA:        remoteIA,
 		IP:              ip,
 		LocalPort:       as.StartPort,
@@ -490,8 +687,11 @@ func (s *SCIONLabASController) getSCIONLabASInfo(slReq SCIONLabRequest) (*SCIONL
 		RemoteIP:        remoteIP,
 		RemoteBRID:      brID,
 		RemotePort:      remoteAS.GetPortNumberFromBRID(brID),
+		LinkMTU:         linkMTU,
+		Bandwidth:       bandwidth,
 		VPNServerIP:     vpnIP,
 		VPNServerPort:   vpnPort,
+		VPNServerKey:    remoteAS.AP.WGPublicKey,
 		LocalAS:         as,
 		RemoteAS:        remoteAS,
 	}, nil
@@ -504,6 +704,7 @@ func getSCIONLabASInfoFromDB(conn *models.Connection) (*SCIONLabASInfo, error) {
 	asInfo := SCIONLabASInfo{
 		IsNewConnection: false,
 		IsVPN:           conn.IsVPN,
+		VPNType:         connVPNType(conn.IsVPN, conn.VPNType),
 		RemoteIA:        conn.RespondAP.AS.IA(),
 		IP:              conn.JoinIP,
 		LocalPort:       conn.JoinAS.StartPort,
@@ -511,8 +712,11 @@ func getSCIONLabASInfoFromDB(conn *models.Connection) (*SCIONLabASInfo, error) {
 		RemoteIP:        conn.RespondIP,
 		RemoteBRID:      conn.RespondBRID,
 		RemotePort:      conn.RespondAP.AS.GetPortNumberFromBRID(conn.RespondBRID),
+		LinkMTU:         conn.MTU,
+		Bandwidth:       conn.Bandwidth,
 		VPNServerIP:     conn.RespondAP.AS.PublicIP,
 		VPNServerPort:   conn.RespondAP.VPNPort,
+		VPNServerKey:    conn.RespondAP.WGPublicKey,
 		LocalAS:         conn.JoinAS,
 		RemoteAS:        conn.RespondAP.AS,
 	}
@@ -520,7 +724,7 @@ func getSCIONLabASInfoFromDB(conn *models.Connection) (*SCIONLabASInfo, error) {
 }
 
 // Updates the relevant database tables related to SCIONLab AS creation.
-func (s *SCIONLabASController) updateDB(asInfo *SCIONLabASInfo) error {
+func (s *SCIONLabASController) updateDB(ctx context.Context, asInfo *SCIONLabASInfo) error {
 	userEmail := asInfo.LocalAS.UserEmail
 	if asInfo.IsNewConnection {
 		// flag the old connections for deletion:
@@ -537,8 +741,12 @@ func (s *SCIONLabASController) updateDB(asInfo *SCIONLabASInfo) error {
 			RespondBRID:   asInfo.RemoteBRID,
 			Linktype:      models.Parent,
 			IsVPN:         asInfo.IsVPN,
+			VPNType:       asInfo.VPNType,
+			WGPublicKey:   asInfo.WGPublicKey,
 			JoinStatus:    models.Active,
 			RespondStatus: models.Create,
+			MTU:           asInfo.LinkMTU,
+			Bandwidth:     asInfo.Bandwidth,
 		}
 		if err := newCn.Insert(); err != nil {
 			return fmt.Errorf("error inserting new Connection for user %v: %v",
@@ -565,16 +773,27 @@ func (s *SCIONLabASController) updateDB(asInfo *SCIONLabASInfo) error {
 				asInfo.LocalAS.IAString(), asInfo.RemoteIA, len(cns))
 		}
 		cn := cns[0]
+		oldVPNIP := ""
+		if cn.IsVPN && (!asInfo.IsVPN || cn.LocalIP != asInfo.IP) {
+			oldVPNIP = cn.LocalIP
+		}
 		cn.BRID = 1
 		cn.IsVPN = asInfo.IsVPN
+		cn.VPNType = asInfo.VPNType
+		cn.WGPublicKey = asInfo.WGPublicKey
 		cn.LocalIP = asInfo.IP
 		cn.NeighborIP = asInfo.RemoteIP
+		cn.MTU = asInfo.LinkMTU
+		cn.Bandwidth = asInfo.Bandwidth
 		cn.NeighborStatus = asInfo.LocalAS.Status
 		cn.Status = models.Active
 		if err := asInfo.LocalAS.UpdateASAndConnectionFromJoinConnInfo(&cn); err != nil {
 			return fmt.Errorf("error updating database tables for user %v: %v",
 				userEmail, err)
 		}
+		if oldVPNIP != "" {
+			releaseVPNIP(ctx, asInfo.RemoteAS.AP, oldVPNIP, asInfo.LocalAS)
+		}
 	}
 	return nil
 }
@@ -607,8 +826,9 @@ func (asInfo *SCIONLabASInfo) topologyFile() string {
 // Generates the topology file for the SCIONLab AS AS. It uses the template file
 // simple_config_topo.tmpl under templates folder in order to populate and generate the
 // JSON file.
-func generateTopologyFile(asInfo *SCIONLabASInfo) error {
-	log.Printf("Generating topology file for SCIONLab AS")
+func generateTopologyFile(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Generating topology file for SCIONLab AS")
 	t, err := template.ParseFiles("templates/simple_config_topo.tmpl")
 	if err != nil {
 		return fmt.Errorf("error parsing topology template config for user %v: %v",
@@ -627,6 +847,9 @@ func generateTopologyFile(asInfo *SCIONLabASInfo) error {
 
 	// Topology file parameters
 	data := map[string]string{
+		"ADDR_TYPE":    utility.IPFamily(localIP),
+		"OVERLAY":      utility.OverlayType(localIP),
+		"LINK_OVERLAY": utility.OverlayType(asInfo.IP),
 		"IP":           asInfo.IP,
 		"BIND_IP":      asInfo.LocalAS.BindIP(asInfo.IsVPN, asInfo.IP),
 		"ISD_ID":       fmt.Sprintf("%d", asInfo.LocalAS.ISD),
@@ -637,6 +860,12 @@ func generateTopologyFile(asInfo *SCIONLabASInfo) error {
 		"TARGET_ISDAS": asInfo.RemoteIA.String(),
 		"REMOTE_ADDR":  asInfo.RemoteIP,
 		"REMOTE_PORT":  strconv.Itoa(int(asInfo.RemotePort)),
+		"MTU":          strconv.Itoa(int(asInfo.LocalAS.InternalMTU())),
+		"LINK_MTU":     strconv.Itoa(int(models.MTUOrDefault(asInfo.LinkMTU))),
+		"BANDWIDTH":    strconv.FormatUint(models.BandwidthOrDefault(asInfo.Bandwidth), 10),
+	}
+	for k, v := range servicePorts(asInfo.LocalAS) {
+		data[k] = v
 	}
 	if err = t.Execute(f, data); err != nil {
 		return fmt.Errorf("error executing topology template file for user %v: %v",
@@ -646,31 +875,52 @@ func generateTopologyFile(asInfo *SCIONLabASInfo) error {
 	return nil
 }
 
+// servicePorts returns the topology parameters of the ports of the internal services and of the
+// control port of the border router of the AS
+func servicePorts(as *models.SCIONLabAS) map[string]string {
+	port := func(offset uint16) string {
+		return strconv.Itoa(int(as.ServicePortBase() + offset))
+	}
+	return map[string]string{
+		"BS_PORT":      port(0),
+		"BR_PORT":      port(1),
+		"CS_PORT":      port(2),
+		"PS_PORT":      port(3),
+		"SB_PORT":      port(4),
+		"BR_CTRL_PORT": strconv.Itoa(brCtrlPort),
+	}
+}
+
 // TODO(mlegner): Add option specifying already existing keys and certificates
 // Creates the local gen folder of the SCIONLab AS AS. It calls a Python wrapper script
 // located under the python directory. The script uses SCION's and SCION-WEB's library
 // functions in order to generate the certificate, AS keys etc.
-func generateLocalGen(asInfo *SCIONLabASInfo) error {
-	log.Printf("Creating gen folder for SCIONLab AS")
+func generateLocalGen(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Creating gen folder for SCIONLab AS")
 	isd := asInfo.LocalAS.ISD
 	asID := asInfo.LocalAS.ASID
 	userEmail := asInfo.LocalAS.UserEmail
-	log.Printf("Calling create local gen. ISD-ID: %v, AS-ID: %v, UserEmail: %v", isd, asID,
+	log.Infof("Calling create local gen. ISD-ID: %v, AS-ID: %v, UserEmail: %v", isd, asID,
 		userEmail)
 	signingAs, haveit := config.SigningASes[isd]
 	if !haveit {
 		return fmt.Errorf("signing AS for ISD %v not configured", isd)
 	}
 
-	cmd := exec.Command("python3", localGenPath,
-		"--topo_file="+asInfo.topologyFile(), "--user_id="+asInfo.UserPackageName(),
-		"--joining_ia="+utility.IAStringStandard(isd, asID),
-		"--core_ia="+utility.IAStringStandard(isd, signingAs),
-		"--core_sign_priv_key_file="+CoreSigKey(isd),
-		"--core_cert_file="+CoreCertFile(isd),
-		"--trc_file="+TrcFile(isd),
-		"--package_path="+PackagePath,
-		"--no-prometheus")
+	args := []string{localGenPath,
+		"--topo_file=" + asInfo.topologyFile(), "--user_id=" + asInfo.UserPackageName(),
+		"--joining_ia=" + utility.IAStringStandard(isd, asID),
+		"--core_ia=" + utility.IAStringStandard(isd, signingAs),
+		"--core_sign_priv_key_file=" + CoreSigKey(isd),
+		"--core_cert_file=" + CoreCertFile(isd),
+		"--trc_file=" + TrcFile(isd),
+		"--package_path=" + PackagePath,
+		"--no-prometheus"}
+	if asInfo.CertVersion > 0 {
+		args = append(args, fmt.Sprintf("--cert_version=%d", asInfo.CertVersion))
+	}
+	cmd := exec.Command("python3", args...)
 	pyPaths := []string{}
 	if pythonPath != "" {
 		pyPaths = []string{pythonPath}
@@ -682,7 +932,7 @@ func generateLocalGen(asInfo *SCIONLabASInfo) error {
 		pyPaths = append(pyPaths, scionUtilPath)
 	}
 	pyPath := strings.Join(pyPaths, ":")
-	fmt.Println("PYTHONPATH:", pyPath)
+	log.Debugf("PYTHONPATH: %v", pyPath)
 	os.Setenv("PYTHONPATH", pyPath)
 	cmd.Env = os.Environ()
 	cmdOut, _ := cmd.StdoutPipe()
@@ -694,43 +944,28 @@ func generateLocalGen(asInfo *SCIONLabASInfo) error {
 	// read stdout and stderr
 	stdOutput, _ := ioutil.ReadAll(cmdOut)
 	errOutput, _ := ioutil.ReadAll(cmdErr)
-	fmt.Printf("STDOUT generateLocalGen: %s\n", stdOutput)
-	fmt.Printf("ERROUT generateLocalGen: %s\n", errOutput)
+	log.With("stdout", string(stdOutput)).With("stderr", string(errOutput)).
+		Debugf("Output of generate local gen for %v", userEmail)
 	if len(errOutput) != 0 {
 		return fmt.Errorf("generate local gen command reported errors: %s", errOutput)
 	}
 	return nil
 }
 
-func addAuxiliaryFiles(asInfo *SCIONLabASInfo) error {
-	userEmail := asInfo.LocalAS.UserEmail
-	userPackagePath := asInfo.UserPackagePath()
-	log.Printf("Adding auxiliary files to the package %v", asInfo.UserPackageName())
-	if asInfo.LocalAS.Type == models.Dedicated {
-		dedicatedAuxFiles := filepath.Join(auxFilesPath, "dedicated_box")
-		err := utility.CopyPath(dedicatedAuxFiles, userPackagePath)
-		if err != nil {
-			return fmt.Errorf("failed to copy files for user %v: src: %v, dst: %v, %v",
-				userEmail, dedicatedAuxFiles, userPackagePath, err)
-		}
-	}
-	return nil
-}
-
 // the generated AS will have new certificates. Only if they have a higher version that our cache
 // we will keep them. Otherwise we will replace them with our cache's
-func preserveCerts(asInfo *SCIONLabASInfo) error {
-	// this functions copies "certs" and "keys" from "src" to all
+func preserveCerts(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	log := logger.FromContext(ctx)
+	// this functions copies "certs" and "keys" between the certificate cache and all
 	// "dstSubDirs" in "dst"
 	packageName := asInfo.UserPackageName()
-	log.Printf("Trying to preserve certificates for %s", packageName)
-	src := filepath.Join(PackagePath, CertsPath, packageName)
+	log.Infof("Trying to preserve certificates for %s", packageName)
+	cacheKey := certCacheKey(asInfo.LocalAS)
 	dst := filepath.Join(asInfo.UserPackagePath(),
 		"gen",
 		fmt.Sprintf("ISD%d", asInfo.LocalAS.ISD),
 		fmt.Sprintf("AS%s", asInfo.LocalAS.IA().A.FileFmt()),
 	)
-	dstSubDirs := []string{"."} // we assume we'll copy to the cache
 	if _, err := os.Stat(dst); os.IsNotExist(err) {
 		return fmt.Errorf("Path should exist but does not (%s): %v", dst, err)
 	}
@@ -747,7 +982,7 @@ func preserveCerts(asInfo *SCIONLabASInfo) error {
 		if len(groups) == 2 {
 			v, err := strconv.Atoi(groups[1][1:])
 			if err != nil {
-				log.Printf(`skipping version "%s": cannot parse: %v`, groups[1][1:], err)
+				log.Warnf(`skipping version "%s": cannot parse: %v`, groups[1][1:], err)
 				continue
 			}
 			if v > maxNewVersion {
@@ -758,154 +993,104 @@ func preserveCerts(asInfo *SCIONLabASInfo) error {
 	if maxNewVersion < 0 {
 		return fmt.Errorf("Could not find a valid certificate version for AS in %s", packageName)
 	}
-	// get the highest version from the cache:
-	maxExistingVersion := -1
-	if _, err := os.Stat(src); os.IsNotExist(err) {
-		// we don't have a cache yet. Create it with no version yet
-		log.Printf("No certificate cache, creating empty one")
-		err = os.MkdirAll(src, 0700)
-		if err != nil {
-			return fmt.Errorf("Error calling mkdir on %s: %v", dst, err)
-		}
-	} else if err == nil {
-		// we have a cache. Find out the newest version
-		log.Printf("Certificate cache found.")
-		existingVersions, err := filepath.Glob(filepath.Join(src, "V*"))
-		if err != nil {
-			return fmt.Errorf("Could not read %s: %v", newCertsPath, err)
-		}
-		for _, d := range existingVersions {
-			d = filepath.Base(d)
-			v, err := strconv.Atoi(d[1:])
-			if err != nil {
-				log.Printf(`skipping version "%s": cannot parse: %v`, d[1:], err)
-				continue
+	// get the highest version from the cache; -1 if we don't have a cache yet
+	maxExistingVersion, err := latestCachedCertVersion(ctx, cacheKey)
+	if err != nil {
+		return err
+	}
+	log.Infof("Cert. versions. Existing is %d, generated is %d", maxExistingVersion, maxNewVersion)
+	if maxNewVersion > maxExistingVersion {
+		// new generated certificate version is newer. Store it in the cache, using the certs
+		// from endhost
+		for _, dir := range []string{"certs", "keys"} {
+			src := filepath.Join(dst, "endhost", dir)
+			if _, err := os.Stat(src); err != nil {
+				return fmt.Errorf("Could not read the directory %s", src)
 			}
-			if v > maxExistingVersion {
-				maxExistingVersion = v
+			err = storage.PutDir(Artifacts, src, fmt.Sprintf("%s/V%d/%s", cacheKey,
+				maxNewVersion, dir))
+			if err != nil {
+				return fmt.Errorf("Could not store %s in the certificate cache: %v", src, err)
 			}
 		}
-	} else {
-		return fmt.Errorf("Error when stat on path %s: %v", src, err)
+		log.Infof("Preserve certificates completed")
+		return nil
 	}
-	log.Printf("Cert. versions. Existing is %d, generated is %d", maxExistingVersion, maxNewVersion)
-	if maxNewVersion > maxExistingVersion {
-		// new generated certificate version is newer. Swap src and dst
-		src, dst = dst, src
-		dst = filepath.Join(dst, fmt.Sprintf("V%d", maxNewVersion))
-		err = os.MkdirAll(dst, 0700)
-		if err != nil {
-			return fmt.Errorf("Error calling mkdir on %s: %v", dst, err)
-		}
-		src = filepath.Join(src, "endhost") // use the certs from endhost
-	} else {
-		// "normal" case, from cache to AS folder. Find the dstSubDirs
-		src = filepath.Join(src, fmt.Sprintf("V%d", maxExistingVersion))
-		fileInfos, err := ioutil.ReadDir(dst)
-		if err != nil {
-			return fmt.Errorf("Could not read directory %s: %v", dst, err)
-		}
-		dstSubDirs = nil
-		for _, f := range fileInfos {
-			name := f.Name()
-			if f.IsDir() && strings.HasPrefix(name, "br") ||
-				strings.HasPrefix(name, "cs") ||
-				strings.HasPrefix(name, "bs") ||
-				strings.HasPrefix(name, "ps") ||
-				name == "endhost" {
-				dstSubDirs = append(dstSubDirs, name)
-			}
+	// "normal" case, from cache to AS folder. Find the dstSubDirs
+	fileInfos, err := ioutil.ReadDir(dst)
+	if err != nil {
+		return fmt.Errorf("Could not read directory %s: %v", dst, err)
+	}
+	var dstSubDirs []string
+	for _, f := range fileInfos {
+		name := f.Name()
+		if f.IsDir() && strings.HasPrefix(name, "br") ||
+			strings.HasPrefix(name, "cs") ||
+			strings.HasPrefix(name, "bs") ||
+			strings.HasPrefix(name, "ps") ||
+			name == "endhost" {
+			dstSubDirs = append(dstSubDirs, name)
 		}
 	}
-	// in any case, copy from src to dst:
 	for _, dir := range []string{"certs", "keys"} {
-		srcItem := filepath.Join(src, dir)
-		_, err := os.Stat(srcItem)
-		if err != nil {
-			return fmt.Errorf("Could not read the directory %s", srcItem)
-		}
+		src := fmt.Sprintf("%s/V%d/%s", cacheKey, maxExistingVersion, dir)
 		for _, dstSubDir := range dstSubDirs {
 			dstItem := filepath.Join(dst, dstSubDir, dir)
-			err = os.MkdirAll(dstItem, 0700)
-			if err != nil {
-				return fmt.Errorf("Could not mkdir part of the destination path %s: %v", dstItem, err)
-			}
-			err = utility.CopyPath(srcItem, dstItem)
-			if err != nil {
-				return fmt.Errorf("Could not fully copy %s to %s: %v", srcItem, dstItem, err)
+			if err = storage.GetDir(Artifacts, src, dstItem); err != nil {
+				return fmt.Errorf("Could not fully copy %s to %s: %v", src, dstItem, err)
 			}
 		}
 	}
-	log.Printf("Preserve certificates completed")
+	log.Infof("Preserve certificates completed")
 	return nil
 }
 
-// TODO(mlegner): Add README for Dedicated setup
-// Packages the SCIONLab AS configuration as a tarball and returns the name of the
-// generated file.
-func packageConfiguration(asInfo *SCIONLabASInfo) error {
-	log.Printf("Packaging SCIONLab AS")
-	userEmail := asInfo.LocalAS.UserEmail
-	userPackageName := asInfo.UserPackageName()
-	userPackagePath := asInfo.UserPackagePath()
-
-	// Only copy all vagrant-related files if this is a VM-type AS
-	if asInfo.LocalAS.Type == models.VM {
-		vagrantDir, err := os.Open(vagrantPath)
-		if err != nil {
-			return fmt.Errorf("failed to open directory. Path: %v, %v", vagrantPath, err)
-		}
-		objects, err := vagrantDir.Readdir(-1)
-		if err != nil {
-			return fmt.Errorf("failed to read directory contents. Path: %v, %v", vagrantPath, err)
-		}
-		for _, obj := range objects {
-			src := filepath.Join(vagrantPath, obj.Name())
-			dst := filepath.Join(userPackagePath, obj.Name())
-			if !obj.IsDir() {
-				if err = utility.CopyFile(src, dst); err != nil {
-					return fmt.Errorf("failed to copy files for user %v: src: %v, dst: %v, %v",
-						userEmail, src, dst, err)
-				}
-			}
-		}
-		portForwarding := ""
-		if !asInfo.IsVPN {
-			portForwarding = fmt.Sprintf("config.vm.network \"forwarded_port\", "+
-				"guest: %[1]v, host: %[1]v, protocol: \"udp\"", asInfo.LocalPort)
-		}
-		data := struct {
-			ASID           string
-			PortForwarding string
-		}{
-			ASID:           asInfo.LocalAS.ASID.FileFmt(),
-			PortForwarding: portForwarding,
-		}
-		if err := utility.FillTemplateAndSave("templates/Vagrantfile.tmpl",
-			data, filepath.Join(userPackagePath, "Vagrantfile")); err != nil {
-			return err
-		}
+// Adds the files of the package format of the AS and packages the SCIONLab AS configuration
+// as a tarball.
+func packageConfiguration(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Packaging SCIONLab AS")
+	format, err := GetPackageFormat(asInfo.LocalAS.PackageFormat, asInfo.LocalAS.Type)
+	if err != nil {
+		return err
 	}
+	log.Infof("Adding the files of the %v package format", format.Name())
+	if err = format.AddFiles(asInfo); err != nil {
+		return fmt.Errorf("failed to add the %v files for user %v: %v", format.Name(),
+			asInfo.LocalAS.UserEmail, err)
+	}
+	return createTarball(ctx, asInfo)
+}
 
-	cmd := exec.Command("tar", "czf", userPackageName+".tar.gz", userPackageName)
+// Creates the tarball of the package directory, stores it and keeps a copy of it for the
+// current configuration version.
+func createTarball(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	userEmail := asInfo.LocalAS.UserEmail
+	cmd := exec.Command("tar", "czf", "-", asInfo.UserPackageName())
 	cmd.Dir = PackagePath
-	err := cmd.Start()
-	if err == nil {
-		err = cmd.Wait()
-	}
+	tarball, err := cmd.Output()
 	if err != nil {
 		return fmt.Errorf("failed to create SCIONLabAS tarball for user %v: %v", userEmail, err)
 	}
+	as := asInfo.LocalAS
+	if err = storage.PutBytes(Artifacts, packageKey(userEmail, as.ISD, as.ASID),
+		tarball); err != nil {
+		return fmt.Errorf("failed to store SCIONLabAS tarball for user %v: %v", userEmail, err)
+	}
+	if err = archivePackageVersion(ctx, asInfo, tarball); err != nil {
+		return fmt.Errorf("failed to keep version %v of the SCIONLabAS tarball for user %v: %v",
+			asInfo.LocalAS.ConfVersion, userEmail, err)
+	}
 
 	return nil
 }
 
-func createUserLoginConfiguration(asInfo *SCIONLabASInfo) error {
-	log.Printf("Creating user authentication files")
-	userEmail := asInfo.LocalAS.UserEmail
-	acc, err := models.FindAccountByUserEmail(userEmail)
+func createUserLoginConfiguration(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Creating user authentication files")
+	acc, err := asInfo.LocalAS.OwnerAccount()
 	if err != nil {
-		return fmt.Errorf("failed to find account for email %s: %v", userEmail, err)
+		return fmt.Errorf("failed to find the account of AS %v: %v", asInfo.LocalAS, err)
 	}
 
 	userGenDir := filepath.Join(asInfo.UserPackagePath(), "gen")
@@ -948,9 +1133,10 @@ func createUserLoginConfiguration(asInfo *SCIONLabASInfo) error {
 
 // API end-point to serve the generated SCIONLab AS configuration tarball.
 func (s *SCIONLabASController) ReturnTarball(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	_, uSess, err := middleware.GetUserSession(r)
 	if err != nil {
-		log.Printf("Error getting the user session: %v", err)
+		log.Errorf("Error getting the user session: %v", err)
 		s.Forbidden(w, err, "Error getting the user session")
 		return
 	}
@@ -958,27 +1144,32 @@ func (s *SCIONLabASController) ReturnTarball(w http.ResponseWriter, r *http.Requ
 	asIDstr := vars["as_id"]
 	asID, err := utility.ASIDFromString(asIDstr)
 	if err != nil {
-		s.BadRequestAndLog(w, nil, err.Error())
+		s.BadRequestAndLog(r.Context(), w, nil, err.Error())
+		return
+	}
+	as := memberAS(r.Context(), s.HTTPController, w, uSess.Email, asID, models.MemberMaintainer)
+	if as == nil {
 		return
 	}
-	as, err := models.FindSCIONLabASByUserEmailAndASID(uSess.Email, asID)
-	if err != nil || as.Status == models.Inactive || as.Status == models.Remove {
-		s.BadRequestAndLog(w, nil, "No active configuration found for user %v, asID %v", uSess.Email, asID)
+	if as.Status == models.Inactive || as.Status == models.Remove {
+		s.BadRequestAndLog(r.Context(), w, nil, "No active configuration found for user %v, asID %v", uSess.Email, asID)
 		return
 	}
 
-	fileName := UserPackageName(uSess.Email, as.ISD, as.ASID) + ".tar.gz"
-	filePath := filepath.Join(PackagePath, fileName)
-	err = sendAlreadyCompressedFile(w, filePath, "scion_lab_"+fileName)
+	fileName := UserPackageName(as.UserEmail, as.ISD, as.ASID) + ".tar.gz"
+	err = sendAlreadyCompressedFile(w, packageKey(as.UserEmail, as.ISD, as.ASID),
+		"scion_lab_"+fileName)
 	if err != nil {
 		s.Error500(w, err, "Error reading tarball")
 		return
 	}
 }
 
-func logAndSendError(w http.ResponseWriter, errorMsgFmt string, parms ...interface{}) string {
+func logAndSendError(ctx context.Context, w http.ResponseWriter, errorMsgFmt string,
+	parms ...interface{}) string {
+	log := logger.FromContext(ctx)
 	errorMsg := fmt.Sprintf(errorMsgFmt, parms...)
-	log.Print(errorMsg)
+	log.Errorf("%v", errorMsg)
 	dict := make(map[string]interface{})
 	dict["error"] = true
 	dict["msg"] = errorMsg
@@ -986,9 +1177,9 @@ func logAndSendError(w http.ResponseWriter, errorMsgFmt string, parms ...interfa
 	return errorMsg
 }
 
-func logAndSendErrorAndNotifyAdmins(w http.ResponseWriter, errorMsgFmt string, parms ...interface{}) {
-	msg := logAndSendError(w, errorMsgFmt, parms...)
-	email.SendEmailToAdmins("ERROR in remap", msg)
+func logAndSendErrorAndNotifyAdmins(ctx context.Context, w http.ResponseWriter, errorMsgFmt string, parms ...interface{}) {
+	msg := logAndSendError(ctx, w, errorMsgFmt, parms...)
+	email.SendEmailToAdmins(ctx, "ERROR in remap", msg)
 }
 
 func getASAndCheckChallenge(r *http.Request, ia string, verifyChallenge bool) (
@@ -1028,14 +1219,15 @@ func getASAndCheckChallenge(r *http.Request, ia string, verifyChallenge bool) (
 	if err != nil {
 		return nil, nil, newMappingError(true, "Internal error: cannot decode the stored challenge, IA: %v", ia)
 	}
-	err = verifySignatureFromAS(as, challengeAsBytes, receivedSignature)
+	err = verifySignatureFromAS(r.Context(), as, challengeAsBytes, receivedSignature)
 	if err != nil {
 		return nil, nil, newMappingError(true, "Cannot verify signature for IA %v: %v", ia, err)
 	}
 	return as, request, nil
 }
 
-func verifySignatureFromAS(as *models.SCIONLabAS, thingToSign, receivedSignature []byte) error {
+func verifySignatureFromAS(ctx context.Context, as *models.SCIONLabAS, thingToSign,
+	receivedSignature []byte) error {
 	path := filepath.Join(PackagePath,
 		UserPackageName(as.UserEmail, as.ISD, as.ASID),
 		"gen",
@@ -1067,7 +1259,7 @@ func verifySignatureFromAS(as *models.SCIONLabAS, thingToSign, receivedSignature
 	chain, err = cert.ChainFromRaw(chainBytes, false)
 	if err != nil || chain == nil {
 		msg := fmt.Sprintf("ERROR in Coordinator: cannot load the public certificate for AS %s : %v", as.IAString(), err)
-		email.SendEmailToAdmins("ERROR in remap", msg)
+		email.SendEmailToAdmins(ctx, "ERROR in remap", msg)
 		return errors.New(msg)
 	}
 	publicKey := chain.Leaf.SubjectSignKey
@@ -1078,7 +1270,7 @@ func verifySignatureFromAS(as *models.SCIONLabAS, thingToSign, receivedSignature
 // remapASIDComputeNewGenFolder creates a new gen folder using a valid remapped ID
 // e.g. 17-ffaa:0:1 . This does not change IDs in the DB but recomputes topologies and certificates.
 // After finishing, there will be a new tgz file ready to download using the mapped ID.
-func remapASIDComputeNewGenFolder(as *models.SCIONLabAS) (*addr.IA, error) {
+func remapASIDComputeNewGenFolder(ctx context.Context, as *models.SCIONLabAS) (*addr.IA, error) {
 	ia := utility.MapOldIAToNewOne(as.ISD, as.ASID)
 	if ia.I == 0 || ia.A == 0 {
 		return nil, fmt.Errorf("Invalid source address to map: (%d, %d)", as.ISD, as.ASID)
@@ -1088,13 +1280,13 @@ func remapASIDComputeNewGenFolder(as *models.SCIONLabAS) (*addr.IA, error) {
 	as.ASID = ia.A
 	// generate the tarball with +1, as it is a new configuration. But don't save to DB
 	as.ConfVersion++
-	err := computeNewGenFolder(as)
+	err := computeNewGenFolder(ctx, as)
 	ia = as.IA()
 	return &ia, err
 }
 
 // computeNewGenFolder takes a SCIONLabAS model and (re)creates a tarbal and configuration folder
-func computeNewGenFolder(as *models.SCIONLabAS) error {
+func computeNewGenFolder(ctx context.Context, as *models.SCIONLabAS) error {
 	ia := as.IA()
 	// retrieve connection:
 	conns, err := as.GetJoinNotRemovedConnections()
@@ -1113,11 +1305,12 @@ func computeNewGenFolder(as *models.SCIONLabAS) error {
 	}
 	// finally, generate the gen folder:
 	os.RemoveAll(asInfo.UserPackagePath())
-	return generateGenForAS(asInfo)
+	return generateGenForAS(ctx, asInfo)
 }
 
 // RemapASIdentityChallengeAndSolution returns the challenge the AS should solve if said AS has to map the identity.
 func (s *SCIONLabASController) RemapASIdentityChallengeAndSolution(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	answeringChallenge := false
 	if r.Method == http.MethodPost {
 		answeringChallenge = true
@@ -1127,13 +1320,13 @@ func (s *SCIONLabASController) RemapASIdentityChallengeAndSolution(w http.Respon
 	vars := mux.Vars(r)
 	ia, err := utility.NormalizeIAString(vars["ia"])
 	if err != nil {
-		logAndSendError(w, err.Error())
+		logAndSendError(r.Context(), w, err.Error())
 		return
 	}
-	log.Printf("Remap request from %v. Solving challenge? %v", ia, answeringChallenge)
+	log.Infof("Remap request from %v. Solving challenge? %v", ia, answeringChallenge)
 	as, _, mapErr := getASAndCheckChallenge(r, ia, answeringChallenge)
 	if mapErr != nil {
-		mapErr.LogAndNotifyAppropriately(w, mapErr.Error())
+		mapErr.LogAndNotifyAppropriately(r.Context(), w, mapErr.Error())
 		return
 	}
 	if !answeringChallenge {
@@ -1141,49 +1334,51 @@ func (s *SCIONLabASController) RemapASIdentityChallengeAndSolution(w http.Respon
 		answer["pending"] = needsRemap
 		challenge, err := as.GetRemapChallenge()
 		if err != nil && needsRemap {
-			logAndSendErrorAndNotifyAdmins(w, err.Error())
+			logAndSendErrorAndNotifyAdmins(r.Context(), w, err.Error())
 			return
 		}
 		answer["challenge"] = challenge
 		utility.SendJSON(answer, w)
-		log.Printf("Remap: sent challenge for %v", ia)
+		log.Infof("Remap: sent challenge for %v", ia)
 		return
 	}
-	answer["ia"], err = remapASIDComputeNewGenFolder(as)
+	answer["ia"], err = remapASIDComputeNewGenFolder(r.Context(), as)
 	if err != nil {
-		logAndSendErrorAndNotifyAdmins(w, "ERROR in Coordinator: while mapping the ID, cannot generate a gen folder for the AS %s : %s", ia, err.Error())
+		logAndSendErrorAndNotifyAdmins(r.Context(), w, "ERROR in Coordinator: while mapping the ID, cannot generate a gen folder for the AS %s : %s", ia, err.Error())
 		return
 	}
 	err = utility.SendJSON(answer, w)
 	if err != nil {
-		log.Printf("Error during JSON marshaling: %v", err)
+		log.Errorf("Error during JSON marshaling: %v", err)
 		s.Error500(w, err, "Error during JSON marshaling")
 		return
 	}
-	log.Printf("Remap: finished computing new GEN.")
+	log.Infof("Remap: finished computing new GEN.")
 }
 
 // RemapASDownloadGen will accept a JSON object containing the query from a user AS to obtain the
 // new gen folder for a new ID after the remap on the IDs during the summer of 2018
 func (s *SCIONLabASController) RemapASDownloadGen(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	vars := mux.Vars(r)
 	ia, err := utility.NormalizeIAString(vars["ia"])
 	if err != nil {
-		logAndSendError(w, err.Error())
+		logAndSendError(r.Context(), w, err.Error())
 		return
 	}
-	log.Printf("Remap: request download GEN from %v", ia)
+	log.Infof("Remap: request download GEN from %v", ia)
 	as, _, mapErr := getASAndCheckChallenge(r, ia, true)
 	if mapErr != nil {
-		mapErr.LogAndNotifyAppropriately(w, mapErr.Error())
+		mapErr.LogAndNotifyAppropriately(r.Context(), w, mapErr.Error())
 		return
 	}
 	mappedIA := utility.MapOldIAToNewOne(as.ISD, as.ASID)
 	fileName := UserPackageName(as.UserEmail, mappedIA.I, mappedIA.A) + ".tar.gz"
-	filePath := filepath.Join(PackagePath, fileName)
-	err = sendAlreadyCompressedFile(w, filePath, "scion_lab_"+fileName)
+	err = sendAlreadyCompressedFile(w, packageKey(as.UserEmail, mappedIA.I, mappedIA.A),
+		"scion_lab_"+fileName)
 	if err != nil {
-		logAndSendError(w, "Error reading the tarball. FileName: %v, %v", fileName, err)
+		logAndSendError(r.Context(), w, "Error reading the tarball. FileName: %v, %v", fileName,
+			err)
 		return
 	}
 }
@@ -1191,16 +1386,17 @@ func (s *SCIONLabASController) RemapASDownloadGen(w http.ResponseWriter, r *http
 // RemapASConfirmStatus receives confirmation from a user AS that they applied the mapping.
 // The confirmation is writen in the DB with a timestamp.
 func (s *SCIONLabASController) RemapASConfirmStatus(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	vars := mux.Vars(r)
 	ia, err := utility.NormalizeIAString(vars["ia"])
 	if err != nil {
-		logAndSendError(w, err.Error())
+		logAndSendError(r.Context(), w, err.Error())
 		return
 	}
-	log.Printf("Remap: confirming mapping for %v", ia)
+	log.Infof("Remap: confirming mapping for %v", ia)
 	as, _, mapErr := getASAndCheckChallenge(r, ia, true)
 	if mapErr != nil {
-		mapErr.LogAndNotifyAppropriately(w, mapErr.Error())
+		mapErr.LogAndNotifyAppropriately(r.Context(), w, mapErr.Error())
 		return
 	}
 	mappedIA := utility.MapOldIAToNewOne(as.ISD, as.ASID)
@@ -1212,17 +1408,18 @@ func (s *SCIONLabASController) RemapASConfirmStatus(w http.ResponseWriter, r *ht
 	// set its status to Create so the AP will create it:
 	conns, err := as.GetJoinNotRemovedConnections()
 	if err != nil {
-		logAndSendError(w, err.Error())
+		logAndSendError(r.Context(), w, err.Error())
 		return
 	}
 	if len(conns) != 1 {
-		logAndSendError(w, "User AS should have only 1 connection. %s has %d", ia, len(conns))
+		logAndSendError(r.Context(), w, "User AS should have only 1 connection. %s has %d", ia,
+			len(conns))
 		return
 	}
 	conns[0].RespondStatus = models.Create
 	err = conns[0].Update()
 	if err != nil {
-		logAndSendError(w, "Cannot update connection for AS %v: %v", ia, err)
+		logAndSendError(r.Context(), w, "Cannot update connection for AS %v: %v", ia, err)
 		return
 	}
 	as.Status = models.Create
@@ -1233,59 +1430,80 @@ func (s *SCIONLabASController) RemapASConfirmStatus(w http.ResponseWriter, r *ht
 		answer["error"] = true
 		msg := fmt.Sprintf("Could not update mapping status for AS: %v", err)
 		answer["msg"] = msg
-		log.Print(msg)
+		log.Errorf("%v", msg)
 		utility.SendJSONError(answer, w)
 		return
 	}
-	log.Printf("Updated mapping for AS %v -> %v", ia, mappedIA)
+	log.Infof("Updated mapping for AS %v -> %v", ia, mappedIA)
 }
 
 // The handler function to remove a SCIONLab AS for the given user.
 // If successful, it will return a 200 status with an empty response.
 func (s *SCIONLabASController) RemoveSCIONLabAS(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	_, uSess, err := middleware.GetUserSession(r)
 	if err != nil {
-		log.Printf("Error getting the user session: %v", err)
+		log.Errorf("Error getting the user session: %v", err)
 		s.Error500(w, err, "Error getting the user session")
 	}
-	userEmail := uSess.Email
 	vars := mux.Vars(r)
 	asIDStr := vars["as_id"]
 	asID, err := utility.ASIDFromString(asIDStr)
 	if err != nil {
-		log.Println(err.Error())
+		log.Errorf("%v", err)
 		s.Error500(w, err, "Bad format")
 		return
 	}
+	as := memberAS(r.Context(), s.HTTPController, w, uSess.Email, asID, models.MemberOwner)
+	if as == nil {
+		return
+	}
+	userEmail := as.UserEmail
 	// check if there is an active AS which can be removed
-	canRemove, as, cn, err := s.canRemove(userEmail, asID)
+	canRemove, as, cn, err := canRemove(userEmail, asID)
 	if err != nil {
-		log.Printf("Error checking if your AS can be removed for user %v: %v", userEmail, err)
+		log.Errorf("Error checking if your AS can be removed for user %v: %v", userEmail, err)
 		s.Error500(w, err, "Error checking if AS can be removed")
 		return
 	}
 	if !canRemove {
-		s.BadRequestAndLog(w, nil, "You currently do not have an active SCIONLab AS.")
+		s.BadRequestAndLog(r.Context(), w, nil, "You currently do not have an active SCIONLab AS.")
 		return
 	}
-	as.ConfVersion++
-	as.Status = models.Remove
-	cn.NeighborStatus = models.Remove
-	cn.Status = models.Inactive
-	if err := as.UpdateASAndConnectionFromJoinConnInfo(cn); err != nil {
-		log.Printf("Error marking AS and Connection as removed for user %v: %v",
+	if err := markASRemoved(r.Context(), as, cn); err != nil {
+		log.Errorf("Error marking AS and Connection as removed for user %v: %v",
 			userEmail, err)
 		s.Error500(w, err, "Error marking AS and Connection as removed")
 		return
 	}
-	log.Printf("Marked removal of SCIONLabAS of user %v.", userEmail)
 	fmt.Fprintln(w, "Your AS will be removed within the next few minutes. "+
 		"You will receive a confirmation email as soon as the removal is complete.")
 }
 
+// markASRemoved marks the AS and its connection as removed, so that the AP tears down the
+// connection at its next synchronization, and revokes the VPN keys of the AS
+func markASRemoved(ctx context.Context, as *models.SCIONLabAS, cn *models.ConnectionInfo) error {
+	log := logger.FromContext(ctx)
+	as.ConfVersion++
+	as.Status = models.Remove
+	cn.NeighborStatus = models.Remove
+	cn.Status = models.Inactive
+	if err := as.UpdateASAndConnectionFromJoinConnInfo(cn); err != nil {
+		return err
+	}
+	log.Infof("Marked removal of SCIONLabAS of user %v.", as.UserEmail)
+	if err := cleanVPNKeys(ctx, as.UserEmail, as.ASID); err != nil {
+		log.Errorf("Error revoking the VPN certificate of the removed AS %v: %v", as.ASID, err)
+	}
+	if err := cleanWireGuardKeys(as.UserEmail, as.ASID); err != nil {
+		log.Errorf("Error removing the WireGuard keys of the removed AS %v: %v", as.ASID, err)
+	}
+	return nil
+}
+
 // Check if the user's AS is already removed or in the process of being removed.
 // Can remove a AS only if it is in the Active state.
-func (s *SCIONLabASController) canRemove(userEmail string, asID addr.AS) (bool, *models.SCIONLabAS,
+func canRemove(userEmail string, asID addr.AS) (bool, *models.SCIONLabAS,
 	*models.ConnectionInfo, error) {
 	as, err := models.FindSCIONLabASByUserEmailAndASID(userEmail, asID)
 	if err != nil {
@@ -1329,10 +1547,11 @@ func (s *SCIONLabASController) getIAParameter(r *http.Request) (*models.SCIONLab
 
 // QueryUpdateBranch API for SCIONLabASes to query which git branch they should use for updates
 func (s *SCIONLabASController) QueryUpdateBranch(w http.ResponseWriter, r *http.Request) {
-	log.Printf("API Call for queryUpdateBranch = %v", r.URL.Query())
+	log := logger.FromContext(r.Context())
+	log.Infof("API Call for queryUpdateBranch = %v", r.URL.Query())
 	as, err := s.getIAParameter(r)
 	if err != nil {
-		s.BadRequestAndLog(w, nil, err.Error())
+		s.BadRequestAndLog(r.Context(), w, nil, err.Error())
 		return
 	}
 	s.Plain(as.Branch, w, r)
@@ -1341,10 +1560,11 @@ func (s *SCIONLabASController) QueryUpdateBranch(w http.ResponseWriter, r *http.
 // ConfirmUpdate API for SCIONLabASes to report a successful update
 // E.g. curl -X POST -I http://localhost:8080/api/as/confirmUpdate/someid/some_secret?IA=1-ffaa_1_1
 func (s *SCIONLabASController) ConfirmUpdate(w http.ResponseWriter, r *http.Request) {
-	log.Printf("API Call for confirmUpdate = %v", r.URL.Query())
+	log := logger.FromContext(r.Context())
+	log.Infof("API Call for confirmUpdate = %v", r.URL.Query())
 	as, err := s.getIAParameter(r)
 	if err != nil {
-		s.BadRequestAndLog(w, nil, err.Error())
+		s.BadRequestAndLog(r.Context(), w, nil, err.Error())
 		return
 	}
 	as.Update() // just to set the Updated field to Now()
@@ -1360,16 +1580,17 @@ func (s *SCIONLabASController) ConfirmUpdate(w http.ResponseWriter, r *http.Requ
 // If the force=true (or force=1) flag was specified, ignore versions and assume client's is older
 // E.g. curl -s -D - --output myfile.tgz http://localhost:8080/api/as/getASData/someid/some_secret/9-ffaa_1_1?local_version=1
 func (s *SCIONLabASController) GetASData(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	vars := mux.Vars(r)
-	log.Printf("API call for GetASDAta as_id=%s, URL = %v", vars["ia"], r.URL.Query())
+	log.Infof("API call for GetASDAta as_id=%s, URL = %v", vars["ia"], r.URL.Query())
 	ia, err := utility.NormalizeIAString(vars["ia"])
 	if err != nil {
-		s.BadRequestAndLog(w, nil, err.Error())
+		s.BadRequestAndLog(r.Context(), w, nil, err.Error())
 		return
 	}
 	as, err := models.FindSCIONLabASByIAString(ia)
 	if err != nil {
-		s.BadRequestAndLog(w, err, "Cannot find AS with given IA %s", ia)
+		s.BadRequestAndLog(r.Context(), w, err, "Cannot find AS with given IA %s", ia)
 		return
 	}
 	ia = as.IAString() // because we get the AS ignoring the ISD part, the real ia could be different
@@ -1377,16 +1598,16 @@ func (s *SCIONLabASController) GetASData(w http.ResponseWriter, r *http.Request)
 	str := r.URL.Query().Get("local_version")
 	v64, err := strconv.ParseUint(str, 10, 32)
 	if err != nil {
-		log.Printf("WARNING! version string (%s) cannot be converted to a 32 uint. Using 0 as version", str)
+		log.Warnf("Version string (%s) cannot be converted to a 32 uint. Using 0 as version", str)
 	}
 	localVersion := uint(v64)
-	log.Printf("IA %s, current version %d, local version is %d", ia, as.ConfVersion, localVersion)
+	log.Infof("IA %s, current version %d, local version is %d", ia, as.ConfVersion, localVersion)
 	if !forceFlag && localVersion > as.ConfVersion {
 		messageToAdmins := fmt.Sprintf("The AS with IA %s reported a possibly wrong local version "+
 			"> AS.ConvVersion (%d > %d)", ia, localVersion, as.ConfVersion)
-		err = email.SendEmailToAdmins("ERROR During GetASData", messageToAdmins)
+		err = email.SendEmailToAdmins(r.Context(), "ERROR During GetASData", messageToAdmins)
 		if err != nil {
-			fmt.Printf("ERROR (again): could not send email to admins: %v", err)
+			log.Errorf("Could not send email to admins: %v", err)
 		}
 		// try to recover by sending the configuration or the code to remove the AS:
 		forceFlag = true
@@ -1396,16 +1617,17 @@ func (s *SCIONLabASController) GetASData(w http.ResponseWriter, r *http.Request)
 	} else if as.Status == models.Remove {
 		w.WriteHeader(http.StatusResetContent)
 	} else {
-		err = computeNewGenFolder(as)
+		err = computeNewGenFolder(r.Context(), as)
 		if err != nil {
-			s.BadRequestAndLog(w, nil, "We failed (re)creating the tarball file for IA %s: %v", ia, err)
+			s.BadRequestAndLog(r.Context(), w, nil, "We failed (re)creating the tarball file for IA %s: %v", ia, err)
 			return
 		}
 		fileName := UserPackageName(as.UserEmail, as.ISD, as.ASID) + ".tar.gz"
-		filePath := filepath.Join(PackagePath, fileName)
-		err = sendAlreadyCompressedFile(w, filePath, "scion_lab_"+fileName)
+		err = sendAlreadyCompressedFile(w, packageKey(as.UserEmail, as.ISD, as.ASID),
+			"scion_lab_"+fileName)
 		if err != nil {
-			s.BadRequestAndLog(w, nil, "Error reading the tarball. FileName: %v: %v", fileName, err)
+			s.BadRequestAndLog(r.Context(), w, nil, "Error reading the tarball. FileName: %v: %v",
+				fileName, err)
 			return
 		}
 	}
@@ -15,15 +15,16 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 
 	"github.com/netsec-ethz/scion-coord/config"
 	"github.com/netsec-ethz/scion-coord/email"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
 	"github.com/netsec-ethz/scion-coord/utility"
 	"github.com/scionproto/scion/go/lib/addr"
@@ -42,13 +43,57 @@ const (
 // The struct used for API calls between scion-coord and SCIONLab APs
 // TODO(mlegner): Change field names here and in the `update_gen.py` to reflect new conventions
 type APConnectionInfo struct {
-	ASID      string // ISD-AS of the AS
-	IsVPN     bool   // is this a VPN connection
-	VPNUserID string // user identifier used for VPN, currently the user's email + ASID
-	UserIP    string // IP address of the SCIONLab AS
-	UserPort  uint16 // port number of the AS connecting to the AP
-	APPort    uint16 // port number at the AP
-	APBRID    uint16 // ID of the border router at the AP
+	ASID        string // ISD-AS of the AS
+	IsVPN       bool   // is this a VPN connection
+	VPNType     string // "openvpn" or "wireguard" for VPN connections; APs may omit "openvpn"
+	VPNUserID   string // user identifier used for VPN, currently the user's email + ASID
+	WGPublicKey string // WireGuard public key of the AS, for WireGuard connections
+	UserIP      string // IP address of the SCIONLab AS
+	UserPort    uint16 // port number of the AS connecting to the AP
+	APPort      uint16 // port number at the AP
+	APBRID      uint16 // ID of the border router at the AP
+	MTU         uint16 // MTU of the link
+	Bandwidth   uint64 // bandwidth of the link
+}
+
+// WireGuardPeer is a peer of the WireGuard interface of an AP
+type WireGuardPeer struct {
+	ASID       string // ISD-AS of the AS
+	PublicKey  string // WireGuard public key of the AS
+	AllowedIPs string // VPN address of the AS
+}
+
+// wireGuardPeers returns the peers of the WireGuard connections
+func wireGuardPeers(conns []APConnectionInfo) []WireGuardPeer {
+	peers := []WireGuardPeer{}
+	for _, c := range conns {
+		if c.VPNType == models.WireGuard {
+			peers = append(peers, WireGuardPeer{
+				ASID:       c.ASID,
+				PublicKey:  c.WGPublicKey,
+				AllowedIPs: hostPrefix(c.UserIP),
+			})
+		}
+	}
+	return peers
+}
+
+// equals compares two APConnectionInfo, ignoring differences in the textual representation of
+// the user IP address (e.g. compressed and expanded IPv6 addresses). APs not reporting the link
+// parameters are assumed to use the default ones.
+func (c APConnectionInfo) equals(other APConnectionInfo) bool {
+	c.MTU, other.MTU = models.MTUOrDefault(c.MTU), models.MTUOrDefault(other.MTU)
+	c.VPNType = connVPNType(c.IsVPN, c.VPNType)
+	other.VPNType = connVPNType(other.IsVPN, other.VPNType)
+	c.Bandwidth = models.BandwidthOrDefault(c.Bandwidth)
+	other.Bandwidth = models.BandwidthOrDefault(other.Bandwidth)
+	if ip, err := utility.NormalizeIP(c.UserIP); err == nil {
+		c.UserIP = ip
+	}
+	if ip, err := utility.NormalizeIP(other.UserIP); err == nil {
+		other.UserIP = ip
+	}
+	return c == other
 }
 
 // API end-point for the SCIONLab APs to query actions to be done for users' SCIONLabASes.
@@ -58,15 +103,19 @@ type APConnectionInfo struct {
 //         "Remove":[],
 //         "Update":[{"ASID":"1-1020",
 //                    "IsVPN":true,
+//                    "VPNType":"openvpn",
 //                    "VPNUserID":"user@example.com_1020",
-//                    "IP":"10.0.8.42",
+//                    "UserIP":"10.0.8.42",
 //                    "UserPort":50000,
 //                    "APPort":50053,
-//                    "APBRID":5}]
+//                    "APBRID":5,
+//                    "MTU":1472,
+//                    "Bandwidth":1000}]
 //        }
 // }
 func (s *SCIONLabASController) GetUpdatesForAP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("API Call for getUpdatesForAP = %v", r.URL.Query())
+	log := logger.FromContext(r.Context())
+	log.Infof("API Call for getUpdatesForAP = %v", r.URL.Query())
 	apIA, err := checkAuthorization(r, r.URL.Query().Get("scionLabAP"))
 	if err != nil {
 		s.Forbidden(w, err, "The account is not authorized for this AP")
@@ -75,13 +124,13 @@ func (s *SCIONLabASController) GetUpdatesForAP(w http.ResponseWriter, r *http.Re
 
 	as, err := models.FindSCIONLabASByIAInt(apIA.I, apIA.A)
 	if err != nil {
-		log.Printf("Error looking up the AS %v: %v", apIA, err)
+		log.Errorf("Error looking up the AS %v: %v", apIA, err)
 		s.Error500(w, err, "Error looking up SCIONLab AS from DB")
 		return
 	}
 	cnInfos, err := as.GetRespondConnectionInfo()
 	if err != nil {
-		log.Printf("Error looking up connections for AS %v: %v", apIA, err)
+		log.Errorf("Error looking up connections for AS %v: %v", apIA, err)
 		s.Error500(w, err, "Error looking up SCIONLab ASes from DB")
 		return
 	}
@@ -90,13 +139,17 @@ func (s *SCIONLabASController) GetUpdatesForAP(w http.ResponseWriter, r *http.Re
 	var cnsRemoveResp []APConnectionInfo
 	for _, cn := range cnInfos {
 		cnInfo := APConnectionInfo{
-			ASID:      utility.IAStringStandard(as.ISD, cn.NeighborAS),
-			IsVPN:     cn.IsVPN,
-			VPNUserID: vpnUserID(cn.NeighborUser, cn.NeighborAS),
-			UserIP:    cn.NeighborIP,
-			UserPort:  cn.NeighborPort,
-			APPort:    cn.LocalPort,
-			APBRID:    cn.BRID,
+			ASID:        utility.IAStringStandard(as.ISD, cn.NeighborAS),
+			IsVPN:       cn.IsVPN,
+			VPNType:     connVPNType(cn.IsVPN, cn.VPNType),
+			VPNUserID:   vpnUserID(cn.NeighborUser, cn.NeighborAS),
+			WGPublicKey: cn.WGPublicKey,
+			UserIP:      cn.NeighborIP,
+			UserPort:    cn.NeighborPort,
+			APPort:      cn.LocalPort,
+			APBRID:      cn.BRID,
+			MTU:         cn.LinkMTU(),
+			Bandwidth:   cn.LinkBandwidth(),
 		}
 		switch cn.Status {
 		case models.Create:
@@ -116,11 +169,11 @@ func (s *SCIONLabASController) GetUpdatesForAP(w http.ResponseWriter, r *http.Re
 	}
 	b, err := json.Marshal(resp)
 	if err != nil {
-		log.Printf("Error during JSON marshaling: %v", err)
+		log.Errorf("Error during JSON marshaling: %v", err)
 		s.Error500(w, err, "Error during JSON marshaling")
 		return
 	}
-	log.Printf("getUpdatesForAP will return: %v", string(b))
+	log.Infof("getUpdatesForAP will return: %v", string(b))
 	fmt.Fprintln(w, string(b))
 }
 
@@ -151,10 +204,11 @@ type rejectedAS struct {
 // }
 // If successful, the API will return an empty JSON response with HTTP code 200.
 func (s *SCIONLabASController) ConfirmUpdatesFromAP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("API Call for ConfirmUpdatesFromAP")
+	log := logger.FromContext(r.Context())
+	log.Infof("API Call for ConfirmUpdatesFromAP")
 	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading body of HTTP request. Error: %v \nBody: %v", r.Body, err)
+		log.Errorf("Error reading body of HTTP request. Error: %v \nBody: %v", r.Body, err)
 		s.BadRequest(w, err, "Error reading request body")
 		return
 	}
@@ -163,7 +217,7 @@ func (s *SCIONLabASController) ConfirmUpdatesFromAP(w http.ResponseWriter, r *ht
 	var updateLists map[string]map[string][]attachedASAckMessage
 	decoder := json.NewDecoder(strings.NewReader(body))
 	if err := decoder.Decode(&updateLists); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", err, body)
+		log.Errorf("Error decoding JSON: %v, %v", err, body)
 		s.BadRequest(w, err, "Error decoding JSON")
 		return
 	}
@@ -178,7 +232,7 @@ func (s *SCIONLabASController) ConfirmUpdatesFromAP(w http.ResponseWriter, r *ht
 	for ia, event := range updateLists {
 		IA, err := utility.IAFromString(ia)
 		if err != nil {
-			log.Print(err.Error())
+			log.Errorf("%v", err)
 			return
 		}
 		// ensure ia is always non file format:
@@ -186,11 +240,11 @@ func (s *SCIONLabASController) ConfirmUpdatesFromAP(w http.ResponseWriter, r *ht
 		var as *models.SCIONLabAS
 		_, isAuthorized := ownedASes[ia]
 		if !isAuthorized {
-			log.Printf("Unauthorized updates from AS %v", ia)
+			log.Warnf("Unauthorized updates from AS %v", ia)
 		} else {
 			as, err = models.FindSCIONLabASByIAInt(IA.I, IA.A)
 			if err != nil {
-				log.Printf("Error finding AS %v when processing confirmations: %v", ia, err)
+				log.Errorf("Error finding AS %v when processing confirmations: %v", ia, err)
 			}
 		}
 		if !isAuthorized || err != nil {
@@ -212,12 +266,12 @@ func (s *SCIONLabASController) ConfirmUpdatesFromAP(w http.ResponseWriter, r *ht
 					rejectedIAs = append(rejectedIAs, rejectedAS{IA: conn.IA, AP: ia, action: action})
 				}
 			}
-			failedConfirmations = append(failedConfirmations, s.processConfirmedUpdatesFromAP(as, action, successIAs)...)
+			failedConfirmations = append(failedConfirmations, s.processConfirmedUpdatesFromAP(r.Context(), as, action, successIAs)...)
 		}
 	}
-	failedConfirmations = append(failedConfirmations, s.processRejectedUpdatesFromAP(rejectedIAs)...)
+	failedConfirmations = append(failedConfirmations, s.processRejectedUpdatesFromAP(r.Context(), rejectedIAs)...)
 	if len(failedConfirmations) > 0 {
-		log.Printf("ERROR processing confirmations for the following ASes: %v", failedConfirmations)
+		log.Errorf("Error processing confirmations for the following ASes: %v", failedConfirmations)
 		s.Error500(w, nil, "Error processing confirmations for the following ASes: %v",
 			failedConfirmations)
 		return
@@ -227,27 +281,28 @@ func (s *SCIONLabASController) ConfirmUpdatesFromAP(w http.ResponseWriter, r *ht
 
 // Updates the relevant DB tables based on the received confirmations from the SCIONLab AP and sends
 // out confirmation emails
-func (s *SCIONLabASController) processConfirmedUpdatesFromAP(apAS *models.SCIONLabAS, action string, cns []string) []string {
-	log.Printf("action = %v, cns = %v", action, cns)
+func (s *SCIONLabASController) processConfirmedUpdatesFromAP(ctx context.Context, apAS *models.SCIONLabAS, action string, cns []string) []string {
+	log := logger.FromContext(ctx)
+	log.Infof("action = %v, cns = %v", action, cns)
 	var failedConfirmations []string
 	var successEmails []emailConfirmation
 	for _, ia := range cns {
 		// find the connection to the SCIONLabAS. e.g. ia=1-1001
 		IA, err := addr.IAFromString(ia)
 		if err != nil {
-			log.Printf("Error converting IA (%v) to its components: %v", ia, err)
+			log.Errorf("Error converting IA (%v) to its components: %v", ia, err)
 			failedConfirmations = append(failedConfirmations, ia)
 			continue
 		}
 		as, err := models.FindSCIONLabASByASID(IA.A)
 		if err != nil {
-			log.Printf("Error finding SCIONLabAS with AS ID %v: %v", IA.A, err)
+			log.Errorf("Error finding SCIONLabAS with AS ID %v: %v", IA.A, err)
 			failedConfirmations = append(failedConfirmations, ia)
 			continue
 		}
 		asCns, err := as.GetJoinConnectionInfoToAS(apAS.IAString())
 		if err != nil {
-			log.Printf("Error finding the connection to SCIONLabAS %v: %v", ia, err)
+			log.Errorf("Error finding the connection to SCIONLabAS %v: %v", ia, err)
 			failedConfirmations = append(failedConfirmations, ia)
 			continue
 		}
@@ -263,7 +318,7 @@ func (s *SCIONLabASController) processConfirmedUpdatesFromAP(apAS *models.SCIONL
 		}
 		if len(workingSet) != 1 {
 			// we've failed our axiom that there's only one active connection. Complain
-			log.Printf("Error confirming updates for AS %v: we expected 1 connection to %v and found %v",
+			log.Errorf("Error confirming updates for AS %v: we expected 1 connection to %v and found %v",
 				ia, apAS.IAString(), len(workingSet))
 			failedConfirmations = append(failedConfirmations, ia)
 			continue
@@ -280,19 +335,22 @@ func (s *SCIONLabASController) processConfirmedUpdatesFromAP(apAS *models.SCIONL
 				// this means to remove the connection entry but don't update the AS status
 				err = as.DeleteConnectionFromDB(&cnInfo)
 				if err != nil {
-					log.Printf("Error removing connection between AS %v and AP %v: %v", ia, apAS.IAString(), err)
+					log.Errorf("Error removing connection between AS %v and AP %v: %v", ia, apAS.IAString(), err)
 					continue
 				}
 			}
+			if cnInfo.IsVPN {
+				releaseVPNIP(ctx, apAS.AP, cnInfo.LocalIP, as)
+			}
 		default:
-			log.Printf("Unsupported action \"%v\" for AS %v. User: %v", action, ia, as.UserEmail)
+			log.Warnf("Unsupported action \"%v\" for AS %v. User: %v", action, ia, as.UserEmail)
 			failedConfirmations = append(failedConfirmations, ia)
 			continue
 		}
 		if cnInfo.IsCurrentConnection() {
 			as.Status = cnInfo.Status
 			if err = as.UpdateASAndConnectionFromJoinConnInfo(&cnInfo); err != nil {
-				log.Printf("Error updating database tables for AS %v: %v", as.IAString(), err)
+				log.Errorf("Error updating database tables for AS %v: %v", as.IAString(), err)
 				failedConfirmations = append(failedConfirmations, ia)
 				continue
 			}
@@ -300,7 +358,7 @@ func (s *SCIONLabASController) processConfirmedUpdatesFromAP(apAS *models.SCIONL
 			// just checking for consistency
 			if action != REMOVED {
 				// logic error! print failed assertion but don't quit this update
-				log.Printf("Logic error confirming updates for AS %v to AP %v. The connection is inactive but the action %v != REMOVED",
+				log.Errorf("Logic error confirming updates for AS %v to AP %v. The connection is inactive but the action %v != REMOVED",
 					ia, apAS.IAString(), action)
 				continue
 			}
@@ -308,8 +366,8 @@ func (s *SCIONLabASController) processConfirmedUpdatesFromAP(apAS *models.SCIONL
 		successEmails = append(successEmails, emailConfirmation{as.UserEmail, as.IAString(), action})
 	}
 	for _, e := range successEmails {
-		if err := sendConfirmationEmail(e.user, e.IA, e.action); err != nil {
-			log.Printf("Error sending email confirmation to user %v: %v", e.user, err)
+		if err := sendConfirmationEmail(ctx, e.user, e.IA, e.action); err != nil {
+			log.Errorf("Error sending email confirmation to user %v: %v", e.user, err)
 		}
 	}
 	return failedConfirmations
@@ -317,13 +375,15 @@ func (s *SCIONLabASController) processConfirmedUpdatesFromAP(apAS *models.SCIONL
 
 // processRejectedUpdatesFromAP will receive a list of AS with rejected updates,
 // will notify the ScionLab administrators, and remove the pending change.
-func (s *SCIONLabASController) processRejectedUpdatesFromAP(rejections []rejectedAS) []string {
+func (s *SCIONLabASController) processRejectedUpdatesFromAP(ctx context.Context,
+	rejections []rejectedAS) []string {
+	log := logger.FromContext(ctx)
 	var failedNotifications []string
 	// for each rejected AS, send an email to the admin and user
 	for _, rejectedAS := range rejections {
 		IA, err := addr.IAFromString(rejectedAS.IA)
 		if err != nil {
-			log.Printf("Error converting IA (%v) to its components: %v", rejectedAS.IA, err)
+			log.Errorf("Error converting IA (%v) to its components: %v", rejectedAS.IA, err)
 			failedNotifications = append(failedNotifications, rejectedAS.IA)
 			continue
 		}
@@ -332,37 +392,37 @@ func (s *SCIONLabASController) processRejectedUpdatesFromAP(rejections []rejecte
 		originalIA := rejectedAS.IA
 		as, err := models.FindSCIONLabASByASID(IA.A)
 		if err != nil {
-			log.Printf("Error finding SCIONLabAS with AS ID %v: %v", IA.A, err)
+			log.Errorf("Error finding SCIONLabAS with AS ID %v: %v", IA.A, err)
 			failedNotifications = append(failedNotifications, originalIA)
 			continue
 		}
 		IA, err = addr.IAFromString(rejectedAS.AP)
 		if err != nil {
-			log.Printf("Error converting IA (%v) to its components: %v", rejectedAS.AP, err)
+			log.Errorf("Error converting IA (%v) to its components: %v", rejectedAS.AP, err)
 			failedNotifications = append(failedNotifications, originalIA)
 			continue
 		}
 		ap, err := models.FindSCIONLabASByASID(IA.A)
 		if err != nil {
-			log.Printf("Error finding SCIONLabAS with AS ID %v: %v", IA.A, err)
+			log.Errorf("Error finding SCIONLabAS with AS ID %v: %v", IA.A, err)
 			failedNotifications = append(failedNotifications, originalIA)
 			continue
 		}
 
-		err = sendRejectedEmail(as.UserEmail, originalIA, rejectedAS.action, rejectedAS.AP)
+		err = sendRejectedEmail(ctx, as.UserEmail, originalIA, rejectedAS.action, rejectedAS.AP)
 		if err != nil {
-			log.Printf("Error sending email about rejected AS old IA: %v, new IA: %v: %v", originalIA, as.IA(), err)
+			log.Errorf("Error sending email about rejected AS old IA: %v, new IA: %v: %v", originalIA, as.IA(), err)
 			failedNotifications = append(failedNotifications, originalIA)
 			continue
 		}
 
 		asCns, err := ap.GetRespondConnectionInfoToAS(as.IA().A)
 		if err != nil {
-			log.Printf("Error finding the connection to SCIONLabAS %v: %v", as.IA(), err)
+			log.Errorf("Error finding the connection to SCIONLabAS %v: %v", as.IA(), err)
 			failedNotifications = append(failedNotifications, originalIA)
 			continue
 		}
-		log.Printf("[DEBUG ConfirmUpdatesFromAP] connections between AP %v and user AS %v: %v", ap.IAString(), as.IA().A, asCns)
+		log.Debugf("ConfirmUpdatesFromAP: connections between AP %v and user AS %v: %v", ap.IAString(), as.IA().A, asCns)
 		// now, clear the rejected AS, as the AP went out of sync with the Coordinator
 		for _, cn := range asCns {
 			if cn.Status != models.Create && cn.Status != models.Update && cn.Status != models.Remove {
@@ -371,7 +431,7 @@ func (s *SCIONLabASController) processRejectedUpdatesFromAP(rejections []rejecte
 			}
 			err = models.DeleteConnectionFromDB(cn.ID)
 			if err != nil {
-				log.Printf("ERROR removing rejected connection. UserAS: %s, AP: %s, action: %s", rejectedAS.IA, rejectedAS.AP, rejectedAS.action)
+				log.Errorf("Error removing rejected connection. UserAS: %s, AP: %s, action: %s", rejectedAS.IA, rejectedAS.AP, rejectedAS.action)
 				failedNotifications = append(failedNotifications, rejectedAS.IA)
 			}
 			break // only one connection could have been rejected. We just processed it, so get out of here
@@ -382,7 +442,7 @@ func (s *SCIONLabASController) processRejectedUpdatesFromAP(rejections []rejecte
 			// only case where a rejected connection could keep the Status out of sync
 			cns, err := as.GetJoinConnectionInfo()
 			if err != nil {
-				log.Printf("ERROR removing rejected connection, get connections to reset AS Status for AS %s: %v", rejectedAS.IA, err)
+				log.Errorf("Error removing rejected connection, get connections to reset AS Status for AS %s: %v", rejectedAS.IA, err)
 				failedNotifications = append(failedNotifications, rejectedAS.IA)
 				continue
 			}
@@ -394,7 +454,7 @@ func (s *SCIONLabASController) processRejectedUpdatesFromAP(rejections []rejecte
 			default:
 			}
 			if err = as.Update(); err != nil {
-				log.Printf("ERROR removing rejected connection. Updating status of user AS failed for %s: %v", rejectedAS.IA, err)
+				log.Errorf("Error removing rejected connection. Updating status of user AS failed for %s: %v", rejectedAS.IA, err)
 				continue
 			}
 		}
@@ -404,7 +464,8 @@ func (s *SCIONLabASController) processRejectedUpdatesFromAP(rejections []rejecte
 }
 
 // Function which sends confirmation emails to users
-func sendConfirmationEmail(userEmail, IA, action string) error {
+func sendConfirmationEmail(ctx context.Context, userEmail, IA, action string) error {
+	log := logger.FromContext(ctx)
 	user, err := models.FindUserByEmail(userEmail)
 	if err != nil {
 		return err
@@ -437,13 +498,14 @@ func sendConfirmationEmail(userEmail, IA, action string) error {
 		HostAddress: config.HTTPHostAddress,
 		Message:     message,
 	}
-	log.Printf("Sending confirmation email to user %v.", userEmail)
-	return email.ConstructFromTemplateAndSend("as_status.html", subject, data, "as-update", userEmail, false)
+	log.Infof("Sending confirmation email to user %v.", userEmail)
+	return email.ConstructFromTemplateAndSend(ctx, "as_status.html", subject, data, "as-update", userEmail, false)
 }
 
 // sends an email notifying of a failure to synchronize the attachment point with the user AS.\
 // Also notifies an admin in NetSec
-func sendRejectedEmail(userEmail string, userIA, action, attachmentPointIA string) error {
+func sendRejectedEmail(ctx context.Context, userEmail string, userIA, action,
+	attachmentPointIA string) error {
 	user, err := models.FindUserByEmail(userEmail)
 	if err != nil {
 		return err
@@ -465,7 +527,7 @@ func sendRejectedEmail(userEmail string, userIA, action, attachmentPointIA strin
 		Operation:   action,
 		AP:          attachmentPointIA,
 	}
-	return email.ConstructFromTemplateAndSend("as_failure.html", subject, data, "as-rejection", userEmail, true)
+	return email.ConstructFromTemplateAndSend(ctx, "as_failure.html", subject, data, "as-rejection", userEmail, true)
 }
 
 // GetConnectionsForAP will return a JSON with the connections for an AP as seen by the Coordinator
@@ -476,17 +538,37 @@ func sendRejectedEmail(userEmail string, userIA, action, attachmentPointIA strin
 //         {
 //             "ASID": "17-ffaa:1:14",
 //             "IsVPN": true,
+//             "VPNType": "openvpn",
 //             "VPNUserID": "user@example.com_ffaa_1_14",
 //             "UserIP": "10.0.8.42",
 //             "UserPort": 50000,
 //             "APPort": 50053,
-//             "APBRID": 5
+//             "APBRID": 5,
+//             "MTU": 1472,
+//             "Bandwidth": 1000
+//         },
+//         {
+//             "ASID": "17-ffaa:1:15",
+//             "IsVPN": true,
+//             "VPNType": "wireguard",
+//             "VPNUserID": "user@example.com_ffaa_1_15",
+//             "WGPublicKey": "RwHQhIhFH1RaQJ+1iuPlhYHKQKw/fxFGmM1x3qxzygE=",
+//             "UserIP": "10.0.8.43",
+//             ...
+//         }
+//         ],
+//         "wireguardPeers": [
+//         {
+//             "ASID": "17-ffaa:1:15",
+//             "PublicKey": "RwHQhIhFH1RaQJ+1iuPlhYHKQKw/fxFGmM1x3qxzygE=",
+//             "AllowedIPs": "10.0.8.43/32"
 //         }
 //         ]
 //     }
 // }
 func (s *SCIONLabASController) GetConnectionsForAP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("API Call for GetConnectionsForAP ----------------- BEGIN ----------------- %v",
+	log := logger.FromContext(r.Context())
+	log.Infof("API Call for GetConnectionsForAP ----------------- BEGIN ----------------- %v",
 		r.URL.Query())
 	apIAparam := r.URL.Query().Get("scionLabAP")
 	// seconds since Epoch filtering the connections. Only the older than that ones will be sent:
@@ -498,17 +580,40 @@ func (s *SCIONLabASController) GetConnectionsForAP(w http.ResponseWriter, r *htt
 	}
 	ap, err := models.FindSCIONLabASByIAInt(apIA.I, apIA.A)
 	if err != nil {
-		log.Printf("Error looking up the AS %v: %v", apIA, err)
+		log.Errorf("Error looking up the AS %v: %v", apIA, err)
 		s.Error500(w, err, "Error looking up SCIONLab AS from DB")
 		return
 	}
-	cns, err := ap.GetRespondConnections()
+	conns, err := apConnections(ap, cutoff)
 	if err != nil {
-		log.Printf("Error looking up connections for AS %v: %v", apIA, err)
+		log.Errorf("Error looking up connections for AS %v: %v", apIA, err)
 		s.Error500(w, err, "Error looking up SCIONLab ASes from DB")
 		return
 	}
-	// var conns []APConnectionInfo
+	resp := map[string]map[string]interface{}{
+		apIA.FileFmt(false): {
+			"connections":    conns,
+			"wireguardPeers": wireGuardPeers(conns),
+		},
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Errorf("Error during JSON marshaling: %v", err)
+		s.Error500(w, err, "Error during JSON marshaling")
+		return
+	}
+	log.Infof("getUpdatesForAP will return: %v", string(b))
+	log.Infof("API Call for GetConnectionsForAP ----------------- END -----------------")
+	fmt.Fprintln(w, string(b))
+}
+
+// apConnections returns the connections the AP should have, leaving out the ones updated after
+// cutoff (in seconds since Epoch)
+func apConnections(ap *models.SCIONLabAS, cutoff int64) ([]APConnectionInfo, error) {
+	cns, err := ap.GetRespondConnections()
+	if err != nil {
+		return nil, err
+	}
 	conns := []APConnectionInfo{}
 	shouldStatusBeInAP := func(status uint8) bool {
 		switch status {
@@ -527,30 +632,21 @@ func (s *SCIONLabASController) GetConnectionsForAP(w http.ResponseWriter, r *htt
 			continue
 		}
 		cnInfo := APConnectionInfo{
-			ASID:      userAS.IAString(),
-			IsVPN:     cn.IsVPN,
-			VPNUserID: vpnUserID(userAS.UserEmail, userAS.ASID),
-			UserIP:    cn.JoinIP,
-			UserPort:  userAS.GetPortNumberFromBRID(cn.JoinBRID),
-			APPort:    ap.GetPortNumberFromBRID(cn.RespondBRID),
-			APBRID:    cn.RespondBRID,
+			ASID:        userAS.IAString(),
+			IsVPN:       cn.IsVPN,
+			VPNType:     connVPNType(cn.IsVPN, cn.VPNType),
+			VPNUserID:   vpnUserID(userAS.UserEmail, userAS.ASID),
+			WGPublicKey: cn.WGPublicKey,
+			UserIP:      cn.JoinIP,
+			UserPort:    userAS.GetPortNumberFromBRID(cn.JoinBRID),
+			APPort:      ap.GetPortNumberFromBRID(cn.RespondBRID),
+			APBRID:      cn.RespondBRID,
+			MTU:         models.MTUOrDefault(cn.MTU),
+			Bandwidth:   models.BandwidthOrDefault(cn.Bandwidth),
 		}
 		conns = append(conns, cnInfo)
 	}
-	resp := map[string]map[string]interface{}{
-		apIA.FileFmt(false): {
-			"connections": conns,
-		},
-	}
-	b, err := json.Marshal(resp)
-	if err != nil {
-		log.Printf("Error during JSON marshaling: %v", err)
-		s.Error500(w, err, "Error during JSON marshaling")
-		return
-	}
-	log.Printf("getUpdatesForAP will return: %v", string(b))
-	log.Printf("API Call for GetConnectionsForAP ----------------- END -----------------")
-	fmt.Fprintln(w, string(b))
+	return conns, nil
 }
 
 // SetConnectionsForAP receives the connections an AP has and flags them as such in the Coordinator
@@ -573,7 +669,8 @@ func (s *SCIONLabASController) GetConnectionsForAP(w http.ResponseWriter, r *htt
 //     }
 //   }
 func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("API Call for SetConnectionsForAP ----------------- BEGIN -----------------")
+	log := logger.FromContext(r.Context())
+	log.Infof("API Call for SetConnectionsForAP ----------------- BEGIN -----------------")
 	type IssueInAP struct {
 		ShouldTryAgain    bool
 		CriticalError     string
@@ -588,7 +685,7 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 	cutoff := utility.GetTimeCutoff(r)
 	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading body of HTTP request. Error: %v \nBody: %v", r.Body, err)
+		log.Errorf("Error reading body of HTTP request. Error: %v \nBody: %v", r.Body, err)
 		s.BadRequest(w, err, "Error reading request body")
 		return
 	}
@@ -596,7 +693,7 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 	var allStatusMap map[string]map[string][]APConnectionInfo
 	decoder := json.NewDecoder(strings.NewReader(body))
 	if err := decoder.Decode(&allStatusMap); err != nil {
-		log.Printf("Error decoding JSON: %v, %v", err, body)
+		log.Errorf("Error decoding JSON: %v, %v", err, body)
 		s.BadRequest(w, err, "Error decoding JSON")
 		return
 	}
@@ -630,16 +727,16 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 		}
 		// ensure apIA is always non file format:
 		apIAStr = apIA.String()
-		log.Printf("[DEBUG] IA: %v, status: %v", apIAStr, reportedStatus)
+		log.Debugf("IA: %v, status: %v", apIAStr, reportedStatus)
 		reportedConnections := reportedStatus["connections"]
 		var ap *models.SCIONLabAS
 		_, isAuthorized := ownedASes[apIAStr]
 		if !isAuthorized {
-			log.Printf("Unauthorized updates from AS %v", apIAStr)
+			log.Warnf("Unauthorized updates from AS %v", apIAStr)
 		} else {
 			ap, err = models.FindSCIONLabASByIAInt(apIA.I, apIA.A)
 			if err != nil {
-				log.Printf("[ERROR] Error finding AS %v when processing confirmations: %v", apIAStr, err)
+				log.Errorf("Error finding AS %v when processing confirmations: %v", apIAStr, err)
 			}
 		}
 		if !isAuthorized || err != nil {
@@ -664,8 +761,8 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 		for _, c := range reportedConnections {
 			ia, err := addr.IAFromString(c.ASID)
 			if err != nil {
-				msg := fmt.Sprintf("[ERROR] String (%v) does not parse to IA: %v", c.ASID, err)
-				log.Print(msg)
+				msg := fmt.Sprintf("String (%v) does not parse to IA: %v", c.ASID, err)
+				log.Errorf("%v", msg)
 				setUserASError(c.ASID, msg)
 				continue
 			}
@@ -676,8 +773,8 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 		// and change the status accordingly
 		cnsInDB, err := ap.GetRespondConnections()
 		if err != nil {
-			msg := fmt.Sprintf("[ERROR] Error looking up connections for AS %v: %v", apIAStr, err)
-			log.Print(msg)
+			msg := fmt.Sprintf("Error looking up connections for AS %v: %v", apIAStr, err)
+			log.Errorf("%v", msg)
 			setCriticalError(msg)
 			continue
 		}
@@ -705,19 +802,23 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 			userAS := cnInDB.GetJoinAS()
 			userASIA := userAS.IAString()
 			apCnInfo := APConnectionInfo{
-				ASID:      userASIA,
-				IsVPN:     cnInDB.IsVPN,
-				VPNUserID: vpnUserID(userAS.UserEmail, userAS.ASID),
-				UserIP:    cnInDB.JoinIP,
-				UserPort:  userAS.GetPortNumberFromBRID(cnInDB.JoinBRID),
-				APPort:    ap.GetPortNumberFromBRID(cnInDB.RespondBRID),
-				APBRID:    cnInDB.RespondBRID,
+				ASID:        userASIA,
+				IsVPN:       cnInDB.IsVPN,
+				VPNType:     connVPNType(cnInDB.IsVPN, cnInDB.VPNType),
+				VPNUserID:   vpnUserID(userAS.UserEmail, userAS.ASID),
+				WGPublicKey: cnInDB.WGPublicKey,
+				UserIP:      cnInDB.JoinIP,
+				UserPort:    userAS.GetPortNumberFromBRID(cnInDB.JoinBRID),
+				APPort:      ap.GetPortNumberFromBRID(cnInDB.RespondBRID),
+				APBRID:      cnInDB.RespondBRID,
+				MTU:         models.MTUOrDefault(cnInDB.MTU),
+				Bandwidth:   models.BandwidthOrDefault(cnInDB.Bandwidth),
 			}
 			cnInfosInDB[userASIA] = append(cnInfosInDB[userASIA], apCnInfo)
 			cnArr := fromAP[userAS.ASID]
 			foundPendingInReported := false
 			for _, reportedCn := range cnArr {
-				if reportedCn == apCnInfo {
+				if reportedCn.equals(apCnInfo) {
 					foundPendingInReported = true
 					break
 				}
@@ -732,19 +833,22 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 				} else {
 					err := models.DeleteConnectionFromDB(cnInDB.ID)
 					if err != nil {
-						msg := fmt.Sprintf("[ERROR] Error removing connection between AP %v and AS %v: %v",
+						msg := fmt.Sprintf("Error removing connection between AP %v and AS %v: %v",
 							apIA, userASIA, err)
-						log.Print(msg)
+						log.Errorf("%v", msg)
 						setUserASError(userASIA, msg)
 						continue
 					}
 				}
+				if cnInDB.IsVPN {
+					releaseVPNIP(r.Context(), ap.AP, cnInDB.JoinIP, userAS)
+				}
 			} else {
 				// this is a not found connection that is active or pending to create or update. Complain
-				msg := fmt.Sprintf("[ERROR] Connection present in DB but not in AP. Data: "+
+				msg := fmt.Sprintf("Connection present in DB but not in AP. Data: "+
 					"from AP %v to ASID %v, user email %v, DB id %d, updated on %v, to %v",
 					apIAStr, userASIA, userAS.UserEmail, cnInDB.ID, cnInDB.Updated, origStatus)
-				log.Print(msg)
+				log.Errorf("%v", msg)
 				setUserASError(userASIA, msg)
 				sendToAdminMessages = append(sendToAdminMessages, msg)
 				continue
@@ -756,13 +860,14 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 					// then update the user AS status:
 					userAS.Status = cnInDB.RespondStatus
 					if err = userAS.UpdateASAndConnection(cnInDB); err != nil {
-						msg := fmt.Sprintf("[ERROR] Cannot update AS and connection for AS %v: %v",
+						msg := fmt.Sprintf("Cannot update AS and connection for AS %v: %v",
 							userAS.IAString(), err)
-						log.Print(msg)
+						log.Errorf("%v", msg)
 						setUserASError(userASIA, msg)
-						err = sendRejectedEmail(userAS.UserEmail, userASIA, actionString, apIAStr)
+						err = sendRejectedEmail(r.Context(), userAS.UserEmail, userASIA,
+							actionString, apIAStr)
 						if err != nil {
-							log.Printf("[ERROR] Could not send email to user %v about failed sync between AP %v and user AS %v",
+							log.Errorf("Could not send email to user %v about failed sync between AP %v and user AS %v",
 								userAS.UserEmail, apIAStr, userASIA)
 						}
 						continue
@@ -775,10 +880,10 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 			} else {
 				if origStatus != models.Remove {
 					// logic error! print failed assertion but don't quit this update
-					msg := fmt.Sprintf("[ERROR] Logic error setting connections for AP %v to user AS %v. "+
+					msg := fmt.Sprintf("Logic error setting connections for AP %v to user AS %v. "+
 						"The connection is inactive but the action %v != REMOVED",
 						apIAStr, userAS.IAString(), origStatus)
-					log.Print(msg)
+					log.Errorf("%v", msg)
 					sendToAdminMessages = append(sendToAdminMessages, msg)
 					continue
 				}
@@ -788,7 +893,7 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 		for _, reportedConn := range reportedConnections {
 			foundInDB := false
 			for _, c := range cnInfosInDB[reportedConn.ASID] {
-				if c == reportedConn {
+				if c.equals(reportedConn) {
 					foundInDB = true
 					break
 				}
@@ -796,42 +901,42 @@ func (s *SCIONLabASController) SetConnectionsForAP(w http.ResponseWriter, r *htt
 			if !foundInDB {
 				msg := fmt.Sprintf("A reported connection was not found in the DB. AP: %v user AS: %v, full APConnectionInfo: %v",
 					apIAStr, reportedConn.ASID, reportedConn)
-				log.Print(msg)
+				log.Errorf("%v", msg)
 				sendToAdminMessages = append(sendToAdminMessages, msg)
 				setUserASError(reportedConn.ASID, msg)
 			}
 		}
 	} // for each AP,status
 	for _, e := range successEmails {
-		if err := sendConfirmationEmail(e.user, e.IA, e.action); err != nil {
+		if err := sendConfirmationEmail(r.Context(), e.user, e.IA, e.action); err != nil {
 			msg := fmt.Sprintf("Cannot send confirmation email to user %v about IA %v for action %v. Error is: %v",
 				e.user, e.IA, e.action, err)
-			log.Print(msg)
+			log.Errorf("%v", msg)
 			sendToAdminMessages = append(sendToAdminMessages, msg)
 		}
 	}
 
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
-		msg := fmt.Sprintf("[ERROR] Cannot serialize response to SetConnections to JSON: %v", err)
-		log.Print(msg)
+		msg := fmt.Sprintf("Cannot serialize response to SetConnections to JSON: %v", err)
+		log.Errorf("%v", msg)
 		sendToAdminMessages = append(sendToAdminMessages, msg)
 		responseJSON = []byte("{}")
 	}
-	log.Printf("[DEBUG] Response to AP: %v\n", string(responseJSON))
+	log.Debugf("Response to AP: %v\n", string(responseJSON))
 	if len(sendToAdminMessages) > 0 {
-		log.Printf("[DEBUG] Messages to the admins: %v", sendToAdminMessages)
+		log.Debugf("Messages to the admins: %v", sendToAdminMessages)
 		data := struct {
 			ErrorMessages string
 		}{
 			ErrorMessages: strings.Join(sendToAdminMessages, "\n"),
 		}
-		err = email.ConstructFromTemplateAndSendToAdmins("setconnections_failed.html",
+		err = email.ConstructFromTemplateAndSendToAdmins(r.Context(), "setconnections_failed.html",
 			"FAILED SetConnections", data, "")
 		if err != nil {
-			log.Printf("[ERROR] Error sending email: %v", err)
+			log.Errorf("Error sending email: %v", err)
 		}
 	}
-	log.Printf("API Call for SetConnectionsForAP ----------------- END -----------------")
+	log.Infof("API Call for SetConnectionsForAP ----------------- END -----------------")
 	fmt.Fprintln(w, string(responseJSON))
 }
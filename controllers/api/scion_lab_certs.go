@@ -0,0 +1,273 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/email"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/crypto/cert"
+)
+
+// certCacheKey returns the directory key below which the certificate versions of the AS are
+// cached
+func certCacheKey(as *models.SCIONLabAS) string {
+	return storage.Join(CertsPath, UserPackageName(as.UserEmail, as.ISD, as.ASID))
+}
+
+// latestCachedCertVersion returns the highest version V<n> found in the certificate cache,
+// or -1 if there is none
+func latestCachedCertVersion(ctx context.Context, cacheKey string) (int, error) {
+	log := logger.FromContext(ctx)
+	maxVersion := -1
+	keys, err := Artifacts.List(cacheKey)
+	if err != nil {
+		return maxVersion, fmt.Errorf("Could not read %s: %v", cacheKey, err)
+	}
+	for _, k := range keys {
+		d := strings.SplitN(strings.TrimPrefix(k, cacheKey+"/"), "/", 2)[0]
+		if !strings.HasPrefix(d, "V") {
+			continue
+		}
+		v, err := strconv.Atoi(d[1:])
+		if err != nil {
+			log.Warnf(`skipping version "%s": cannot parse: %v`, d[1:], err)
+			continue
+		}
+		if v > maxVersion {
+			maxVersion = v
+		}
+	}
+	return maxVersion, nil
+}
+
+// readCertChain parses the newest certificate chain found below the directory key
+func readCertChain(dir string) (*cert.Chain, error) {
+	keys, err := Artifacts.List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %s: %v", dir, err)
+	}
+	var files []string
+	for _, k := range keys {
+		if strings.HasSuffix(k, ".crt") {
+			files = append(files, k)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Cannot find any .crt file in %s", dir)
+	}
+	newest := files[len(files)-1]
+	raw, err := storage.ReadAll(Artifacts, newest)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertChain(raw, newest)
+}
+
+func readCertChainFile(path string) (*cert.Chain, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertChain(raw, path)
+}
+
+func parseCertChain(raw []byte, name string) (*cert.Chain, error) {
+	chain, err := cert.ChainFromRaw(raw, false)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse certificate chain %s: %v", name, err)
+	}
+	if chain == nil || chain.Leaf == nil || chain.Issuer == nil {
+		return nil, fmt.Errorf("Incomplete certificate chain in %s", name)
+	}
+	return chain, nil
+}
+
+// recordCertExpiration sets the version and expiration of the newest cached certificate of
+// the AS. The AS is not stored in the DB.
+func recordCertExpiration(ctx context.Context, as *models.SCIONLabAS) error {
+	cacheKey := certCacheKey(as)
+	v, err := latestCachedCertVersion(ctx, cacheKey)
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return fmt.Errorf("No cached certificate in %s", cacheKey)
+	}
+	chain, err := readCertChain(fmt.Sprintf("%s/V%d/certs", cacheKey, v))
+	if err != nil {
+		return err
+	}
+	as.CertVersion = uint64(v)
+	as.CertExpires = time.Unix(int64(chain.Leaf.ExpirationTime), 0).UTC()
+	return nil
+}
+
+// coreCertExpiration returns the expiration of the certificate signing the AS certificates of
+// the ISD. New AS certificates cannot be valid for longer than this.
+func coreCertExpiration(isd addr.ISD) (time.Time, error) {
+	chain, err := readCertChainFile(CoreCertFile(isd))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(chain.Issuer.ExpirationTime), 0).UTC(), nil
+}
+
+// renewalBlocked returns whether renewing a certificate expiring before the deadline is
+// pointless: the AS certificates issued by local_gen.py expire just before the core certificate
+// of the ISD, so a renewal cannot push the expiry past the deadline if the core certificate
+// expires before it.
+func renewalBlocked(coreExpires, deadline time.Time) bool {
+	return !coreExpires.After(deadline)
+}
+
+// generateRenewedGen generates the configuration of an AS with a renewed certificate, see
+// generateGenForAS. It is replaced in tests, which cannot run local_gen.py.
+var generateRenewedGen = generateGenForAS
+
+// renewCertificate issues a new certificate version for the AS and increases its configuration
+// version, so the AS obtains the new configuration through GetASData.
+func renewCertificate(ctx context.Context, as *models.SCIONLabAS) error {
+	conns, err := as.GetJoinNotRemovedConnections()
+	if err != nil {
+		return err
+	}
+	if len(conns) != 1 {
+		return fmt.Errorf("User AS should have only 1 connection. %s has %d", as.IAString(),
+			len(conns))
+	}
+	asInfo, err := getSCIONLabASInfoFromDB(conns[0])
+	if err != nil {
+		return err
+	}
+	asInfo.LocalAS = as
+	asInfo.CertVersion = as.CertVersion + 1
+	as.ConfVersion++
+	os.RemoveAll(asInfo.UserPackagePath())
+	if err = generateRenewedGen(ctx, asInfo); err != nil {
+		return err
+	}
+	if as.CertVersion != asInfo.CertVersion {
+		return fmt.Errorf("The certificate version %d was not stored for AS %s",
+			asInfo.CertVersion, as.IAString())
+	}
+	return as.Update()
+}
+
+// RenewExpiringCertificates issues new certificates for the ASes whose certificate expires
+// within the configured margin. If the core certificate of the ISD expires within the margin as
+// well, renewing is pointless and the admins are notified instead.
+func RenewExpiringCertificates(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	deadline := time.Now().Add(time.Duration(config.CertRenewalMargin) * 24 * time.Hour)
+	ases, err := models.FindSCIONLabASesWithCertExpiringBefore(deadline)
+	if err != nil {
+		log.Errorf("Error looking up ASes with expiring certificates: %v", err)
+		return
+	}
+	var failed []string
+	blocked := make(map[addr.ISD][]string)
+	for i := range ases {
+		as := &ases[i]
+		coreExpires, err := coreCertExpiration(as.ISD)
+		if err != nil {
+			log.Errorf("Error reading the core certificate of ISD %d: %v", as.ISD, err)
+			failed = append(failed, as.IAString())
+			continue
+		}
+		if renewalBlocked(coreExpires, deadline) {
+			blocked[as.ISD] = append(blocked[as.ISD], as.IAString())
+			continue
+		}
+		log.Infof("Renewing certificate of AS %s, expiring on %v", as.IAString(), as.CertExpires)
+		if err = renewCertificate(ctx, as); err != nil {
+			log.Errorf("Error renewing the certificate of AS %s: %v", as.IAString(), err)
+			failed = append(failed, as.IAString())
+			continue
+		}
+		if err = sendCertRenewedEmail(ctx, as); err != nil {
+			log.Errorf("Error sending certificate renewal email to user %v: %v", as.UserEmail, err)
+		}
+	}
+	if len(failed) == 0 && len(blocked) == 0 {
+		return
+	}
+	var lines []string
+	if len(failed) > 0 {
+		lines = append(lines, fmt.Sprintf("Renewing the certificates of the following ASes "+
+			"failed: %v", failed))
+	}
+	for isd, ias := range blocked {
+		lines = append(lines, fmt.Sprintf("The core certificate of ISD %d expires within the "+
+			"renewal margin and must be renewed before the certificates of the following ASes: %v", isd, ias))
+	}
+	err = email.SendEmailToAdmins(ctx, "Certificate renewal", strings.Join(lines, "\n"))
+	if err != nil {
+		log.Errorf("Error sending certificate renewal report to admins: %v", err)
+	}
+}
+
+// RenewCertificatesPeriodically checks for expiring certificates every
+// config.CertRenewalPeriod hours. It is meant to be run as a goroutine.
+func RenewCertificatesPeriodically(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	if config.CertRenewalPeriod <= 0 {
+		log.Infof("Automatic certificate renewal is disabled")
+		return
+	}
+	for {
+		RenewExpiringCertificates(ctx)
+		time.Sleep(time.Duration(config.CertRenewalPeriod) * time.Hour)
+	}
+}
+
+// Function which notifies the owner of an AS about its renewed certificate
+func sendCertRenewedEmail(ctx context.Context, as *models.SCIONLabAS) error {
+	log := logger.FromContext(ctx)
+	user, err := models.FindUserByEmail(as.UserEmail)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("The certificate of your SCIONLab AS %s was about to expire and "+
+		"has been renewed. The new certificate is valid until %s.\n"+
+		"ASes running the SCIONLab update service install the new configuration automatically. "+
+		"Otherwise, please download and install the configuration of your AS again.",
+		as.IAString(), as.CertExpires.Format(time.RFC1123))
+	data := struct {
+		FirstName   string
+		LastName    string
+		HostAddress string
+		Message     string
+	}{
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		HostAddress: config.HTTPHostAddress,
+		Message:     message,
+	}
+	log.Infof("Sending certificate renewal email to user %v.", as.UserEmail)
+	return email.ConstructFromTemplateAndSend(ctx, "as_status.html",
+		"[SCIONLab] AS certificate renewed", data, "as-cert-renewal", as.UserEmail, false)
+}
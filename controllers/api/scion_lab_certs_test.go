@@ -0,0 +1,227 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/scionproto/scion/go/lib/crypto/cert"
+)
+
+// useTempArtifacts keeps the artifacts and packages in a temporary directory
+func useTempArtifacts(t *testing.T) func() {
+	tmp, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldArtifacts, oldPackagePath := Artifacts, PackagePath
+	Artifacts = storage.NewLocal(tmp)
+	PackagePath = tmp
+	return func() {
+		Artifacts, PackagePath = oldArtifacts, oldPackagePath
+		os.RemoveAll(tmp)
+	}
+}
+
+// putCertChain caches a certificate chain of the AS with the version, whose AS certificate
+// expires at leafExpires. A nil issuer leaves the chain incomplete.
+func putCertChain(t *testing.T, as *models.SCIONLabAS, version uint64, leafExpires time.Time,
+	issuer *cert.Certificate) {
+	chain := &cert.Chain{
+		Leaf: &cert.Certificate{Subject: as.IA(), Issuer: as.IA(), Version: version,
+			ExpirationTime: uint64(leafExpires.Unix())},
+		Issuer: issuer,
+	}
+	raw, err := json.Marshal(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := fmt.Sprintf("%s/V%d/certs/ISD%d-AS%s-V%d.crt", certCacheKey(as), version, as.ISD,
+		as.ASID.FileFmt(), version)
+	if err = storage.PutBytes(Artifacts, key, raw); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// coreCert returns the certificate issuing the AS certificates, expiring in a year
+func coreCert(as *models.SCIONLabAS) *cert.Certificate {
+	return &cert.Certificate{Subject: as.IA(), Issuer: as.IA(), CanIssue: true,
+		ExpirationTime: uint64(time.Now().Add(365 * 24 * time.Hour).Unix())}
+}
+
+func TestRenewalBlocked(t *testing.T) {
+	now := time.Now()
+	margin := 30 * 24 * time.Hour
+	deadline := now.Add(margin)
+	for _, c := range []struct {
+		name        string
+		coreExpires time.Time
+		blocked     bool
+	}{
+		{"core outlives the margin", now.Add(365 * 24 * time.Hour), false},
+		// the AS certificate expires 1s before the core certificate, which is inside the margin
+		{"core inside the margin", now.Add(margin / 2), true},
+		{"core expires at the deadline", deadline, true},
+		{"core expired", now.Add(-time.Hour), true},
+	} {
+		if blocked := renewalBlocked(c.coreExpires, deadline); blocked != c.blocked {
+			t.Errorf("%s: renewal blocked is %v, expected %v", c.name, blocked, c.blocked)
+		}
+	}
+}
+
+func TestLatestCachedCertVersion(t *testing.T) {
+	defer useTempArtifacts(t)()
+	ctx := context.Background()
+	as := &models.SCIONLabAS{UserEmail: "user@example.com", ISD: 1, ASID: 0xffaa00010001}
+	cacheKey := certCacheKey(as)
+
+	v, err := latestCachedCertVersion(ctx, cacheKey)
+	if err != nil || v != -1 {
+		t.Errorf("an empty cache has version %v: %v", v, err)
+	}
+	for _, key := range []string{"V2/certs/a.crt", "V10/keys/as-sig.key", "V9/certs/a.crt",
+		"Vx/certs/a.crt", "other/a.crt"} {
+		if err = storage.PutBytes(Artifacts, cacheKey+"/"+key, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// versions are compared as numbers, directories not named V<n> are skipped
+	v, err = latestCachedCertVersion(ctx, cacheKey)
+	if err != nil || v != 10 {
+		t.Errorf("the cache has version %v, expected 10: %v", v, err)
+	}
+}
+
+func TestRecordCertExpiration(t *testing.T) {
+	defer useTempArtifacts(t)()
+	ctx := context.Background()
+	as := &models.SCIONLabAS{UserEmail: "user@example.com", ISD: 1, ASID: 0xffaa00010001}
+
+	if err := recordCertExpiration(ctx, as); err == nil {
+		t.Errorf("no error without cached certificates")
+	}
+	expires := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second).UTC()
+	putCertChain(t, as, 1, expires.Add(-24*time.Hour), coreCert(as))
+	putCertChain(t, as, 2, expires, coreCert(as))
+	// the expiration of the newest version is recorded
+	if err := recordCertExpiration(ctx, as); err != nil {
+		t.Fatal(err)
+	}
+	if as.CertVersion != 2 || !as.CertExpires.Equal(expires) {
+		t.Errorf("recorded version %v expiring on %v, expected 2 expiring on %v",
+			as.CertVersion, as.CertExpires, expires)
+	}
+
+	// a version without certificate or with an incomplete chain cannot be read
+	err := storage.PutBytes(Artifacts, certCacheKey(as)+"/V3/keys/as-sig.key", []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = recordCertExpiration(ctx, as); err == nil {
+		t.Errorf("no error without certificate in the newest version")
+	}
+	putCertChain(t, as, 4, expires, nil)
+	if err = recordCertExpiration(ctx, as); err == nil {
+		t.Errorf("no error for an incomplete chain")
+	}
+	if as.CertVersion != 2 || !as.CertExpires.Equal(expires) {
+		t.Errorf("the failures changed the recorded version %v expiring on %v",
+			as.CertVersion, as.CertExpires)
+	}
+}
+
+func TestRenewCertificate(t *testing.T) {
+	defer useTempArtifacts(t)()
+	defer func(generate func(context.Context, *SCIONLabASInfo) error) {
+		generateRenewedGen = generate
+	}(generateRenewedGen)
+	ctx := context.Background()
+
+	apAS := &models.SCIONLabAS{UserEmail: "renew.ap@example.com", PublicIP: "192.0.2.1",
+		StartPort: 50000, ISD: 1, ASID: 0xffaa0001f028, Status: models.Active,
+		Type: models.Infrastructure}
+	if err := apAS.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer apAS.Delete()
+	ap := &models.AttachmentPoint{AS: apAS}
+	if err := ap.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer ap.Delete()
+	expires := time.Now().Add(24 * time.Hour).Truncate(time.Second).UTC()
+	as := &models.SCIONLabAS{UserEmail: "renew@example.com", StartPort: 50000, ISD: 1,
+		ASID: 0xffaa0001f128, Status: models.Active, Type: models.VM, ConfVersion: 4,
+		CertVersion: 1, CertExpires: expires}
+	if err := as.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer as.Delete()
+	cn := &models.Connection{JoinIP: "192.0.2.2", RespondIP: apAS.PublicIP, JoinAS: as,
+		RespondAP: ap, JoinBRID: 1, RespondBRID: 5, Linktype: models.Parent,
+		JoinStatus: models.Active, RespondStatus: models.Active}
+	if err := cn.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Delete()
+
+	// the generation stores the requested certificate version in the cache, as local_gen.py
+	// and preserveCerts do
+	renewed := expires.Add(365 * 24 * time.Hour)
+	generateRenewedGen = func(ctx context.Context, asInfo *SCIONLabASInfo) error {
+		putCertChain(t, asInfo.LocalAS, asInfo.CertVersion, renewed, coreCert(asInfo.LocalAS))
+		return recordCertExpiration(ctx, asInfo.LocalAS)
+	}
+	if err := renewCertificate(ctx, as); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := models.FindSCIONLabASByUserEmailAndASID(as.UserEmail, as.ASID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CertVersion != 2 || !stored.CertExpires.Equal(renewed) {
+		t.Errorf("stored version %v expiring on %v, expected 2 expiring on %v",
+			stored.CertVersion, stored.CertExpires, renewed)
+	}
+	// the AS obtains the new configuration with its next update
+	if stored.ConfVersion != 5 {
+		t.Errorf("stored configuration version %v, expected 5", stored.ConfVersion)
+	}
+
+	// a renewal which does not store a new certificate version fails and is not stored
+	generateRenewedGen = func(ctx context.Context, asInfo *SCIONLabASInfo) error {
+		return recordCertExpiration(ctx, asInfo.LocalAS)
+	}
+	if err = renewCertificate(ctx, as); err == nil {
+		t.Errorf("no error although the certificate version was not stored")
+	}
+	stored, err = models.FindSCIONLabASByUserEmailAndASID(as.UserEmail, as.ASID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CertVersion != 2 || stored.ConfVersion != 5 {
+		t.Errorf("the failed renewal stored version %v and configuration version %v",
+			stored.CertVersion, stored.ConfVersion)
+	}
+}
@@ -0,0 +1,452 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/netsec-ethz/scion-coord/utility"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// VersionsPath is the directory key below which the packages of every configuration version
+// are stored
+var VersionsPath = "versions"
+
+const redacted = "[redacted]"
+
+// inline private keys, e.g. in the OpenVPN client configuration
+var inlineKeyRegexp = regexp.MustCompile(`(?s)(<key>\n).*?(</key>)`)
+
+// private key in the WireGuard client configuration
+var wireGuardKeyRegexp = regexp.MustCompile(`(?m)^(PrivateKey *= *).*$`)
+
+// packageFileInfo describes a file of a configuration package
+type packageFileInfo struct {
+	Path     string // path relative to the package directory
+	Size     int64  // size in bytes
+	SHA256   string // hex encoded hash of the content; empty for secret files
+	Redacted bool   // the file contains secrets which are not shown
+}
+
+type packageContents struct {
+	IA      string
+	Version uint
+	Files   []packageFileInfo
+}
+
+// packageFileDiff describes how a file differs between two configuration versions
+type packageFileDiff struct {
+	Path   string
+	Status string // "added", "removed" or "modified"
+	Diff   string // unified diff, only for text files
+}
+
+type packageDiff struct {
+	IA    string
+	From  uint
+	To    uint
+	Files []packageFileDiff
+}
+
+// packageVersionParams are the parameters of the AS and its connection with which a
+// configuration version was generated. They are needed to roll back to this version.
+type packageVersionParams struct {
+	Version       uint
+	Created       time.Time
+	Type          uint8
+	PackageFormat string
+	Label         string
+	PublicIP      string
+	StartPort     uint16
+	MTU           uint16
+	ServicePort   uint16
+	AP            string // IA of the attachment point
+	IsVPN         bool
+	VPNType       string // empty for versions archived before WireGuard was supported
+	JoinIP        string
+	RespondIP     string
+	RespondBRID   uint16
+	LinkMTU       uint16
+	Bandwidth     uint64
+}
+
+// packageVersionsKey returns the directory key below which the configuration versions of the
+// AS are kept
+func packageVersionsKey(as *models.SCIONLabAS) string {
+	return storage.Join(VersionsPath, UserPackageName(as.UserEmail, as.ISD, as.ASID))
+}
+
+// packageVersionKey returns the key of the package of the given configuration version
+func packageVersionKey(as *models.SCIONLabAS, version uint) string {
+	return fmt.Sprintf("%s/V%d.tar.gz", packageVersionsKey(as), version)
+}
+
+// packageVersionParamsKey returns the key of the parameters of the given configuration version
+func packageVersionParamsKey(as *models.SCIONLabAS, version uint) string {
+	return fmt.Sprintf("%s/V%d.json", packageVersionsKey(as), version)
+}
+
+// archivePackageVersion keeps a copy of the generated tarball and the parameters of the current
+// configuration version of the AS. Only the newest config.PackageVersions versions are kept.
+func archivePackageVersion(ctx context.Context, asInfo *SCIONLabASInfo, tarball []byte) error {
+	as := asInfo.LocalAS
+	if err := storage.PutBytes(Artifacts, packageVersionKey(as, as.ConfVersion),
+		tarball); err != nil {
+		return err
+	}
+	params := packageVersionParams{
+		Version:       as.ConfVersion,
+		Created:       time.Now().UTC(),
+		Type:          as.Type,
+		PackageFormat: as.PackageFormat,
+		Label:         as.Label,
+		PublicIP:      as.PublicIP,
+		StartPort:     as.StartPort,
+		MTU:           as.MTU,
+		ServicePort:   as.ServicePort,
+		AP:            asInfo.RemoteIA.String(),
+		IsVPN:         asInfo.IsVPN,
+		VPNType:       asInfo.VPNType,
+		JoinIP:        asInfo.IP,
+		RespondIP:     asInfo.RemoteIP,
+		RespondBRID:   asInfo.RemoteBRID,
+		LinkMTU:       asInfo.LinkMTU,
+		Bandwidth:     asInfo.Bandwidth,
+	}
+	raw, err := json.MarshalIndent(params, "", "    ")
+	if err != nil {
+		return err
+	}
+	err = storage.PutBytes(Artifacts, packageVersionParamsKey(as, as.ConfVersion), raw)
+	if err != nil {
+		return err
+	}
+	return prunePackageVersions(ctx, as, config.PackageVersions)
+}
+
+// packageVersions returns the sorted configuration versions kept for the AS
+func packageVersions(ctx context.Context, as *models.SCIONLabAS) ([]uint, error) {
+	log := logger.FromContext(ctx)
+	keys, err := Artifacts.List(packageVersionsKey(as))
+	if err != nil {
+		return nil, err
+	}
+	var versions []uint
+	for _, k := range keys {
+		name := path.Base(k)
+		if !strings.HasPrefix(name, "V") || !strings.HasSuffix(name, ".tar.gz") {
+			continue
+		}
+		name = strings.TrimSuffix(name, ".tar.gz")
+		v, err := strconv.ParseUint(name[1:], 10, 32)
+		if err != nil {
+			log.Warnf(`skipping version "%s": cannot parse: %v`, name[1:], err)
+			continue
+		}
+		versions = append(versions, uint(v))
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+// prunePackageVersions removes all but the newest keep configuration versions of the AS
+func prunePackageVersions(ctx context.Context, as *models.SCIONLabAS, keep int) error {
+	versions, err := packageVersions(ctx, as)
+	if err != nil || keep <= 0 || len(versions) <= keep {
+		return err
+	}
+	for _, v := range versions[:len(versions)-keep] {
+		for _, k := range []string{packageVersionKey(as, v), packageVersionParamsKey(as, v)} {
+			if err = Artifacts.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readPackageVersionParams returns the parameters of the given configuration version
+func readPackageVersionParams(as *models.SCIONLabAS, version uint) (*packageVersionParams, error) {
+	raw, err := storage.ReadAll(Artifacts, packageVersionParamsKey(as, version))
+	if err != nil {
+		return nil, err
+	}
+	var params packageVersionParams
+	if err = json.Unmarshal(raw, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse the parameters of version %v of AS %v: %v",
+			version, as.IAString(), err)
+	}
+	return &params, nil
+}
+
+// readPackage returns the content of the regular files in the stored package tarball, indexed
+// by their path relative to the package directory
+func readPackage(tarball string) (map[string][]byte, error) {
+	f, err := Artifacts.Get(tarball)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %v: %v", tarball, err)
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v: %v", tarball, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// strip the name of the package directory
+		name := path.Clean(hdr.Name)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v from %v: %v", hdr.Name, tarball, err)
+		}
+		files[name] = content
+	}
+	return files, nil
+}
+
+// isSecretFile tells whether the whole file is secret, e.g. the AS keys or the account secret
+func isSecretFile(name string) bool {
+	base := path.Base(name)
+	return base == "account_secret" || path.Base(path.Dir(name)) == "keys" ||
+		strings.HasSuffix(base, ".key") || strings.HasSuffix(base, ".seed")
+}
+
+// redactSecrets returns the content of the file with all secrets replaced
+func redactSecrets(name string, content []byte) ([]byte, bool) {
+	if isSecretFile(name) {
+		return []byte(redacted + "\n"), true
+	}
+	if inlineKeyRegexp.Match(content) {
+		return inlineKeyRegexp.ReplaceAll(content, []byte("${1}"+redacted+"\n${2}")), true
+	}
+	if wireGuardKeyRegexp.Match(content) {
+		return wireGuardKeyRegexp.ReplaceAll(content, []byte("${1}"+redacted)), true
+	}
+	return content, false
+}
+
+func isText(content []byte) bool {
+	return utf8.Valid(content) && bytes.IndexByte(content, 0) < 0
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// listPackageFiles describes the files of the package
+func listPackageFiles(files map[string][]byte) []packageFileInfo {
+	infos := []packageFileInfo{}
+	for _, name := range sortedNames(files) {
+		content := files[name]
+		info := packageFileInfo{
+			Path: name,
+			Size: int64(len(content)),
+		}
+		if isSecretFile(name) {
+			info.Redacted = true
+		} else {
+			sum := sha256.Sum256(content)
+			info.SHA256 = hex.EncodeToString(sum[:])
+			_, info.Redacted = redactSecrets(name, content)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// diffPackages compares the files of two packages. Secrets are redacted before comparing the
+// text files, so a changed secret is reported without showing it.
+func diffPackages(from, to map[string][]byte, fromName, toName string) []packageFileDiff {
+	all := make(map[string][]byte)
+	for name, content := range from {
+		all[name] = content
+	}
+	for name, content := range to {
+		all[name] = content
+	}
+	diffs := []packageFileDiff{}
+	for _, name := range sortedNames(all) {
+		a, inFrom := from[name]
+		b, inTo := to[name]
+		fd := packageFileDiff{Path: name}
+		switch {
+		case !inFrom:
+			fd.Status = "added"
+		case !inTo:
+			fd.Status = "removed"
+		case bytes.Equal(a, b):
+			continue
+		default:
+			fd.Status = "modified"
+		}
+		if (inFrom && !isText(a)) || (inTo && !isText(b)) {
+			diffs = append(diffs, fd)
+			continue
+		}
+		a, _ = redactSecrets(name, a)
+		b, _ = redactSecrets(name, b)
+		ud := difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(a)),
+			B:        difflib.SplitLines(string(b)),
+			FromFile: path.Join(fromName, name),
+			ToFile:   path.Join(toName, name),
+			Context:  3,
+		}
+		if !inFrom {
+			ud.A = nil
+		}
+		if !inTo {
+			ud.B = nil
+		}
+		fd.Diff, _ = difflib.GetUnifiedDiffString(ud)
+		diffs = append(diffs, fd)
+	}
+	return diffs
+}
+
+// packageAS returns the AS addressed by the request. Admins address any AS by its IA, users
+// the ASes of the accounts they maintain by AS ID.
+func (s *SCIONLabASController) packageAS(r *http.Request) (*models.SCIONLabAS, error) {
+	vars := mux.Vars(r)
+	if ia, ok := vars["ia"]; ok {
+		IA, err := utility.IAFromString(ia)
+		if err != nil {
+			return nil, err
+		}
+		return models.FindSCIONLabASByIAInt(IA.I, IA.A)
+	}
+	_, uSess, err := middleware.GetUserSession(r)
+	if err != nil {
+		return nil, err
+	}
+	asID, err := utility.ASIDFromString(vars["as_id"])
+	if err != nil {
+		return nil, err
+	}
+	return models.FindSCIONLabASForUser(uSess.Email, asID, models.MemberMaintainer)
+}
+
+// readPackageVersion parses the version in the request variable and reads the package of this
+// version of the AS
+func readPackageVersion(as *models.SCIONLabAS, versionStr string) (uint, map[string][]byte,
+	error) {
+	version, err := strconv.ParseUint(versionStr, 10, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid configuration version \"%v\"", versionStr)
+	}
+	if version == 0 || uint(version) > as.ConfVersion {
+		return 0, nil, fmt.Errorf("AS %v has no configuration version %v", as.IAString(),
+			version)
+	}
+	files, err := readPackage(packageVersionKey(as, uint(version)))
+	if err == storage.ErrNotExist {
+		return 0, nil, fmt.Errorf("the package of version %v of AS %v is not available",
+			version, as.IAString())
+	}
+	return uint(version), files, err
+}
+
+// PackageContents lists the files of the package of a configuration version of an AS
+// E.g. /api/as/packageContents/ffaa_1_1/3 for users, /api/admin/packageContents/17-ffaa_1_1/3 for admins
+func (s *SCIONLabASController) PackageContents(w http.ResponseWriter, r *http.Request) {
+	as, err := s.packageAS(r)
+	if err != nil {
+		s.BadRequestAndLog(r.Context(), w, err, "Error looking up the AS")
+		return
+	}
+	version, files, err := readPackageVersion(as, mux.Vars(r)["version"])
+	if err != nil {
+		s.NotFound(w, nil, err.Error())
+		return
+	}
+	s.JSON(packageContents{
+		IA:      as.IAString(),
+		Version: version,
+		Files:   listPackageFiles(files),
+	}, w, r)
+}
+
+// PackageDiff shows which files changed between two configuration versions of an AS, with a
+// unified diff for text files
+// E.g. /api/as/packageDiff/ffaa_1_1/2/3 for users, /api/admin/packageDiff/17-ffaa_1_1/2/3 for admins
+func (s *SCIONLabASController) PackageDiff(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	as, err := s.packageAS(r)
+	if err != nil {
+		s.BadRequestAndLog(r.Context(), w, err, "Error looking up the AS")
+		return
+	}
+	vars := mux.Vars(r)
+	from, fromFiles, err := readPackageVersion(as, vars["from"])
+	if err != nil {
+		s.NotFound(w, nil, err.Error())
+		return
+	}
+	to, toFiles, err := readPackageVersion(as, vars["to"])
+	if err != nil {
+		s.NotFound(w, nil, err.Error())
+		return
+	}
+	log.Infof("Comparing configuration versions %v and %v of AS %v", from, to, as.IAString())
+	s.JSON(packageDiff{
+		IA:    as.IAString(),
+		From:  from,
+		To:    to,
+		Files: diffPackages(fromFiles, toFiles, fmt.Sprintf("V%d", from), fmt.Sprintf("V%d", to)),
+	}, w, r)
+}
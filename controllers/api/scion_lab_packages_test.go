@@ -0,0 +1,246 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/scionproto/scion/go/lib/addr"
+)
+
+const testClientConf = "remote 1.2.3.4 1194\n<key>\nSECRETKEY\n</key>\n"
+
+// writeTestPackage writes a tarball like the one generated for the AS
+func writeTestPackage(t *testing.T, tarball string, files map[string]string) {
+	f, err := os.Create(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	if err = tw.WriteHeader(&tar.Header{Name: "pkg/", Typeflag: tar.TypeDir,
+		Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range sortedNames(toBytes(files)) {
+		hdr := &tar.Header{Name: "pkg/" + name, Typeflag: tar.TypeReg, Mode: 0644,
+			Size: int64(len(files[name]))}
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func toBytes(files map[string]string) map[string][]byte {
+	m := make(map[string][]byte)
+	for name, content := range files {
+		m[name] = []byte(content)
+	}
+	return m
+}
+
+func TestReadPackage(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	files := map[string]string{
+		"client.conf":            testClientConf,
+		"gen/account_secret":     "secret",
+		"gen/ISD1/topology.json": "{}\n",
+	}
+	oldArtifacts := Artifacts
+	Artifacts = storage.NewLocal(tmp)
+	defer func() { Artifacts = oldArtifacts }()
+	writeTestPackage(t, filepath.Join(tmp, "V1.tar.gz"), files)
+	read, err := readPackage("V1.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, toBytes(files)) {
+		t.Errorf("readPackage returned %v, expected %v", read, files)
+	}
+}
+
+func TestListPackageFiles(t *testing.T) {
+	infos := listPackageFiles(toBytes(map[string]string{
+		"client.conf":                           testClientConf,
+		"gen/account_secret":                    "secret",
+		"gen/ISD1/AS1/endhost/keys/as-sig.seed": "seed",
+		"gen/ISD1/AS1/endhost/topology.json":    "{}\n",
+	}))
+	expected := []struct {
+		path     string
+		hashed   bool
+		redacted bool
+	}{
+		{"client.conf", true, true},
+		{"gen/ISD1/AS1/endhost/keys/as-sig.seed", false, true},
+		{"gen/ISD1/AS1/endhost/topology.json", true, false},
+		{"gen/account_secret", false, true},
+	}
+	if len(infos) != len(expected) {
+		t.Fatalf("listPackageFiles returned %v files, expected %v", len(infos), len(expected))
+	}
+	for i, e := range expected {
+		info := infos[i]
+		if info.Path != e.path || (info.SHA256 != "") != e.hashed || info.Redacted != e.redacted {
+			t.Errorf("listPackageFiles returned %+v, expected %+v", info, e)
+		}
+	}
+	if infos[2].SHA256 != "ca3d163bab055381827226140568f3bef7eaac187cebd76878e0b63e9e442356" {
+		t.Errorf("wrong hash of topology.json: %v", infos[2].SHA256)
+	}
+}
+
+func TestDiffPackages(t *testing.T) {
+	from := toBytes(map[string]string{
+		"client.conf":        testClientConf,
+		"gen/account_secret": "secret",
+		"gen/topology.json":  "{\n\"MTU\": 1472\n}\n",
+		"old.txt":            "old\n",
+		"same.txt":           "same\n",
+	})
+	to := toBytes(map[string]string{
+		"client.conf":        strings.Replace(testClientConf, "SECRETKEY", "NEWKEY", 1),
+		"gen/account_secret": "changed",
+		"gen/topology.json":  "{\n\"MTU\": 1280\n}\n",
+		"new.bin":            "\x00\x01",
+		"same.txt":           "same\n",
+	})
+	diffs := diffPackages(from, to, "V1", "V2")
+	status := make(map[string]string)
+	for _, d := range diffs {
+		status[d.Path] = d.Status
+		if strings.Contains(d.Diff, "SECRET") || strings.Contains(d.Diff, "NEWKEY") ||
+			strings.Contains(d.Diff, "changed") {
+			t.Errorf("the diff of %v shows a secret:\n%v", d.Path, d.Diff)
+		}
+		switch d.Path {
+		case "gen/topology.json":
+			if !strings.Contains(d.Diff, "-\"MTU\": 1472") ||
+				!strings.Contains(d.Diff, "+\"MTU\": 1280") {
+				t.Errorf("wrong diff of topology.json:\n%v", d.Diff)
+			}
+		case "new.bin":
+			if d.Diff != "" {
+				t.Errorf("binary files should not be diffed:\n%v", d.Diff)
+			}
+		case "old.txt":
+			if !strings.Contains(d.Diff, "-old") {
+				t.Errorf("wrong diff of removed file:\n%v", d.Diff)
+			}
+		}
+	}
+	expected := map[string]string{
+		"client.conf":        "modified",
+		"gen/account_secret": "modified",
+		"gen/topology.json":  "modified",
+		"new.bin":            "added",
+		"old.txt":            "removed",
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("diffPackages reported %v, expected %v", status, expected)
+	}
+}
+
+func TestArchivePackageVersions(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	oldArtifacts := Artifacts
+	Artifacts = storage.NewLocal(tmp)
+	defer func() { Artifacts = oldArtifacts }()
+
+	as := &models.SCIONLabAS{
+		UserEmail: "user@example.com",
+		ISD:       1,
+		ASID:      0xffaa00010001,
+		Type:      models.Dedicated,
+		PublicIP:  "192.0.2.1",
+		StartPort: 50000,
+	}
+	asInfo := &SCIONLabASInfo{
+		IP:         "192.0.2.1",
+		RemoteIA:   addr.IA{I: 1, A: 0xffaa00000001},
+		RemoteIP:   "192.0.2.254",
+		RemoteBRID: 5,
+		LocalAS:    as,
+	}
+	for v := uint(1); v <= 4; v++ {
+		as.ConfVersion = v
+		tarball := filepath.Join(tmp, asInfo.UserPackageName()+".tar.gz")
+		writeTestPackage(t, tarball, map[string]string{"gen/coord_conf.ver": fmt.Sprint(v)})
+		raw, err := ioutil.ReadFile(tarball)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = archivePackageVersion(context.Background(), asInfo, raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = prunePackageVersions(context.Background(), as, 2); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := packageVersions(context.Background(), as)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions, []uint{3, 4}) {
+		t.Errorf("kept versions %v, expected [3 4]", versions)
+	}
+	if _, err = readPackageVersionParams(as, 2); err != storage.ErrNotExist {
+		t.Errorf("the parameters of a pruned version should be removed: %v", err)
+	}
+	params, err := readPackageVersionParams(as, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Version != 3 || params.AP != "1-ffaa:0:1" || params.RespondBRID != 5 ||
+		params.JoinIP != "192.0.2.1" || params.Type != models.Dedicated {
+		t.Errorf("wrong parameters of version 3: %+v", params)
+	}
+	files, err := readPackage(packageVersionKey(as, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(files["gen/coord_conf.ver"]) != "3" {
+		t.Errorf("wrong package kept for version 3: %v", files)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/utility"
+)
+
+// rollbackASInfo restores the parameters of the AS from a previous configuration version and
+// returns the SCIONLabASInfo needed to restore its connection. It fails if the connection
+// cannot be restored as it was, e.g. because the BR ID or VPN IP is now used by another AS.
+func rollbackASInfo(as *models.SCIONLabAS, params *packageVersionParams) (*SCIONLabASInfo,
+	error) {
+	remoteAS, err := models.FindSCIONLabASByIAString(params.AP)
+	if err != nil || remoteAS.AP == nil {
+		return nil, fmt.Errorf("the AttachmentPoint %v does not exist anymore", params.AP)
+	}
+	cns, err := as.GetJoinConnectionInfo()
+	if err != nil {
+		return nil, fmt.Errorf("error looking up connections of AS %v: %v", as.IAString(), err)
+	}
+	newConnection := true
+	var oldAP string
+	var cn models.ConnectionInfo
+	for _, cn = range models.OnlyCurrentConnections(cns) {
+		oldAP = utility.IAString(cn.NeighborISD, cn.NeighborAS)
+		if oldAP == params.AP {
+			newConnection = false
+			break
+		}
+	}
+
+	remoteIP := remoteAS.PublicIP
+	vpnType := connVPNType(params.IsVPN, params.VPNType)
+	if params.IsVPN {
+		if !remoteAS.AP.HasVPNType(vpnType) {
+			return nil, fmt.Errorf("the AttachmentPoint %v does not have a %v server "+
+				"running anymore", params.AP, vpnType)
+		}
+		remoteIP = remoteAS.AP.VPNIP
+	}
+	if remoteIP != params.RespondIP {
+		return nil, fmt.Errorf("the address of the AttachmentPoint %v has changed from %v to %v",
+			params.AP, params.RespondIP, remoteIP)
+	}
+	apCns, err := remoteAS.GetRespondConnectionInfo()
+	if err != nil {
+		return nil, fmt.Errorf("error looking up connections of AP %v: %v", params.AP, err)
+	}
+	if newConnection {
+		for _, apCn := range apCns {
+			if apCn.BRID == params.RespondBRID {
+				return nil, fmt.Errorf("the border router %v of the AttachmentPoint %v is "+
+					"used by another connection", params.RespondBRID, params.AP)
+			}
+		}
+	} else if cn.NeighborBRID != params.RespondBRID {
+		return nil, fmt.Errorf("the connection to the AttachmentPoint %v now uses the border "+
+			"router %v instead of %v", params.AP, cn.NeighborBRID, params.RespondBRID)
+	}
+	if params.IsVPN {
+		pool, err := remoteAS.AP.VPNPool()
+		if err != nil {
+			return nil, fmt.Errorf("error loading the VPN pool of AP %v: %v", params.AP, err)
+		}
+		if !pool.IsFree(params.JoinIP, models.VPNOwner(as), time.Now().UTC()) {
+			return nil, fmt.Errorf("the VPN IP %v is used by another AS or not part of the "+
+				"VPN pool of the AttachmentPoint %v anymore", params.JoinIP, params.AP)
+		}
+	}
+
+	as.Type = params.Type
+	as.PackageFormat = params.PackageFormat
+	as.Label = params.Label
+	as.PublicIP = params.PublicIP
+	as.StartPort = params.StartPort
+	as.MTU = params.MTU
+	as.ServicePort = params.ServicePort
+	if as.Status == models.Inactive {
+		as.Status = models.Create
+	} else {
+		as.Status = models.Update
+	}
+	asInfo := &SCIONLabASInfo{
+		IsNewConnection: newConnection,
+		IsVPN:           params.IsVPN,
+		VPNType:         vpnType,
+		RemoteIA:        remoteAS.IA(),
+		IP:              params.JoinIP,
+		LocalPort:       as.StartPort,
+		RemoteIP:        remoteIP,
+		RemoteBRID:      params.RespondBRID,
+		RemotePort:      remoteAS.GetPortNumberFromBRID(params.RespondBRID),
+		LinkMTU:         params.LinkMTU,
+		Bandwidth:       params.Bandwidth,
+		LocalAS:         as,
+		RemoteAS:        remoteAS,
+	}
+	if newConnection {
+		asInfo.OldAP = oldAP
+	}
+	if params.IsVPN {
+		asInfo.VPNServerIP = remoteAS.PublicIP
+		asInfo.VPNServerPort = remoteAS.AP.VPNPort
+		asInfo.VPNServerKey = remoteAS.AP.WGPublicKey
+	}
+	return asInfo, nil
+}
+
+// restorePackage unpacks the package of the given version as the current package of the AS
+// and packages it again with the current configuration version. The AS keeps its newest
+// certificate and current credentials.
+func restorePackage(ctx context.Context, asInfo *SCIONLabASInfo, version uint) error {
+	log := logger.FromContext(ctx)
+	os.RemoveAll(asInfo.UserPackagePath())
+	tarball, err := Artifacts.Get(packageVersionKey(asInfo.LocalAS, version))
+	if err != nil {
+		return fmt.Errorf("failed to read version %v: %v", version, err)
+	}
+	defer tarball.Close()
+	cmd := exec.Command("tar", "xzf", "-")
+	cmd.Dir = PackagePath
+	cmd.Stdin = tarball
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to unpack version %v: %v: %s", version, err, out)
+	}
+	if err := preserveCerts(ctx, asInfo); err != nil {
+		return fmt.Errorf("Error reusing existing certificates: %v", err)
+	}
+	if err := recordCertExpiration(ctx, asInfo.LocalAS); err != nil {
+		log.Errorf("Error reading the certificate expiration of AS %v: %v",
+			asInfo.LocalAS.IAString(), err)
+	}
+	if asInfo.IsVPN {
+		if err := generateVPNConfig(ctx, asInfo); err != nil {
+			return fmt.Errorf("Error generating VPN config: %v", err)
+		}
+	}
+	if err := createUserLoginConfiguration(ctx, asInfo); err != nil {
+		return fmt.Errorf("Error generating user credential files: %v", err)
+	}
+	return createTarball(ctx, asInfo)
+}
+
+// PackageVersions lists the configuration versions of the AS which can be rolled back to
+func (s *SCIONLabASController) PackageVersions(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	as, err := s.packageAS(r)
+	if err != nil {
+		s.BadRequestAndLog(r.Context(), w, err, "Error looking up the AS")
+		return
+	}
+	versions, err := packageVersions(r.Context(), as)
+	if err != nil {
+		log.Errorf("Error listing the configuration versions of AS %v: %v", as.IAString(), err)
+		s.Error500(w, err, "Error listing the configuration versions")
+		return
+	}
+	params := []packageVersionParams{}
+	for _, v := range versions {
+		p, err := readPackageVersionParams(as, v)
+		if err != nil {
+			log.Warnf("Skipping version %v of AS %v: %v", v, as.IAString(), err)
+			continue
+		}
+		params = append(params, *p)
+	}
+	s.JSON(params, w, r)
+}
+
+// RollbackSCIONLabAS issues a new configuration version of the AS with the content of a
+// previous version, and restores the connection of that version. The APs receive the
+// corresponding create, update or remove requests.
+func (s *SCIONLabASController) RollbackSCIONLabAS(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, uSess, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("Error getting the user session: %v", err)
+		s.Forbidden(w, err, "Error getting the user session")
+		return
+	}
+	vars := mux.Vars(r)
+	asID, err := utility.ASIDFromString(vars["as_id"])
+	if err != nil {
+		s.BadRequestAndLog(r.Context(), w, nil, err.Error())
+		return
+	}
+	version, err := strconv.ParseUint(vars["version"], 10, 32)
+	if err != nil {
+		s.BadRequestAndLog(r.Context(), w, nil, "Invalid configuration version %v", vars["version"])
+		return
+	}
+	as := memberAS(r.Context(), s.HTTPController, w, uSess.Email, asID, models.MemberMaintainer)
+	if as == nil {
+		return
+	}
+	if err := s.canConfigure(as.UserEmail, asID); err != nil {
+		log.Errorf("Error checking pending create or update for user %v: %v", uSess.Email, err)
+		s.Error500(w, err, "Error checking pending create or update")
+		return
+	}
+	params, err := readPackageVersionParams(as, uint(version))
+	if err != nil {
+		log.Errorf("Error reading version %v of AS %v: %v", version, as.IAString(), err)
+		s.NotFound(w, nil, "Configuration version %v is not available", version)
+		return
+	}
+	asInfo, err := rollbackASInfo(as, params)
+	if err != nil {
+		s.BadRequestAndLog(r.Context(), w, nil, "Cannot roll back to configuration version %v: %v",
+			version, err)
+		return
+	}
+	asInfo.LocalAS.ConfVersion++
+	log.Infof("Rolling back AS %v to configuration version %v as version %v", as.IAString(),
+		version, as.ConfVersion)
+	if err = restorePackage(r.Context(), asInfo, uint(version)); err != nil {
+		log.Errorf("%v", err)
+		s.Error500(w, err, "Error restoring the configuration")
+		return
+	}
+	if err = s.updateDB(r.Context(), asInfo); err != nil {
+		log.Errorf("Error updating DB tables: %v", err)
+		s.Error500(w, err, "Error updating DB tables")
+		return
+	}
+	fmt.Fprintf(w, "Your SCIONLab AS has been rolled back to configuration version %v. "+
+		"It will be updated within a few minutes. You will receive an email confirmation as "+
+		"soon as the process is complete.", version)
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLinkParameters(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		req   SCIONLabRequest
+		valid bool
+	}{
+		{"defaults", SCIONLabRequest{}, true},
+		{"MTUs at the minimum", SCIONLabRequest{MTU: 1280, LinkMTU: 1280}, true},
+		{"MTU below the minimum", SCIONLabRequest{MTU: 1279}, false},
+		{"link MTU below the minimum", SCIONLabRequest{LinkMTU: 576}, false},
+		{"lowest service ports", SCIONLabRequest{ServicePort: 1024}, true},
+		{"highest service ports", SCIONLabRequest{ServicePort: 65531}, true},
+		{"service ports below the range", SCIONLabRequest{ServicePort: 1023}, false},
+		{"service ports above the range", SCIONLabRequest{ServicePort: 65532}, false},
+		{"service ports end below the BR control port", SCIONLabRequest{ServicePort: 30037},
+			true},
+		{"service ports end at the BR control port", SCIONLabRequest{ServicePort: 30038},
+			false},
+		{"service ports start at the BR control port", SCIONLabRequest{ServicePort: 30042},
+			false},
+		{"service ports start above the BR control port", SCIONLabRequest{ServicePort: 30043},
+			true},
+		{"port at the BR control port", SCIONLabRequest{Port: 30042}, false},
+	} {
+		err := c.req.validateLinkParameters()
+		if c.valid {
+			assert.NoError(t, err, c.name)
+		} else {
+			assert.Error(t, err, c.name)
+		}
+	}
+}
+
+func TestLinkWithinLimits(t *testing.T) {
+	defer func(mtu, bandwidth int) {
+		config.MTU, config.Bandwidth = mtu, bandwidth
+	}(config.MTU, config.Bandwidth)
+	config.MTU, config.Bandwidth = 1472, 1000
+
+	for _, c := range []struct {
+		name      string
+		req       SCIONLabRequest
+		ap        models.AttachmentPoint
+		mtu       uint16
+		bandwidth uint64
+		valid     bool
+	}{
+		{"defaults", SCIONLabRequest{}, models.AttachmentPoint{}, 0, 0, true},
+		{"within the default limits", SCIONLabRequest{LinkMTU: 1400, Bandwidth: 500},
+			models.AttachmentPoint{}, 1400, 500, true},
+		{"MTU above the default limit", SCIONLabRequest{LinkMTU: 1500},
+			models.AttachmentPoint{}, 0, 0, false},
+		{"bandwidth above the default limit", SCIONLabRequest{Bandwidth: 1001},
+			models.AttachmentPoint{}, 0, 0, false},
+		{"above the defaults within the limits of the AP",
+			SCIONLabRequest{LinkMTU: 9000, Bandwidth: 10000},
+			models.AttachmentPoint{MaxMTU: 9000, MaxBandwidth: 10000}, 9000, 10000, true},
+		{"defaults clamped to the lower limits of the AP", SCIONLabRequest{},
+			models.AttachmentPoint{MaxMTU: 1400, MaxBandwidth: 100}, 1400, 100, true},
+		{"MTU above the lower limit of the AP", SCIONLabRequest{LinkMTU: 1472},
+			models.AttachmentPoint{MaxMTU: 1400}, 0, 0, false},
+		{"bandwidth above the lower limit of the AP", SCIONLabRequest{Bandwidth: 1000},
+			models.AttachmentPoint{MaxBandwidth: 100}, 0, 0, false},
+	} {
+		mtu, bandwidth, err := c.req.linkWithinLimits(&c.ap)
+		if !c.valid {
+			assert.Error(t, err, c.name)
+			continue
+		}
+		if assert.NoError(t, err, c.name) {
+			assert.Equal(t, c.mtu, mtu, c.name)
+			assert.Equal(t, c.bandwidth, bandwidth, c.name)
+		}
+	}
+}
+
+func TestServicePortsDefault(t *testing.T) {
+	defer func(port uint16) { config.ServiceStartPort = port }(config.ServiceStartPort)
+	// the default of service_start_port
+	config.ServiceStartPort = 31041
+
+	// the ports of the topology before they were configurable
+	expected := map[string]string{
+		"BS_PORT":      "31041",
+		"BR_PORT":      "31042",
+		"CS_PORT":      "31043",
+		"PS_PORT":      "31044",
+		"SB_PORT":      "31045",
+		"BR_CTRL_PORT": "30042",
+	}
+	assert.Equal(t, expected, servicePorts(&models.SCIONLabAS{}))
+
+	expected = map[string]string{
+		"BS_PORT":      "40000",
+		"BR_PORT":      "40001",
+		"CS_PORT":      "40002",
+		"PS_PORT":      "40003",
+		"SB_PORT":      "40004",
+		"BR_CTRL_PORT": "30042",
+	}
+	assert.Equal(t, expected, servicePorts(&models.SCIONLabAS{ServicePort: 40000}))
+}
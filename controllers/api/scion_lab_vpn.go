@@ -15,40 +15,63 @@
 package api
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
 	"github.com/netsec-ethz/scion-coord/utility"
 	"github.com/scionproto/scion/go/lib/addr"
 )
 
-func generateVPNConfig(asInfo *SCIONLabASInfo) error {
-	config, err := readVPNConfig(asInfo)
+// connVPNType returns the VPN type of a connection; empty if it does not use a VPN
+func connVPNType(isVPN bool, vpnType string) string {
+	if !isVPN {
+		return ""
+	}
+	return models.VPNTypeOrDefault(vpnType)
+}
+
+// releaseVPNIP puts the VPN address the AS no longer uses at the AP on hold for it. Errors are
+// only logged, the address is then free again right away.
+func releaseVPNIP(ctx context.Context, ap *models.AttachmentPoint, ip string,
+	as *models.SCIONLabAS) {
+	log := logger.FromContext(ctx)
+	if err := ap.HoldVPNIP(ip, as); err != nil {
+		log.Errorf("Error holding VPN address %v for AS %v: %v", ip, as.IAString(), err)
+	}
+}
+
+// generateVPNConfig adds the configuration of the VPN client of the AS to its package
+func generateVPNConfig(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	if asInfo.VPNType == models.WireGuard {
+		return generateWireGuardConfig(ctx, asInfo)
+	}
+	vpnConfig, err := readVPNConfig(ctx, asInfo)
 	if err != nil {
-		err = cleanVPNKeys(asInfo)
+		err = cleanVPNKeys(ctx, asInfo.LocalAS.UserEmail, asInfo.LocalAS.ASID)
 		if err == nil {
-			config, err = readVPNConfig(asInfo)
+			vpnConfig, err = readVPNConfig(ctx, asInfo)
 		}
 	}
 	if err != nil {
 		return err
 	}
-	err = utility.FillTemplateAndSave("templates/client.conf.tmpl", config,
+	err = utility.FillTemplateAndSave("templates/client.conf.tmpl", vpnConfig,
 		filepath.Join(asInfo.UserPackagePath(), "client.conf"))
 	return err
 }
 
 // Generates client keys and configuration necessary for a VPN-based setup
-func readVPNConfig(asInfo *SCIONLabASInfo) (map[string]string, error) {
-	log.Printf("Creating VPN config for SCIONLab AS")
-	if err := generateVPNKeys(asInfo); err != nil {
+func readVPNConfig(ctx context.Context, asInfo *SCIONLabASInfo) (map[string]string, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("Creating VPN config for SCIONLab AS")
+	if err := generateVPNKeys(ctx, asInfo); err != nil {
 		return nil, err
 	}
 	userEmail := asInfo.LocalAS.UserEmail
@@ -59,113 +82,101 @@ func readVPNConfig(asInfo *SCIONLabASInfo) (map[string]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error reading CA certificate file: %v", err)
 	}
-	vpnCertPath := vpnCertPath(userEmail, userASID)
-	clientCert, err = ioutil.ReadFile(vpnCertPath)
+	vpnCertKey := vpnCertKey(userEmail, userASID)
+	clientCert, err = storage.ReadAll(Artifacts, vpnCertKey)
 	if err != nil {
-		return nil, fmt.Errorf("error reading VPN certificate file for user %v: %v", userEmail, err)
+		return nil, fmt.Errorf("error reading VPN certificate for user %v: %v", userEmail, err)
 	}
 	clientCertStr := string(clientCert)
 	startCert := strings.Index(clientCertStr, "-----BEGIN CERTIFICATE-----")
 	if startCert < 0 {
-		return nil, fmt.Errorf("Internal error: certificate file %s exists but wrong contents. Will try one more time",
-			vpnCertPath)
+		return nil, fmt.Errorf("Internal error: certificate %s exists but wrong contents. Will try one more time",
+			vpnCertKey)
 	}
-	clientKey, err = ioutil.ReadFile(vpnKeyPath(userEmail, userASID))
+	clientKey, err = storage.ReadAll(Artifacts, vpnKeyKey(userEmail, userASID))
 	if err != nil {
-		return nil, fmt.Errorf("error reading VPN key file for user %v: %v", userEmail, err)
+		return nil, fmt.Errorf("error reading VPN key for user %v: %v", userEmail, err)
+	}
+	proto := "udp"
+	if utility.IsIPv6(asInfo.VPNServerIP) {
+		proto = "udp6"
 	}
-	config := map[string]string{
+	vpnConfig := map[string]string{
+		"Proto":      proto,
 		"ServerIP":   asInfo.VPNServerIP,
 		"ServerPort": fmt.Sprintf("%v", asInfo.VPNServerPort),
 		"CACert":     string(caCert),
 		"ClientCert": clientCertStr[startCert:],
 		"ClientKey":  string(clientKey),
 	}
-	return config, nil
+	return vpnConfig, nil
 }
 
-// removes the files for the VPN keys
-func cleanVPNKeys(asInfo *SCIONLabASInfo) error {
-	userEmail := asInfo.LocalAS.UserEmail
-	userASID := asInfo.LocalAS.ASID
-	p := vpnKeyPath(userEmail, userASID)
-	err := os.Remove(p)
-	if err != nil && !os.IsNotExist(err) {
-		err = fmt.Errorf("Cleaning VPN keys: could not remove file under %s: %v", p, err)
-		log.Print(err)
+// revokes the client certificate of the AS and removes the stored VPN keys and their files in
+// the easy-rsa directory
+func cleanVPNKeys(ctx context.Context, userEmail string, userASID addr.AS) error {
+	log := logger.FromContext(ctx)
+	if err := revokeVPNCert(ctx, userEmail, userASID); err != nil {
+		err = fmt.Errorf("Cleaning VPN keys: could not revoke the certificate of %s: %v",
+			vpnUserID(userEmail, userASID), err)
+		log.Errorf("%v", err)
 		return err
 	}
-	p = vpnCertPath(userEmail, userASID)
-	_, err = os.Stat(p)
-	if err == nil {
-		err = os.Remove(p)
-	} else if !os.IsNotExist(err) {
-		err = fmt.Errorf("Cleaning VPN keys: error when stat on %s: %v", p, err)
-	}
-	if err != nil {
-		msg := fmt.Sprintf("Cleaning VPN keys: could not remove file under %s: %v", p, err)
-		log.Print(msg)
-		return fmt.Errorf(msg)
-	}
-	// find the key in the TXT DB and remove it:
-	dbFile := filepath.Join(RSAKeyPath, "index.txt")
-	if err = utility.RotateFiles(dbFile+".bak", 4); err != nil {
-		return err
-	}
-	// write contents to index.txt:
-	dbData, err := ioutil.ReadFile(dbFile)
-	if err != nil {
-		return err
-	}
-	id := vpnUserID(userEmail, userASID)
-	newData := []byte{}
-	scanner := bufio.NewScanner(bytes.NewReader(dbData))
-	for scanner.Scan() {
-		line := scanner.Text() + "\n"
-		if strings.Index(line, id) == -1 {
-			newData = append(newData, line...)
+	for _, k := range []string{vpnKeyKey(userEmail, userASID), vpnCertKey(userEmail, userASID)} {
+		if err := Artifacts.Delete(k); err != nil {
+			err = fmt.Errorf("Cleaning VPN keys: could not remove %s: %v", k, err)
+			log.Errorf("%v", err)
+			return err
 		}
 	}
-	if err = os.Rename(dbFile, dbFile+".bak"); err != nil {
-		return err
+	for _, p := range []string{vpnKeyPath(userEmail, userASID), vpnCertPath(userEmail, userASID)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			err = fmt.Errorf("Cleaning VPN keys: could not remove file under %s: %v", p, err)
+			log.Errorf("%v", err)
+			return err
+		}
 	}
-	err = ioutil.WriteFile(dbFile, newData, 0664)
-	return err
+	return nil
 }
 
-// Creates the keys for VPN setup
-func generateVPNKeys(asInfo *SCIONLabASInfo) error {
+// Creates the keys for VPN setup and stores them. Keys found in the easy-rsa directory but
+// not in the storage, i.e. issued by easy-rsa, are stored as well.
+func generateVPNKeys(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	log := logger.FromContext(ctx)
 	userEmail := asInfo.LocalAS.UserEmail
 	userASID := asInfo.LocalAS.ASID
-	log.Printf("Getting RSA keys for %s %s", userEmail, userASID)
-	_, err := os.Stat(vpnKeyPath(userEmail, userASID))
-	if err == nil {
-		_, err = os.Stat(vpnCertPath(userEmail, userASID))
-	}
-	if err == nil {
-		log.Print("Previous VPN keys exist")
-	} else if os.IsNotExist(err) {
-		log.Print("Missing files, will generate them")
-		cmd := exec.Command("/bin/bash", "-c", "source vars; ./build-key --batch "+
-			vpnUserID(userEmail, userASID))
-		cmd.Dir = EasyRSAPath
-		cmdOut, _ := cmd.StdoutPipe()
-		cmdErr, _ := cmd.StderrPipe()
-		if err := cmd.Run(); err != nil {
-			log.Printf("Error during generation of VPN keys for user %v: %v", userEmail, err)
+	log.Infof("Getting RSA keys for %s %s", userEmail, userASID)
+	stored, err := storage.Exists(Artifacts, vpnKeyKey(userEmail, userASID))
+	if err == nil && stored {
+		stored, err = storage.Exists(Artifacts, vpnCertKey(userEmail, userASID))
+	}
+	if err != nil {
+		log.Errorf("Error checking for existence of VPN keys for user %v: %v", userEmail, err)
+		return err
+	}
+	if stored {
+		log.Infof("Previous VPN keys exist")
+		return nil
+	}
+	key, errKey := ioutil.ReadFile(vpnKeyPath(userEmail, userASID))
+	cert, errCert := ioutil.ReadFile(vpnCertPath(userEmail, userASID))
+	if errKey == nil && errCert == nil {
+		log.Infof("Storing the VPN keys issued by easy-rsa")
+	} else {
+		log.Infof("Missing keys, will issue them")
+		issued, err := issueVPNCert(ctx, vpnUserID(userEmail, userASID))
+		if err != nil {
+			log.Errorf("Error during generation of VPN keys for user %v: %v", userEmail, err)
 			return err
 		}
-
-		// read stdout and stderr
-		stdOutput, _ := ioutil.ReadAll(cmdOut)
-		errOutput, _ := ioutil.ReadAll(cmdErr)
-		fmt.Printf("STDOUT generateVPNKeys: %s\n", stdOutput)
-		fmt.Printf("ERROUT generateVPNKeys: %s\n", errOutput)
-	} else {
-		log.Printf("Error checking for existence of VPN keys for user %v: %v", userEmail, err)
-		return err
+		key, cert = issued.KeyPEM, issued.CertPEM
+	}
+	if err = storage.PutBytes(Artifacts, vpnKeyKey(userEmail, userASID), key); err != nil {
+		return fmt.Errorf("error storing VPN key for user %v: %v", userEmail, err)
+	}
+	if err = storage.PutBytes(Artifacts, vpnCertKey(userEmail, userASID), cert); err != nil {
+		return fmt.Errorf("error storing VPN certificate for user %v: %v", userEmail, err)
 	}
-
 	return nil
 }
 
@@ -175,7 +186,7 @@ func vpnUserID(userEmail string, asID addr.AS) string {
 	return ret
 }
 
-// Path for client key and certificate; fileExt can be "key" or "cert"
+// Path for client key and certificate in the easy-rsa directory; fileExt can be "key" or "crt"
 func vpnKeyCertPath(userEmail string, asID addr.AS, fileExt string) string {
 	return filepath.Join(RSAKeyPath, vpnUserID(userEmail, asID)+"."+fileExt)
 }
@@ -189,3 +200,19 @@ func vpnKeyPath(userEmail string, asID addr.AS) string {
 func vpnCertPath(userEmail string, asID addr.AS) string {
 	return vpnKeyCertPath(userEmail, asID, "crt")
 }
+
+// Key of the stored client key and certificate; fileExt can be "key" or "crt". The keys match
+// the location of the files in the easy-rsa directory.
+func vpnKeyCertKey(userEmail string, asID addr.AS, fileExt string) string {
+	return storage.Join("easy-rsa", "keys", vpnUserID(userEmail, asID)+"."+fileExt)
+}
+
+// Key of the stored client key
+func vpnKeyKey(userEmail string, asID addr.AS) string {
+	return vpnKeyCertKey(userEmail, asID, "key")
+}
+
+// Key of the stored client certificate
+func vpnCertKey(userEmail string, asID addr.AS) string {
+	return vpnKeyCertKey(userEmail, asID, "crt")
+}
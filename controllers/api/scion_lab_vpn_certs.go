@@ -0,0 +1,230 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/netsec-ethz/scion-coord/vpnca"
+	"github.com/scionproto/scion/go/lib/addr"
+)
+
+// vpnCertRegistry records the issued and revoked VPN client certificates
+type vpnCertRegistry interface {
+	Insert(vc *models.VPNCertificate) error
+	Update(vc *models.VPNCertificate) error
+	// FindBySerial returns orm.ErrNoRows if the certificate is not recorded
+	FindBySerial(serial string) (*models.VPNCertificate, error)
+	FindByCommonName(commonName string) ([]models.VPNCertificate, error)
+	FindRevoked(issuer string) ([]models.VPNCertificate, error)
+}
+
+// vpnCerts is the registry used to record the VPN client certificates
+var vpnCerts vpnCertRegistry = dbVPNCertRegistry{}
+
+// dbVPNCertRegistry records the certificates in the vpn_certificate table
+type dbVPNCertRegistry struct{}
+
+func (dbVPNCertRegistry) Insert(vc *models.VPNCertificate) error {
+	return vc.Insert()
+}
+
+func (dbVPNCertRegistry) Update(vc *models.VPNCertificate) error {
+	return vc.Update()
+}
+
+func (dbVPNCertRegistry) FindBySerial(serial string) (*models.VPNCertificate, error) {
+	return models.FindVPNCertificateBySerial(serial)
+}
+
+func (dbVPNCertRegistry) FindByCommonName(commonName string) ([]models.VPNCertificate, error) {
+	return models.FindVPNCertificatesByCommonName(commonName)
+}
+
+func (dbVPNCertRegistry) FindRevoked(issuer string) ([]models.VPNCertificate, error) {
+	return models.FindRevokedVPNCertificatesByIssuer(issuer)
+}
+
+// issueVPNCert issues a client certificate with the VPN CA and records it
+func issueVPNCert(ctx context.Context, commonName string) (*vpnca.Issued, error) {
+	log := logger.FromContext(ctx)
+	ca, err := vpnca.Load(CACertPath, CAKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	issued, err := ca.Issue(commonName,
+		time.Duration(config.VPNCertValidity)*24*time.Hour, config.VPNKeySize)
+	if err != nil {
+		return nil, err
+	}
+	vc := &models.VPNCertificate{
+		Serial:     vpnca.SerialString(issued.Cert.SerialNumber),
+		CommonName: commonName,
+		Issuer:     ca.ID(),
+		Issued:     issued.Cert.NotBefore.UTC(),
+		Expires:    issued.Cert.NotAfter.UTC(),
+	}
+	if err = vpnCerts.Insert(vc); err != nil {
+		return nil, fmt.Errorf("error recording the VPN certificate %v: %v", vc.Serial, err)
+	}
+	log.Infof("Issued VPN certificate %v for %v", vc.Serial, commonName)
+	return issued, nil
+}
+
+// revokeVPNCert revokes the stored client certificate of the AS and any other certificate
+// recorded for it. A certificate issued by easy-rsa is recorded as revoked.
+func revokeVPNCert(ctx context.Context, userEmail string, asID addr.AS) error {
+	log := logger.FromContext(ctx)
+	commonName := vpnUserID(userEmail, asID)
+	raw, err := storage.ReadAll(Artifacts, vpnCertKey(userEmail, asID))
+	if err == storage.ErrNotExist {
+		raw, err = ioutil.ReadFile(vpnCertPath(userEmail, asID))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		cert, err := vpnca.ParseCertificatePEM(raw)
+		if err != nil {
+			log.Errorf("Cannot parse the VPN certificate of %v, not revoking it: %v",
+				commonName, err)
+		} else if err = recordRevokedVPNCert(ctx, commonName, cert); err != nil {
+			return err
+		}
+	}
+	vcs, err := vpnCerts.FindByCommonName(commonName)
+	if err != nil {
+		return err
+	}
+	for i := range vcs {
+		if err = revokeVPNCertRecord(ctx, &vcs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRevokedVPNCert marks the certificate as revoked, recording it first if it was not
+// issued by the VPN CA
+func recordRevokedVPNCert(ctx context.Context, commonName string, cert *x509.Certificate) error {
+	serial := vpnca.SerialString(cert.SerialNumber)
+	vc, err := vpnCerts.FindBySerial(serial)
+	if err == orm.ErrNoRows {
+		vc = &models.VPNCertificate{
+			Serial:     serial,
+			CommonName: commonName,
+			Issuer:     vpnca.IssuerID(cert),
+			Issued:     cert.NotBefore.UTC(),
+			Expires:    cert.NotAfter.UTC(),
+		}
+		err = vpnCerts.Insert(vc)
+	}
+	if err != nil {
+		return err
+	}
+	return revokeVPNCertRecord(ctx, vc)
+}
+
+// revokeVPNCertRecord marks the recorded certificate as revoked now, unless it already is
+func revokeVPNCertRecord(ctx context.Context, vc *models.VPNCertificate) error {
+	log := logger.FromContext(ctx)
+	if vc.Revoked {
+		return nil
+	}
+	log.Infof("Revoking VPN certificate %v of %v", vc.Serial, vc.CommonName)
+	vc.Revoked = true
+	vc.RevokedAt = time.Now().UTC()
+	return vpnCerts.Update(vc)
+}
+
+// vpnCRL returns the PEM encoded CRL of the VPN CA
+func vpnCRL(ctx context.Context) ([]byte, error) {
+	ca, err := vpnca.Load(CACertPath, CAKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := revokedVPNCerts(ctx, ca)
+	if err != nil {
+		return nil, err
+	}
+	return ca.CRL(revoked, time.Duration(config.VPNCRLValidity)*24*time.Hour)
+}
+
+// revokedVPNCerts returns the entries of the CRL of the VPN CA. Expired certificates are left
+// out, as OpenVPN rejects them anyway.
+func revokedVPNCerts(ctx context.Context, ca *vpnca.CA) ([]pkix.RevokedCertificate, error) {
+	log := logger.FromContext(ctx)
+	vcs, err := vpnCerts.FindRevoked(ca.ID())
+	if err != nil {
+		return nil, fmt.Errorf("error looking up the revoked VPN certificates: %v", err)
+	}
+	now := time.Now()
+	var revoked []pkix.RevokedCertificate
+	for _, vc := range vcs {
+		if vc.Expires.Before(now) {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(vc.Serial, 16)
+		if !ok {
+			log.Warnf("Invalid serial number %v of the revoked VPN certificate of %v",
+				vc.Serial, vc.CommonName)
+			continue
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: vc.RevokedAt,
+		})
+	}
+	return revoked, nil
+}
+
+// API end-point for the APs to fetch the CRL of the VPN CA, to be used as the crl-verify file
+// of their OpenVPN server. The CRL has to be fetched again before its next update.
+// Example:
+// GET /api/as/getVPNCRL/<account_id>/<secret>?scionLabAP=1-ffaa:0:1107
+// returns
+// -----BEGIN X509 CRL-----
+// ...
+// -----END X509 CRL-----
+func (s *SCIONLabASController) GetVPNCRL(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	log.Infof("API Call for getVPNCRL = %v", r.URL.Query())
+	apIA, err := checkAuthorization(r, r.URL.Query().Get("scionLabAP"))
+	if err != nil {
+		s.Forbidden(w, err, "The account is not authorized for this AP")
+		return
+	}
+	crl, err := vpnCRL(r.Context())
+	if err != nil {
+		log.Errorf("Error generating the VPN CRL for AP %v: %v", apIA, err)
+		s.Error500(w, err, "Error generating the VPN CRL")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(crl)
+}
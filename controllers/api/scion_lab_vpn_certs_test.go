@@ -0,0 +1,277 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/netsec-ethz/scion-coord/vpnca"
+)
+
+// memVPNCertRegistry records the VPN certificates in memory instead of the DB
+type memVPNCertRegistry struct {
+	certs []*models.VPNCertificate
+}
+
+func (m *memVPNCertRegistry) Insert(vc *models.VPNCertificate) error {
+	vc.ID = uint64(len(m.certs) + 1)
+	m.certs = append(m.certs, vc)
+	return nil
+}
+
+func (m *memVPNCertRegistry) Update(vc *models.VPNCertificate) error {
+	*m.certs[vc.ID-1] = *vc
+	return nil
+}
+
+func (m *memVPNCertRegistry) FindBySerial(serial string) (*models.VPNCertificate, error) {
+	for _, vc := range m.certs {
+		if vc.Serial == serial {
+			c := *vc
+			return &c, nil
+		}
+	}
+	return nil, orm.ErrNoRows
+}
+
+func (m *memVPNCertRegistry) FindByCommonName(commonName string) ([]models.VPNCertificate, error) {
+	var vcs []models.VPNCertificate
+	for i := len(m.certs) - 1; i >= 0; i-- {
+		if m.certs[i].CommonName == commonName {
+			vcs = append(vcs, *m.certs[i])
+		}
+	}
+	return vcs, nil
+}
+
+func (m *memVPNCertRegistry) FindRevoked(issuer string) ([]models.VPNCertificate, error) {
+	var vcs []models.VPNCertificate
+	for _, vc := range m.certs {
+		if vc.Issuer == issuer && vc.Revoked {
+			vcs = append(vcs, *vc)
+		}
+	}
+	return vcs, nil
+}
+
+// prepareVPNCA creates a CA in a temporary easy-rsa key directory and keeps the VPN keys and
+// certificates in a temporary storage and an in-memory registry
+func prepareVPNCA(t *testing.T) (*vpnca.CA, func()) {
+	tmp, err := ioutil.TempDir("", "vpn_certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"ETH"}, CommonName: "ETH CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRSAKeyPath, oldCACertPath, oldCAKeyPath := RSAKeyPath, CACertPath, CAKeyPath
+	oldArtifacts, oldVPNCerts, oldKeySize := Artifacts, vpnCerts, config.VPNKeySize
+	RSAKeyPath = filepath.Join(tmp, "keys")
+	CACertPath = filepath.Join(RSAKeyPath, "ca.crt")
+	CAKeyPath = filepath.Join(RSAKeyPath, "ca.key")
+	Artifacts = storage.NewLocal(filepath.Join(tmp, "storage"))
+	vpnCerts = &memVPNCertRegistry{}
+	config.VPNKeySize = 1024
+	restore := func() {
+		RSAKeyPath, CACertPath, CAKeyPath = oldRSAKeyPath, oldCACertPath, oldCAKeyPath
+		Artifacts, vpnCerts, config.VPNKeySize = oldArtifacts, oldVPNCerts, oldKeySize
+		os.RemoveAll(tmp)
+	}
+	if err = os.MkdirAll(RSAKeyPath, 0700); err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(CACertPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(CAKeyPath, pem.EncodeToMemory(&pem.Block{
+			Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	}
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	ca, err := vpnca.Load(CACertPath, CAKeyPath)
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	return ca, restore
+}
+
+// storedVPNSerial returns the serial number of the stored client certificate of the AS
+func storedVPNSerial(t *testing.T, asInfo *SCIONLabASInfo) *big.Int {
+	raw, err := storage.ReadAll(Artifacts,
+		vpnCertKey(asInfo.LocalAS.UserEmail, asInfo.LocalAS.ASID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := vpnca.ParseCertificatePEM(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.SerialNumber
+}
+
+// revokedSerials fetches the CRL, checks its signature and returns the revoked serial numbers
+func revokedSerials(t *testing.T, ca *vpnca.CA) map[string]bool {
+	raw, err := vpnCRL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ca.Cert.CheckCRLSignature(crl); err != nil {
+		t.Fatalf("invalid CRL signature: %v", err)
+	}
+	if !crl.TBSCertList.NextUpdate.After(time.Now()) {
+		t.Errorf("the CRL expires at %v", crl.TBSCertList.NextUpdate)
+	}
+	serials := make(map[string]bool)
+	for _, rc := range crl.TBSCertList.RevokedCertificates {
+		serials[vpnca.SerialString(rc.SerialNumber)] = true
+	}
+	return serials
+}
+
+func TestVPNCertRevokedOnRemoval(t *testing.T) {
+	ca, restore := prepareVPNCA(t)
+	defer restore()
+	asInfo := &SCIONLabASInfo{LocalAS: &models.SCIONLabAS{UserEmail: "user@example.com",
+		ISD: 1, ASID: 0xffaa00010001}}
+	if err := generateVPNKeys(context.Background(), asInfo); err != nil {
+		t.Fatal(err)
+	}
+	serial := vpnca.SerialString(storedVPNSerial(t, asInfo))
+	if revoked := revokedSerials(t, ca); len(revoked) != 0 {
+		t.Errorf("the CRL lists %v before the removal", revoked)
+	}
+	// a certificate issued by easy-rsa for another AS, which is not recorded
+	other := &models.SCIONLabAS{UserEmail: "user@example.com", ISD: 1, ASID: 0xffaa00010002}
+	issued, err := ca.Issue(vpnUserID(other.UserEmail, other.ASID), time.Hour, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(vpnCertPath(other.UserEmail, other.ASID), issued.CertPEM, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cleanVPNKeys(context.Background(), asInfo.LocalAS.UserEmail, asInfo.LocalAS.ASID); err != nil {
+		t.Fatal(err)
+	}
+	if err = cleanVPNKeys(context.Background(), other.UserEmail, other.ASID); err != nil {
+		t.Fatal(err)
+	}
+	revoked := revokedSerials(t, ca)
+	if !revoked[serial] || len(revoked) != 2 {
+		t.Errorf("the CRL lists %v, expected %v and the certificate issued by easy-rsa",
+			revoked, serial)
+	}
+	stored, err := storage.Exists(Artifacts,
+		vpnCertKey(asInfo.LocalAS.UserEmail, asInfo.LocalAS.ASID))
+	if err != nil || stored {
+		t.Errorf("the VPN certificate is still stored after the removal (%v)", err)
+	}
+	if _, err = os.Stat(vpnCertPath(other.UserEmail, other.ASID)); !os.IsNotExist(err) {
+		t.Errorf("the certificate issued by easy-rsa still exists (%v)", err)
+	}
+}
+
+func TestVPNCertRevokedOnRegeneration(t *testing.T) {
+	ca, restore := prepareVPNCA(t)
+	defer restore()
+	asInfo := &SCIONLabASInfo{IsVPN: true, VPNServerIP: "1.2.3.4", VPNServerPort: 1194,
+		LocalAS: &models.SCIONLabAS{UserEmail: "user@example.com", ISD: 1,
+			ASID: 0xffaa00010001}}
+	defer preparePackageDir(t, asInfo)()
+	// the client.conf template is looked up relative to the repository root
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err = generateVPNConfig(context.Background(), asInfo); err != nil {
+		t.Fatal(err)
+	}
+	oldSerial := storedVPNSerial(t, asInfo)
+	// keys are reused as long as they are valid
+	if err = generateVPNConfig(context.Background(), asInfo); err != nil {
+		t.Fatal(err)
+	}
+	if serial := storedVPNSerial(t, asInfo); serial.Cmp(oldSerial) != 0 {
+		t.Fatalf("the VPN certificate was reissued although it is valid")
+	}
+	if revoked := revokedSerials(t, ca); len(revoked) != 0 {
+		t.Errorf("the CRL lists %v although no certificate was regenerated", revoked)
+	}
+	// a stored certificate without PEM block makes the keys regenerate; the replaced
+	// certificate is revoked through its record
+	err = storage.PutBytes(Artifacts, vpnCertKey(asInfo.LocalAS.UserEmail, asInfo.LocalAS.ASID),
+		[]byte("corrupted\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = generateVPNConfig(context.Background(), asInfo); err != nil {
+		t.Fatal(err)
+	}
+	newSerial := storedVPNSerial(t, asInfo)
+	if newSerial.Cmp(oldSerial) == 0 {
+		t.Fatal("the VPN certificate was not regenerated")
+	}
+	revoked := revokedSerials(t, ca)
+	if !revoked[vpnca.SerialString(oldSerial)] {
+		t.Errorf("the CRL does not list the replaced certificate %v",
+			vpnca.SerialString(oldSerial))
+	}
+	if revoked[vpnca.SerialString(newSerial)] {
+		t.Errorf("the CRL lists the new certificate %v", vpnca.SerialString(newSerial))
+	}
+}
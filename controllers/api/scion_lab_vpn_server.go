@@ -0,0 +1,194 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/utility"
+	"github.com/netsec-ethz/scion-coord/vpnca"
+)
+
+// vpnServerConfig is the complete server-side VPN configuration of an AP
+type vpnServerConfig struct {
+	Version        string // changes whenever any other field or the CRL's next update changes
+	VPNType        string
+	Subnet         string
+	CCD            []ccdEntry // OpenVPN client-config-dir entries, sorted by VPNUserID
+	WireGuardPeers []WireGuardPeer
+	CA             string // PEM bundle of the VPN CA
+	CRL            string // PEM CRL of the VPN CA, valid for config.VPNCRLValidity days
+}
+
+// ccdEntry is the client-config-dir file of an OpenVPN client
+type ccdEntry struct {
+	VPNUserID string // file name, the common name of the client certificate
+	IP        string
+	Config    string // file content
+}
+
+// newVPNServerConfig returns the VPN server configuration of the AP with the VPN address prefix
+// and address apIP serving the connections, without CRL. The version is computed from the
+// configuration, the revoked certificates and the next update of the CRL, so that it only
+// changes with the content or when the AP has to fetch a new CRL.
+func newVPNServerConfig(vpnType string, conns []APConnectionInfo, prefix *net.IPNet, apIP string,
+	caBundle []byte, revoked []pkix.RevokedCertificate, crlNextUpdate time.Time) vpnServerConfig {
+	cfg := vpnServerConfig{
+		VPNType:        vpnType,
+		Subnet:         prefix.String(),
+		CCD:            []ccdEntry{},
+		WireGuardPeers: wireGuardPeers(conns),
+		CA:             string(caBundle),
+	}
+	for _, c := range conns {
+		if c.VPNType != models.OpenVPN {
+			continue
+		}
+		cfg.CCD = append(cfg.CCD, ccdEntry{
+			VPNUserID: c.VPNUserID,
+			IP:        c.UserIP,
+			Config:    ccdConfig(c.UserIP, prefix, apIP),
+		})
+	}
+	sort.Slice(cfg.CCD, func(i, j int) bool { return cfg.CCD[i].VPNUserID < cfg.CCD[j].VPNUserID })
+
+	h := sha256.New()
+	json.NewEncoder(h).Encode(cfg)
+	for _, rc := range revoked {
+		fmt.Fprintf(h, "%x %d\n", rc.SerialNumber, rc.RevocationTime.Unix())
+	}
+	fmt.Fprintf(h, "%d\n", crlNextUpdate.Unix())
+	cfg.Version = hex.EncodeToString(h.Sum(nil))[:16]
+	return cfg
+}
+
+// vpnCRLUpdate returns the issue time of the CRL served with the VPN server configuration at the
+// time now. CRLs are reissued every half of their validity, so that an AP polling with the
+// version of its configuration receives a new CRL well before the previous one expires.
+func vpnCRLUpdate(now time.Time, validity time.Duration) time.Time {
+	return now.Truncate(validity / 2)
+}
+
+// ccdConfig returns the client-config-dir directives assigning the address to the client
+func ccdConfig(ip string, prefix *net.IPNet, apIP string) string {
+	if utility.IsIPv6(ip) {
+		ones, _ := prefix.Mask.Size()
+		return fmt.Sprintf("ifconfig-ipv6-push %s/%d %s\n", ip, ones, apIP)
+	}
+	return fmt.Sprintf("ifconfig-push %s %s\n", ip, net.IP(prefix.Mask))
+}
+
+// API end-point for the APs to fetch the complete configuration of their VPN server: the
+// client-config-dir entries of the OpenVPN clients, the WireGuard peers, the CA bundle and the
+// current CRL. If the version of the configuration the AP has is passed, 304 Not Modified is
+// returned as long as it is current. The CRL is reissued every half of vpn.crl_validity days,
+// which changes the version.
+// Example:
+// GET /api/as/getVPNServerConfig/<account_id>/<secret>?scionLabAP=1-ffaa:0:1107&version=<version>
+// returns
+// {
+//     "Version": "5c0b7a8d3e2f1a90",
+//     "VPNType": "openvpn",
+//     "Subnet": "10.0.8.0/24",
+//     "CCD": [
+//         {
+//             "VPNUserID": "user@example.com_ffaa_1_14",
+//             "IP": "10.0.8.42",
+//             "Config": "ifconfig-push 10.0.8.42 255.255.255.0\n"
+//         }
+//     ],
+//     "WireGuardPeers": [],
+//     "CA": "-----BEGIN CERTIFICATE-----\n...",
+//     "CRL": "-----BEGIN X509 CRL-----\n..."
+// }
+func (s *SCIONLabASController) GetVPNServerConfig(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	log.Infof("API Call for getVPNServerConfig = %v", r.URL.Query())
+	apIA, err := checkAuthorization(r, r.URL.Query().Get("scionLabAP"))
+	if err != nil {
+		s.Forbidden(w, err, "The account is not authorized for this AP")
+		return
+	}
+	ap, err := models.FindSCIONLabASByIAInt(apIA.I, apIA.A)
+	if err != nil || ap.AP == nil {
+		log.Errorf("Error looking up the AP %v: %v", apIA, err)
+		s.NotFound(w, err, "AttachmentPoint not found")
+		return
+	}
+	if !ap.AP.HasVPN {
+		s.NotFound(w, errors.New("no VPN server"), "The AttachmentPoint has no VPN server")
+		return
+	}
+	conns, err := apConnections(ap, math.MaxInt64)
+	if err != nil {
+		log.Errorf("Error looking up connections for AS %v: %v", apIA, err)
+		s.Error500(w, err, "Error looking up SCIONLab ASes from DB")
+		return
+	}
+	pool, err := ap.AP.VPNPool()
+	if err != nil {
+		log.Errorf("Error loading the VPN pool of AP %v: %v", apIA, err)
+		s.Error500(w, err, "Error loading the VPN pool of the AttachmentPoint")
+		return
+	}
+	caBundle, err := ioutil.ReadFile(CACertPath)
+	if err != nil {
+		log.Errorf("Error reading the VPN CA certificate: %v", err)
+		s.Error500(w, err, "Error reading the VPN CA certificate")
+		return
+	}
+	ca, err := vpnca.Load(CACertPath, CAKeyPath)
+	if err != nil {
+		log.Errorf("Error loading the VPN CA: %v", err)
+		s.Error500(w, err, "Error loading the VPN CA")
+		return
+	}
+	revoked, err := revokedVPNCerts(r.Context(), ca)
+	if err != nil {
+		log.Errorf("Error looking up the revoked VPN certificates: %v", err)
+		s.Error500(w, err, "Error looking up the revoked VPN certificates")
+		return
+	}
+	validity := time.Duration(config.VPNCRLValidity) * 24 * time.Hour
+	crlUpdate := vpnCRLUpdate(time.Now(), validity)
+	cfg := newVPNServerConfig(models.VPNTypeOrDefault(ap.AP.VPNType), conns, pool.Prefix(),
+		ap.AP.VPNIP, caBundle, revoked, crlUpdate.Add(validity))
+	if cfg.Version == r.URL.Query().Get("version") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	crl, err := ca.CRLAt(revoked, crlUpdate, crlUpdate.Add(validity))
+	if err != nil {
+		log.Errorf("Error generating the VPN CRL for AP %v: %v", apIA, err)
+		s.Error500(w, err, "Error generating the VPN CRL")
+		return
+	}
+	cfg.CRL = string(crl)
+	s.JSON(cfg, w, r)
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/models"
+)
+
+func TestNewVPNServerConfig(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("10.0.8.0/24")
+	conns := []APConnectionInfo{
+		{ASID: "1-ffaa:1:2", IsVPN: true, VPNType: models.OpenVPN,
+			VPNUserID: "user@example.com_ffaa_1_2", UserIP: "10.0.8.3"},
+		{ASID: "1-ffaa:1:1", IsVPN: true, VPNType: models.OpenVPN,
+			VPNUserID: "user@example.com_ffaa_1_1", UserIP: "10.0.8.2"},
+		{ASID: "1-ffaa:1:3", UserIP: "192.0.2.1"},
+		{ASID: "1-ffaa:1:4", IsVPN: true, VPNType: models.WireGuard, UserIP: "10.0.8.4",
+			WGPublicKey: testAPPublicKey},
+	}
+	ca := []byte("-----BEGIN CERTIFICATE-----\n")
+	next := time.Date(2018, 6, 8, 0, 0, 0, 0, time.UTC)
+	cfg := newVPNServerConfig(models.OpenVPN, conns, prefix, "10.0.8.1", ca, nil, next)
+	expected := []ccdEntry{
+		{VPNUserID: "user@example.com_ffaa_1_1", IP: "10.0.8.2",
+			Config: "ifconfig-push 10.0.8.2 255.255.255.0\n"},
+		{VPNUserID: "user@example.com_ffaa_1_2", IP: "10.0.8.3",
+			Config: "ifconfig-push 10.0.8.3 255.255.255.0\n"},
+	}
+	if !reflect.DeepEqual(cfg.CCD, expected) {
+		t.Errorf("wrong client-config-dir entries %v, expected %v", cfg.CCD, expected)
+	}
+	if len(cfg.WireGuardPeers) != 1 || cfg.Subnet != "10.0.8.0/24" || cfg.CA != string(ca) {
+		t.Errorf("wrong configuration %+v", cfg)
+	}
+
+	// the version only depends on the content
+	reordered := []APConnectionInfo{conns[1], conns[0], conns[2], conns[3]}
+	if other := newVPNServerConfig(models.OpenVPN, reordered, prefix, "10.0.8.1", ca,
+		nil, next); other.Version != cfg.Version {
+		t.Error("the version changed although the configuration did not")
+	}
+	conns[0].UserIP = "10.0.8.5"
+	if other := newVPNServerConfig(models.OpenVPN, conns, prefix, "10.0.8.1", ca,
+		nil, next); other.Version == cfg.Version {
+		t.Error("the version did not change with the client addresses")
+	}
+	revoked := []pkix.RevokedCertificate{{SerialNumber: big.NewInt(42),
+		RevocationTime: time.Now()}}
+	if other := newVPNServerConfig(models.OpenVPN, reordered, prefix, "10.0.8.1", ca,
+		revoked, next); other.Version == cfg.Version {
+		t.Error("the version did not change with the revoked certificates")
+	}
+	if other := newVPNServerConfig(models.OpenVPN, reordered, prefix, "10.0.8.1", ca,
+		nil, next.Add(time.Hour)); other.Version == cfg.Version {
+		t.Error("the version did not change with the next update of the CRL")
+	}
+}
+
+func TestVPNCRLUpdate(t *testing.T) {
+	validity := 7 * 24 * time.Hour
+	start := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	update := vpnCRLUpdate(start, validity)
+	if update.After(start) || start.Sub(update) >= validity/2 {
+		t.Errorf("wrong CRL update %v at %v", update, start)
+	}
+	// the CRL stays the same for a while, but is reissued before half of its validity has passed
+	if other := vpnCRLUpdate(start.Add(time.Hour), validity); !other.Equal(update) {
+		t.Errorf("the CRL update changed from %v to %v within an hour", update, other)
+	}
+	if other := vpnCRLUpdate(update.Add(validity/2), validity); !other.After(update) {
+		t.Error("the CRL is not reissued after half of its validity")
+	}
+}
+
+func TestCCDConfigIPv6(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("fd00:8::/64")
+	if c := ccdConfig("fd00:8::2", prefix, "fd00:8::1"); c !=
+		"ifconfig-ipv6-push fd00:8::2/64 fd00:8::1\n" {
+		t.Errorf("wrong client-config-dir entry %q", c)
+	}
+}
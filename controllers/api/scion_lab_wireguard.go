@@ -0,0 +1,127 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/netsec-ethz/scion-coord/utility"
+	"github.com/netsec-ethz/scion-coord/wireguard"
+	"github.com/scionproto/scion/go/lib/addr"
+)
+
+// generateWireGuardConfig adds the WireGuard configuration wg0.conf to the package of the AS.
+// The key pair of the AS is reused if it exists; its public key is set in asInfo.
+func generateWireGuardConfig(ctx context.Context, asInfo *SCIONLabASInfo) error {
+	if asInfo.VPNServerKey == "" {
+		return errors.New("the AttachmentPoint has no WireGuard public key configured")
+	}
+	private, public, err := wireGuardKeys(ctx, asInfo.LocalAS.UserEmail, asInfo.LocalAS.ASID)
+	if err != nil {
+		return err
+	}
+	wgConfig := map[string]string{
+		"PrivateKey":      private.String(),
+		"Address":         hostPrefix(asInfo.IP),
+		"ServerPublicKey": asInfo.VPNServerKey,
+		"Endpoint": net.JoinHostPort(asInfo.VPNServerIP,
+			strconv.Itoa(int(asInfo.VPNServerPort))),
+		"AllowedIPs": hostPrefix(asInfo.RemoteIP),
+	}
+	err = utility.FillTemplateAndSave(filepath.Join(templatesPath, "wg0.conf.tmpl"), wgConfig,
+		filepath.Join(asInfo.UserPackagePath(), "wg0.conf"))
+	if err != nil {
+		return err
+	}
+	asInfo.WGPublicKey = public.String()
+	return nil
+}
+
+// hostPrefix returns the prefix only containing the IPv4 or IPv6 address
+func hostPrefix(ip string) string {
+	if utility.IsIPv6(ip) {
+		return ip + "/128"
+	}
+	return ip + "/32"
+}
+
+// wireGuardKeys returns the stored WireGuard key pair of the AS, generating and storing it if
+// there is none
+func wireGuardKeys(ctx context.Context, userEmail string,
+	asID addr.AS) (wireguard.Key, wireguard.Key, error) {
+	log := logger.FromContext(ctx)
+	raw, err := storage.ReadAll(Artifacts, wireGuardPrivateKeyKey(userEmail, asID))
+	if err == nil {
+		rawPublic, err := storage.ReadAll(Artifacts, wireGuardPublicKeyKey(userEmail, asID))
+		if err != nil {
+			return wireguard.Key{}, wireguard.Key{}, err
+		}
+		private, err := wireguard.ParseKey(strings.TrimSpace(string(raw)))
+		if err != nil {
+			return wireguard.Key{}, wireguard.Key{}, err
+		}
+		public, err := wireguard.ParseKey(strings.TrimSpace(string(rawPublic)))
+		return private, public, err
+	}
+	if err != storage.ErrNotExist {
+		return wireguard.Key{}, wireguard.Key{}, err
+	}
+	log.Infof("Generating WireGuard keys for %s %s", userEmail, asID)
+	private, public, err := wireguard.GenerateKeyPair()
+	if err != nil {
+		return wireguard.Key{}, wireguard.Key{}, err
+	}
+	// the public key is stored first, so a stored private key always has its public key
+	if err = storage.PutBytes(Artifacts, wireGuardPublicKeyKey(userEmail, asID),
+		[]byte(public.String()+"\n")); err != nil {
+		return wireguard.Key{}, wireguard.Key{}, fmt.Errorf(
+			"error storing WireGuard public key for user %v: %v", userEmail, err)
+	}
+	if err = storage.PutBytes(Artifacts, wireGuardPrivateKeyKey(userEmail, asID),
+		[]byte(private.String()+"\n")); err != nil {
+		return wireguard.Key{}, wireguard.Key{}, fmt.Errorf(
+			"error storing WireGuard key for user %v: %v", userEmail, err)
+	}
+	return private, public, nil
+}
+
+// cleanWireGuardKeys removes the stored WireGuard key pair of the AS
+func cleanWireGuardKeys(userEmail string, asID addr.AS) error {
+	for _, k := range []string{wireGuardPrivateKeyKey(userEmail, asID),
+		wireGuardPublicKeyKey(userEmail, asID)} {
+		if err := Artifacts.Delete(k); err != nil {
+			return fmt.Errorf("could not remove %s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// Key of the stored WireGuard private key of the AS
+func wireGuardPrivateKeyKey(userEmail string, asID addr.AS) string {
+	return storage.Join("wireguard", vpnUserID(userEmail, asID)+".key")
+}
+
+// Key of the stored WireGuard public key of the AS
+func wireGuardPublicKeyKey(userEmail string, asID addr.AS) string {
+	return storage.Join("wireguard", vpnUserID(userEmail, asID)+".pub")
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/netsec-ethz/scion-coord/wireguard"
+)
+
+const testAPPublicKey = "RwHQhIhFH1RaQJ+1iuPlhYHKQKw/fxFGmM1x3qxzygE="
+
+var privateKeyLine = regexp.MustCompile(`(?m)^PrivateKey = (.*)$`)
+
+func TestGenerateWireGuardConfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "wireguard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	oldArtifacts := Artifacts
+	Artifacts = storage.NewLocal(tmp)
+	defer func() { Artifacts = oldArtifacts }()
+	asInfo := &SCIONLabASInfo{
+		IsVPN:         true,
+		VPNType:       models.WireGuard,
+		IP:            "10.0.8.42",
+		RemoteIP:      "10.0.8.1",
+		VPNServerIP:   "2001:db8::1",
+		VPNServerPort: 51820,
+		VPNServerKey:  testAPPublicKey,
+		LocalAS: &models.SCIONLabAS{UserEmail: "user@example.com", ISD: 1,
+			ASID: 0xffaa00010001, Type: models.Dedicated},
+	}
+	defer preparePackageDir(t, asInfo)()
+
+	if err = generateVPNConfig(context.Background(), asInfo); err != nil {
+		t.Fatal(err)
+	}
+	conf := readPackageFile(t, asInfo, "wg0.conf")
+	for _, line := range []string{"Address = 10.0.8.42/32", "PublicKey = " + testAPPublicKey,
+		"Endpoint = [2001:db8::1]:51820", "AllowedIPs = 10.0.8.1/32"} {
+		if !strings.Contains(conf, line+"\n") {
+			t.Errorf("wg0.conf does not contain %q:\n%s", line, conf)
+		}
+	}
+	m := privateKeyLine.FindStringSubmatch(conf)
+	if m == nil {
+		t.Fatalf("wg0.conf does not contain the private key:\n%s", conf)
+	}
+	if _, err = wireguard.ParseKey(m[1]); err != nil {
+		t.Error(err)
+	}
+	if _, err = wireguard.ParseKey(asInfo.WGPublicKey); err != nil {
+		t.Errorf("invalid public key of the AS: %v", err)
+	}
+	if redactedConf, ok := redactSecrets("wg0.conf", []byte(conf)); !ok ||
+		strings.Contains(string(redactedConf), m[1]) {
+		t.Errorf("the private key is not redacted:\n%s", redactedConf)
+	}
+
+	// the keys are kept when the configuration is generated again
+	publicKey := asInfo.WGPublicKey
+	if err = generateVPNConfig(context.Background(), asInfo); err != nil {
+		t.Fatal(err)
+	}
+	if asInfo.WGPublicKey != publicKey ||
+		!strings.Contains(readPackageFile(t, asInfo, "wg0.conf"), m[0]) {
+		t.Error("the WireGuard keys changed although they are stored")
+	}
+	// and replaced after the AS was removed
+	if err = cleanWireGuardKeys(asInfo.LocalAS.UserEmail, asInfo.LocalAS.ASID); err != nil {
+		t.Fatal(err)
+	}
+	if err = generateVPNConfig(context.Background(), asInfo); err != nil {
+		t.Fatal(err)
+	}
+	if asInfo.WGPublicKey == publicKey {
+		t.Error("the WireGuard keys were not replaced after they were removed")
+	}
+
+	f, err := GetPackageFormat(DockerComposeFormat, models.Dedicated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.AddFiles(asInfo); err != nil {
+		t.Fatal(err)
+	}
+	compose := readPackageFile(t, asInfo, "docker-compose.yml")
+	if !strings.Contains(compose, "./wg0.conf:") || strings.Contains(compose, "client.conf") {
+		t.Errorf("docker-compose.yml does not mount only wg0.conf:\n%s", compose)
+	}
+
+	asInfo.VPNServerKey = ""
+	if err = generateVPNConfig(context.Background(), asInfo); err == nil {
+		t.Error("generating the configuration without the key of the AP should fail")
+	}
+}
+
+func TestWireGuardPeers(t *testing.T) {
+	conns := []APConnectionInfo{
+		{ASID: "1-ffaa:1:1", IsVPN: true, VPNType: models.OpenVPN, UserIP: "10.0.8.2"},
+		{ASID: "1-ffaa:1:2", IsVPN: true, VPNType: models.WireGuard, UserIP: "10.0.8.3",
+			WGPublicKey: testAPPublicKey},
+		{ASID: "1-ffaa:1:3", UserIP: "192.0.2.1"},
+		{ASID: "1-ffaa:1:4", IsVPN: true, VPNType: models.WireGuard, UserIP: "fd00::4",
+			WGPublicKey: testAPPublicKey},
+	}
+	expected := []WireGuardPeer{
+		{ASID: "1-ffaa:1:2", PublicKey: testAPPublicKey, AllowedIPs: "10.0.8.3/32"},
+		{ASID: "1-ffaa:1:4", PublicKey: testAPPublicKey, AllowedIPs: "fd00::4/128"},
+	}
+	if peers := wireGuardPeers(conns); !reflect.DeepEqual(peers, expected) {
+		t.Errorf("wireGuardPeers returned %v, expected %v", peers, expected)
+	}
+}
+
+func TestAPConnectionInfoEqualsVPNType(t *testing.T) {
+	inDB := APConnectionInfo{ASID: "1-ffaa:1:1", IsVPN: true, VPNType: models.OpenVPN,
+		UserIP: "10.0.8.2"}
+	// APs not knowing about WireGuard do not report the VPN type
+	reported := inDB
+	reported.VPNType = ""
+	if !reported.equals(inDB) {
+		t.Error("an OpenVPN connection reported without VPN type should be equal")
+	}
+	reported.VPNType = models.WireGuard
+	if reported.equals(inDB) {
+		t.Error("connections with different VPN types should differ")
+	}
+}
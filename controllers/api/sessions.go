@@ -0,0 +1,165 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+)
+
+// sessionInfo describes a session of a user on the account and admin pages
+type sessionInfo struct {
+	ID        uint64
+	Created   time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+	Current   bool // the session of the request
+}
+
+// sessionInfos returns the active sessions of the user; the session with the key current is
+// marked
+func sessionInfos(email, current string) ([]sessionInfo, error) {
+	sessions, err := models.FindWebSessionsByUserEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	infos := []sessionInfo{}
+	for _, s := range sessions {
+		infos = append(infos, sessionInfo{
+			ID:        s.ID,
+			Created:   s.Created,
+			LastSeen:  s.LastSeen,
+			IP:        s.IP,
+			UserAgent: s.UserAgent,
+			Current:   s.Key == current,
+		})
+	}
+	return infos, nil
+}
+
+// Sessions lists the active sessions of the logged-in user
+func (c *UserController) Sessions(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	session, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	infos, err := sessionInfos(userSession.Email, session.ID)
+	if err != nil {
+		log.Errorf("Error looking up the sessions of %v: %v", userSession.Email, err)
+		c.Error500(w, err, "Error looking up the sessions")
+		return
+	}
+	c.JSON(infos, w, r)
+}
+
+// RevokeSession logs the logged-in user out of one of their sessions
+func (c *UserController) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		c.BadRequest(w, err, "Invalid session ID")
+		return
+	}
+	s, err := models.FindWebSessionByIDAndUserEmail(id, userSession.Email)
+	if err != nil {
+		c.NotFound(w, err, "Session not found")
+		return
+	}
+	if err = s.Delete(); err != nil {
+		log.Errorf("Error revoking session %v of %v: %v", id, userSession.Email, err)
+		c.Error500(w, err, "Error revoking the session")
+		return
+	}
+	log.Infof("Revoked session %v of %v", id, userSession.Email)
+	c.JSON(struct{}{}, w, r)
+}
+
+// LogoutEverywhere logs the logged-in user out of all their sessions, including the current one
+func (c *UserController) LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	session, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	n, err := models.DeleteWebSessionsByUserEmail(userSession.Email, "")
+	if err != nil {
+		log.Errorf("Error revoking the sessions of %v: %v", userSession.Email, err)
+		c.Error500(w, err, "Error revoking the sessions")
+		return
+	}
+	log.Infof("Revoked %v sessions of %v", n, userSession.Email)
+
+	// expire the cookie of the current session
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		c.Error500(w, err, "Error: Session expired")
+		return
+	}
+	c.JSON(struct{}{}, w, r)
+}
+
+// UserSessions lists the active sessions of the user with the email address in the URL
+func (c AdminController) UserSessions(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	email := mux.Vars(r)["email"]
+	if _, err := models.FindUserByEmail(email); err != nil {
+		c.NotFound(w, err, "User not found")
+		return
+	}
+	infos, err := sessionInfos(email, "")
+	if err != nil {
+		log.Errorf("Error looking up the sessions of %v: %v", email, err)
+		c.Error500(w, err, "Error looking up the sessions")
+		return
+	}
+	c.JSON(infos, w, r)
+}
+
+// RevokeUserSessions logs the user with the email address in the URL out of all sessions, e.g.
+// if the account is compromised
+func (c AdminController) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	email := mux.Vars(r)["email"]
+	if _, err := models.FindUserByEmail(email); err != nil {
+		c.NotFound(w, err, "User not found")
+		return
+	}
+	n, err := models.DeleteWebSessionsByUserEmail(email, "")
+	if err != nil {
+		log.Errorf("Error revoking the sessions of %v: %v", email, err)
+		c.Error500(w, err, "Error revoking the sessions")
+		return
+	}
+	log.Infof("Admin revoked %v sessions of %v", n, email)
+	c.JSON(struct{}{}, w, r)
+}
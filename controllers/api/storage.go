@@ -0,0 +1,49 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/scionproto/scion/go/lib/addr"
+)
+
+// Artifacts keeps the package tarballs, the configuration versions, the certificate caches and
+// the VPN keys. The package directories themselves are only built locally below PackagePath.
+// The keys are chosen such that the local storage keeps the layout of PackagePath.
+var Artifacts = newArtifactStorage()
+
+func newArtifactStorage() storage.Storage {
+	switch config.StorageType {
+	case "local":
+		return storage.NewLocal(PackagePath)
+	case "s3":
+		return storage.NewS3(config.StorageS3Endpoint, config.StorageS3Region,
+			config.StorageS3Bucket, config.StorageS3AccessKey, config.StorageS3SecretKey)
+	}
+	logger.Fatalf("Unknown storage type \"%v\"", config.StorageType)
+	return nil
+}
+
+// packageKey returns the key of the package tarball of the AS
+func packageKey(email string, isd addr.ISD, as addr.AS) string {
+	return storage.Join(UserPackageName(email, isd, as) + ".tar.gz")
+}
+
+// boxPackageKey returns the key of the tarball of the gen folder served to the SCION box
+func boxPackageKey(email string) string {
+	return storage.Join("SCIONBox", email+".tar.gz")
+}
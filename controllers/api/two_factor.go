@@ -0,0 +1,223 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/totp"
+)
+
+// issuer shown by the authenticator apps
+const totpIssuer = "SCIONLab"
+
+type twoFactorStatus struct {
+	Enabled           bool
+	Required          bool // the user is an admin and two-factor authentication is enforced
+	RecoveryCodesLeft int
+}
+
+type twoFactorEnrolment struct {
+	Secret string // base32 secret to enter manually
+	URI    string // otpauth URI shown as QR code
+}
+
+type twoFactorRequest struct {
+	Code     string
+	Password string // only needed to disable two-factor authentication
+}
+
+type recoveryCodes struct {
+	RecoveryCodes []string
+}
+
+// decodeTwoFactorRequest returns the session of the logged-in user and the decoded body of POST
+// requests to the two-factor end-points. On errors, the response is written and false returned.
+func (c *UserController) decodeTwoFactorRequest(w http.ResponseWriter, r *http.Request) (
+	*models.Session, twoFactorRequest, bool) {
+	log := logger.FromContext(r.Context())
+	var req twoFactorRequest
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return nil, req, false
+	}
+	if r.Method == http.MethodPost {
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			c.BadRequest(w, err, "Error decoding JSON")
+			return nil, req, false
+		}
+	}
+	return userSession, req, true
+}
+
+// TwoFactorStatus returns whether the logged-in user has enabled two-factor authentication
+func (c *UserController) TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	userSession, _, ok := c.decodeTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	u, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		c.Error500(w, err, "Error looking up the user")
+		return
+	}
+	c.JSON(twoFactorStatus{
+		Enabled:           u.TOTPEnabled,
+		Required:          u.TwoFactorRequired(),
+		RecoveryCodesLeft: u.RecoveryCodesLeft(),
+	}, w, r)
+}
+
+// EnrolTwoFactor generates a new TOTP secret for the logged-in user, which has to be confirmed
+// with EnableTwoFactor
+func (c *UserController) EnrolTwoFactor(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	userSession, _, ok := c.decodeTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	u, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		c.Error500(w, err, "Error looking up the user")
+		return
+	}
+	secret, err := u.BeginTOTPEnrolment()
+	if err == models.ErrTOTPEnabled {
+		c.BadRequest(w, err, err.Error())
+		return
+	}
+	if err != nil {
+		log.Errorf("Error starting the two-factor enrolment of %v: %v", u.Email, err)
+		c.Error500(w, err, "Error starting the two-factor enrolment")
+		return
+	}
+	c.JSON(twoFactorEnrolment{
+		Secret: secret,
+		URI:    totp.URI(secret, totpIssuer, u.Email),
+	}, w, r)
+}
+
+// EnableTwoFactor enables two-factor authentication for the logged-in user with a code from the
+// enrolled secret and returns the recovery codes. Admins obtain their privileges immediately.
+func (c *UserController) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	session, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	var req twoFactorRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	u, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		c.Error500(w, err, "Error looking up the user")
+		return
+	}
+	codes, err := u.EnableTOTP(req.Code, time.Now())
+	switch err {
+	case nil:
+	case models.ErrTOTPEnabled, models.ErrTOTPNotEnrolled, models.ErrInvalidTOTPCode:
+		c.BadRequest(w, err, err.Error())
+		return
+	default:
+		log.Errorf("Error enabling two-factor authentication for %v: %v", u.Email, err)
+		c.Error500(w, err, "Error enabling two-factor authentication")
+		return
+	}
+	log.Infof("Two-factor authentication enabled for %v", u.Email)
+
+	userSession.IsAdmin = u.HasAdminPrivileges()
+	session.Values[middleware.ScionSessionName] = userSession
+	if err := session.Save(r, w); err != nil {
+		log.Errorf("Error while saving the session: %v", err)
+		c.Error500(w, err, "Error while saving the session")
+		return
+	}
+	c.JSON(recoveryCodes{codes}, w, r)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged-in user after checking a
+// TOTP code
+func (c *UserController) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	userSession, req, ok := c.decodeTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	u, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		c.Error500(w, err, "Error looking up the user")
+		return
+	}
+	if err := u.CheckSecondFactor(req.Code, time.Now()); err != nil {
+		c.Forbidden(w, err, "Invalid authentication code")
+		return
+	}
+	codes, err := u.RegenerateRecoveryCodes()
+	if err != nil {
+		log.Errorf("Error generating recovery codes for %v: %v", u.Email, err)
+		c.Error500(w, err, "Error generating the recovery codes")
+		return
+	}
+	c.JSON(recoveryCodes{codes}, w, r)
+}
+
+// DisableTwoFactor disables two-factor authentication for the logged-in user after checking the
+// password and a code. Admins cannot disable it while it is enforced.
+func (c *UserController) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	userSession, req, ok := c.decodeTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	u, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		c.Error500(w, err, "Error looking up the user")
+		return
+	}
+	if u.TwoFactorRequired() {
+		c.Forbidden(w, errors.New("two-factor authentication is required"),
+			"Two-factor authentication is required for admins")
+		return
+	}
+	if err := u.CheckPassword(req.Password); err != nil {
+		c.Forbidden(w, err, "Incorrect password")
+		return
+	}
+	if err := u.CheckSecondFactor(req.Code, time.Now()); err != nil {
+		c.Forbidden(w, err, "Invalid authentication code")
+		return
+	}
+	if err := u.DisableTOTP(); err != nil {
+		log.Errorf("Error disabling two-factor authentication for %v: %v", u.Email, err)
+		c.Error500(w, err, "Error disabling two-factor authentication")
+		return
+	}
+	log.Infof("Two-factor authentication disabled for %v", u.Email)
+	c.JSON(struct{}{}, w, r)
+}
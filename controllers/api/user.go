@@ -17,12 +17,12 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/netsec-ethz/scion-coord/config"
 	"github.com/netsec-ethz/scion-coord/controllers"
 	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
 	"github.com/netsec-ethz/scion-coord/utility"
 	"github.com/scionproto/scion/go/lib/addr"
@@ -44,14 +44,24 @@ type asInfo struct {
 	IsVPN     bool      // Is this a VPN-based setup
 	AP        string    // ISD-AS of the connected Attachment Point
 	Port      uint16    // Port of BR on the user's AS
+	MTU       uint16    // MTU inside the AS, 0 if default
+	LinkMTU   uint16    // MTU of the link to the AP, 0 if default
+	Bandwidth uint64    // Bandwidth of the link to the AP, 0 if default
+	SvcPort   uint16    // First port of the internal services, 0 if default
+	Package   string    // Package format of the configuration
+	Account   string    // Name of the account owning the AS
+	Role      string    // Role of the user in that account, see models.MemberOwner
 	ASText    string    // Text to be displayed by the frontend
 	Buttons   uiButtons // Buttons shown for this AS
 }
 
 type apInfo struct {
-	ISD    string
-	Label  string
-	HasVPN bool // Does this AP have a running VPN server
+	ISD          string
+	Label        string
+	HasVPN       bool   // Does this AP have a running VPN server
+	VPNType      string // Type of the VPN server, "openvpn" or "wireguard"
+	MaxMTU       uint16 // Largest link MTU accepted by this AP
+	MaxBandwidth uint64 // Largest link bandwidth accepted by this AP
 }
 
 type buttonConfiguration struct {
@@ -71,7 +81,8 @@ type uiButtons struct {
 
 type userPageData struct {
 	User        user
-	MaxASes     int // maximal number of ASes this user can have
+	MaxASes     int         // maximal number of ASes this user can have
+	ASQuota     asQuotaInfo // ASes of the user and where MaxASes comes from
 	APs         map[string]apInfo
 	ASInfos     []asInfo
 	GrafanaLink string
@@ -83,12 +94,16 @@ type passwordChangeRequest struct {
 	PasswordConfirmation string `json:"passwordConfirmation"`
 }
 
-// generates the structs containing information about the user's AS and the
+// generates the structs containing information about the ASes of the user's accounts and the
 // configuration of UI buttons
 func populateASStatusButtons(userEmail string) ([]asInfo, map[string]apInfo, error) {
 	asInfos := []asInfo{}
 	apInfos := map[string]apInfo{}
-	ases, err := models.FindSCIONLabASesByUserEmail(userEmail)
+	u, err := models.FindUserByEmail(userEmail)
+	if err != nil {
+		return asInfos, apInfos, err
+	}
+	ases, err := models.FindSCIONLabASesForUser(userEmail)
 	if err != nil {
 		return asInfos, apInfos, err
 	}
@@ -98,9 +113,12 @@ func populateASStatusButtons(userEmail string) ([]asInfo, map[string]apInfo, err
 	}
 	for _, ap := range aps {
 		apI := apInfo{
-			ISD:    fmt.Sprintf("ISD %v", ap.ISD),
-			Label:  ap.String(),
-			HasVPN: ap.AP.HasVPN,
+			ISD:          fmt.Sprintf("ISD %v", ap.ISD),
+			Label:        ap.String(),
+			HasVPN:       ap.AP.HasVPN,
+			VPNType:      models.VPNTypeOrDefault(ap.AP.VPNType),
+			MaxMTU:       ap.AP.LinkMTULimit(),
+			MaxBandwidth: ap.AP.BandwidthLimit(),
 		}
 		apInfos[ap.IAString()] = apI
 	}
@@ -136,6 +154,14 @@ func populateASStatusButtons(userEmail string) ([]asInfo, map[string]apInfo, err
 			IP:        as.PublicIP,
 			Type:      as.Type,
 			Port:      as.StartPort,
+			MTU:       as.MTU,
+			SvcPort:   as.ServicePort,
+			Package:   as.PackageFormat,
+			Account:   as.Account.Name,
+		}
+		asI.Role, err = u.MemberRole(as.Account)
+		if err != nil {
+			return asInfos, apInfos, err
 		}
 
 		cns, err := as.GetJoinConnectionInfo()
@@ -146,6 +172,8 @@ func populateASStatusButtons(userEmail string) ([]asInfo, map[string]apInfo, err
 		// TODO: Currently only one active connection allowed
 		if len(cns) > 0 {
 			asI.IsVPN = cns[0].IsVPN
+			asI.LinkMTU = cns[0].MTU
+			asI.Bandwidth = cns[0].Bandwidth
 			asI.AP = utility.IAStringStandard(cns[0].NeighborISD, cns[0].NeighborAS)
 		}
 
@@ -170,11 +198,14 @@ func populateASStatusButtons(userEmail string) ([]asInfo, map[string]apInfo, err
 			asI.ASText = "Your SCIONLab AS configuration is currently scheduled for removal."
 			buttons.Download.Disable = true
 		}
-		if asI.Type == models.Infrastructure {
+		if asI.Type == models.Infrastructure || asI.Role == models.MemberViewer {
 			buttons.Configure.Hide = true
 			buttons.Download.Hide = true
 			buttons.Disconnect.Hide = true
 		}
+		if asI.Role == models.MemberMaintainer {
+			buttons.Disconnect.Hide = true
+		}
 		asI.Buttons = buttons
 
 		asInfos = append(asInfos, asI)
@@ -203,10 +234,19 @@ func populateUserData(r *http.Request) (u user, a accountData, err error) {
 		Email:        storedUser.Email,
 		FirstName:    storedUser.FirstName,
 		LastName:     storedUser.LastName,
-		IsAdmin:      storedUser.IsAdmin,
+		IsAdmin:      userSession.IsAdmin, // requires two-factor authentication if configured
 		Account:      storedUser.Account.Name,
 		Organisation: storedUser.Account.Organisation,
 	}
+	u.TwoFactorEnrolmentRequired = storedUser.IsAdmin && !userSession.IsAdmin
+	if storedUser.EmailChangePending() {
+		u.PendingEmail = storedUser.NewEmail
+	}
+	grants, err := storedUser.Grants()
+	if err != nil {
+		return
+	}
+	u.Permissions = grants.Permissions()
 
 	a = accountData{
 		AccountID:     storedUser.Account.AccountID,
@@ -218,25 +258,34 @@ func populateUserData(r *http.Request) (u user, a accountData, err error) {
 
 // API function that generates all information necessary for displaying the user page
 func (c *UserController) UserInformation(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 
 	user, _, err := populateUserData(r)
 	if err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Forbidden(w, err, "Error authenticating user: Not logged in")
 		return
 	}
 
 	asInfo, aps, err := populateASStatusButtons(user.Email)
 	if err != nil {
-		log.Printf("Error when generating AS info and button configuration for user %v: %v",
+		log.Errorf("Error when generating AS info and button configuration for user %v: %v",
 			user.Email, err)
 		c.Forbidden(w, err, "Error when generating AS info and button configuration")
 		return
 	}
 
+	quota, err := userASQuota(user.Email)
+	if err != nil {
+		log.Errorf("Error looking up the AS quota of %v: %v", user.Email, err)
+		c.Error500(w, err, "Error looking up the AS quota")
+		return
+	}
+
 	userData := userPageData{
 		User:        user,
-		MaxASes:     config.MaxASes(user.IsAdmin),
+		MaxASes:     quota.Max,
+		ASQuota:     quota,
 		ASInfos:     asInfo,
 		APs:         aps,
 		GrafanaLink: config.GrafanaURL,
@@ -247,6 +296,7 @@ func (c *UserController) UserInformation(w http.ResponseWriter, r *http.Request)
 
 // API function that changes password for logged-in users
 func (c *UserController) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 
 	// parse the JSON coming from the client
 	var pwChangeRequest passwordChangeRequest
@@ -254,15 +304,15 @@ func (c *UserController) ChangePassword(w http.ResponseWriter, r *http.Request)
 
 	// check if the parsing succeeded
 	if err := decoder.Decode(&pwChangeRequest); err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Error500(w, err, "Error decoding JSON")
 		return
 	}
 
 	// get the current user session
-	_, userSession, err := middleware.GetUserSession(r)
+	session, userSession, err := middleware.GetUserSession(r)
 	if err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Forbidden(w, err, "Error getting user session")
 		return
 	}
@@ -270,29 +320,34 @@ func (c *UserController) ChangePassword(w http.ResponseWriter, r *http.Request)
 	// load the user and verify email and password authentication
 	dbUser, err := models.FindUserByEmail(userSession.Email)
 	if err != nil || dbUser == nil {
-		log.Printf("User %v not found in database: %v", userSession.Email, err)
+		log.Warnf("User %v not found in database: %v", userSession.Email, err)
 		c.Forbidden(w, err, "Error authenticating user")
 		return
 	}
 
 	if err := dbUser.CheckPassword(pwChangeRequest.CurrentPassword); err != nil {
-		log.Printf("Incorrect password for user %v", userSession.Email)
+		log.Warnf("Incorrect password for user %v", userSession.Email)
 		c.Forbidden(w, err, "Incorrect password")
 		return
 	}
 
 	// check if new passwords are valid
 	if err := passwordsAreValid(pwChangeRequest.NewPassword, pwChangeRequest.PasswordConfirmation); err != nil {
-		log.Println(err)
+		log.Errorf("%v", err)
 		c.Error500(w, err, err.Error())
 		return
 	}
 
 	if err := dbUser.UpdatePassword(pwChangeRequest.NewPassword); err != nil {
-		log.Printf("Error updating the password in the database: %v", err)
+		log.Errorf("Error updating the password in the database: %v", err)
 		c.Error500(w, err, "Error updating the password in the database")
 		return
 	}
 
+	// log out the other sessions, which may have been opened with the old password
+	if _, err := models.DeleteWebSessionsByUserEmail(dbUser.Email, session.ID); err != nil {
+		log.Errorf("Error revoking the other sessions of %v: %v", dbUser.Email, err)
+	}
+
 	return
 }
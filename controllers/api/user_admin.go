@@ -0,0 +1,395 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+)
+
+// page sizes of the lists of users and admin actions
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// adminUserInfo is a user as listed on the admin page
+type adminUserInfo struct {
+	Email           string
+	FirstName       string
+	LastName        string
+	Organisation    string
+	Account         string
+	AccountID       string
+	Verified        bool
+	IsAdmin         bool
+	Disabled        bool
+	Locked          bool
+	DeletionPending bool
+	Created         time.Time
+}
+
+type userListData struct {
+	Users   []adminUserInfo
+	Total   int64
+	Page    int
+	PerPage int
+}
+
+// adminUserDetails is a user with the ASes the user is the contact of, the AS quota, the roles
+// and the actions of admins on the user
+type adminUserDetails struct {
+	User         adminUserInfo
+	ASes         []exportedAS
+	ASQuota      asQuotaInfo
+	Roles        []roleAssignmentInfo
+	AdminActions []models.AdminAction
+}
+
+type adminActionsData struct {
+	Actions []models.AdminAction
+	Total   int64
+	Page    int
+	PerPage int
+}
+
+type setAdminRequest struct {
+	Admin bool
+}
+
+type setDisabledRequest struct {
+	Disabled bool
+}
+
+// pageParams returns the page, starting at 1, and the page size requested in the query
+func pageParams(r *http.Request) (int, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("perPage"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	return page, perPage
+}
+
+// actingAdmin returns the email address of the logged-in admin
+func actingAdmin(r *http.Request) (string, error) {
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		return "", err
+	}
+	return userSession.Email, nil
+}
+
+// Users lists the users whose email address, name or organisation contains the query q, page by
+// page
+func (c AdminController) Users(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	page, perPage := pageParams(r)
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	users, total, err := models.FindUsers(query, (page-1)*perPage, perPage)
+	if err != nil {
+		log.Errorf("Error searching users for %q: %v", query, err)
+		c.Error500(w, err, "Error looking up the users")
+		return
+	}
+	data := userListData{Users: []adminUserInfo{}, Total: total, Page: page, PerPage: perPage}
+	now := time.Now()
+	for _, u := range users {
+		info := adminUserInfo{
+			Email:           u.Email,
+			FirstName:       u.FirstName,
+			LastName:        u.LastName,
+			Verified:        u.Verified,
+			IsAdmin:         u.IsAdmin,
+			Disabled:        u.Disabled,
+			Locked:          u.IsLocked(now),
+			DeletionPending: u.DeletionPending(),
+			Created:         u.Created,
+		}
+		if u.Account != nil {
+			info.Organisation = u.Account.Organisation
+			info.Account = u.Account.Name
+			info.AccountID = u.Account.AccountID
+		}
+		data.Users = append(data.Users, info)
+	}
+	c.JSON(data, w, r)
+}
+
+// User returns a user with the ASes the user is the contact of, the AS quota, the roles and the
+// actions of admins on the user
+func (c AdminController) User(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	userEmail := mux.Vars(r)["email"]
+	u, err := models.FindUserByEmail(userEmail)
+	if err != nil {
+		c.NotFound(w, err, "User not found")
+		return
+	}
+	details := adminUserDetails{
+		User: adminUserInfo{
+			Email:           u.Email,
+			FirstName:       u.FirstName,
+			LastName:        u.LastName,
+			Verified:        u.Verified,
+			IsAdmin:         u.IsAdmin,
+			Disabled:        u.Disabled,
+			Locked:          u.IsLocked(time.Now()),
+			DeletionPending: u.DeletionPending(),
+			Created:         u.Created,
+		},
+		ASes:  []exportedAS{},
+		Roles: []roleAssignmentInfo{},
+	}
+	if u.Account != nil {
+		details.User.Organisation = u.Account.Organisation
+		details.User.Account = u.Account.Name
+		details.User.AccountID = u.Account.AccountID
+	}
+	ases, err := models.FindSCIONLabASesByUserEmail(u.Email)
+	if err != nil {
+		log.Errorf("Error looking up the ASes of %v: %v", u.Email, err)
+		c.Error500(w, err, "Error looking up the ASes of the user")
+		return
+	}
+	for i := range ases {
+		as, err := newExportedAS(&ases[i])
+		if err != nil {
+			log.Errorf("Error looking up AS %v: %v", ases[i].IAString(), err)
+			c.Error500(w, err, "Error looking up the ASes of the user")
+			return
+		}
+		details.ASes = append(details.ASes, as)
+	}
+	details.ASQuota, err = userASQuota(u.Email)
+	if err != nil {
+		log.Errorf("Error looking up the AS quota of %v: %v", u.Email, err)
+		c.Error500(w, err, "Error looking up the AS quota of the user")
+		return
+	}
+	assignments, err := models.FindRoleAssignmentsByUserEmail(u.Email)
+	if err != nil {
+		log.Errorf("Error looking up the roles of %v: %v", u.Email, err)
+		c.Error500(w, err, "Error looking up the roles of the user")
+		return
+	}
+	for _, a := range assignments {
+		details.Roles = append(details.Roles, roleAssignmentInfo{
+			ID:      a.ID,
+			Email:   u.Email,
+			Role:    a.Role.Name,
+			ISD:     a.ISD,
+			Created: a.Created,
+		})
+	}
+	details.AdminActions, _, err = models.FindAdminActions(u.ID, 0, maxPerPage)
+	if err != nil {
+		log.Errorf("Error looking up the admin actions on %v: %v", u.Email, err)
+		c.Error500(w, err, "Error looking up the admin actions")
+		return
+	}
+	if details.AdminActions == nil {
+		details.AdminActions = []models.AdminAction{}
+	}
+	c.JSON(details, w, r)
+}
+
+// AdminActions lists the recorded actions of admins on users, the newest first, page by page
+func (c AdminController) AdminActions(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	page, perPage := pageParams(r)
+	actions, total, err := models.FindAdminActions(0, (page-1)*perPage, perPage)
+	if err != nil {
+		log.Errorf("Error looking up the admin actions: %v", err)
+		c.Error500(w, err, "Error looking up the admin actions")
+		return
+	}
+	if actions == nil {
+		actions = []models.AdminAction{}
+	}
+	c.JSON(adminActionsData{Actions: actions, Total: total, Page: page, PerPage: perPage}, w, r)
+}
+
+// SetUserAdmin assigns the admin role to a user or revokes it
+func (c AdminController) SetUserAdmin(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	admin, err := actingAdmin(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	var req setAdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	u, err := models.FindUserByEmail(mux.Vars(r)["email"])
+	if err != nil {
+		c.NotFound(w, err, "User not found")
+		return
+	}
+	if u.Email == admin && !req.Admin {
+		c.BadRequest(w, nil, "You cannot revoke your own admin role")
+		return
+	}
+	if err := u.SetAdmin(req.Admin); err != nil {
+		log.Errorf("Error setting admin of %v to %v: %v", u.Email, req.Admin, err)
+		c.Error500(w, err, "Error changing the admin role")
+		return
+	}
+	action := models.ActionSetAdmin
+	if !req.Admin {
+		action = models.ActionUnsetAdmin
+	}
+	if err := models.RecordAdminAction(admin, u, action, ""); err != nil {
+		log.Errorf("Error recording that %v did %v on %v: %v", admin, action, u.Email, err)
+	}
+	log.Infof("%v did %v on %v", admin, action, u.Email)
+	c.JSON(struct{}{}, w, r)
+}
+
+// SetUserDisabled disables the login of a user or re-enables it. The sessions of a disabled
+// user are revoked; if that fails, the request fails and can be repeated. The access tokens of
+// the user and the secret of an account whose users are all disabled are rejected.
+func (c AdminController) SetUserDisabled(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	admin, err := actingAdmin(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	var req setDisabledRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	u, err := models.FindUserByEmail(mux.Vars(r)["email"])
+	if err != nil {
+		c.NotFound(w, err, "User not found")
+		return
+	}
+	if u.Email == admin && req.Disabled {
+		c.BadRequest(w, nil, "You cannot disable your own login")
+		return
+	}
+	if err := u.SetDisabled(req.Disabled); err != nil {
+		log.Errorf("Error setting disabled of %v to %v: %v", u.Email, req.Disabled, err)
+		c.Error500(w, err, "Error changing the login of the user")
+		return
+	}
+	action := models.ActionEnable
+	details := ""
+	var revokeErr error
+	if req.Disabled {
+		action = models.ActionDisable
+		var revoked int64
+		revoked, revokeErr = models.DeleteWebSessionsByUserEmail(u.Email, "")
+		details = fmt.Sprintf("%d sessions revoked", revoked)
+		if revokeErr != nil {
+			log.Errorf("Error revoking the sessions of %v: %v", u.Email, revokeErr)
+			details = "revoking the sessions failed"
+		}
+	}
+	if err := models.RecordAdminAction(admin, u, action, details); err != nil {
+		log.Errorf("Error recording that %v did %v on %v: %v", admin, action, u.Email, err)
+	}
+	log.Infof("%v did %v on %v", admin, action, u.Email)
+	// disabling the user again retries revoking the sessions
+	if revokeErr != nil {
+		c.Error500(w, revokeErr, "The user is disabled, but the sessions could not be revoked, "+
+			"please try again")
+		return
+	}
+	c.JSON(struct{}{}, w, r)
+}
+
+// ResetUserPassword invalidates the password of a user, revokes the sessions of the user and
+// sends a link to set a new password
+func (c AdminController) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	admin, err := actingAdmin(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	u, err := models.FindUserByEmail(mux.Vars(r)["email"])
+	if err != nil {
+		c.NotFound(w, err, "User not found")
+		return
+	}
+	if err := resetPassword(r.Context(), u.Email); err != nil {
+		log.Errorf("Error resetting the password of %v: %v", u.Email, err)
+		c.Error500(w, err, "Error resetting the password")
+		return
+	}
+	if _, err := models.DeleteWebSessionsByUserEmail(u.Email, ""); err != nil {
+		log.Errorf("Error revoking the sessions of %v: %v", u.Email, err)
+	}
+	if err := models.RecordAdminAction(admin, u, models.ActionResetPassword, ""); err != nil {
+		log.Errorf("Error recording that %v reset the password of %v: %v", admin, u.Email, err)
+	}
+	log.Infof("%v reset the password of %v", admin, u.Email)
+	c.JSON(struct{}{}, w, r)
+}
+
+// ResendUserVerification sends the link verifying the email address to a user again
+func (c AdminController) ResendUserVerification(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	admin, err := actingAdmin(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	u, err := models.FindUserByEmail(mux.Vars(r)["email"])
+	if err != nil {
+		c.NotFound(w, err, "User not found")
+		return
+	}
+	if u.Verified {
+		c.BadRequest(w, nil, "The email address of %v is already verified", u.Email)
+		return
+	}
+	if err := sendVerificationEmail(r.Context(), u.ID); err != nil {
+		log.Errorf("Error sending verification email to %v: %v", u.Email, err)
+		c.Error500(w, err, "Error sending verification email")
+		return
+	}
+	if err := models.RecordAdminAction(admin, u, models.ActionResendVerification,
+		""); err != nil {
+		log.Errorf("Error recording that %v resent the verification of %v: %v", admin,
+			u.Email, err)
+	}
+	log.Infof("%v resent the verification email to %v", admin, u.Email)
+	c.JSON(struct{}{}, w, r)
+}
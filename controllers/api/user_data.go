@@ -0,0 +1,608 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/email"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+)
+
+// interval in which the deletions waiting for the removal of ASes are completed
+const userDeletionPeriod = 10 * time.Minute
+
+var asStatusNames = map[uint8]string{
+	models.Inactive: "inactive",
+	models.Active:   "active",
+	models.Create:   "create",
+	models.Update:   "update",
+	models.Remove:   "remove",
+	models.Removed:  "removed",
+}
+
+// userDataExport is the personal data of a user returned by ExportData. Passwords, secrets and
+// keys are not included.
+type userDataExport struct {
+	Exported         time.Time
+	Profile          exportedProfile
+	Account          exportedAccount
+	Memberships      []membershipInfo
+	Invitations      []accountInvitationInfo
+	ASes             []exportedAS
+	Events           []exportedEvent
+	Sessions         []sessionInfo
+	AccessTokens     []accessTokenInfo
+	LinkedIdentities []exportedIdentity
+}
+
+type exportedProfile struct {
+	Email            string
+	FirstName        string
+	LastName         string
+	Verified         bool
+	IsAdmin          bool
+	TwoFactorEnabled bool
+	FailedLogins     int
+	LastFailedLogin  time.Time
+	Created          time.Time
+	Updated          time.Time
+}
+
+type exportedAccount struct {
+	Name         string
+	Organisation string
+	AccountID    string
+	Created      time.Time
+	Updated      time.Time
+}
+
+type exportedAS struct {
+	IA              string
+	Label           string
+	PublicIP        string
+	Type            uint8
+	Status          string
+	Branch          string
+	ConfVersion     uint
+	CertExpires     time.Time
+	Created         time.Time
+	Updated         time.Time
+	Connections     []exportedConnection
+	VPNCertificates []exportedVPNCertificate
+}
+
+type exportedConnection struct {
+	AttachmentPoint string
+	JoinIP          string
+	RespondIP       string
+	IsVPN           bool
+	VPNType         string
+	Status          string
+	MTU             uint16
+	Bandwidth       uint64
+	Created         time.Time
+	Updated         time.Time
+}
+
+type exportedVPNCertificate struct {
+	Serial  string
+	Issued  time.Time
+	Expires time.Time
+	Revoked bool
+}
+
+// exportedEvent is a join or connection request of the account or a reply to it
+type exportedEvent struct {
+	Type      string
+	RequestID uint64
+	Status    string
+	RequestIA string `json:",omitempty"`
+	RespondIA string `json:",omitempty"`
+	Info      string
+	Timestamp string `json:",omitempty"`
+}
+
+type exportedIdentity struct {
+	Provider  string
+	Subject   string
+	Created   time.Time
+	LastLogin time.Time
+}
+
+type deleteAccountRequest struct {
+	Password     string
+	Confirmation string // the email address of the user
+}
+
+// accountDeletedMailData fills the template account_deleted.html
+type accountDeletedMailData struct {
+	FirstName   string
+	LastName    string
+	HostAddress string
+	ASes        string
+}
+
+func newExportedAS(as *models.SCIONLabAS) (exportedAS, error) {
+	e := exportedAS{
+		IA:              as.IAString(),
+		Label:           as.Label,
+		PublicIP:        as.PublicIP,
+		Type:            as.Type,
+		Status:          asStatusNames[as.Status],
+		Branch:          as.Branch,
+		ConfVersion:     as.ConfVersion,
+		CertExpires:     as.CertExpires,
+		Created:         as.Created,
+		Updated:         as.Updated,
+		Connections:     []exportedConnection{},
+		VPNCertificates: []exportedVPNCertificate{},
+	}
+	cns, err := as.GetJoinConnections()
+	if err != nil {
+		return e, err
+	}
+	for _, cn := range cns {
+		e.Connections = append(e.Connections, exportedConnection{
+			AttachmentPoint: cn.GetRespondAS().IAString(),
+			JoinIP:          cn.JoinIP,
+			RespondIP:       cn.RespondIP,
+			IsVPN:           cn.IsVPN,
+			VPNType:         connVPNType(cn.IsVPN, cn.VPNType),
+			Status:          asStatusNames[cn.JoinStatus],
+			MTU:             models.MTUOrDefault(cn.MTU),
+			Bandwidth:       models.BandwidthOrDefault(cn.Bandwidth),
+			Created:         cn.Created,
+			Updated:         cn.Updated,
+		})
+	}
+	vcs, err := vpnCerts.FindByCommonName(vpnUserID(as.UserEmail, as.ASID))
+	if err != nil {
+		return e, err
+	}
+	for _, vc := range vcs {
+		e.VPNCertificates = append(e.VPNCertificates, exportedVPNCertificate{
+			Serial:  vc.Serial,
+			Issued:  vc.Issued,
+			Expires: vc.Expires,
+			Revoked: vc.Revoked,
+		})
+	}
+	return e, nil
+}
+
+// accountEvents returns the join and connection requests of the account and the replies to them
+func accountEvents(a *models.Account) ([]exportedEvent, error) {
+	events := []exportedEvent{}
+	joinRequests, err := models.FindJoinRequestsByRequester(a.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	for _, jr := range joinRequests {
+		events = append(events, exportedEvent{Type: "join request", RequestID: jr.RequestID,
+			Status: jr.Status, RespondIA: jr.RespondIA, Info: jr.Info})
+	}
+	joinReplies, err := models.FindJoinRepliesByRequester(a.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	for _, jr := range joinReplies {
+		events = append(events, exportedEvent{Type: "join reply", RequestID: jr.RequestID,
+			Status: jr.Status, RequestIA: jr.JoiningIA, RespondIA: jr.RespondIA, Info: jr.Info})
+	}
+	connRequests, err := models.FindConnRequestsByAccount(a)
+	if err != nil {
+		return nil, err
+	}
+	for _, cr := range connRequests {
+		events = append(events, exportedEvent{Type: "connection request",
+			RequestID: cr.RequestID, Status: cr.Status, RequestIA: cr.RequestIA,
+			RespondIA: cr.RespondIA, Info: cr.Info, Timestamp: cr.Timestamp})
+	}
+	connReplies, err := models.FindConnRepliesByAccount(a)
+	if err != nil {
+		return nil, err
+	}
+	for _, cr := range connReplies {
+		events = append(events, exportedEvent{Type: "connection reply", RequestID: cr.RequestID,
+			Status: cr.Status, RequestIA: cr.RequestIA, RespondIA: cr.RespondIA, Info: cr.Info})
+	}
+	return events, nil
+}
+
+// exportUserData collects the data stored about the user with the email address
+func exportUserData(userEmail string) (*userDataExport, error) {
+	u, err := models.FindUserByEmail(userEmail)
+	if err != nil {
+		return nil, err
+	}
+	a, err := models.FindAccountByID(u.Account.ID)
+	if err != nil {
+		return nil, err
+	}
+	export := &userDataExport{
+		Exported: time.Now().UTC(),
+		Profile: exportedProfile{
+			Email:            u.Email,
+			FirstName:        u.FirstName,
+			LastName:         u.LastName,
+			Verified:         u.Verified,
+			IsAdmin:          u.IsAdmin,
+			TwoFactorEnabled: u.TOTPEnabled,
+			FailedLogins:     u.FailedLogins,
+			LastFailedLogin:  u.LastFailedLogin,
+			Created:          u.Created,
+			Updated:          u.Updated,
+		},
+		Account: exportedAccount{
+			Name:         a.Name,
+			Organisation: a.Organisation,
+			AccountID:    a.AccountID,
+			Created:      a.Created,
+			Updated:      a.Updated,
+		},
+		ASes:             []exportedAS{},
+		AccessTokens:     []accessTokenInfo{},
+		LinkedIdentities: []exportedIdentity{},
+	}
+
+	accounts, err := userAccountsData(userEmail)
+	if err != nil {
+		return nil, err
+	}
+	export.Memberships = accounts.Accounts
+	export.Invitations = accounts.Invitations
+	ases, err := models.FindSCIONLabASesByUserEmail(userEmail)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ases {
+		e, err := newExportedAS(&ases[i])
+		if err != nil {
+			return nil, err
+		}
+		export.ASes = append(export.ASes, e)
+	}
+	if export.Events, err = accountEvents(a); err != nil {
+		return nil, err
+	}
+	if export.Sessions, err = sessionInfos(userEmail, ""); err != nil {
+		return nil, err
+	}
+	tokens, err := models.FindAccessTokensByUserEmail(userEmail)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		export.AccessTokens = append(export.AccessTokens, newAccessTokenInfo(&tokens[i]))
+	}
+	identities, err := models.FindOIDCIdentitiesByUserEmail(userEmail)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range identities {
+		export.LinkedIdentities = append(export.LinkedIdentities, exportedIdentity{
+			Provider:  i.Provider,
+			Subject:   i.Subject,
+			Created:   i.Created,
+			LastLogin: i.LastLogin,
+		})
+	}
+	return export, nil
+}
+
+// ExportData returns the data stored about the logged-in user as JSON file
+func (c *UserController) ExportData(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	_, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	export, err := exportUserData(userSession.Email)
+	if err != nil {
+		log.Errorf("Error exporting the data of %v: %v", userSession.Email, err)
+		c.Error500(w, err, "Error exporting your data")
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="scionlab-data.json"`)
+	c.JSON(export, w, r)
+}
+
+// DeleteAccount deletes the logged-in user after confirming the password and the email address.
+// The ASes shared with other owners of their account are handed over to them. The other active
+// ASes of the user are removed through their attachment points first; the user is deleted by
+// finishUserDeletion once the removal is confirmed and cannot log in meanwhile.
+func (c *UserController) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.BadRequest(w, err, "Error decoding JSON")
+		return
+	}
+	session, userSession, err := middleware.GetUserSession(r)
+	if err != nil {
+		log.Errorf("%v", err)
+		c.Forbidden(w, err, "Error getting user session")
+		return
+	}
+	dbUser, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		log.Warnf("User %v not found in database: %v", userSession.Email, err)
+		c.Forbidden(w, err, "Error authenticating user")
+		return
+	}
+	if strings.TrimSpace(req.Confirmation) != dbUser.Email {
+		c.BadRequest(w, nil, "Please enter your email address to confirm the deletion")
+		return
+	}
+	// users created at their first login with an OpenID Connect provider may have no password
+	if !dbUser.PasswordInvalid {
+		if err := dbUser.CheckPassword(req.Password); err != nil {
+			log.Warnf("Incorrect password for user %v", dbUser.Email)
+			c.Forbidden(w, err, "Incorrect password")
+			return
+		}
+	}
+
+	ases, err := models.FindSCIONLabASesByUserEmail(dbUser.Email)
+	if err != nil {
+		log.Errorf("Error looking up the ASes of %v: %v", dbUser.Email, err)
+		c.Error500(w, err, "Error looking up your ASes")
+		return
+	}
+	for _, as := range ases {
+		if as.Type == models.Infrastructure {
+			c.Forbidden(w, nil, "You are the contact of the infrastructure AS %v, please "+
+				"contact the SCIONLab administrators", as.IAString())
+			return
+		}
+		if as.Status == models.Create || as.Status == models.Update {
+			c.BadRequest(w, nil, "Your AS %v has pending changes, please try again in a "+
+				"few minutes", as.IAString())
+			return
+		}
+	}
+
+	if err := handOverSharedASes(r.Context(), dbUser.Email, ases); err != nil {
+		log.Errorf("Error handing over the ASes of %v: %v", dbUser.Email, err)
+		c.Error500(w, err, "Error handing over your ASes to the other owners of their account")
+		return
+	}
+	if err := dbUser.RequestDeletion(); err != nil {
+		log.Errorf("Error marking %v for deletion: %v", dbUser.Email, err)
+		c.Error500(w, err, "Error deleting your account")
+		return
+	}
+	log.Infof("User %v requested the deletion of the account", dbUser.Email)
+	tokens, err := models.FindAccessTokensByUserEmail(dbUser.Email)
+	if err != nil {
+		log.Errorf("Error looking up the access tokens of %v: %v", dbUser.Email, err)
+	}
+	for i := range tokens {
+		if err := tokens[i].Delete(); err != nil {
+			log.Errorf("Error revoking access token %v of %v: %v", tokens[i].ID,
+				dbUser.Email, err)
+		}
+	}
+	if _, err := models.DeleteWebSessionsByUserEmail(dbUser.Email, ""); err != nil {
+		log.Errorf("Error revoking the sessions of %v: %v", dbUser.Email, err)
+	}
+
+	deleted, err := finishUserDeletion(r.Context(), dbUser.Email)
+	if err != nil {
+		log.Errorf("Error deleting user %v: %v", dbUser.Email, err)
+	}
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		c.Error500(w, err, "Error: Session expired")
+		return
+	}
+	if deleted {
+		fmt.Fprintln(w, "Your account has been deleted.")
+		return
+	}
+	fmt.Fprintln(w, "Your ASes will be removed within the next few minutes. Your account will "+
+		"be deleted afterwards and you will receive a confirmation email.")
+}
+
+// handOverSharedASes makes another owner of the account of each AS of the user the contact of
+// the AS, see models.Account.OtherOwner. ASes whose account has no other owner are left to the
+// user.
+func handOverSharedASes(ctx context.Context, userEmail string, ases []models.SCIONLabAS) error {
+	log := logger.FromContext(ctx)
+	for i := range ases {
+		as := &ases[i]
+		account, err := as.OwnerAccount()
+		if err != nil {
+			return fmt.Errorf("error looking up the account of AS %v: %v", as.IAString(), err)
+		}
+		owner, err := account.OtherOwner(userEmail)
+		if err == orm.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error looking up the owners of account %v: %v", account.Name,
+				err)
+		}
+		if err := handOverAS(ctx, as, owner.Email); err != nil {
+			return fmt.Errorf("error handing over AS %v to %v: %v", as.IAString(), owner.Email,
+				err)
+		}
+		log.Infof("Handed over AS %v of %v to %v", as.IAString(), userEmail, owner.Email)
+	}
+	return nil
+}
+
+// handOverAS makes the user with the email address newEmail the contact of the AS. Its
+// artifacts are moved as for a change of the email address of the contact, and the configuration
+// of a configured AS is regenerated, so that it obtains a VPN certificate for the new contact
+// with its next update.
+func handOverAS(ctx context.Context, as *models.SCIONLabAS, newEmail string) error {
+	log := logger.FromContext(ctx)
+	oldEmail := as.UserEmail
+	moves := asArtifactMoves(as, newEmail)
+	if err := copyArtifacts(ctx, moves); err != nil {
+		return err
+	}
+	handed := *as
+	handed.UserEmail = newEmail
+	// inactive and removed ASes have no configuration
+	if as.Status != models.Inactive && as.Status != models.Removed {
+		handed.ConfVersion++
+		if err := computeNewGenFolder(ctx, &handed); err != nil {
+			deleteArtifactCopies(moves)
+			return err
+		}
+	}
+	if err := handed.Update(); err != nil {
+		deleteArtifactCopies(moves)
+		return err
+	}
+	*as = handed
+	deleteMovedArtifacts(ctx, moves)
+	if err := cleanVPNKeys(ctx, oldEmail, as.ASID); err != nil {
+		log.Errorf("Error revoking the VPN certificate of %v for AS %v: %v", oldEmail,
+			as.IAString(), err)
+	}
+	packagePath := filepath.Join(PackagePath, UserPackageName(oldEmail, as.ISD, as.ASID))
+	if err := os.RemoveAll(packagePath); err != nil {
+		log.Errorf("Error removing %v: %v", packagePath, err)
+	}
+	return nil
+}
+
+// finishUserDeletion deletes the user marked for deletion together with the packages, the
+// certificate caches and the VPN keys of the ASes of the user. The active ASes are marked for
+// removal from their attachment points first; the user is only deleted once all removals are
+// confirmed. It returns whether the user was deleted.
+func finishUserDeletion(ctx context.Context, userEmail string) (bool, error) {
+	log := logger.FromContext(ctx)
+	u, err := models.FindUserByEmail(userEmail)
+	if err != nil {
+		return false, err
+	}
+	if !u.DeletionPending() {
+		return false, fmt.Errorf("the deletion of %v was not requested", userEmail)
+	}
+	ases, err := models.FindSCIONLabASesByUserEmail(userEmail)
+	if err != nil {
+		return false, err
+	}
+	var removed []string
+	pending := false
+	for _, as := range ases {
+		switch as.Status {
+		case models.Inactive, models.Removed:
+			removed = append(removed, as.IAString())
+			continue
+		case models.Remove:
+			pending = true
+			continue
+		}
+		// ASes which are not attached to an attachment point are deleted right away
+		removable, active, cn, err := canRemove(userEmail, as.ASID)
+		if err != nil {
+			return false, fmt.Errorf("error removing AS %v: %v", as.IAString(), err)
+		}
+		if !removable {
+			continue
+		}
+		if err := markASRemoved(ctx, active, cn); err != nil {
+			return false, fmt.Errorf("error removing AS %v: %v", as.IAString(), err)
+		}
+		pending = true
+	}
+	if pending {
+		return false, nil
+	}
+
+	for i := range ases {
+		as := &ases[i]
+		if err := cleanVPNKeys(ctx, as.UserEmail, as.ASID); err != nil {
+			return false, err
+		}
+		if err := cleanWireGuardKeys(as.UserEmail, as.ASID); err != nil {
+			return false, err
+		}
+		if err := Artifacts.Delete(packageKey(as.UserEmail, as.ISD, as.ASID)); err != nil {
+			return false, err
+		}
+		for _, dir := range []string{packageVersionsKey(as), certCacheKey(as)} {
+			if err := storage.DeleteAll(Artifacts, dir); err != nil {
+				return false, err
+			}
+		}
+		packagePath := filepath.Join(PackagePath, UserPackageName(as.UserEmail, as.ISD, as.ASID))
+		if err := os.RemoveAll(packagePath); err != nil {
+			return false, err
+		}
+	}
+	if err := Artifacts.Delete(boxPackageKey(userEmail)); err != nil {
+		return false, err
+	}
+	if err := os.RemoveAll(userPackagePath(userEmail)); err != nil {
+		return false, err
+	}
+
+	accountDeleted, err := u.DeleteWithData()
+	if err != nil {
+		return false, err
+	}
+	log.Infof("Deleted user %v; account deleted: %v", userEmail, accountDeleted)
+	data := accountDeletedMailData{
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		HostAddress: config.HTTPHostAddress,
+		ASes:        strings.Join(removed, ", "),
+	}
+	if err := email.ConstructFromTemplateAndSend(ctx, "account_deleted.html",
+		"[SCIONLab] Your account was deleted", data, "account-deleted", userEmail,
+		false); err != nil {
+		log.Errorf("Error notifying %v about the deletion: %v", userEmail, err)
+	}
+	return true, nil
+}
+
+// FinishUserDeletionsPeriodically deletes the users whose ASes were removed since they asked to
+// delete their account every userDeletionPeriod. It is meant to be run as a goroutine.
+func FinishUserDeletionsPeriodically(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	for {
+		users, err := models.FindUsersPendingDeletion()
+		if err != nil {
+			log.Errorf("Error looking up the users to delete: %v", err)
+		}
+		for _, u := range users {
+			if _, err := finishUserDeletion(ctx, u.Email); err != nil {
+				log.Errorf("Error deleting user %v: %v", u.Email, err)
+			}
+		}
+		time.Sleep(userDeletionPeriod)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/netsec-ethz/scion-coord/storage"
+	"github.com/netsec-ethz/scion-coord/vpnca"
+)
+
+func TestHandOverSharedASes(t *testing.T) {
+	ca, restore := prepareVPNCA(t)
+	defer restore()
+	u, err := models.RegisterUser("handover", "Scion Test-Bed", "handover@example.com",
+		"some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+	owner, err := models.RegisterUser("handover-owner", "Scion Test-Bed",
+		"handover.owner@example.com", "some password", "Jane", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Delete()
+	defer owner.Account.Delete()
+
+	as := &models.SCIONLabAS{UserEmail: u.Email, ISD: 1, ASID: 0xffaa0001f246,
+		Type: models.VM, Status: models.Inactive, ConfVersion: 3, Account: u.Account}
+	if err := as.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer as.Delete()
+	if err = generateVPNKeys(context.Background(), &SCIONLabASInfo{LocalAS: as}); err != nil {
+		t.Fatal(err)
+	}
+	serial := vpnca.SerialString(storedVPNSerial(t, &SCIONLabASInfo{LocalAS: as}))
+	oldKeys := []string{
+		packageKey(as.UserEmail, as.ISD, as.ASID),
+		packageVersionKey(as, 1),
+		certCacheKey(as) + "/V1/certs/a.crt",
+	}
+	for _, key := range oldKeys {
+		if err = storage.PutBytes(Artifacts, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the AS is left to the user as long as the account has no other owner
+	ases := []models.SCIONLabAS{*as}
+	if err = handOverSharedASes(context.Background(), u.Email, ases); err != nil {
+		t.Fatal(err)
+	}
+	if ases[0].UserEmail != u.Email {
+		t.Fatalf("the AS was handed over to %v without another owner", ases[0].UserEmail)
+	}
+
+	i, err := models.InviteAccountMember(u.Account, owner.Email, models.MemberOwner, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = i.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	if err = handOverSharedASes(context.Background(), u.Email, ases); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := models.FindSCIONLabASByUserEmailAndASID(owner.Email, as.ASID)
+	if err != nil {
+		t.Fatalf("the AS was not handed over to %v: %v", owner.Email, err)
+	}
+	// inactive ASes have no configuration to regenerate
+	if stored.ConfVersion != as.ConfVersion {
+		t.Errorf("the configuration version changed from %v to %v", as.ConfVersion,
+			stored.ConfVersion)
+	}
+
+	// the artifacts are moved to the keys of the new contact
+	handed := *as
+	handed.UserEmail = owner.Email
+	newKeys := []string{
+		packageKey(handed.UserEmail, as.ISD, as.ASID),
+		packageVersionKey(&handed, 1),
+		certCacheKey(&handed) + "/V1/certs/a.crt",
+	}
+	for i, key := range newKeys {
+		content, err := storage.ReadAll(Artifacts, key)
+		if err != nil || string(content) != oldKeys[i] {
+			t.Errorf("%v: unexpected content %q: %v", key, content, err)
+		}
+		if exists, err := storage.Exists(Artifacts, oldKeys[i]); exists || err != nil {
+			t.Errorf("%v should have been deleted: %v, %v", oldKeys[i], exists, err)
+		}
+	}
+	// the VPN certificate of the previous contact is revoked
+	if revoked := revokedSerials(t, ca); !revoked[serial] {
+		t.Errorf("the CRL lists %v, expected %v", revoked, serial)
+	}
+	if exists, err := storage.Exists(Artifacts, vpnCertKey(u.Email, as.ASID)); exists ||
+		err != nil {
+		t.Errorf("the VPN certificate of %v is still stored: %v, %v", u.Email, exists, err)
+	}
+}
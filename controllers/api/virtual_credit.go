@@ -18,11 +18,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
 	"github.com/scionproto/scion/go/lib/addr"
 )
@@ -51,6 +51,7 @@ var systemDisabledError = errors.New(
 //}
 
 func (c *ASInfoController) ListASesConnectionsWithCredits(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
 	if config.VirtualCreditEnable == false {
 		c.NotFound(w, nil, systemDisabledError.Error())
 		return
@@ -81,7 +82,7 @@ func (c *ASInfoController) ListASesConnectionsWithCredits(w http.ResponseWriter,
 
 	connections, err := requestingAS.ListConnections()
 	if err != nil {
-		log.Printf("Error while retrieving list of ASes. ISD-AS: %v", requestingAS)
+		log.Errorf("Error while retrieving list of ASes. ISD-AS: %v", requestingAS)
 		c.BadRequest(w, err, "Error while retrieving list of ASes. ISD-AS: %v", requestingAS)
 		return
 	}
@@ -89,7 +90,7 @@ func (c *ASInfoController) ListASesConnectionsWithCredits(w http.ResponseWriter,
 
 	b, err := json.Marshal(response)
 	if err != nil {
-		log.Printf("Error during JSON marshaling. ISD-AS: %v, %v", requestingAS, err)
+		log.Errorf("Error during JSON marshaling. ISD-AS: %v, %v", requestingAS, err)
 		c.Error500(w, err, "Error during JSON marshaling. ISD-AS: %v", requestingAS)
 		return
 	}
@@ -106,6 +107,7 @@ func (c *ASInfoController) ListASesConnectionsWithCredits(w http.ResponseWriter,
 //
 func (c *ASInfoController) checkAndUpdateCredits(w http.ResponseWriter, r *http.Request,
 	cr *ConnRequest) error {
+	log := logger.FromContext(r.Context())
 
 	if config.VirtualCreditEnable == false {
 		return systemDisabledError
@@ -113,7 +115,7 @@ func (c *ASInfoController) checkAndUpdateCredits(w http.ResponseWriter, r *http.
 
 	as, err := models.FindASInfoByIA(cr.RequestIA)
 	if err != nil {
-		log.Printf("Error: Unkown AS: %v, %v", r.Body, err)
+		log.Errorf("Error: Unkown AS: %v, %v", r.Body, err)
 		c.BadRequest(w, err, "Error: Unknown AS: %v", r.Body)
 		return err
 	}
@@ -122,14 +124,14 @@ func (c *ASInfoController) checkAndUpdateCredits(w http.ResponseWriter, r *http.
 	if (as.Credits - creditsNeeded) <= 0 {
 		err = fmt.Errorf("error: Not enough credits to create a connection request! "+
 			"You need %v, but have only %v", creditsNeeded, as.Credits)
-		log.Printf("Info: Not enough credits! AS: %v, Request: %v, Error: %v", as, r.Body, err)
+		log.Warnf("Not enough credits! AS: %v, Request: %v, Error: %v", as, r.Body, err)
 		c.BadRequest(w, err, "Info: Not enough credits! AS: %v, Request: %v", as, r.Body)
 		return err
 	}
 
 	// Subtracting credits from AS
 	if err := as.UpdateCurrency(-1 * creditsNeeded); err != nil {
-		log.Printf("Error: Subtracting credits! AS: %v, Request: %v, Error: %v", as, r.Body, err)
+		log.Errorf("Error: Subtracting credits! AS: %v, Request: %v, Error: %v", as, r.Body, err)
 		c.Error500(w, err, "Error: Subtracting credits! AS: %v, Request: %v", as, r.Body)
 		return err
 	}
@@ -145,6 +147,7 @@ func (c *ASInfoController) checkAndUpdateCredits(w http.ResponseWriter, r *http.
 //
 func (c *ASInfoController) checkAndUpdateCreditsAtResponse(w http.ResponseWriter, r *http.Request,
 	cr *models.ConnRequest, reply ConnReply) error {
+	log := logger.FromContext(r.Context())
 
 	if !config.VirtualCreditEnable == false {
 		return systemDisabledError
@@ -156,21 +159,21 @@ func (c *ASInfoController) checkAndUpdateCreditsAtResponse(w http.ResponseWriter
 	if cr.Status == models.Approved {
 		otherAS, err := models.FindASInfoByIA(cr.RespondIA)
 		if err != nil {
-			log.Printf("Error finding the RespondIA. Request ID: %v RequestIA: %v, RespondIA: %v, %v",
+			log.Errorf("Error finding the RespondIA. Request ID: %v RequestIA: %v, RespondIA: %v, %v",
 				reply.RequestID, reply.RequestIA, reply.RespondIA, err)
 			c.Error500(w, err, "Error finding the RespondIA. Request ID: %v RequestIA: %v, RespondIA: %v",
 				reply.RequestID, reply.RequestIA, reply.RespondIA)
 			return err
 		}
 		if err := otherAS.UpdateCurrency(credits); err != nil {
-			log.Printf("Error: Adding credits! OtherAS: %v, Request: %v, Error: %v", otherAS, r.Body, err)
+			log.Errorf("Error: Adding credits! OtherAS: %v, Request: %v, Error: %v", otherAS, r.Body, err)
 			c.Error500(w, err, "Error: Adding credits! OtherAS: %v, Request: %v", otherAS, r.Body)
 			return err
 		}
 		// If the connection request is denied, release the reserved credits for the connection
 	} else if cr.Status != models.Pending {
 		if err := as.UpdateCurrency(credits); err != nil {
-			log.Printf("Error: Re-adding credits! ThisAS: %v, Request: %v, Error: %v", as, r.Body, err)
+			log.Errorf("Error: Re-adding credits! ThisAS: %v, Request: %v, Error: %v", as, r.Body, err)
 			c.Error500(w, err, "Error: Re-adding credits! ThisAS: %v, Request: %v", as, r.Body)
 			return err
 		}
@@ -185,13 +188,14 @@ func (c *ASInfoController) checkAndUpdateCreditsAtResponse(w http.ResponseWriter
 //	If everything was fine, the function returns nil.
 //
 func (c *ASInfoController) rollBackCreditUpdate(w http.ResponseWriter, r *http.Request, cr *ConnRequest) {
+	log := logger.FromContext(r.Context())
 	if !config.VirtualCreditEnable {
 		return
 	}
 
 	as, err := models.FindASInfoByIA(cr.RequestIA)
 	if err != nil {
-		log.Printf("Error: Unkown AS: %v, %v", r.Body, err)
+		log.Errorf("Error: Unkown AS: %v, %v", r.Body, err)
 		c.BadRequest(w, err, "Error: Unknown AS: %v", r.Body)
 		return
 	}
@@ -199,7 +203,7 @@ func (c *ASInfoController) rollBackCreditUpdate(w http.ResponseWriter, r *http.R
 	creditsNeeded := models.BandwidthToCredits(cr.Bandwidth)
 	// Roll back UpdateCurrency changes
 	if err := as.UpdateCurrency(creditsNeeded); err != nil {
-		log.Printf("Critical error: Can't roll back UpdateCurrency changes! "+
+		log.Errorf("Critical error: Can't roll back UpdateCurrency changes! "+
 			"Credits: %v, AS: %v, Request: %v, Error: %v", creditsNeeded, as, r.Body, err)
 	}
 }
@@ -19,10 +19,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 
 	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/logger"
 )
 
 type output interface {
@@ -31,7 +31,8 @@ type output interface {
 	// returns plain text data
 	Plain(data string, w http.ResponseWriter, r *http.Request)
 
-	Error(w http.ResponseWriter, err error, errorCode int, description string, a ...interface{})
+	Error(w http.ResponseWriter, r *http.Request, err error, errorCode int, description string,
+		a ...interface{})
 
 	// Response with a 500 and an error
 	Error500(w http.ResponseWriter, err error, desc string, a ...interface{})
@@ -56,7 +57,7 @@ type HTTPController struct {
 var Verbosity func(err error, format string, a ...interface{}) string
 
 func init() {
-	if config.LogDebugMode {
+	if config.LogLevel == logger.LevelDebug {
 		Verbosity = func(err error, format string, a ...interface{}) string {
 			if err != nil {
 				return fmt.Sprintf(format+": %v", append(a, err)...)
@@ -90,7 +91,7 @@ func (c HTTPController) JSON(data interface{}, w http.ResponseWriter, r *http.Re
 
 	// write the content to socket
 	if _, err := w.Write(content); err != nil {
-		log.Printf("Error writing data to socket: %v", err)
+		logger.FromContext(r.Context()).Errorf("Error writing data to socket: %v", err)
 		c.Error500(w, err, "Error creating response")
 	}
 
@@ -102,17 +103,18 @@ func (c HTTPController) Plain(data string, w http.ResponseWriter, r *http.Reques
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	// write the content to socket
 	if _, err := w.Write([]byte(data)); err != nil {
-		log.Printf("Error writing data to socket: %v", err)
+		logger.FromContext(r.Context()).Errorf("Error writing data to socket: %v", err)
 		c.Error500(w, err, "Error creating response")
 	}
 }
 
 //TODO (chaehni): Replace calls to error functions with this generic function.
 //if used throughout, it makes the dedicated error functions obsolete.
-func (c HTTPController) Error(w http.ResponseWriter, err error, errorCode int, description string, a ...interface{}) {
+func (c HTTPController) Error(w http.ResponseWriter, r *http.Request, err error, errorCode int,
+	description string, a ...interface{}) {
 
 	// Log the error
-	log.Println(fmt.Sprintf(description+": %v", append(a, err)...))
+	logger.FromContext(r.Context()).Errorf(description+": %v", append(a, err)...)
 
 	// Forward the error to the web interface
 	http.Error(w, Verbosity(err, description, a...), errorCode)
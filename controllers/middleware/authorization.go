@@ -15,22 +15,147 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
 )
 
 type CheckFunction func(r *http.Request) bool
 
 var (
-	AuthHandler  = constructHandler(checkAPI)
-	UserHandler  = constructHandler(checkLogin)
-	AdminHandler = constructHandler(checkAdmin)
+	AuthHandler = withAccessToken(constructHandler(checkAPI))
+	UserHandler = constructHandler(checkLogin)
 )
 
-// TODO(mlegner): We need an additional authorization handler that checks if the account is an admin
-func checkAccountSecret(r *http.Request) bool {
+type contextKey int
+
+const (
+	accessTokenKey contextKey = iota
+	grantsKey
+)
+
+// bearerToken returns the token sent in an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	const scheme = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(scheme) || !strings.EqualFold(h[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(scheme):]), true
+}
+
+// withAccessToken accepts requests carrying a valid personal access token in the Authorization
+// header and passes the token on in the request context. Requests without such a header are
+// handled by legacyHandler.
+func withAccessToken(legacyHandler func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		legacy := legacyHandler(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.FromContext(r.Context())
+			token, ok := bearerToken(r)
+			if !ok {
+				legacy.ServeHTTP(w, r)
+				return
+			}
+			t, err := models.FindAccessToken(token)
+			if err != nil {
+				http.Error(w, "Invalid access token", http.StatusUnauthorized)
+				return
+			}
+			if err = t.Touch(); err != nil {
+				log.Errorf("Error recording the use of access token %v: %v", t.ID, err)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accessTokenKey, t)))
+		})
+	}
+}
+
+// RequestAccessToken returns the personal access token the request was authenticated with, or
+// nil if another scheme was used
+func RequestAccessToken(r *http.Request) *models.AccessToken {
+	t, _ := r.Context().Value(accessTokenKey).(*models.AccessToken)
+	return t
+}
+
+// RequireScope rejects requests authenticated with a personal access token lacking the scope.
+// Requests authenticated otherwise are passed on.
+func RequireScope(scope string) Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t := RequestAccessToken(r); t != nil && !t.HasScope(scope) {
+				http.Error(w, "The access token does not have the scope "+scope,
+					http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermission rejects requests whose principal has none of the permissions, see
+// requestGrants. The grants are passed on in the request context for the checks limited to an
+// ISD.
+func RequirePermission(perms ...string) Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.FromContext(r.Context())
+			grants, err := requestGrants(r)
+			if err != nil {
+				log.Errorf("Error looking up the permissions of a request: %v", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError),
+					http.StatusInternalServerError)
+				return
+			}
+			for _, p := range perms {
+				if grants.Has(p) {
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), grantsKey,
+						grants)))
+					return
+				}
+			}
+			http.Error(w, "Not authorized", http.StatusForbidden)
+		})
+	}
+}
+
+// RequestGrants returns the permissions of the principal of the request checked by
+// RequirePermission, or no permissions if the route does not check any
+func RequestGrants(r *http.Request) models.Grants {
+	if g, ok := r.Context().Value(grantsKey).(models.Grants); ok {
+		return g
+	}
+	return models.Grants{}
+}
+
+// requestGrants looks up the permissions of the user of the access token, of the logged-in user,
+// or of the users of the account whose secret is in the request
+func requestGrants(r *http.Request) (models.Grants, error) {
+	email := ""
+	if t := RequestAccessToken(r); t != nil {
+		email = t.UserEmail()
+	} else if _, userSession, err := GetUserSession(r); err == nil && userSession.HasLoggedIn {
+		email = userSession.Email
+	}
+	if email != "" {
+		u, err := models.FindUserByEmail(email)
+		if err != nil {
+			return nil, err
+		}
+		return u.Grants()
+	}
+	if account := requestAccount(r); account != nil {
+		return account.Grants()
+	}
+	return models.Grants{}, nil
+}
+
+// requestAccount returns the account whose ID and secret are in the path or query of the
+// request, or nil if there is none or the secret is wrong
+func requestAccount(r *http.Request) *models.Account {
 	vars := mux.Vars(r)
 	accountID := vars["account_id"]
 	secret := vars["secret"]
@@ -38,8 +163,7 @@ func checkAccountSecret(r *http.Request) bool {
 	if accountID != "" && secret != "" {
 		if account, err := models.FindAccountByAccountIDAndSecret(accountID, secret); err == nil &&
 			account != nil {
-			// proceed with the next handler
-			return true
+			return account
 		}
 
 	}
@@ -51,27 +175,31 @@ func checkAccountSecret(r *http.Request) bool {
 	if accountID != "" && secret != "" {
 		if account, err := models.FindAccountByAccountIDAndSecret(accountID, secret); err == nil &&
 			account != nil {
-			// proceed with the next handler
-			return true
+			return account
 		}
 	}
-	return false
+	return nil
+}
+
+func checkAccountSecret(r *http.Request) bool {
+	return requestAccount(r) != nil
 }
 
+// checkLogin accepts requests of logged-in users. The sessions of disabled users are rejected,
+// also if they could not be revoked when the user was disabled, as FindAccessToken rejects their
+// access tokens.
 func checkLogin(r *http.Request) bool {
 	_, userSession, err := GetUserSession(r)
-	if err == nil && userSession != nil && userSession.HasLoggedIn {
-		return true
+	if err != nil || userSession == nil || !userSession.HasLoggedIn {
+		return false
 	}
-	return false
-}
-
-func checkAdmin(r *http.Request) bool {
-	_, userSession, _ := GetUserSession(r)
-	if userSession != nil && userSession.HasLoggedIn && userSession.IsAdmin {
-		return true
+	u, err := models.FindUserByEmail(userSession.Email)
+	if err != nil {
+		logger.FromContext(r.Context()).Warnf("Error looking up the user %v of a session: %v",
+			userSession.Email, err)
+		return false
 	}
-	return false
+	return !u.Disabled
 }
 
 func checkAPI(r *http.Request) bool {
@@ -0,0 +1,61 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/netsec-ethz/scion-coord/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearerToken(t *testing.T) {
+	for header, expected := range map[string]string{
+		"Bearer sct_0123":  "sct_0123",
+		"bearer  sct_0123": "sct_0123",
+		"Basic dXNlcjpwdw": "",
+		"Bearer ":          "",
+		"":                 "",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", header)
+		token, ok := bearerToken(r)
+		assert.Equal(t, expected, token, header)
+		assert.Equal(t, expected != "", ok, header)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	handler := RequireScope(models.ScopeAP)(testApp)
+	for scopes, expected := range map[string]int{
+		models.ScopeAP:                        http.StatusOK,
+		models.ScopeAS + "," + models.ScopeAP: http.StatusOK,
+		models.ScopeAS:                        http.StatusForbidden,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), accessTokenKey,
+			&models.AccessToken{Scopes: scopes}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, expected, w.Code, scopes)
+	}
+	// requests authenticated otherwise are not restricted
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -15,24 +15,157 @@
 package middleware
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
-	"github.com/gorilla/handlers"
-	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/logger"
 )
 
-func LoggingHandler(next http.Handler) http.Handler {
-	// Log to a file
-	if config.LogFile != "" {
-		logFile, err := os.OpenFile(config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			// we can panic here, because it means there is a wrong config.
-			panic(err)
+// RequestIDHeader carries the ID of a request, set by a proxy in front of the coordinator or
+// assigned by RequestID, and is returned in the response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the IDs accepted from clients
+const maxRequestIDLength = 64
+
+type requestIDKey struct{}
+
+// GetRequestID returns the ID assigned to the request by RequestID, or "" if there is none
+func GetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID returns whether the ID received from a client can be logged as it is
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
 		}
-		return handlers.LoggingHandler(logFile, next)
 	}
+	return true
+}
 
-	// log to console
-	return handlers.LoggingHandler(os.Stdout, next)
+// newRequestID returns a random ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestID keeps the ID of the request in the RequestIDHeader, or assigns a new one if there is
+// none or it is invalid, and returns it in the response. The context of the request carries the
+// ID and a logger adding it to every entry. Requests which already have an ID are passed on.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetRequestID(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = logger.NewContext(ctx, logger.Default().With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder records the status and the size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.size += n
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer cannot be hijacked")
+	}
+	return h.Hijack()
+}
+
+// credentialVars are the route variables carrying credentials, the secret of an account and the
+// UUID of an email verification, which are replaced by their names in the logged path
+var credentialVars = []string{"secret", "uuid"}
+
+// loggedPath returns the path of the request with the credentials in it replaced by placeholders
+func loggedPath(r *http.Request) string {
+	vars := mux.Vars(r)
+	segments := strings.Split(r.URL.Path, "/")
+	for _, name := range credentialVars {
+		value, ok := vars[name]
+		if !ok || value == "" {
+			continue
+		}
+		for i, s := range segments {
+			if s == value {
+				segments[i] = "{" + name + "}"
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// LoggingHandler logs every request with its method, path, status, size and duration, with the
+// request ID assigned by RequestID. The query is not logged and the credentials in the path are
+// replaced by placeholders, see loggedPath.
+func LoggingHandler(next http.Handler) http.Handler {
+	return RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		path := loggedPath(r)
+		logger.FromContext(r.Context()).
+			With("method", r.Method).
+			With("path", path).
+			With("status", rec.status).
+			With("bytes", rec.size).
+			With("duration_ms", time.Since(start).Seconds()*1000).
+			With("remote", r.RemoteAddr).
+			Infof("%v %v %v", r.Method, path, rec.status)
+	}))
 }
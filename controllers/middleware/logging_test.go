@@ -0,0 +1,114 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = GetRequestID(r)
+	}))
+
+	// an ID set by a proxy is kept
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "proxy-1234")
+	h.ServeHTTP(w, r)
+	assert.Equal(t, "proxy-1234", seen)
+	assert.Equal(t, "proxy-1234", w.Header().Get(RequestIDHeader))
+
+	// a new ID is assigned if there is none or it is invalid
+	for _, id := range []string{"", "bad id\n", strings.Repeat("a", maxRequestIDLength+1)} {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r)
+		assert.Len(t, seen, 32)
+		assert.Equal(t, seen, w.Header().Get(RequestIDHeader))
+	}
+}
+
+func TestLoggingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	defer logger.SetOutput(os.Stderr)
+
+	h := LoggingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Infof("handling")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+	}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/as?secret=abc", nil)
+	r.Header.Set(RequestIDHeader, "req-1")
+	h.ServeHTTP(w, r)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	var entries [2]map[string]interface{}
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &entries[i]); err != nil {
+			t.Fatalf("invalid entry %q: %v", line, err)
+		}
+		assert.Equal(t, "req-1", entries[i]["request_id"])
+	}
+	assert.Equal(t, "handling", entries[0]["msg"])
+	assert.Equal(t, "info", entries[1]["level"])
+	assert.Equal(t, "GET", entries[1]["method"])
+	assert.Equal(t, "/api/as", entries[1]["path"])
+	assert.Equal(t, float64(http.StatusNotFound), entries[1]["status"])
+	assert.Equal(t, float64(len("not found")), entries[1]["bytes"])
+	assert.NotContains(t, buf.String(), "secret=abc")
+}
+
+func TestLoggingHandlerCredentialsInPath(t *testing.T) {
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	defer logger.SetOutput(os.Stderr)
+
+	router := mux.NewRouter()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Handle("/api/as/getUpdatesForAP/{account_id}/{secret}", LoggingHandler(ok))
+	router.Handle("/api/verifyEmail/{uuid}", LoggingHandler(ok))
+	for path, logged := range map[string]string{
+		"/api/as/getUpdatesForAP/account1/s3cr3t": "/api/as/getUpdatesForAP/account1/{secret}",
+		"/api/verifyEmail/0a1b2c3d":               "/api/verifyEmail/{uuid}",
+	} {
+		buf.Reset()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		var entry map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid entry %q: %v", buf.String(), err)
+		}
+		assert.Equal(t, logged, entry["path"])
+		assert.NotContains(t, buf.String(), "s3cr3t")
+		assert.NotContains(t, buf.String(), "0a1b2c3d")
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"encoding/base32"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/netsec-ethz/scion-coord/logger"
+	"github.com/netsec-ethz/scion-coord/models"
+)
+
+const (
+	sessionKeyLength = 32
+	maxUserAgent     = 512
+	// how often expired sessions are removed from the database
+	sessionCleanupPeriod = time.Hour
+)
+
+// DBStore is a sessions.Store keeping the sessions in the database, see models.WebSession. The
+// cookie only holds the signed and encrypted key of the session.
+type DBStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+// NewDBStore returns a store whose cookies and stored values are protected with the key pairs
+// and expire after maxAge seconds, see securecookie.CodecsFromPairs
+func NewDBStore(maxAge int, keyPairs ...[]byte) *DBStore {
+	s := &DBStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   maxAge,
+			HttpOnly: true,
+		},
+	}
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(maxAge)
+		}
+	}
+	return s
+}
+
+// Get returns the session of the request, cached in the registry of the request
+func (s *DBStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session of the request. If the cookie does not refer to an unexpired session,
+// e.g. because it was revoked, a new session is returned.
+func (s *DBStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	log := logger.FromContext(r.Context())
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var key string
+	if err := securecookie.DecodeMulti(name, c.Value, &key, s.Codecs...); err != nil {
+		return session, err
+	}
+	stored, err := models.FindWebSession(key)
+	if err == orm.ErrNoRows {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err := securecookie.DecodeMulti(name, stored.Data, &session.Values,
+		s.Codecs...); err != nil {
+		return session, err
+	}
+	session.ID = key
+	session.IsNew = false
+	if err := stored.Touch(time.Now()); err != nil {
+		log.Errorf("Error recording the activity of a session: %v", err)
+	}
+	return session, nil
+}
+
+// Save stores the session and sets its cookie. Sessions with a MaxAge <= 0 are deleted.
+func (s *DBStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := models.DeleteWebSession(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, newCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(
+			securecookie.GenerateRandomKey(sessionKeyLength)), "=")
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	stored := &models.WebSession{
+		Key:       session.ID,
+		Data:      data,
+		IP:        SourceIP(r),
+		UserAgent: r.UserAgent(),
+		LastSeen:  now,
+		Expires:   now.Add(time.Duration(session.Options.MaxAge) * time.Second),
+	}
+	if len(stored.UserAgent) > maxUserAgent {
+		stored.UserAgent = stored.UserAgent[:maxUserAgent]
+	}
+	if userSession, ok := session.Values[ScionSessionName].(*models.Session); ok &&
+		userSession.HasLoggedIn {
+		stored.SetUserID(userSession.UserID)
+	}
+	if err := stored.Save(); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, newCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func newCookie(name, value string, options *sessions.Options) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+	}
+	if options.MaxAge > 0 {
+		c.Expires = time.Now().Add(time.Duration(options.MaxAge) * time.Second)
+	} else if options.MaxAge < 0 {
+		c.Expires = time.Unix(1, 0)
+	}
+	return c
+}
+
+// RotateSession gives the session a new key when it is saved next, e.g. after a login, so that
+// a key known before cannot be used anymore
+func RotateSession(session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+	if err := models.DeleteWebSession(session.ID); err != nil {
+		return err
+	}
+	session.ID = ""
+	return nil
+}
+
+// SourceIP returns the address the request was sent from
+func SourceIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// DeleteExpiredSessionsPeriodically removes expired sessions from the database every
+// sessionCleanupPeriod. It is meant to be run as a goroutine.
+func DeleteExpiredSessionsPeriodically(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	for {
+		if n, err := models.DeleteExpiredWebSessions(time.Now()); err != nil {
+			log.Errorf("Error deleting expired sessions: %v", err)
+		} else if n > 0 {
+			log.Infof("Deleted %v expired sessions", n)
+		}
+		time.Sleep(sessionCleanupPeriod)
+	}
+}
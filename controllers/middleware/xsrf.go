@@ -16,20 +16,21 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/hex"
 	"errors"
-	"log"
 	"net/http"
 
 	"github.com/gorilla/sessions"
 	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
 )
 
 var (
-	store = sessions.NewFilesystemStore(config.SessionPath,
+	store = NewDBStore(config.SessionLifetime*24*60*60,
 		[]byte(config.SessionVerificationKey), []byte(config.SessionEncryptionKey)) // random value to validate the session
 	sessionName              = "session"                                           // cookie name
 	ScionSessionName         = "scion-session"                                     // key name in the session map
@@ -44,6 +45,7 @@ var (
 
 func XSRFHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := logger.FromContext(r.Context())
 
 		// Get a session. If a session does not exist, a new one is generated.
 		// If a client session exists, but the server does not know about it, then an error is raised
@@ -53,7 +55,7 @@ func XSRFHandler(next http.Handler) http.Handler {
 		// if it's a new session then generate a new scion-coord session, with an XSRF Token
 		if session != nil && session.IsNew || err != nil {
 			// in this case the token was not present, because it's a new session
-			token, err := generateXSRFToken()
+			token, err := generateXSRFToken(r.Context())
 
 			// in case of error generating the token, return - logging is provided by the generateXSRFToken function
 			if err != nil {
@@ -70,7 +72,7 @@ func XSRFHandler(next http.Handler) http.Handler {
 			session.Values[ScionSessionName] = newSession
 			// save the session status
 			if err := session.Save(r, w); err != nil {
-				log.Println("Error while saving the session", err)
+				log.Errorf("Error while saving the session %v", err)
 				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			}
 
@@ -117,7 +119,7 @@ func XSRFHandler(next http.Handler) http.Handler {
 
 					// parse the values coming from the request
 					if err := r.ParseForm(); err != nil {
-						log.Println("XSRF handler, error parsing form values", err)
+						log.Errorf("XSRF handler, error parsing form values %v", err)
 					}
 
 					if subtle.ConstantTimeCompare([]byte(r.FormValue(formXSRFParameter)), []byte(token)) == 1 {
@@ -140,11 +142,12 @@ func XSRFHandler(next http.Handler) http.Handler {
 	})
 }
 
-func generateXSRFToken() (string, error) {
+func generateXSRFToken(ctx context.Context) (string, error) {
+	log := logger.FromContext(ctx)
 	buffer := make([]byte, tokenSize)
 	_, err := rand.Read(buffer)
 	if err != nil {
-		log.Println("XSRF Token generation error:", err)
+		log.Errorf("XSRF Token generation error: %v", err)
 		return "", err
 	}
 	// The slice should now contain random bytes instead of only zeroes.
@@ -165,7 +168,7 @@ func GetUserSession(r *http.Request) (*sessions.Session, *models.Session, error)
 	requestSession, err := store.Get(r, sessionName)
 	if err != nil {
 		return nil, nil, errors.New("could not get or generate a new session. " +
-			"Most likely a session configuration problem. Check the session path if it exists")
+			"Most likely a session configuration problem. Check the session keys and the database")
 	}
 
 	// this should always be true, but just in case double check it
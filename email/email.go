@@ -17,13 +17,14 @@ package email
 
 import (
 	"bytes"
+	"context"
 	"html/template"
-	"log"
 	"path/filepath"
 	"strings"
 
 	"github.com/keighl/postmark"
 	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/logger"
 )
 
 const mailTemplatesPath = "email/templates"
@@ -59,10 +60,11 @@ func construct(subject, body string, to []string) *Email {
 // constructFromTemplate builds an email to the user specified by
 // their userEmail by filling in the specified template with the given subject
 // and information in the data object
-func constructFromTemplate(emailTemplate, subject string, data interface{}, tag, userEmail string) (*Email, error) {
+func constructFromTemplate(ctx context.Context, emailTemplate, subject string, data interface{}, tag, userEmail string) (*Email, error) {
+	log := logger.FromContext(ctx)
 	tmpl, err := template.ParseFiles(MailTemplatePath(emailTemplate))
 	if err != nil {
-		log.Printf("Parsing template %v failed: %v", emailTemplate, err)
+		log.Errorf("Parsing template %v failed: %v", emailTemplate, err)
 		return nil, err
 	}
 	buf := new(bytes.Buffer)
@@ -71,7 +73,8 @@ func constructFromTemplate(emailTemplate, subject string, data interface{}, tag,
 }
 
 // Send connects to the PostMark email API and sends the email
-func Send(mail *Email) error {
+func Send(ctx context.Context, mail *Email) error {
+	log := logger.FromContext(ctx)
 	client := postmark.NewClient(config.EmailPMServerToken, config.EmailPMAccountToken)
 
 	email := postmark.Email{
@@ -86,7 +89,7 @@ func Send(mail *Email) error {
 	_, err := client.SendEmail(email)
 
 	if err != nil {
-		log.Printf("Error sending email via PostMark to: %v, %v", email.To, err)
+		log.Errorf("Error sending email via PostMark to: %v, %v", email.To, err)
 		return err
 	}
 
@@ -94,24 +97,25 @@ func Send(mail *Email) error {
 }
 
 // ConstructFromTemplateAndSend builds and then sends an email. It relies on Construct and Send
-func ConstructFromTemplateAndSend(emailTemplate string, subject string, data interface{},
-	tag string, userEmail string, alsoToAdmins bool) error {
+func ConstructFromTemplateAndSend(ctx context.Context, emailTemplate string, subject string,
+	data interface{}, tag string, userEmail string, alsoToAdmins bool) error {
+	log := logger.FromContext(ctx)
 
-	mail, err := constructFromTemplate(emailTemplate, subject, data, tag, userEmail)
+	mail, err := constructFromTemplate(ctx, emailTemplate, subject, data, tag, userEmail)
 	if err != nil {
-		log.Printf("ConstructAndSend failed in Construct: %v", err)
+		log.Errorf("ConstructAndSend failed in Construct: %v", err)
 		return err
 	}
-	if err = Send(mail); err != nil {
-		log.Printf("Sending email to %v failed: %v", userEmail, err)
+	if err = Send(ctx, mail); err != nil {
+		log.Errorf("Sending email to %v failed: %v", userEmail, err)
 		return err
 	}
 
 	if alsoToAdmins {
 		mail.To = config.EmailAdmins
 		mail.Subject = "[SCIONLab ADMIN]" + mail.Subject
-		if err = Send(mail); err != nil {
-			log.Printf("Sending email to %v failed: %v", config.EmailAdmins, err)
+		if err = Send(ctx, mail); err != nil {
+			log.Errorf("Sending email to %v failed: %v", config.EmailAdmins, err)
 			return err
 		}
 	}
@@ -120,22 +124,23 @@ func ConstructFromTemplateAndSend(emailTemplate string, subject string, data int
 }
 
 // ConstructFromTemplateAndSendToAdmins builds and sends an email to the ScionLab admins
-func ConstructFromTemplateAndSendToAdmins(emailTemplate string, subject string, data interface{}, tag string) error {
-	mail, err := constructFromTemplate(emailTemplate, subject, data, tag, "")
+func ConstructFromTemplateAndSendToAdmins(ctx context.Context, emailTemplate string, subject string, data interface{}, tag string) error {
+	log := logger.FromContext(ctx)
+	mail, err := constructFromTemplate(ctx, emailTemplate, subject, data, tag, "")
 	if err != nil {
-		log.Printf("ConstructAndSend failed in Construct: %v", err)
+		log.Errorf("ConstructAndSend failed in Construct: %v", err)
 		return err
 	}
 	mail.To = config.EmailAdmins
-	if err = Send(mail); err != nil {
-		log.Printf("Sending email to %v failed: %v", config.EmailAdmins, err)
+	if err = Send(ctx, mail); err != nil {
+		log.Errorf("Sending email to %v failed: %v", config.EmailAdmins, err)
 		return err
 	}
 	return nil
 }
 
 // SendEmailToAdmins sends an email to the SCIONLab administrators
-func SendEmailToAdmins(subject, body string) error {
+func SendEmailToAdmins(ctx context.Context, subject, body string) error {
 	mail := construct(subject, body, config.EmailAdmins)
-	return Send(mail)
+	return Send(ctx, mail)
 }
@@ -0,0 +1,231 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logger writes log entries as JSON lines with a time, a level, a message and the fields
+// of the logger, e.g. the ID of the request the entry belongs to. The logger of a request is
+// carried through its context.Context, so that the functions serving it log with its fields.
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+// Levels of log entries, from the least to the most severe
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the name, ignoring case
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(name), n) {
+			return Level(l), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of %v", name, levelNames)
+}
+
+// sink is the destination shared by a logger and the loggers derived from it
+type sink struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level Level
+}
+
+// Logger writes entries of at least its level with its fields
+type Logger struct {
+	sink   *sink
+	fields map[string]interface{}
+}
+
+var std = New(os.Stdout, LevelInfo)
+
+// New returns a logger writing the entries of at least the level to out
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{sink: &sink{out: out, level: level}}
+}
+
+// Default returns the logger used outside of requests and by FromContext if a context has none
+func Default() *Logger {
+	return std
+}
+
+// SetOutput sets the destination of the default logger and the loggers derived from it
+func SetOutput(out io.Writer) {
+	std.sink.mu.Lock()
+	defer std.sink.mu.Unlock()
+	std.sink.out = out
+}
+
+// SetLevel sets the lowest level written by the default logger and the loggers derived from it
+func SetLevel(level Level) {
+	std.sink.mu.Lock()
+	defer std.sink.mu.Unlock()
+	std.sink.level = level
+}
+
+// With returns a logger adding the field to the entries of l. It shares the destination and
+// the level with l.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make(map[string]interface{}, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return &Logger{sink: l.sink, fields: fields}
+}
+
+// Enabled returns whether entries of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	return level >= l.sink.level
+}
+
+// Debugf writes an entry with level debug
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args...)
+}
+
+// Infof writes an entry with level info
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args...)
+}
+
+// Warnf writes an entry with level warn
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args...)
+}
+
+// Errorf writes an entry with level error
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, format, args...)
+}
+
+// Fatalf writes an entry with level error and exits
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(LevelError, format, args...)
+	os.Exit(1)
+}
+
+// Writer returns a writer logging every line written to it as an entry of the level, e.g. as
+// output of the standard library logger
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{logger: l, level: level}
+}
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	entry := make(map[string]interface{}, len(l.fields)+3)
+	for k, v := range l.fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{
+			"time":  entry["time"].(string),
+			"level": level.String(),
+			"msg":   fmt.Sprintf("%v (fields: %v)", entry["msg"], err),
+		})
+	}
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	l.sink.out.Write(append(line, '\n'))
+}
+
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		w.logger.log(w.level, "%s", line)
+	}
+	return len(p), nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by the context, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return std
+}
+
+// Debugf writes an entry with level debug to the default logger
+func Debugf(format string, args ...interface{}) {
+	std.log(LevelDebug, format, args...)
+}
+
+// Infof writes an entry with level info to the default logger
+func Infof(format string, args ...interface{}) {
+	std.log(LevelInfo, format, args...)
+}
+
+// Warnf writes an entry with level warn to the default logger
+func Warnf(format string, args ...interface{}) {
+	std.log(LevelWarn, format, args...)
+}
+
+// Errorf writes an entry with level error to the default logger
+func Errorf(format string, args ...interface{}) {
+	std.log(LevelError, format, args...)
+}
+
+// Fatalf writes an entry with level error to the default logger and exits
+func Fatalf(format string, args ...interface{}) {
+	std.log(LevelError, format, args...)
+	os.Exit(1)
+}
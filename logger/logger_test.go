@@ -0,0 +1,110 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+// entries decodes the JSON lines written to buf
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid entry %q: %v", line, err)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestLevels(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := New(buf, LevelInfo)
+	l.Debugf("hidden")
+	l.Infof("shown %d", 1)
+	l.Errorf("failed: %v", errors.New("boom"))
+	e := entries(t, buf)
+	if len(e) != 2 {
+		t.Fatalf("%d entries written, expected 2: %v", len(e), e)
+	}
+	if e[0]["level"] != "info" || e[0]["msg"] != "shown 1" {
+		t.Errorf("unexpected entry %v", e[0])
+	}
+	if e[1]["level"] != "error" || e[1]["msg"] != "failed: boom" {
+		t.Errorf("unexpected entry %v", e[1])
+	}
+	if _, ok := e[0]["time"]; !ok {
+		t.Errorf("entry without time: %v", e[0])
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{
+		"debug": LevelDebug, "INFO": LevelInfo, " warn": LevelWarn, "Error": LevelError,
+	} {
+		level, err := ParseLevel(name)
+		if err != nil || level != expected {
+			t.Errorf("ParseLevel(%q) = %v, %v; expected %v", name, level, err, expected)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel accepted an unknown level")
+	}
+}
+
+func TestFieldsAndContext(t *testing.T) {
+	buf := new(bytes.Buffer)
+	base := New(buf, LevelDebug)
+	l := base.With("request_id", "abc").With("err", errors.New("boom"))
+	ctx := NewContext(context.Background(), l)
+	FromContext(ctx).Debugf("in request")
+	base.Infof("outside")
+
+	e := entries(t, buf)
+	if len(e) != 2 {
+		t.Fatalf("%d entries written, expected 2: %v", len(e), e)
+	}
+	if e[0]["request_id"] != "abc" || e[0]["err"] != "boom" {
+		t.Errorf("fields missing in %v", e[0])
+	}
+	if _, ok := e[1]["request_id"]; ok {
+		t.Errorf("field of derived logger added to %v", e[1])
+	}
+	if FromContext(context.Background()) != Default() {
+		t.Error("FromContext without logger does not return the default logger")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	std := log.New(New(buf, LevelInfo).Writer(LevelWarn), "", 0)
+	std.Printf("first\nsecond")
+	e := entries(t, buf)
+	if len(e) != 2 || e[0]["msg"] != "first" || e[1]["msg"] != "second" ||
+		e[1]["level"] != "warn" {
+		t.Errorf("unexpected entries %v", e)
+	}
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -31,6 +32,7 @@ import (
 	"github.com/netsec-ethz/scion-coord/controllers"
 	"github.com/netsec-ethz/scion-coord/controllers/api"
 	"github.com/netsec-ethz/scion-coord/controllers/middleware"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
 	"github.com/scionproto/scion/go/lib/addr"
 	"golang.org/x/crypto/acme/autocert"
@@ -92,6 +94,11 @@ func checkCredentialsDirectories() error {
 	return nil
 }
 
+// jobContext returns the context of a background job, whose log entries name the job
+func jobContext(job string) context.Context {
+	return logger.NewContext(context.Background(), logger.Default().With("job", job))
+}
+
 func main() {
 	for _, v := range os.Args {
 		if v == "--help" {
@@ -100,18 +107,43 @@ func main() {
 			return
 		}
 	}
+	// third-party packages logging with the standard logger write entries as well
+	log.SetFlags(0)
+	log.SetOutput(logger.Default().Writer(logger.LevelInfo))
+
 	if err := initializeISD(); err != nil {
-		fmt.Printf("There was an error updating"+
+		logger.Errorf("There was an error updating"+
 			" the ISD location mapping in the database: %v", err)
 		return
 	}
 
+	// create the built-in roles; on the first start, existing admins and AP operators obtain them
+	if err := models.InitializeRoles(); err != nil {
+		logger.Errorf("There was an error creating the roles in the database: %v", err)
+		return
+	}
+
+	// ASes created before they were owned by accounts obtain the account of their contact
+	if err := models.AssignASAccounts(); err != nil {
+		logger.Errorf("There was an error assigning the ASes to their accounts: %v", err)
+		return
+	}
+
 	// check if credential files exist and create necessary directories
 	if err := checkCredentialsDirectories(); err != nil {
-		fmt.Printf("There was an error checking credential files: %v", err)
+		logger.Errorf("There was an error checking credential files: %v", err)
 		return
 	}
 
+	// renew AS certificates before they expire
+	go api.RenewCertificatesPeriodically(jobContext("cert_renewal"))
+
+	// remove expired sessions of the web interface
+	go middleware.DeleteExpiredSessionsPeriodically(jobContext("session_cleanup"))
+
+	// delete the users who asked for it once their ASes are removed
+	go api.FinishUserDeletionsPeriodically(jobContext("user_deletion"))
+
 	// controllers
 	registrationController := api.RegistrationController{}
 	loginController := api.LoginController{}
@@ -126,10 +158,21 @@ func main() {
 	resendLimit := tollbooth.NewLimiter(1, time.Minute*10,
 		&limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
 	resendLimit.SetOnLimitReached(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Blocked %v from accessing '/api/resendLink' because of reached rate limit",
+		logger.FromContext(r.Context()).Warnf(
+			"Blocked %v from accessing '/api/resendLink' because of reached rate limit",
 			w.Header().Get("X-Rate-Limit-Request-Remote-Addr"))
 	})
 	resendLimit.SetMessage("You can request an email every 10 minutes")
+	// the failed logins are throttled per user and address in the login controller, this only
+	// limits the rate of login requests per address
+	loginLimit := tollbooth.NewLimiter(10, time.Minute,
+		&limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
+	loginLimit.SetOnLimitReached(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Warnf(
+			"Blocked %v from accessing '/api/login' because of reached rate limit",
+			w.Header().Get("X-Rate-Limit-Request-Remote-Addr"))
+	})
+	loginLimit.SetMessage("Too many login attempts, please try again in a minute")
 
 	// router
 	router := mux.NewRouter()
@@ -143,11 +186,25 @@ func main() {
 	// account_id.secret combination
 	apiChain := middleware.NewWithLogging(middleware.AuthHandler)
 
+	// The AP and AS chains additionally restrict requests authenticated with a personal access
+	// token to tokens with the corresponding scope. The AP endpoints are reserved to AP operators.
+	apChain := apiChain.Append(middleware.RequireScope(models.ScopeAP),
+		middleware.RequirePermission(models.PermAPSync))
+	asChain := apiChain.Append(middleware.RequireScope(models.ScopeAS))
+
 	// User chain goes through UserHandler which checks if the user is logged in
 	userChain := middleware.NewWithLogging(middleware.UserHandler)
 
-	// Admin chain goes through AdminHandler which checks if user is marked as admin
-	adminChain := middleware.NewWithLogging(middleware.AdminHandler)
+	// Permission chains check that the logged-in user has one of the permissions through a role,
+	// see models.AllPermissions
+	permissionChain := func(perms ...string) middleware.Chain {
+		return userChain.Append(middleware.RequirePermission(perms...))
+	}
+	usersChain := permissionChain(models.PermUsersManage)
+	asReadChain := permissionChain(models.PermASRead)
+	rolesChain := permissionChain(models.PermRolesManage)
+	// the admin page is shown to users with any permission, its sections check their own
+	adminPageChain := permissionChain(models.AllPermissions...)
 
 	// handle favicon requests
 	router.Handle("/favicon.ico", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -175,7 +232,16 @@ func main() {
 		registrationController.ResetPassword))).Methods(http.MethodPost)
 
 	// user login
-	router.Handle("/api/login", loggingChain.ThenFunc(loginController.Login))
+	router.Handle("/api/login", tollbooth.LimitHandler(loginLimit, loggingChain.ThenFunc(
+		loginController.Login)))
+	router.Handle("/api/login/twoFactor", tollbooth.LimitHandler(loginLimit, loggingChain.ThenFunc(
+		loginController.LoginSecondFactor))).Methods(http.MethodPost)
+	router.Handle("/api/login/oidc", loggingChain.ThenFunc(
+		loginController.OIDCProviders)).Methods(http.MethodGet)
+	router.Handle("/api/login/oidc/{provider}", tollbooth.LimitHandler(loginLimit,
+		xsrfChain.ThenFunc(loginController.OIDCLogin))).Methods(http.MethodGet)
+	router.Handle("/api/login/oidc/{provider}/callback", tollbooth.LimitHandler(loginLimit,
+		loggingChain.ThenFunc(loginController.OIDCCallback))).Methods(http.MethodGet)
 
 	// user Logout
 	router.Handle("/api/logout", loggingChain.ThenFunc(loginController.Logout))
@@ -187,18 +253,128 @@ func main() {
 	router.Handle("/api/changePassword", userChain.ThenFunc(
 		userController.ChangePassword)).Methods(http.MethodPost)
 
+	// export of the personal data and deletion of the account by logged-in users
+	router.Handle("/api/user/export", userChain.ThenFunc(
+		userController.ExportData)).Methods(http.MethodGet)
+	router.Handle("/api/user/delete", userChain.ThenFunc(
+		userController.DeleteAccount)).Methods(http.MethodPost)
+
+	// change of the email address by logged-in users, confirmed through a link sent to the new
+	// address
+	router.Handle("/api/user/email", userChain.ThenFunc(
+		userController.RequestEmailChange)).Methods(http.MethodPost)
+
+	// two-factor authentication of logged-in users
+	router.Handle("/api/twoFactor", userChain.ThenFunc(
+		userController.TwoFactorStatus)).Methods(http.MethodGet)
+	router.Handle("/api/twoFactor/enrol", userChain.ThenFunc(
+		userController.EnrolTwoFactor)).Methods(http.MethodPost)
+	router.Handle("/api/twoFactor/enable", userChain.ThenFunc(
+		userController.EnableTwoFactor)).Methods(http.MethodPost)
+	router.Handle("/api/twoFactor/recoveryCodes", userChain.ThenFunc(
+		userController.RegenerateRecoveryCodes)).Methods(http.MethodPost)
+	router.Handle("/api/twoFactor/disable", userChain.ThenFunc(
+		userController.DisableTwoFactor)).Methods(http.MethodPost)
+
+	// rotation of the account secret by logged-in users
+	router.Handle("/api/rotateSecret", userChain.ThenFunc(
+		userController.RotateSecret)).Methods(http.MethodPost)
+
+	// personal access tokens of logged-in users
+	router.Handle("/api/accessTokens", userChain.ThenFunc(
+		userController.AccessTokens)).Methods(http.MethodGet)
+	router.Handle("/api/accessTokens", userChain.ThenFunc(
+		userController.CreateAccessToken)).Methods(http.MethodPost)
+	router.Handle("/api/accessTokens/{id}", userChain.ThenFunc(
+		userController.RevokeAccessToken)).Methods(http.MethodDelete)
+
+	// sessions of logged-in users
+	router.Handle("/api/sessions", userChain.ThenFunc(
+		userController.Sessions)).Methods(http.MethodGet)
+	router.Handle("/api/sessions/{id}", userChain.ThenFunc(
+		userController.RevokeSession)).Methods(http.MethodDelete)
+	router.Handle("/api/logoutEverywhere", userChain.ThenFunc(
+		userController.LogoutEverywhere)).Methods(http.MethodPost)
+
+	// accounts shared with other users
+	router.Handle("/api/accounts", userChain.ThenFunc(
+		userController.Accounts)).Methods(http.MethodGet)
+	router.Handle("/api/accounts/{account}/invitations", userChain.ThenFunc(
+		userController.InviteMember)).Methods(http.MethodPost)
+	router.Handle("/api/accounts/{account}/members/{email}", userChain.ThenFunc(
+		userController.SetMemberRole)).Methods(http.MethodPut)
+	router.Handle("/api/accounts/{account}/members/{email}", userChain.ThenFunc(
+		userController.RemoveMember)).Methods(http.MethodDelete)
+	router.Handle("/api/invitations/{id}/accept", userChain.ThenFunc(
+		userController.AcceptInvitation)).Methods(http.MethodPost)
+	router.Handle("/api/invitations/{id}", userChain.ThenFunc(
+		userController.DeleteInvitation)).Methods(http.MethodDelete)
+
 	// email validation
 	router.Handle("/api/verifyEmail/{uuid}", loggingChain.ThenFunc(
 		registrationController.VerifyEmail))
+	router.Handle("/api/confirmEmailChange/{uuid}", loggingChain.ThenFunc(
+		registrationController.ConfirmEmailChange)).Methods(http.MethodGet)
 
 	// set password after pre-approved registration or password reset
 	router.Handle("/api/setPassword", loggingChain.ThenFunc(
 		registrationController.SetPassword)).Methods(http.MethodPost)
 
 	// admin page
-	router.Handle("/api/adminPageData", adminChain.ThenFunc(adminController.AdminInformation))
-	router.Handle("/api/sendInvitations", adminChain.ThenFunc(
+	router.Handle("/api/adminPageData", adminPageChain.ThenFunc(adminController.AdminInformation))
+	router.Handle("/api/sendInvitations", usersChain.ThenFunc(
 		adminController.SendInvitationEmails)).Methods(http.MethodPost)
+	router.Handle("/api/admin/certExpirations", asReadChain.ThenFunc(
+		adminController.CertificateExpirations)).Methods(http.MethodGet)
+	router.Handle("/api/admin/vpnPools", asReadChain.ThenFunc(
+		adminController.VPNPools)).Methods(http.MethodGet)
+	router.Handle("/api/admin/rotateSecret/{account_id}", usersChain.ThenFunc(
+		adminController.RotateAccountSecret)).Methods(http.MethodPost)
+	router.Handle("/api/admin/lockedUsers", usersChain.ThenFunc(
+		adminController.LockedUsers)).Methods(http.MethodGet)
+	router.Handle("/api/admin/unlockUser", usersChain.ThenFunc(
+		adminController.UnlockUser)).Methods(http.MethodPost)
+	router.Handle("/api/admin/sessions/{email}", usersChain.ThenFunc(
+		adminController.UserSessions)).Methods(http.MethodGet)
+	router.Handle("/api/admin/sessions/{email}", usersChain.ThenFunc(
+		adminController.RevokeUserSessions)).Methods(http.MethodDelete)
+	router.Handle("/api/admin/users", usersChain.ThenFunc(
+		adminController.Users)).Methods(http.MethodGet)
+	router.Handle("/api/admin/users/{email}", usersChain.ThenFunc(
+		adminController.User)).Methods(http.MethodGet)
+	router.Handle("/api/admin/users/{email}/disabled", usersChain.ThenFunc(
+		adminController.SetUserDisabled)).Methods(http.MethodPut)
+	router.Handle("/api/admin/users/{email}/resetPassword", usersChain.ThenFunc(
+		adminController.ResetUserPassword)).Methods(http.MethodPost)
+	router.Handle("/api/admin/users/{email}/resendVerification", usersChain.ThenFunc(
+		adminController.ResendUserVerification)).Methods(http.MethodPost)
+	// promoting users to admins is reserved to those who can assign roles
+	router.Handle("/api/admin/users/{email}/admin", rolesChain.ThenFunc(
+		adminController.SetUserAdmin)).Methods(http.MethodPut)
+	router.Handle("/api/admin/actions", usersChain.ThenFunc(
+		adminController.AdminActions)).Methods(http.MethodGet)
+	router.Handle("/api/admin/quotas", usersChain.ThenFunc(
+		adminController.ASQuotas)).Methods(http.MethodGet)
+	router.Handle("/api/admin/users/{email}/quota", usersChain.ThenFunc(
+		adminController.SetUserASQuota)).Methods(http.MethodPut)
+	router.Handle("/api/admin/users/{email}/quota", usersChain.ThenFunc(
+		adminController.ResetUserASQuota)).Methods(http.MethodDelete)
+	router.Handle("/api/admin/accounts/{account_id}/quota", usersChain.ThenFunc(
+		adminController.SetAccountASQuota)).Methods(http.MethodPut)
+	router.Handle("/api/admin/accounts/{account_id}/quota", usersChain.ThenFunc(
+		adminController.ResetAccountASQuota)).Methods(http.MethodDelete)
+	router.Handle("/api/admin/roles", rolesChain.ThenFunc(
+		adminController.Roles)).Methods(http.MethodGet)
+	router.Handle("/api/admin/roles", rolesChain.ThenFunc(
+		adminController.AssignRole)).Methods(http.MethodPost)
+	router.Handle("/api/admin/roles/{id}", rolesChain.ThenFunc(
+		adminController.RevokeRole)).Methods(http.MethodDelete)
+	router.Handle("/api/admin/packageContents/{ia}/{version}", asReadChain.ThenFunc(
+		scionLabASController.PackageContents)).Methods(http.MethodGet)
+	router.Handle("/api/admin/packageDiff/{ia}/{from}/{to}", asReadChain.ThenFunc(
+		scionLabASController.PackageDiff)).Methods(http.MethodGet)
+	router.Handle("/api/admin/packageVersions/{ia}", asReadChain.ThenFunc(
+		scionLabASController.PackageVersions)).Methods(http.MethodGet)
 
 	// generates a SCIONLab AS
 	// TODO(ercanucan): fix the authentication
@@ -210,69 +386,89 @@ func main() {
 		scionLabASController.RemoveSCIONLabAS))
 	router.Handle("/api/as/downloadTarball/{as_id}", userChain.ThenFunc(
 		scionLabASController.ReturnTarball))
+	router.Handle("/api/as/packageContents/{as_id}/{version}", userChain.ThenFunc(
+		scionLabASController.PackageContents)).Methods(http.MethodGet)
+	router.Handle("/api/as/packageDiff/{as_id}/{from}/{to}", userChain.ThenFunc(
+		scionLabASController.PackageDiff)).Methods(http.MethodGet)
+	router.Handle("/api/as/packageVersions/{as_id}", userChain.ThenFunc(
+		scionLabASController.PackageVersions)).Methods(http.MethodGet)
+	router.Handle("/api/as/rollbackAS/{as_id}/{version}", userChain.ThenFunc(
+		scionLabASController.RollbackSCIONLabAS)).Methods(http.MethodPost)
 	router.Handle("/api/as/remapId/{ia}", loggingChain.ThenFunc(
 		scionLabASController.RemapASIdentityChallengeAndSolution)).Methods(http.MethodGet, http.MethodPost)
 	router.Handle("/api/as/remapIdDownloadGen/{ia}", loggingChain.ThenFunc(
 		scionLabASController.RemapASDownloadGen)).Methods(http.MethodPost)
 	router.Handle("/api/as/remapIdConfirmStatus/{ia}", loggingChain.ThenFunc(
 		scionLabASController.RemapASConfirmStatus)).Methods(http.MethodPost)
-	router.Handle("/api/as/getUpdatesForAP/{account_id}/{secret}",
-		apiChain.ThenFunc(scionLabASController.GetUpdatesForAP))
-	router.Handle("/api/as/confirmUpdatesFromAP/{account_id}/{secret}",
-		apiChain.ThenFunc(scionLabASController.ConfirmUpdatesFromAP))
+	// the AP and AS endpoints are also served without account_id and secret in the path for
+	// requests authenticated with a personal access token
+	handleWithToken := func(chain middleware.Chain, path, suffix string, h http.HandlerFunc,
+		methods ...string) {
+		for _, p := range []string{path + "/{account_id}/{secret}" + suffix, path + suffix} {
+			route := router.Handle(p, chain.ThenFunc(h))
+			if len(methods) > 0 {
+				route.Methods(methods...)
+			}
+		}
+	}
+	handleWithToken(apChain, "/api/as/getUpdatesForAP", "",
+		scionLabASController.GetUpdatesForAP)
+	handleWithToken(apChain, "/api/as/confirmUpdatesFromAP", "",
+		scionLabASController.ConfirmUpdatesFromAP)
 	// full synchronization (not only pending changes) for the APs:
-	router.Handle("/api/as/getConnectionsForAP/{account_id}/{secret}",
-		apiChain.ThenFunc(scionLabASController.GetConnectionsForAP))
-	router.Handle("/api/as/setConnectionsForAP/{account_id}/{secret}",
-		apiChain.ThenFunc(scionLabASController.SetConnectionsForAP))
+	handleWithToken(apChain, "/api/as/getConnectionsForAP", "",
+		scionLabASController.GetConnectionsForAP)
+	handleWithToken(apChain, "/api/as/setConnectionsForAP", "",
+		scionLabASController.SetConnectionsForAP)
 	// upgrade related calls:
-	router.Handle("/api/as/queryUpdateBranch/{account_id}/{secret}",
-		apiChain.ThenFunc(scionLabASController.QueryUpdateBranch))
-	router.Handle("/api/as/confirmUpdate/{account_id}/{secret}",
-		apiChain.ThenFunc(scionLabASController.ConfirmUpdate)).Methods(http.MethodPost)
-	router.Handle("/api/as/getASData/{account_id}/{secret}/{ia}",
-		apiChain.ThenFunc(scionLabASController.GetASData))
+	handleWithToken(asChain, "/api/as/queryUpdateBranch", "",
+		scionLabASController.QueryUpdateBranch)
+	handleWithToken(asChain, "/api/as/confirmUpdate", "",
+		scionLabASController.ConfirmUpdate, http.MethodPost)
+	handleWithToken(asChain, "/api/as/getASData", "/{ia}", scionLabASController.GetASData)
+	handleWithToken(apChain, "/api/as/getVPNCRL", "", scionLabASController.GetVPNCRL)
+	handleWithToken(apChain, "/api/as/getVPNServerConfig", "",
+		scionLabASController.GetVPNServerConfig)
 
 	//SCIONBox API
 	router.Handle("/api/as/initBox", loggingChain.ThenFunc(scionBoxController.InitializeBox))
-	router.Handle("/api/as/connectBox/{account_id}/{secret}", apiChain.ThenFunc(
+	router.Handle("/api/as/connectBox/{account_id}/{secret}", asChain.ThenFunc(
 		scionBoxController.ConnectNewBox))
-	router.Handle("/api/as/heartbeat/{account_id}/{secret}", apiChain.ThenFunc(
-		scionBoxController.HeartBeatFunction))
+	handleWithToken(asChain, "/api/as/heartbeat", "", scionBoxController.HeartBeatFunction)
 
 	// ==========================================================
 	// SCION Web API
 
-	router.Handle("/api/as/exists/{as_id}/{account_id}/{secret}", apiChain.ThenFunc(
+	router.Handle("/api/as/exists/{as_id}/{account_id}/{secret}", asChain.ThenFunc(
 		asController.Exists))
 
 	// ISD join request
-	router.Handle("/api/as/uploadJoinRequest/{account_id}/{secret}", apiChain.ThenFunc(
+	router.Handle("/api/as/uploadJoinRequest/{account_id}/{secret}", asChain.ThenFunc(
 		asController.UploadJoinRequest))
-	router.Handle("/api/as/uploadJoinReply/{account_id}/{secret}", apiChain.ThenFunc(
+	router.Handle("/api/as/uploadJoinReply/{account_id}/{secret}", asChain.ThenFunc(
 		asController.UploadJoinReply))
-	router.Handle("/api/as/pollJoinReply/{account_id}/{secret}", apiChain.ThenFunc(
+	router.Handle("/api/as/pollJoinReply/{account_id}/{secret}", asChain.ThenFunc(
 		asController.PollJoinReply))
 
 	// AS connection request
-	router.Handle("/api/as/uploadConnRequest/{account_id}/{secret}", apiChain.ThenFunc(
+	router.Handle("/api/as/uploadConnRequest/{account_id}/{secret}", asChain.ThenFunc(
 		asController.UploadConnRequest))
-	router.Handle("/api/as/uploadConnReply/{account_id}/{secret}", apiChain.ThenFunc(
+	router.Handle("/api/as/uploadConnReply/{account_id}/{secret}", asChain.ThenFunc(
 		asController.UploadConnReply))
 
 	// show all request TO this AS
-	router.Handle("/api/as/pollEvents/{account_id}/{secret}", apiChain.ThenFunc(
+	router.Handle("/api/as/pollEvents/{account_id}/{secret}", asChain.ThenFunc(
 		asController.PollEvents))
 
 	// list the ASes the requesting AS can connect to
-	router.Handle("/api/as/listASes/{account_id}/{secret}", apiChain.ThenFunc(
+	router.Handle("/api/as/listASes/{account_id}/{secret}", asChain.ThenFunc(
 		asController.ListASes))
 
 	// ==========================================================
 	// Virtual currency API
 
 	router.Handle("/api/listASConnections/{account_id}/{secret}/{ia}",
-		apiChain.ThenFunc(asController.ListASesConnectionsWithCredits))
+		asChain.ThenFunc(asController.ListASesConnectionsWithCredits))
 
 	// ==========================================================
 	// Image building API
@@ -290,9 +486,12 @@ func main() {
 	static := http.StripPrefix("/public/", http.FileServer(http.Dir("public")))
 	router.PathPrefix("/public/").Handler(xsrfChain.Then(static))
 
+	// every request is assigned an ID, also those rejected before reaching a chain
+	handler := middleware.RequestID(router)
+
 	// serve website using https or standard http
 	if config.HTTPEnableHTTPS {
-		fmt.Printf("Serving website on %v over HTTPS\n", config.HTTPHostAddress)
+		logger.Infof("Serving website on %v over HTTPS", config.HTTPHostAddress)
 		// redirect HTTP traffic to HTTPS
 		go http.ListenAndServe(":80", http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
@@ -300,11 +499,11 @@ func main() {
 			}))
 
 		// listen to HTTPS requests
-		log.Fatal(http.Serve(autocert.NewListener(config.HTTPHostAddress), router))
+		logger.Fatalf("%v", http.Serve(autocert.NewListener(config.HTTPHostAddress), handler))
 	} else {
-		fmt.Printf("Serving website on %v over HTTP\n", config.HTTPHostAddress)
-		log.Fatal(http.ListenAndServe(fmt.Sprintf("%s:%d",
-			config.HTTPBindAddress, config.HTTPBindPort), router))
+		logger.Infof("Serving website on %v over HTTP", config.HTTPHostAddress)
+		logger.Fatalf("%v", http.ListenAndServe(fmt.Sprintf("%s:%d",
+			config.HTTPBindAddress, config.HTTPBindPort), handler))
 	}
 
 }
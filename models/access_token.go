@@ -0,0 +1,182 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Scopes of personal access tokens
+const (
+	ScopeAP = "ap" // synchronization of the attachment points of the account
+	ScopeAS = "as" // updates of the ASes of the account
+)
+
+// AccessTokenScopes are all scopes a personal access token can have
+var AccessTokenScopes = []string{ScopeAP, ScopeAS}
+
+const (
+	// AccessTokenPrefix starts every personal access token, so that leaked tokens can be found
+	AccessTokenPrefix = "sct_"
+	accessTokenLength = 32
+	// the last use is only recorded with this precision to avoid a DB write for every request
+	lastUsedPrecision = time.Minute
+)
+
+// ErrAccessTokenExpired is returned by FindAccessToken for expired tokens
+var ErrAccessTokenExpired = errors.New("access token expired")
+
+// AccessToken is a personal access token authenticating API requests on behalf of a user. Only
+// the hash of the token is stored.
+type AccessToken struct {
+	ID       uint64 `orm:"column(id);auto;pk"`
+	User     *user  `orm:"rel(fk);index;on_delete(cascade)"`
+	Name     string
+	Hash     string `orm:"unique"` // hex encoded SHA-256 of the token
+	Prefix   string // first characters of the token to recognize it
+	Scopes   string // comma separated
+	Expires  time.Time
+	LastUsed time.Time `orm:"null"`
+	Created  time.Time
+}
+
+// ValidAccessTokenScope returns whether scope is one of AccessTokenScopes
+func ValidAccessTokenScope(scope string) bool {
+	for _, s := range AccessTokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAccessToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// NewAccessToken creates a personal access token of the user and returns it together with the
+// token itself, which cannot be recovered later
+func NewAccessToken(email, name string, scopes []string, validity time.Duration) (*AccessToken,
+	string, error) {
+	u, err := FindUserByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	raw := make([]byte, accessTokenLength)
+	if _, err = rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	token := AccessTokenPrefix + hex.EncodeToString(raw)
+	now := time.Now().UTC()
+	t := &AccessToken{
+		User:    u,
+		Name:    name,
+		Hash:    hashAccessToken(token),
+		Prefix:  token[:len(AccessTokenPrefix)+6],
+		Scopes:  strings.Join(scopes, ","),
+		Expires: now.Add(validity),
+		Created: now,
+	}
+	_, err = o.Insert(t)
+	return t, token, err
+}
+
+// FindAccessToken returns the access token with its user and account. Expired tokens and the
+// tokens of disabled users are rejected with ErrAccessTokenExpired and ErrUserDisabled.
+func FindAccessToken(token string) (*AccessToken, error) {
+	t := new(AccessToken)
+	err := o.QueryTable(t).Filter("Hash", hashAccessToken(token)).RelatedSel().One(t)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(t.Expires) {
+		return nil, ErrAccessTokenExpired
+	}
+	if t.User != nil && t.User.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return t, nil
+}
+
+// FindAccessTokensByUserEmail returns the access tokens of the user, the newest first
+func FindAccessTokensByUserEmail(email string) ([]AccessToken, error) {
+	var t []AccessToken
+	_, err := o.QueryTable(new(AccessToken)).Filter("User__Email", email).OrderBy("-ID").All(&t)
+	return t, err
+}
+
+// FindAccessTokenByIDAndUserEmail returns the access token if it belongs to the user
+func FindAccessTokenByIDAndUserEmail(id uint64, email string) (*AccessToken, error) {
+	t := new(AccessToken)
+	err := o.QueryTable(t).Filter("ID", id).Filter("User__Email", email).One(t)
+	return t, err
+}
+
+// ScopeList returns the scopes of the token
+func (t *AccessToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// HasScope returns whether the token grants the scope
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountID returns the account ID of the user of the token
+func (t *AccessToken) AccountID() string {
+	if t.User == nil || t.User.Account == nil {
+		return ""
+	}
+	return t.User.Account.AccountID
+}
+
+// UserEmail returns the email address of the user of the token
+func (t *AccessToken) UserEmail() string {
+	if t.User == nil {
+		return ""
+	}
+	return t.User.Email
+}
+
+// Touch records that the token was used now
+func (t *AccessToken) Touch() error {
+	now := time.Now().UTC()
+	if now.Sub(t.LastUsed) < lastUsedPrecision {
+		return nil
+	}
+	t.LastUsed = now
+	_, err := o.Update(t, "LastUsed")
+	return err
+}
+
+// Delete revokes the token
+func (t *AccessToken) Delete() error {
+	_, err := o.Delete(t)
+	return err
+}
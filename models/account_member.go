@@ -0,0 +1,362 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/scionproto/scion/go/lib/addr"
+)
+
+// Roles of the members of an account. The users registered with the account are always owners.
+const (
+	MemberViewer     = "viewer"     // lists the ASes of the account
+	MemberMaintainer = "maintainer" // configures, downloads and rolls back the ASes
+	MemberOwner      = "owner"      // removes ASes and manages the members
+)
+
+var memberRoleLevels = map[string]int{MemberViewer: 1, MemberMaintainer: 2, MemberOwner: 3}
+
+var (
+	// ErrMemberRole is returned if the role of the user in the account of an AS is too low
+	ErrMemberRole = errors.New("your role in the account of the AS does not allow this")
+	// ErrAlreadyMember is returned when inviting a user who is already a member of the account
+	ErrAlreadyMember = errors.New("the user is already a member of the account")
+)
+
+// ValidMemberRole returns whether role is one of the member roles
+func ValidMemberRole(role string) bool {
+	return memberRoleLevels[role] > 0
+}
+
+// MemberRoleAtLeast returns whether role grants at least the privileges of min
+func MemberRoleAtLeast(role, min string) bool {
+	return memberRoleLevels[role] > 0 && memberRoleLevels[role] >= memberRoleLevels[min]
+}
+
+// AccountMember gives a user of another account access to the ASes of the account
+type AccountMember struct {
+	ID      uint64   `orm:"column(id);auto;pk"`
+	Account *Account `orm:"rel(fk);index;on_delete(cascade)"`
+	User    *user    `orm:"rel(fk);index;on_delete(cascade)"`
+	Role    string
+	Created time.Time
+}
+
+// TableUnique prevents adding a user to an account twice
+func (m *AccountMember) TableUnique() [][]string {
+	return [][]string{{"Account", "User"}}
+}
+
+// AccountInvitation is a pending invitation of an existing user into an account
+type AccountInvitation struct {
+	ID        uint64   `orm:"column(id);auto;pk"`
+	Account   *Account `orm:"rel(fk);index;on_delete(cascade)"`
+	User      *user    `orm:"rel(fk);index;on_delete(cascade)"` // the invited user
+	Role      string
+	InvitedBy string // email address of the inviting owner
+	Created   time.Time
+}
+
+// TableUnique allows a single pending invitation per user and account
+func (i *AccountInvitation) TableUnique() [][]string {
+	return [][]string{{"Account", "User"}}
+}
+
+// UserEmail returns the email address of the member
+func (m *AccountMember) UserEmail() string {
+	if m.User == nil {
+		return ""
+	}
+	return m.User.Email
+}
+
+// UserEmail returns the email address of the invited user
+func (i *AccountInvitation) UserEmail() string {
+	if i.User == nil {
+		return ""
+	}
+	return i.User.Email
+}
+
+// MemberRole returns the role of the user in the account, or an empty string if the user is
+// not a member
+func (u *user) MemberRole(a *Account) (string, error) {
+	if u.Account != nil && u.Account.ID == a.ID {
+		return MemberOwner, nil
+	}
+	m := new(AccountMember)
+	err := o.QueryTable(m).Filter("Account__ID", a.ID).Filter("User__ID", u.ID).One(m)
+	if err == orm.ErrNoRows {
+		return "", nil
+	}
+	return m.Role, err
+}
+
+// UserEmails returns the email addresses of the users registered with the account, who are its
+// owners
+func (a *Account) UserEmails() ([]string, error) {
+	if _, err := o.LoadRelated(a, "Users"); err != nil {
+		return nil, err
+	}
+	emails := []string{}
+	for _, u := range a.Users {
+		emails = append(emails, u.Email)
+	}
+	sort.Strings(emails)
+	return emails, nil
+}
+
+// OtherOwner returns an owner of the account other than the user with the email address, who
+// is neither disabled nor deleting their account. The users registered with the account are
+// preferred to its members. orm.ErrNoRows is returned if there is no such owner.
+func (a *Account) OtherOwner(email string) (*user, error) {
+	var users []*user
+	_, err := o.QueryTable(new(user)).Filter("Account__ID", a.ID).Exclude("Email", email).
+		Filter("Disabled", false).Filter("DeletionRequested__isnull", true).
+		OrderBy("Email").RelatedSel().All(&users)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) > 0 {
+		return users[0], nil
+	}
+	var members []AccountMember
+	_, err = o.QueryTable(new(AccountMember)).Filter("Account__ID", a.ID).
+		Filter("Role", MemberOwner).Exclude("User__Email", email).
+		Filter("User__Disabled", false).Filter("User__DeletionRequested__isnull", true).
+		OrderBy("User__Email").RelatedSel().All(&members)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) > 0 {
+		return members[0].User, nil
+	}
+	return nil, orm.ErrNoRows
+}
+
+// memberAccountIDs returns the IDs of the accounts the user is a member of, including the
+// account the user is registered with
+func (u *user) memberAccountIDs() ([]uint64, error) {
+	var members []AccountMember
+	_, err := o.QueryTable(new(AccountMember)).Filter("User__ID", u.ID).All(&members)
+	if err != nil {
+		return nil, err
+	}
+	ids := []uint64{u.Account.ID}
+	for _, m := range members {
+		ids = append(ids, m.Account.ID)
+	}
+	return ids, nil
+}
+
+// FindAccountMembershipsByUserEmail returns the memberships of the user in other accounts
+func FindAccountMembershipsByUserEmail(email string) ([]AccountMember, error) {
+	var members []AccountMember
+	_, err := o.QueryTable(new(AccountMember)).Filter("User__Email", email).RelatedSel().
+		OrderBy("Account__Name").All(&members)
+	return members, err
+}
+
+// FindAccountMembers returns the members of the account who are not registered with it
+func FindAccountMembers(a *Account) ([]AccountMember, error) {
+	var members []AccountMember
+	_, err := o.QueryTable(new(AccountMember)).Filter("Account__ID", a.ID).RelatedSel().
+		OrderBy("User__Email").All(&members)
+	return members, err
+}
+
+// FindAccountMember returns the membership of the user with the email address in the account
+func FindAccountMember(a *Account, email string) (*AccountMember, error) {
+	m := new(AccountMember)
+	err := o.QueryTable(m).Filter("Account__ID", a.ID).Filter("User__Email", email).
+		RelatedSel().One(m)
+	return m, err
+}
+
+// SetRole changes the role of the member
+func (m *AccountMember) SetRole(role string) error {
+	m.Role = role
+	_, err := o.Update(m, "Role")
+	return err
+}
+
+// Delete removes the user from the account
+func (m *AccountMember) Delete() error {
+	_, err := o.Delete(m)
+	return err
+}
+
+// InviteAccountMember invites the existing user with the email address into the account with
+// the role. Inviting a user again replaces the role of the pending invitation.
+func InviteAccountMember(a *Account, email, role, invitedBy string) (*AccountInvitation, error) {
+	u, err := FindUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	current, err := u.MemberRole(a)
+	if err != nil {
+		return nil, err
+	}
+	if current != "" {
+		return nil, ErrAlreadyMember
+	}
+	i := &AccountInvitation{Account: a, User: u}
+	err = o.Read(i, "Account", "User")
+	if err != nil && err != orm.ErrNoRows {
+		return nil, err
+	}
+	exists := err == nil
+	i.Role = role
+	i.InvitedBy = invitedBy
+	i.Created = time.Now().UTC()
+	if exists {
+		_, err = o.Update(i)
+	} else {
+		_, err = o.Insert(i)
+	}
+	return i, err
+}
+
+// FindAccountInvitationsByUserEmail returns the pending invitations of the user
+func FindAccountInvitationsByUserEmail(email string) ([]AccountInvitation, error) {
+	var invitations []AccountInvitation
+	_, err := o.QueryTable(new(AccountInvitation)).Filter("User__Email", email).RelatedSel().
+		OrderBy("Created").All(&invitations)
+	return invitations, err
+}
+
+// FindAccountInvitationsByAccount returns the pending invitations into the account
+func FindAccountInvitationsByAccount(a *Account) ([]AccountInvitation, error) {
+	var invitations []AccountInvitation
+	_, err := o.QueryTable(new(AccountInvitation)).Filter("Account__ID", a.ID).RelatedSel().
+		OrderBy("User__Email").All(&invitations)
+	return invitations, err
+}
+
+// FindAccountInvitationByID returns the invitation with its account and user
+func FindAccountInvitationByID(id uint64) (*AccountInvitation, error) {
+	i := new(AccountInvitation)
+	err := o.QueryTable(i).Filter("ID", id).RelatedSel().One(i)
+	return i, err
+}
+
+// Accept adds the invited user to the account and removes the invitation
+func (i *AccountInvitation) Accept() (*AccountMember, error) {
+	m := &AccountMember{Account: i.Account, User: i.User, Role: i.Role,
+		Created: time.Now().UTC()}
+	if _, err := o.Insert(m); err != nil {
+		return nil, err
+	}
+	return m, i.Delete()
+}
+
+// Delete declines or cancels the invitation
+func (i *AccountInvitation) Delete() error {
+	_, err := o.Delete(i)
+	return err
+}
+
+// OwnerAccount returns the account owning the AS, or the account of its contact if the AS is
+// not owned by an account yet
+func (as *SCIONLabAS) OwnerAccount() (*Account, error) {
+	if as.Account == nil {
+		return FindAccountByUserEmail(as.UserEmail)
+	}
+	if as.Account.AccountID == "" {
+		// only the ID is loaded without RelatedSel
+		if err := o.Read(as.Account); err != nil {
+			return nil, err
+		}
+	}
+	return as.Account, nil
+}
+
+// FindSCIONLabASForUser returns the AS with the AS ID if the user with the email address has at
+// least the role min in the account owning it. orm.ErrNoRows is returned if the user is not a
+// member of that account, ErrMemberRole if their role is too low.
+func FindSCIONLabASForUser(email string, asID addr.AS, min string) (*SCIONLabAS, error) {
+	as := new(SCIONLabAS)
+	if err := o.QueryTable(as).Filter("ASID", asID).RelatedSel().One(as); err != nil {
+		return nil, err
+	}
+	if as.Account == nil {
+		// not owned by an account, only the contact has access
+		if as.UserEmail != email {
+			return nil, orm.ErrNoRows
+		}
+		return as, nil
+	}
+	u, err := FindUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	role, err := u.MemberRole(as.Account)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		return nil, orm.ErrNoRows
+	}
+	if !MemberRoleAtLeast(role, min) {
+		return nil, ErrMemberRole
+	}
+	return as, nil
+}
+
+// FindSCIONLabASesForUser returns the ASes of all accounts the user with the email address is
+// a member of
+func FindSCIONLabASesForUser(email string) ([]SCIONLabAS, error) {
+	u, err := FindUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := u.memberAccountIDs()
+	if err != nil {
+		return nil, err
+	}
+	var ases []SCIONLabAS
+	_, err = o.QueryTable(new(SCIONLabAS)).Filter("Account__ID__in", ids).RelatedSel().
+		OrderBy("ID").All(&ases)
+	return ases, err
+}
+
+// AssignASAccounts makes the account of the contact of each AS without an account its owner.
+// ASes created before they were owned by accounts are migrated this way at startup.
+func AssignASAccounts() error {
+	var ases []SCIONLabAS
+	_, err := o.QueryTable(new(SCIONLabAS)).Filter("Account__isnull", true).All(&ases)
+	if err != nil {
+		return err
+	}
+	for i := range ases {
+		u, err := FindUserByEmail(ases[i].UserEmail)
+		if err == orm.ErrNoRows {
+			// infrastructure ASes have no user
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ases[i].Account = u.Account
+		if _, err := o.Update(&ases[i], "Account"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountMembers(t *testing.T) {
+	owner, err := RegisterUser("members-owner", "Scion Test-Bed", "members.owner@example.com",
+		"some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Delete()
+	defer owner.Account.Delete()
+	other, err := RegisterUser("members-other", "Scion Test-Bed", "members.other@example.com",
+		"some password", "Jane", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Delete()
+	defer other.Account.Delete()
+
+	// the AS is owned by the account of its contact
+	as := &SCIONLabAS{UserEmail: owner.Email, ISD: 1, ASID: 0xffaa0001f044, Type: VM}
+	if err := as.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer as.Delete()
+	assert.Equal(t, owner.Account.ID, as.Account.ID)
+	found, err := FindSCIONLabASForUser(owner.Email, as.ASID, MemberOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, as.ID, found.ID)
+	_, err = FindSCIONLabASForUser(other.Email, as.ASID, MemberViewer)
+	assert.Equal(t, orm.ErrNoRows, err)
+
+	// the invited user becomes a member once the invitation is accepted
+	_, err = InviteAccountMember(owner.Account, owner.Email, MemberViewer, owner.Email)
+	assert.Equal(t, ErrAlreadyMember, err)
+	_, err = InviteAccountMember(owner.Account, other.Email, MemberOwner, owner.Email)
+	assert.NoError(t, err)
+	invitation, err := InviteAccountMember(owner.Account, other.Email, MemberViewer, owner.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invitations, err := FindAccountInvitationsByUserEmail(other.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, invitations, 1, "invitation not replaced")
+	assert.Equal(t, MemberViewer, invitations[0].Role)
+	_, err = FindSCIONLabASForUser(other.Email, as.ASID, MemberViewer)
+	assert.Equal(t, orm.ErrNoRows, err)
+
+	if _, err := invitation.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	invitations, err = FindAccountInvitationsByUserEmail(other.Email)
+	assert.NoError(t, err)
+	assert.Empty(t, invitations)
+	_, err = FindSCIONLabASForUser(other.Email, as.ASID, MemberViewer)
+	assert.NoError(t, err)
+	_, err = FindSCIONLabASForUser(other.Email, as.ASID, MemberMaintainer)
+	assert.Equal(t, ErrMemberRole, err)
+	ases, err := FindSCIONLabASesForUser(other.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, ases, 1) {
+		assert.Equal(t, as.ID, ases[0].ID)
+	}
+
+	member, err := FindAccountMember(owner.Account, other.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, member.SetRole(MemberMaintainer))
+	_, err = FindSCIONLabASForUser(other.Email, as.ASID, MemberMaintainer)
+	assert.NoError(t, err)
+	_, err = FindSCIONLabASForUser(other.Email, as.ASID, MemberOwner)
+	assert.Equal(t, ErrMemberRole, err)
+
+	assert.NoError(t, member.Delete())
+	_, err = FindSCIONLabASForUser(other.Email, as.ASID, MemberViewer)
+	assert.Equal(t, orm.ErrNoRows, err)
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/netsec-ethz/scion-coord/config"
+)
+
+// Sources of the AS quota of a user
+const (
+	QuotaSourceUser    = "user"    // set for the user
+	QuotaSourceAccount = "account" // set for the account of the user, shared by its users
+	QuotaSourceDefault = "default" // ases_per_user or ases_per_admin
+)
+
+// ErrNegativeQuota is returned when setting a quota below 0
+var ErrNegativeQuota = errors.New("the quota must not be negative")
+
+// ASQuota overrides the global number of ASes, ases_per_user or ases_per_admin, for a user or
+// for an account, whose users share the quota. Exactly one of User and Account is set.
+type ASQuota struct {
+	ID      uint64   `orm:"column(id);auto;pk"`
+	User    *user    `orm:"rel(fk);null;index;on_delete(cascade)"`
+	Account *Account `orm:"rel(fk);null;index;on_delete(cascade)"`
+	MaxASes int      `orm:"column(max_ases)"`
+	SetBy   string   // email of the admin who set the quota
+	Updated time.Time
+}
+
+// ASQuota returns the quota set for the user, or orm.ErrNoRows
+func (u *user) ASQuota() (*ASQuota, error) {
+	return findASQuota("User__ID", u.ID)
+}
+
+// SetASQuota sets the number of ASes the user can have, overriding the quota of the account
+func (u *user) SetASQuota(maxASes int, setBy string) (*ASQuota, error) {
+	return setASQuota(&ASQuota{User: u}, "User__ID", u.ID, maxASes, setBy)
+}
+
+// ResetASQuota removes the quota set for the user. It returns whether a quota was set.
+func (u *user) ResetASQuota() (bool, error) {
+	return resetASQuota("User__ID", u.ID)
+}
+
+// MaxASes returns the number of ASes the user can have and where it comes from: the quota of
+// the user, else the quota of the account of the user, else the global default. See QuotaASes
+// for the ASes counted against it.
+func (u *user) MaxASes() (int, string, error) {
+	q, err := u.ASQuota()
+	if err == nil {
+		return q.MaxASes, QuotaSourceUser, nil
+	}
+	if err != orm.ErrNoRows {
+		return 0, "", err
+	}
+	if u.Account != nil {
+		q, err = u.Account.ASQuota()
+		if err == nil {
+			return q.MaxASes, QuotaSourceAccount, nil
+		}
+		if err != orm.ErrNoRows {
+			return 0, "", err
+		}
+	}
+	return config.MaxASes(u.IsAdmin), QuotaSourceDefault, nil
+}
+
+// QuotaASes returns the ASes counted against the quota from the source: the ASes owned by the
+// account of the user for the quota of the account, else the ASes the user is the contact of
+func (u *user) QuotaASes(source string) ([]SCIONLabAS, error) {
+	if source == QuotaSourceAccount && u.Account != nil {
+		return FindSCIONLabASesByAccount(u.Account)
+	}
+	return FindSCIONLabASesByUserEmail(u.Email)
+}
+
+// ASQuota returns the quota set for the users of the account, or orm.ErrNoRows
+func (a *Account) ASQuota() (*ASQuota, error) {
+	return findASQuota("Account__ID", a.ID)
+}
+
+// SetASQuota sets the number of ASes the users of the account can have together
+func (a *Account) SetASQuota(maxASes int, setBy string) (*ASQuota, error) {
+	return setASQuota(&ASQuota{Account: a}, "Account__ID", a.ID, maxASes, setBy)
+}
+
+// ResetASQuota removes the quota set for the account. It returns whether a quota was set.
+func (a *Account) ResetASQuota() (bool, error) {
+	return resetASQuota("Account__ID", a.ID)
+}
+
+// FindASQuotas returns all quotas with their users and accounts
+func FindASQuotas() ([]ASQuota, error) {
+	var quotas []ASQuota
+	_, err := o.QueryTable(new(ASQuota)).RelatedSel().OrderBy("ID").All(&quotas)
+	return quotas, err
+}
+
+func findASQuota(field string, id uint64) (*ASQuota, error) {
+	q := new(ASQuota)
+	err := o.QueryTable(q).Filter(field, id).One(q)
+	return q, err
+}
+
+// setASQuota updates the quota matching the filter, or inserts q if there is none
+func setASQuota(q *ASQuota, field string, id uint64, maxASes int, setBy string) (*ASQuota,
+	error) {
+	if maxASes < 0 {
+		return nil, ErrNegativeQuota
+	}
+	existing, err := findASQuota(field, id)
+	switch err {
+	case nil:
+		q.ID = existing.ID
+	case orm.ErrNoRows:
+	default:
+		return nil, err
+	}
+	q.MaxASes = maxASes
+	q.SetBy = setBy
+	q.Updated = time.Now().UTC()
+	if q.ID != 0 {
+		_, err = o.Update(q, "MaxASes", "SetBy", "Updated")
+	} else {
+		_, err = o.Insert(q)
+	}
+	return q, err
+}
+
+func resetASQuota(field string, id uint64) (bool, error) {
+	n, err := o.QueryTable(new(ASQuota)).Filter(field, id).Delete()
+	return n > 0, err
+}
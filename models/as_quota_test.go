@@ -0,0 +1,117 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestASQuota(t *testing.T) {
+	u, err := RegisterUser("quota", "Scion Test-Bed", "quota@example.com", "some password",
+		"Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+
+	maxASes, source, err := u.MaxASes()
+	assert.NoError(t, err)
+	assert.Equal(t, config.MaxASes(false), maxASes)
+	assert.Equal(t, QuotaSourceDefault, source)
+
+	// the quota of the account applies to its users
+	_, err = u.Account.SetASQuota(20, "admin@example.com")
+	assert.NoError(t, err)
+	maxASes, source, err = u.MaxASes()
+	assert.NoError(t, err)
+	assert.Equal(t, 20, maxASes)
+	assert.Equal(t, QuotaSourceAccount, source)
+
+	// the quota of the user overrides it, and setting it again updates it
+	_, err = u.SetASQuota(5, "admin@example.com")
+	assert.NoError(t, err)
+	q, err := u.SetASQuota(0, "other@example.com")
+	assert.NoError(t, err)
+	maxASes, source, err = u.MaxASes()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, maxASes)
+	assert.Equal(t, QuotaSourceUser, source)
+	stored, err := u.ASQuota()
+	if assert.NoError(t, err) {
+		assert.Equal(t, q.ID, stored.ID)
+		assert.Equal(t, "other@example.com", stored.SetBy)
+	}
+	_, err = u.SetASQuota(-1, "admin@example.com")
+	assert.Equal(t, ErrNegativeQuota, err)
+
+	reset, err := u.ResetASQuota()
+	assert.NoError(t, err)
+	assert.True(t, reset)
+	reset, err = u.ResetASQuota()
+	assert.NoError(t, err)
+	assert.False(t, reset)
+	maxASes, source, err = u.MaxASes()
+	assert.NoError(t, err)
+	assert.Equal(t, 20, maxASes)
+	assert.Equal(t, QuotaSourceAccount, source)
+
+	reset, err = u.Account.ResetASQuota()
+	assert.NoError(t, err)
+	assert.True(t, reset)
+	_, source, err = u.MaxASes()
+	assert.NoError(t, err)
+	assert.Equal(t, QuotaSourceDefault, source)
+}
+
+func TestQuotaASes(t *testing.T) {
+	u, err := RegisterUser("quota-shared", "Scion Test-Bed", "quota.shared@example.com",
+		"some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+	// registering with the name of the account joins it
+	other, err := RegisterUser("quota-shared", "Scion Test-Bed", "quota.other@example.com",
+		"some password", "Jane", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Delete()
+	if !assert.Equal(t, u.Account.ID, other.Account.ID) {
+		return
+	}
+
+	as := &SCIONLabAS{UserEmail: other.Email, ISD: 1, ASID: 0xffaa0001f049, Type: VM}
+	if err := as.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer as.Delete()
+
+	// the quota of the account counts the ASes of all its users, other quotas those of the user
+	ases, err := u.QuotaASes(QuotaSourceAccount)
+	assert.NoError(t, err)
+	assert.Len(t, ases, 1)
+	ases, err = u.QuotaASes(QuotaSourceDefault)
+	assert.NoError(t, err)
+	assert.Empty(t, ases)
+	ases, err = other.QuotaASes(QuotaSourceUser)
+	assert.NoError(t, err)
+	assert.Len(t, ases, 1)
+}
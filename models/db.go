@@ -20,6 +20,7 @@ import (
 	"github.com/astaxie/beego/orm"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/logger"
 )
 
 var (
@@ -38,7 +39,26 @@ func (il *ISDLocation) TableName() string {
 	return "isd_location"
 }
 
+func (vc *VPNCertificate) TableName() string {
+	return "vpn_certificate"
+}
+
+func (h *VPNAddressHold) TableName() string {
+	return "vpn_address_hold"
+}
+
+func (t *AccessToken) TableName() string {
+	return "access_token"
+}
+
+func (i *OIDCIdentity) TableName() string {
+	return "oidc_identity"
+}
+
 func init() {
+	// the warnings of the ORM, e.g. about the database connection, are written as log entries
+	orm.DebugLog = orm.NewLog(logger.Default().Writer(logger.LevelWarn))
+
 	orm.RegisterDriver("mysql", orm.DRMySQL)
 	orm.RegisterDataBase("default", "mysql",
 		fmt.Sprintf("%s:%s@(%s:%d)/%s?charset=utf8&parseTime=true",
@@ -51,7 +71,9 @@ func init() {
 	// register the models
 	orm.RegisterModel(new(user), new(Account), new(JoinRequest), new(ConnRequest),
 		new(JoinReply), new(ConnReply), new(SCIONLabAS), new(AttachmentPoint), new(Connection),
-		new(SCIONBox), new(ISDLocation))
+		new(SCIONBox), new(ISDLocation), new(VPNCertificate), new(VPNAddressHold),
+		new(AccessToken), new(WebSession), new(Role), new(RoleAssignment), new(AccountMember),
+		new(AccountInvitation), new(OIDCIdentity), new(AdminAction), new(ASQuota))
 
 	// print verbose logs when generating the tables
 	verbose := true
@@ -61,7 +83,7 @@ func init() {
 
 	err := orm.RunSyncdb("default", force, verbose)
 	if err != nil {
-		fmt.Println(err)
+		logger.Errorf("Error creating the tables: %v", err)
 	}
 
 	// instantiate a new ORM object for executing the queries
@@ -54,3 +54,17 @@ const (
 	Dedicated
 	Box
 )
+
+// VPN types of attachment points and VPN connections
+const (
+	OpenVPN   = "openvpn"
+	WireGuard = "wireguard"
+)
+
+// VPNTypeOrDefault returns vpnType, or OpenVPN if it is not set
+func VPNTypeOrDefault(vpnType string) string {
+	if vpnType == "" {
+		return OpenVPN
+	}
+	return vpnType
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/pborman/uuid"
+)
+
+var (
+	// ErrEmailTaken is returned when changing the email address to one of another user
+	ErrEmailTaken = errors.New("the email address is already registered")
+	// ErrNoEmailChange is returned when confirming a change which was not requested or expired
+	ErrNoEmailChange = errors.New("no change of the email address is pending")
+	// ErrAccountNameTaken is returned when the account named after the previous address cannot
+	// be renamed, as another account is named after the new address
+	ErrAccountNameTaken = errors.New("an account is already named after the email address")
+)
+
+// RequestEmailChange records the new email address until it is confirmed with the returned
+// link, which is valid for the given duration. A previous request is replaced.
+func (u *user) RequestEmailChange(newEmail string, validity time.Duration) (string, error) {
+	if _, err := FindUserByEmail(newEmail); err != orm.ErrNoRows {
+		if err == nil {
+			err = ErrEmailTaken
+		}
+		return "", err
+	}
+	if u.Account != nil && u.Account.Name == u.Email {
+		named, err := o.QueryTable(new(Account)).Filter("Name", newEmail).Count()
+		if err != nil {
+			return "", err
+		}
+		if named > 0 {
+			return "", ErrAccountNameTaken
+		}
+	}
+	u.NewEmail = newEmail
+	u.EmailChangeUUID = uuid.New()
+	u.EmailChangeExpires = time.Now().Add(validity).UTC()
+	u.Updated = time.Now().UTC()
+	_, err := o.Update(u, "NewEmail", "EmailChangeUUID", "EmailChangeExpires", "Updated")
+	return u.EmailChangeUUID, err
+}
+
+// EmailChangePending returns whether the user requested a change of the email address which
+// was not confirmed yet and did not expire
+func (u *user) EmailChangePending() bool {
+	return u.NewEmail != "" && time.Now().Before(u.EmailChangeExpires)
+}
+
+// FindUserByEmailChangeUUID returns the user who requested the change of the email address
+// confirmed with the link
+func FindUserByEmailChangeUUID(link string) (*user, error) {
+	u := new(user)
+	if link == "" {
+		return u, orm.ErrNoRows
+	}
+	err := o.QueryTable(u).Filter("EmailChangeUUID", link).RelatedSel().One(u)
+	return u, err
+}
+
+// ChangeEmail replaces the email address of the user by the requested one. The ASes and the
+// SCION box the user is the contact of, the invitations sent by the user and the name of the
+// account, if it is the address of the user as for self-registered users, are updated in the
+// same transaction. If another account is already named after the new address, nothing is
+// changed and ErrAccountNameTaken is returned. It returns the previous address.
+func (u *user) ChangeEmail() (string, error) {
+	if !u.EmailChangePending() {
+		return "", ErrNoEmailChange
+	}
+	changed := *u
+	changed.Email = u.NewEmail
+	changed.NewEmail = ""
+	changed.EmailChangeUUID = ""
+	changed.EmailChangeExpires = time.Time{}
+	changed.Updated = time.Now().UTC()
+
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return "", err
+	}
+	if err := changeEmail(tx, &changed, u.Email); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	oldEmail := u.Email
+	*u = changed
+	return oldEmail, nil
+}
+
+// changeEmail stores the user with the new address and updates the records referring to the
+// previous address within the transaction tx
+func changeEmail(tx orm.Ormer, u *user, oldEmail string) error {
+	taken, err := tx.QueryTable(new(user)).Filter("Email", u.Email).Count()
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrEmailTaken
+	}
+	if _, err = tx.Update(u, "Email", "NewEmail", "EmailChangeUUID", "EmailChangeExpires",
+		"Updated"); err != nil {
+		return err
+	}
+	if _, err = tx.QueryTable(new(SCIONLabAS)).Filter("UserEmail", oldEmail).
+		Update(orm.Params{"UserEmail": u.Email}); err != nil {
+		return err
+	}
+	if _, err = tx.QueryTable(new(SCIONBox)).Filter("UserEmail", oldEmail).
+		Update(orm.Params{"UserEmail": u.Email}); err != nil {
+		return err
+	}
+	if _, err = tx.QueryTable(new(AccountInvitation)).Filter("InvitedBy", oldEmail).
+		Update(orm.Params{"InvitedBy": u.Email}); err != nil {
+		return err
+	}
+	if u.Account == nil || u.Account.Name != oldEmail {
+		return nil
+	}
+	// the account is renamed, as registering with the previous address would join it otherwise
+	named, err := tx.QueryTable(new(Account)).Filter("Name", u.Email).Count()
+	if err != nil {
+		return err
+	}
+	if named > 0 {
+		return ErrAccountNameTaken
+	}
+	account := *u.Account
+	account.Name = u.Email
+	account.Updated = time.Now().UTC()
+	if _, err = tx.Update(&account, "Name", "Updated"); err != nil {
+		return err
+	}
+	u.Account = &account
+	return nil
+}
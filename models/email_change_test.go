@@ -0,0 +1,135 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeEmail(t *testing.T) {
+	u, err := RegisterUser("change.me@example.com", "Scion Test-Bed", "change.me@example.com",
+		"some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+	other, err := RegisterUser("change-other", "Scion Test-Bed", "change.other@example.com",
+		"some password", "Jane", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Delete()
+	defer other.Account.Delete()
+
+	as := &SCIONLabAS{UserEmail: u.Email, ISD: 1, ASID: 0xffaa0001f047, Type: VM,
+		Status: Inactive}
+	if err := as.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer as.Delete()
+	if _, err := InviteAccountMember(u.Account, other.Email, MemberViewer, u.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	// the address of another user cannot be taken
+	_, err = u.RequestEmailChange(other.Email, time.Hour)
+	assert.Equal(t, ErrEmailTaken, err)
+	_, err = u.ChangeEmail()
+	assert.Equal(t, ErrNoEmailChange, err)
+
+	// expired requests cannot be confirmed
+	link, err := u.RequestEmailChange("changed@example.com", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, u.EmailChangePending())
+	_, err = u.ChangeEmail()
+	assert.Equal(t, ErrNoEmailChange, err)
+
+	link, err = u.RequestEmailChange("changed@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := FindUserByEmailChangeUUID(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.ID, found.ID)
+	assert.True(t, found.EmailChangePending())
+	oldEmail, err := found.ChangeEmail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "change.me@example.com", oldEmail)
+	assert.Equal(t, "changed@example.com", found.Email)
+	assert.False(t, found.EmailChangePending())
+	_, err = FindUserByEmailChangeUUID(link)
+	assert.Error(t, err)
+
+	// the records referring to the user by the address are updated
+	changed, err := FindUserByEmail("changed@example.com")
+	if assert.NoError(t, err) {
+		assert.Equal(t, u.ID, changed.ID)
+		assert.Equal(t, "changed@example.com", changed.Account.Name)
+	}
+	_, err = FindUserByEmail(oldEmail)
+	assert.Error(t, err)
+	ases, err := FindSCIONLabASesByUserEmail("changed@example.com")
+	assert.NoError(t, err)
+	assert.Len(t, ases, 1)
+	invitations, err := FindAccountInvitationsByUserEmail(other.Email)
+	if assert.NoError(t, err) && assert.Len(t, invitations, 1) {
+		assert.Equal(t, "changed@example.com", invitations[0].InvitedBy)
+	}
+}
+
+func TestChangeEmailAccountNameTaken(t *testing.T) {
+	u, err := RegisterUser("rename.me@example.com", "Scion Test-Bed", "rename.me@example.com",
+		"some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+	if _, err = u.RequestEmailChange("renamed@example.com", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// another account is named after the new address before the change is confirmed
+	named := &Account{Name: "renamed@example.com", Organisation: "Scion Test-Bed",
+		AccountID: "renamed-account", Secret: "secret", Created: time.Now().UTC(),
+		Updated: time.Now().UTC()}
+	if err := named.Upsert(); err != nil {
+		t.Fatal(err)
+	}
+	defer named.Delete()
+
+	_, err = u.ChangeEmail()
+	assert.Equal(t, ErrAccountNameTaken, err)
+	// the change is rolled back
+	stored, err := FindUserByEmail("rename.me@example.com")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "rename.me@example.com", stored.Account.Name)
+	}
+	_, err = FindUserByEmail("renamed@example.com")
+	assert.Error(t, err)
+
+	// and cannot be requested anymore
+	_, err = u.RequestEmailChange("renamed@example.com", time.Hour)
+	assert.Equal(t, ErrAccountNameTaken, err)
+}
@@ -56,6 +56,13 @@ func (jr *JoinRequest) Update() error {
 	return err
 }
 
+// FindJoinRequestsByRequester returns the join requests sent by the account with the account ID
+func FindJoinRequestsByRequester(requester string) ([]JoinRequest, error) {
+	var requests []JoinRequest
+	_, err := o.QueryTable(new(JoinRequest)).Filter("RequesterID", requester).All(&requests)
+	return requests, err
+}
+
 func FindJoinRequest(requester string, reqID uint64) (*JoinRequest, error) {
 	req := new(JoinRequest)
 	err := o.QueryTable(req).Filter("RequesterID", requester).Filter("RequestID", reqID).RelatedSel().One(req)
@@ -93,6 +100,13 @@ type JoinReply struct {
 	TRC                  string `orm:"column(trc);type(text)"`
 }
 
+// FindJoinRepliesByRequester returns the replies to the join requests of the account
+func FindJoinRepliesByRequester(requester string) ([]JoinReply, error) {
+	var replies []JoinReply
+	_, err := o.QueryTable(new(JoinReply)).Filter("RequesterID", requester).All(&replies)
+	return replies, err
+}
+
 func FindJoinReply(requester string, reqID uint64) (*JoinReply, error) {
 	jr := new(JoinReply)
 	err := o.QueryTable(jr).Filter("RequesterID", requester).Filter("RequestID", reqID).RelatedSel().One(jr)
@@ -149,6 +163,13 @@ func FindOpenConnRequestsByRespondIA(isdas string) ([]ConnRequest, error) {
 	return requests, err
 }
 
+// FindConnRequestsByAccount returns the connection requests sent by the account
+func FindConnRequestsByAccount(a *Account) ([]ConnRequest, error) {
+	var requests []ConnRequest
+	_, err := o.QueryTable(new(ConnRequest)).Filter("Account__ID", a.ID).All(&requests)
+	return requests, err
+}
+
 func (cr *ConnRequest) Insert() error {
 	_, err := o.Insert(cr)
 	return err
@@ -192,6 +213,13 @@ func FindConnRepliesByRequestIA(isdas string) ([]ConnReply, error) {
 	return cr, err
 }
 
+// FindConnRepliesByAccount returns the replies to the connection requests of the account
+func FindConnRepliesByAccount(a *Account) ([]ConnReply, error) {
+	var replies []ConnReply
+	_, err := o.QueryTable(new(ConnReply)).Filter("Account__ID", a.ID).All(&replies)
+	return replies, err
+}
+
 func (cr *ConnReply) Insert() error {
 	existingCR := new(ConnReply)
 	// should always return with orm.ErrNoRows
@@ -0,0 +1,121 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/throttle"
+)
+
+// UserLoginPolicy is the back-off applied to failed logins of a user
+var UserLoginPolicy = throttle.Policy{
+	FreeAttempts:    config.LoginFreeAttempts,
+	BaseDelay:       time.Second,
+	MaxDelay:        5 * time.Minute,
+	LockoutAttempts: config.LoginLockoutAttempts,
+	Lockout:         time.Duration(config.LoginLockoutMinutes) * time.Minute,
+	Window:          24 * time.Hour,
+}
+
+// LoginThrottledError is returned for logins while the user has to wait
+type LoginThrottledError struct {
+	Wait    time.Duration
+	Locked  bool // the account is locked out rather than delayed
+	NewLock bool // the failed attempt returning the error caused the lockout
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account locked for %v after too many failed logins", e.Wait)
+	}
+	return fmt.Sprintf("too many failed logins, next attempt in %v", e.Wait)
+}
+
+func (u *user) loginState() throttle.State {
+	return throttle.State{
+		Failures:    u.FailedLogins,
+		LastFailure: u.LastFailedLogin,
+		LockedUntil: u.LockedUntil,
+	}
+}
+
+// CheckLoginThrottle returns a *LoginThrottledError if the user has to wait at time now before
+// the next login attempt
+func (u *user) CheckLoginThrottle(now time.Time) error {
+	if wait, locked := UserLoginPolicy.Wait(u.loginState(), now); wait > 0 {
+		return &LoginThrottledError{Wait: wait, Locked: locked}
+	}
+	return nil
+}
+
+// RecordFailedLogin records a failed login at time now, with a wrong password or second factor.
+// If it causes a lockout, a *LoginThrottledError with NewLock is returned.
+func (u *user) RecordFailedLogin(now time.Time) error {
+	s, locked := UserLoginPolicy.Fail(u.loginState(), now)
+	u.FailedLogins = s.Failures
+	u.LastFailedLogin = s.LastFailure
+	u.LockedUntil = s.LockedUntil
+	if _, err := o.Update(u, "FailedLogins", "LastFailedLogin", "LockedUntil"); err != nil {
+		return err
+	}
+	if locked {
+		return &LoginThrottledError{Wait: UserLoginPolicy.Lockout, Locked: true, NewLock: true}
+	}
+	return nil
+}
+
+// ResetFailedLogins forgets the failed logins after a successful one, once all factors were
+// checked
+func (u *user) ResetFailedLogins() error {
+	if u.FailedLogins == 0 {
+		return nil
+	}
+	u.FailedLogins = 0
+	_, err := o.Update(u, "FailedLogins")
+	return err
+}
+
+// IsLocked returns whether the user is locked out at time now
+func (u *user) IsLocked(now time.Time) bool {
+	return now.Before(u.LockedUntil)
+}
+
+// Unlock lifts a lockout and forgets the failed logins
+func (u *user) Unlock() error {
+	u.FailedLogins = 0
+	u.LockedUntil = time.Time{}
+	u.Updated = time.Now().UTC()
+	_, err := o.Update(u, "FailedLogins", "LockedUntil", "Updated")
+	return err
+}
+
+// FindLockedUsers returns the emails of the users locked out at time now with the end of their
+// lockouts
+func FindLockedUsers(now time.Time) (map[string]time.Time, error) {
+	var users []*user
+	_, err := o.QueryTable(new(user)).Filter("LockedUntil__gt", now).All(&users,
+		"Email", "LockedUntil")
+	if err != nil {
+		return nil, err
+	}
+	locked := make(map[string]time.Time)
+	for _, u := range users {
+		locked[u.Email] = u.LockedUntil
+	}
+	return locked, nil
+}
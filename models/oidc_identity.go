@@ -0,0 +1,124 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// ErrEmailNotVerified is returned for a first OpenID Connect login whose email address was not
+// verified by the provider
+var ErrEmailNotVerified = errors.New("the provider did not verify the email address")
+
+// OIDCIdentity links the subject of an OpenID Connect provider to a user
+type OIDCIdentity struct {
+	ID        uint64 `orm:"column(id);auto;pk"`
+	User      *user  `orm:"rel(fk);index;on_delete(cascade)"`
+	Provider  string // ID of the provider in the configuration
+	Subject   string // identifier of the user at the provider
+	Created   time.Time
+	LastLogin time.Time `orm:"null"`
+}
+
+// TableUnique links a subject to a single user
+func (i *OIDCIdentity) TableUnique() [][]string {
+	return [][]string{{"Provider", "Subject"}}
+}
+
+// FindOIDCIdentity returns the identity of the subject at the provider
+func FindOIDCIdentity(provider, subject string) (*OIDCIdentity, error) {
+	i := new(OIDCIdentity)
+	err := o.QueryTable(i).Filter("Provider", provider).Filter("Subject", subject).
+		RelatedSel().One(i)
+	return i, err
+}
+
+// OIDCLogin returns the user of an OpenID Connect login. A subject seen before logs in as the
+// user it is linked to. Otherwise the subject is linked to the user with the email address,
+// which is created if there is none; this requires that the provider verified the address.
+// If that user had not verified the address yet, its password and sessions were set up by
+// whoever registered it and are invalidated. The returned bool tells whether the user was
+// created.
+func OIDCLogin(provider, subject, email string, emailVerified bool, first, last,
+	organisation string) (*user, bool, error) {
+	now := time.Now().UTC()
+	identity, err := FindOIDCIdentity(provider, subject)
+	if err == nil {
+		identity.LastLogin = now
+		if _, err := o.Update(identity, "LastLogin"); err != nil {
+			return nil, false, err
+		}
+		return identity.User, false, nil
+	}
+	if err != orm.ErrNoRows {
+		return nil, false, err
+	}
+
+	if email == "" || !emailVerified {
+		return nil, false, ErrEmailNotVerified
+	}
+	created := false
+	u, err := FindUserByEmail(email)
+	switch err {
+	case nil:
+	case orm.ErrNoRows:
+		// the email is used as unique account name, as for users registering themselves;
+		// without a password, the user can only log in through the provider until setting one
+		if u, err = RegisterUser(email, organisation, email, "", first, last); err != nil {
+			return nil, false, err
+		}
+		created = true
+	default:
+		return nil, false, err
+	}
+	// the provider verified the address, so there is no need to send a verification link
+	if !u.Verified {
+		// anyone could have registered the address, so the password must not open the account
+		if !created {
+			if err := u.UpdatePassword(""); err != nil {
+				return nil, false, err
+			}
+			if _, err := DeleteWebSessionsByUserEmail(u.Email, ""); err != nil {
+				return nil, false, err
+			}
+		}
+		if err := u.UpdateVerified(true); err != nil {
+			return nil, false, err
+		}
+	}
+
+	identity = &OIDCIdentity{
+		User:      u,
+		Provider:  provider,
+		Subject:   subject,
+		Created:   now,
+		LastLogin: now,
+	}
+	if _, err := o.Insert(identity); err != nil {
+		return nil, false, err
+	}
+	return u, created, nil
+}
+
+// FindOIDCIdentitiesByUserEmail returns the identities linked to the user
+func FindOIDCIdentitiesByUserEmail(email string) ([]OIDCIdentity, error) {
+	var identities []OIDCIdentity
+	_, err := o.QueryTable(new(OIDCIdentity)).Filter("User__Email", email).
+		OrderBy("Provider").All(&identities)
+	return identities, err
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	// unverified addresses are neither linked nor used to create users
+	_, _, err := OIDCLogin("test", "subject-1", "oidc.new@example.com", false, "Jane", "Doe",
+		"ETH")
+	assert.Equal(t, ErrEmailNotVerified, err)
+	_, err = FindUserByEmail("oidc.new@example.com")
+	assert.Error(t, err)
+
+	// a new user is created just in time
+	u, created, err := OIDCLogin("test", "subject-1", "oidc.new@example.com", true, "Jane",
+		"Doe", "ETH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+	assert.True(t, created)
+	assert.True(t, u.Verified)
+	assert.True(t, u.PasswordInvalid)
+	assert.False(t, u.IsAdmin)
+	assert.Equal(t, "Jane", u.FirstName)
+
+	// the subject logs in as the same user, even after the address changed at the provider
+	again, created, err := OIDCLogin("test", "subject-1", "other@example.com", false, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, created)
+	assert.Equal(t, u.ID, again.ID)
+
+	// existing users are linked by their verified address
+	existing, err := RegisterUser("oidc-existing", "Scion Test-Bed", "oidc.existing@example.com",
+		"some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer existing.Delete()
+	defer existing.Account.Delete()
+	_, _, err = OIDCLogin("test", "subject-2", existing.Email, false, "", "", "")
+	assert.Equal(t, ErrEmailNotVerified, err)
+	linked, created, err := OIDCLogin("test", "subject-2", existing.Email, true, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, created)
+	assert.Equal(t, existing.ID, linked.ID)
+	assert.True(t, linked.Verified)
+	// the password chosen by whoever registered the unverified address is invalidated
+	assert.True(t, linked.PasswordInvalid)
+	stored, err := FindUserByEmail(existing.Email)
+	if assert.NoError(t, err) {
+		assert.True(t, stored.PasswordInvalid)
+		assert.Error(t, stored.Authenticate("some password"))
+	}
+	identity, err := FindOIDCIdentity("test", "subject-2")
+	if assert.NoError(t, err) {
+		assert.Equal(t, existing.ID, identity.User.ID)
+	}
+
+	// verified users keep their password
+	verified, err := RegisterUser("oidc-verified", "Scion Test-Bed", "oidc.verified@example.com",
+		"some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer verified.Delete()
+	defer verified.Account.Delete()
+	verified.UpdateVerified(true)
+	if _, _, err = OIDCLogin("test", "subject-3", verified.Email, true, "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	stored, err = FindUserByEmail(verified.Email)
+	if assert.NoError(t, err) {
+		assert.False(t, stored.PasswordInvalid)
+		assert.NoError(t, stored.Authenticate("some password"))
+	}
+
+	// subjects are distinguished per provider
+	_, _, err = OIDCLogin("other", "subject-2", "", false, "", "", "")
+	assert.Equal(t, ErrEmailNotVerified, err)
+}
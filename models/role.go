@@ -0,0 +1,306 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/scionproto/scion/go/lib/addr"
+)
+
+// Permissions checked by the routes
+const (
+	PermAPSync      = "ap.sync"      // synchronize the APs of the own account
+	PermAPManage    = "ap.manage"    // synchronize the APs of other accounts, limited to an ISD
+	PermASRead      = "as.read"      // read the configuration and certificates of all ASes
+	PermUsersManage = "users.manage" // manage users and their AS quotas, rotate account secrets
+	PermRolesManage = "roles.manage" // assign roles
+)
+
+// AllPermissions are all permissions a role can grant
+var AllPermissions = []string{PermAPSync, PermAPManage, PermASRead, PermUsersManage,
+	PermRolesManage}
+
+// Built-in roles
+const (
+	RoleAPOperator  = "ap_operator"
+	RoleISDOperator = "isd_operator"
+	RoleSupport     = "support"
+	RoleAdmin       = "admin"
+)
+
+// DefaultRoles are created by InitializeRoles if they do not exist
+var DefaultRoles = []Role{
+	{Name: RoleAPOperator, Description: "Operates the APs of the own account",
+		Permissions: PermAPSync},
+	{Name: RoleISDOperator, Description: "Manages the APs of an ISD",
+		Permissions: strings.Join([]string{PermAPSync, PermAPManage}, ",")},
+	{Name: RoleSupport, Description: "Read-only access to all user ASes",
+		Permissions: PermASRead},
+	{Name: RoleAdmin, Description: "Full administrator",
+		Permissions: strings.Join(AllPermissions, ",")},
+}
+
+// AllISDs is the ISD of role assignments that are not limited to an ISD
+const AllISDs addr.ISD = 0
+
+// ErrRoleAssigned is returned by AssignRole if the user already has the role in the ISD
+var ErrRoleAssigned = errors.New("the role is already assigned to the user")
+
+// Role is a named set of permissions
+type Role struct {
+	ID          uint64 `orm:"column(id);auto;pk"`
+	Name        string `orm:"unique"`
+	Description string
+	Permissions string // comma separated
+}
+
+// RoleAssignment gives a role to a user, optionally limited to an ISD
+type RoleAssignment struct {
+	ID      uint64   `orm:"column(id);auto;pk"`
+	User    *user    `orm:"rel(fk);index;on_delete(cascade)"`
+	Role    *Role    `orm:"rel(fk);on_delete(cascade)"`
+	ISD     addr.ISD `orm:"column(isd);default(0)"` // AllISDs if not limited
+	Created time.Time
+}
+
+// TableUnique prevents assigning a role twice in the same ISD
+func (a *RoleAssignment) TableUnique() [][]string {
+	return [][]string{{"User", "Role", "ISD"}}
+}
+
+// PermissionList returns the permissions of the role
+func (r *Role) PermissionList() []string {
+	if r.Permissions == "" {
+		return []string{}
+	}
+	return strings.Split(r.Permissions, ",")
+}
+
+// Grants maps the permissions of a user to the ISDs they are limited to
+type Grants map[string][]addr.ISD
+
+func (g Grants) add(perms []string, isd addr.ISD) {
+	for _, p := range perms {
+		g[p] = append(g[p], isd)
+	}
+}
+
+// Has returns whether the permission is granted in any ISD
+func (g Grants) Has(perm string) bool {
+	return len(g[perm]) > 0
+}
+
+// HasInISD returns whether the permission is granted in the ISD
+func (g Grants) HasInISD(perm string, isd addr.ISD) bool {
+	for _, i := range g[perm] {
+		if i == AllISDs || i == isd {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the granted permissions, sorted
+func (g Grants) Permissions() []string {
+	perms := []string{}
+	for p := range g {
+		perms = append(perms, p)
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+// FindRoles returns all roles ordered by name
+func FindRoles() ([]Role, error) {
+	var roles []Role
+	_, err := o.QueryTable(new(Role)).OrderBy("Name").All(&roles)
+	return roles, err
+}
+
+// FindRoleAssignments returns all role assignments with their users and roles
+func FindRoleAssignments() ([]RoleAssignment, error) {
+	var a []RoleAssignment
+	_, err := o.QueryTable(new(RoleAssignment)).RelatedSel().OrderBy("User__Email", "ID").All(&a)
+	return a, err
+}
+
+// FindRoleAssignmentsByUserEmail returns the role assignments of the user with their roles
+func FindRoleAssignmentsByUserEmail(email string) ([]RoleAssignment, error) {
+	var a []RoleAssignment
+	_, err := o.QueryTable(new(RoleAssignment)).Filter("User__Email", email).RelatedSel().
+		OrderBy("ID").All(&a)
+	return a, err
+}
+
+// FindRoleAssignmentByID returns the role assignment with its user and role
+func FindRoleAssignmentByID(id uint64) (*RoleAssignment, error) {
+	a := new(RoleAssignment)
+	err := o.QueryTable(a).Filter("ID", id).RelatedSel().One(a)
+	return a, err
+}
+
+// UserEmail returns the email address of the user of the assignment
+func (a *RoleAssignment) UserEmail() string {
+	if a.User == nil {
+		return ""
+	}
+	return a.User.Email
+}
+
+// AssignRole gives the role with the name to the user, limited to the ISD unless it is AllISDs.
+// Assigning the admin role marks the user as admin.
+func AssignRole(email, roleName string, isd addr.ISD) (*RoleAssignment, error) {
+	u, err := FindUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	role := &Role{Name: roleName}
+	if err := o.Read(role, "Name"); err != nil {
+		return nil, err
+	}
+	exists := o.QueryTable(new(RoleAssignment)).Filter("User", u).Filter("Role", role).
+		Filter("ISD", isd).Exist()
+	if exists {
+		return nil, ErrRoleAssigned
+	}
+	a := &RoleAssignment{User: u, Role: role, ISD: isd, Created: time.Now().UTC()}
+	if _, err := o.Insert(a); err != nil {
+		return nil, err
+	}
+	if roleName == RoleAdmin && !u.IsAdmin {
+		u.IsAdmin = true
+		if _, err := o.Update(u, "IsAdmin"); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Delete revokes the role assignment. The user is no longer marked as admin without any
+// assignment of the admin role.
+func (a *RoleAssignment) Delete() error {
+	if _, err := o.Delete(a); err != nil {
+		return err
+	}
+	if a.Role.Name != RoleAdmin {
+		return nil
+	}
+	stillAdmin := o.QueryTable(a).Filter("User", a.User).Filter("Role", a.Role).Exist()
+	if stillAdmin {
+		return nil
+	}
+	u := &user{ID: a.User.ID, IsAdmin: false}
+	_, err := o.Update(u, "IsAdmin")
+	return err
+}
+
+// Grants returns the permissions of the user. The admin role only grants permissions if the user
+// obtains the admin privileges, see HasAdminPrivileges. Disabled users have no permissions.
+func (u *user) Grants() (Grants, error) {
+	if u.Disabled {
+		return make(Grants), nil
+	}
+	var assignments []RoleAssignment
+	_, err := o.QueryTable(new(RoleAssignment)).Filter("User__ID", u.ID).RelatedSel("Role").
+		All(&assignments)
+	if err != nil {
+		return nil, err
+	}
+	g := make(Grants)
+	for _, a := range assignments {
+		if a.Role.Name == RoleAdmin && !u.HasAdminPrivileges() {
+			continue
+		}
+		g.add(a.Role.PermissionList(), a.ISD)
+	}
+	return g, nil
+}
+
+// Grants returns the union of the permissions of the users of the account, used for requests
+// authenticated with the account secret
+func (a *Account) Grants() (Grants, error) {
+	if _, err := o.LoadRelated(a, "Users"); err != nil {
+		return nil, err
+	}
+	g := make(Grants)
+	for _, u := range a.Users {
+		userGrants, err := u.Grants()
+		if err != nil {
+			return nil, err
+		}
+		for p, isds := range userGrants {
+			g[p] = append(g[p], isds...)
+		}
+	}
+	return g, nil
+}
+
+// InitializeRoles creates the missing default roles. When the roles are created for the first
+// time, the admin role is assigned to the users marked as admin and the AP operator role to the
+// users of accounts owning an AP, so that they keep their access.
+func InitializeRoles() error {
+	firstRun := !o.QueryTable(new(Role)).Exist()
+	for _, r := range DefaultRoles {
+		role := r
+		if _, _, err := o.ReadOrCreate(&role, "Name"); err != nil {
+			return err
+		}
+	}
+	if !firstRun {
+		return nil
+	}
+
+	var admins []*user
+	if _, err := o.QueryTable(new(user)).Filter("IsAdmin", true).All(&admins); err != nil {
+		return err
+	}
+	for _, u := range admins {
+		if _, err := AssignRole(u.Email, RoleAdmin, AllISDs); err != nil {
+			return err
+		}
+	}
+
+	aps, err := FindAllAttachmentPoints()
+	if err != nil {
+		return err
+	}
+	operators := make(map[string]bool)
+	for _, ap := range aps {
+		u, err := FindUserByEmail(ap.AS.UserEmail)
+		if err == orm.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := o.LoadRelated(u.Account, "Users"); err != nil {
+			return err
+		}
+		for _, accountUser := range u.Account.Users {
+			operators[accountUser.Email] = true
+		}
+	}
+	for email := range operators {
+		if _, err := AssignRole(email, RoleAPOperator, AllISDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
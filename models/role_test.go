@@ -0,0 +1,90 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoles(t *testing.T) {
+	if err := InitializeRoles(); err != nil {
+		t.Fatal(err)
+	}
+	u, err := RegisterUser("roles", "Scion Test-Bed", "roles@example.com", "some password",
+		"Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+
+	grants, err := u.Grants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, grants.Permissions())
+
+	// ISD operators only manage the APs of their ISD
+	isdOperator, err := AssignRole(u.Email, RoleISDOperator, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = AssignRole(u.Email, RoleISDOperator, 17)
+	assert.Equal(t, ErrRoleAssigned, err)
+	_, err = AssignRole(u.Email, RoleSupport, AllISDs)
+	assert.NoError(t, err)
+	grants, err = u.Grants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{PermAPManage, PermAPSync, PermASRead}, grants.Permissions())
+	assert.True(t, grants.HasInISD(PermAPManage, 17))
+	assert.False(t, grants.HasInISD(PermAPManage, 18))
+	assert.True(t, grants.HasInISD(PermASRead, 18))
+	assert.False(t, grants.Has(PermUsersManage))
+
+	// the users of the account share their grants for requests with the account secret
+	accountGrants, err := u.Account.Grants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, grants.Permissions(), accountGrants.Permissions())
+
+	// the admin role marks the user as admin until its last assignment is revoked
+	admin, err := AssignRole(u.Email, RoleAdmin, AllISDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := FindUserByEmail(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, stored.IsAdmin)
+	assert.NoError(t, admin.Delete())
+	stored, err = FindUserByEmail(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, stored.IsAdmin)
+
+	assert.NoError(t, isdOperator.Delete())
+	grants, err = u.Grants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{PermASRead}, grants.Permissions())
+}
@@ -31,37 +31,48 @@ import (
 // TODO(mlegner): Some of the functions here may not be optimally efficient
 
 type AttachmentPoint struct {
-	ID          uint64        `orm:"column(id);auto;pk"`
-	HasVPN      bool          `orm:"column(has_vpn);default(1)"`
-	VPNPort     uint16        `orm:"column(vpn_port);default(1194)"`
-	VPNIP       string        `orm:"column(vpn_ip)"`
-	StartVPNIP  string        `orm:"column(start_vpn_ip)"`
-	EndVPNIP    string        `orm:"column(end_vpn_ip)"`
-	AS          *SCIONLabAS   `orm:"column(as_id);rel(one);on_delete(cascade)"`
-	Connections []*Connection `orm:"reverse(many);index"` // List of Connections
-}
-
-// TODO(philippmao, mlegner): Link SCIONLabAS to user model?
-// TODO(mlegner): Maybe it would make more sense to replace the user by an account here
+	ID           uint64        `orm:"column(id);auto;pk"`
+	HasVPN       bool          `orm:"column(has_vpn);default(1)"`
+	VPNType      string        `orm:"column(vpn_type);default(openvpn)"` // VPN server of the AP: OpenVPN or WireGuard
+	VPNPort      uint16        `orm:"column(vpn_port);default(1194)"`
+	VPNIP        string        `orm:"column(vpn_ip)"`
+	StartVPNIP   string        `orm:"column(start_vpn_ip)"`
+	EndVPNIP     string        `orm:"column(end_vpn_ip)"`
+	VPNSubnet    string        `orm:"column(vpn_subnet)"`               // CIDR of the VPN pool; StartVPNIP-EndVPNIP if empty
+	VPNExcluded  string        `orm:"column(vpn_excluded)"`             // Comma separated addresses not assigned from the pool
+	WGPublicKey  string        `orm:"column(wg_public_key)"`            // Public key of the AP's WireGuard interface
+	MaxMTU       uint16        `orm:"column(max_mtu);default(0)"`       // Largest link MTU accepted; 0 means config.MTU
+	MaxBandwidth uint64        `orm:"column(max_bandwidth);default(0)"` // Largest link bandwidth accepted; 0 means config.Bandwidth
+	AS           *SCIONLabAS   `orm:"column(as_id);rel(one);on_delete(cascade)"`
+	Connections  []*Connection `orm:"reverse(many);index"` // List of Connections
+}
+
 type SCIONLabAS struct {
-	ID          uint64           `orm:"column(id);auto;pk"`
-	UserEmail   string           // Owner of the AS
-	PublicIP    string           `orm:"column(public_ip)"` // IP address of the AS; can be empty in case of VPN-based setups
-	StartPort   uint16           // First port used for border routers
-	ISD         addr.ISD         `orm:"column(isd);default(0)"` // 0 means no ISD is joined
-	ASID        addr.AS          `orm:"column(as_id)"`
-	Core        bool             `orm:"default(false)"` // Is this SCIONLabAS a core AS
-	Label       string           // Optional label for this AS (can be chosen by the user)
-	Status      uint8            `orm:"default(0)"` // Status of the AS: Active, Create, ...
-	Type        uint8            `orm:"default(0)"` // Type of the AS: Box, VM, Dedicated, ...
-	AP          *AttachmentPoint `orm:"null;reverse(one)"`
-	Credits     int64            // Credits in virtual credit system
-	Branch      string           `orm:"default(scionlab)"` // Update branch the AS is tracking ("scionlab", "scionlab_testing", "none")
-	Created     time.Time        // When the AS was first created
-	Updated     time.Time        // Last time the configuration was modified or the AS called `ConfirmUpdate`
-	Connections []*Connection    `orm:"reverse(many)"` // List of Connections
-	RemapStatus string           `orm:"size(1000);type(json);null"`
-	ConfVersion uint             `orm:"default(0)"`
+	ID            uint64           `orm:"column(id);auto;pk"`
+	UserEmail     string           // Contact of the AS, receives its emails
+	PublicIP      string           `orm:"column(public_ip)"` // IP address of the AS; can be empty in case of VPN-based setups
+	StartPort     uint16           // First port used for border routers
+	ISD           addr.ISD         `orm:"column(isd);default(0)"` // 0 means no ISD is joined
+	ASID          addr.AS          `orm:"column(as_id)"`
+	Core          bool             `orm:"default(false)"` // Is this SCIONLabAS a core AS
+	Label         string           // Optional label for this AS (can be chosen by the user)
+	Status        uint8            `orm:"default(0)"` // Status of the AS: Active, Create, ...
+	Type          uint8            `orm:"default(0)"` // Type of the AS: Box, VM, Dedicated, ...
+	AP            *AttachmentPoint `orm:"null;reverse(one)"`
+	Credits       int64            // Credits in virtual credit system
+	Branch        string           `orm:"default(scionlab)"` // Update branch the AS is tracking ("scionlab", "scionlab_testing", "none")
+	Created       time.Time        // When the AS was first created
+	Updated       time.Time        // Last time the configuration was modified or the AS called `ConfirmUpdate`
+	Connections   []*Connection    `orm:"reverse(many)"` // List of Connections
+	RemapStatus   string           `orm:"size(1000);type(json);null"`
+	ConfVersion   uint             `orm:"default(0)"`
+	MTU           uint16           `orm:"column(mtu);default(0)"` // MTU inside the AS; 0 means config.MTU
+	ServicePort   uint16           `orm:"default(0)"`             // First port of the internal services; 0 means config.ServiceStartPort
+	CertVersion   uint64           `orm:"default(0)"`             // Version of the newest cached AS certificate
+	CertExpires   time.Time        `orm:"null"`                   // Expiration time of that certificate
+	PackageFormat string           // Format of the configuration package; empty means the default of the type
+	// Owner of the AS, its members have access according to their role, see account_member.go
+	Account *Account `orm:"rel(fk);null;index;on_delete(set_null)"`
 }
 
 type Connection struct {
@@ -74,8 +85,12 @@ type Connection struct {
 	RespondBRID   uint16           `orm:"column(respond_br_id)"`      // ID of the responding AS's border router
 	Linktype      uint8            // role of the responding AS
 	IsVPN         bool             `orm:"column(is_vpn)"`
+	VPNType       string           `orm:"column(vpn_type)"`      // OpenVPN or WireGuard; empty means OpenVPN
+	WGPublicKey   string           `orm:"column(wg_public_key)"` // WireGuard public key of the joining AS
 	JoinStatus    uint8
 	RespondStatus uint8
+	MTU           uint16 `orm:"column(mtu);default(0)"` // MTU of the link; 0 means config.MTU
+	Bandwidth     uint64 `orm:"default(0)"`             // Bandwidth of the link; 0 means config.Bandwidth
 	Created       time.Time
 	Updated       time.Time
 }
@@ -103,8 +118,12 @@ type ConnectionInfo struct {
 	LocalPort            uint16 // port of the local border router
 	Linktype             uint8  //"PARENT","CHILD"
 	IsVPN                bool
+	VPNType              string // as stored in the Connection
+	WGPublicKey          string // WireGuard public key of the joining AS
 	Status               uint8
-	KeepASStatusOnUpdate bool // true if this WAS a connection to an AP, but it needs to be deleted in the AP
+	MTU                  uint16 // as stored in the Connection; use LinkMTU() for the effective value
+	Bandwidth            uint64 // as stored in the Connection; use LinkBandwidth() for the effective value
+	KeepASStatusOnUpdate bool   // true if this WAS a connection to an AP, but it needs to be deleted in the AP
 	UpdatedOn            time.Time
 }
 
@@ -115,6 +134,32 @@ func (cn *ConnectionInfo) IsCurrentConnection() bool {
 	return !cn.KeepASStatusOnUpdate
 }
 
+// LinkMTU returns the MTU of the link, falling back to the configured default
+func (cn *ConnectionInfo) LinkMTU() uint16 {
+	return MTUOrDefault(cn.MTU)
+}
+
+// LinkBandwidth returns the bandwidth of the link, falling back to the configured default
+func (cn *ConnectionInfo) LinkBandwidth() uint64 {
+	return BandwidthOrDefault(cn.Bandwidth)
+}
+
+// MTUOrDefault returns mtu, or the configured default MTU if mtu is not set
+func MTUOrDefault(mtu uint16) uint16 {
+	if mtu == 0 {
+		return uint16(config.MTU)
+	}
+	return mtu
+}
+
+// BandwidthOrDefault returns bandwidth, or the configured default bandwidth if it is not set
+func BandwidthOrDefault(bandwidth uint64) uint64 {
+	if bandwidth == 0 {
+		return uint64(config.Bandwidth)
+	}
+	return bandwidth
+}
+
 func filterConnectionsByBeingCurrentStatus(cns []ConnectionInfo, active bool) []ConnectionInfo {
 	var res []ConnectionInfo
 	for _, cn := range cns {
@@ -163,6 +208,34 @@ func (as *SCIONLabAS) ServerIP() string {
 	}
 }
 
+// InternalMTU returns the MTU used inside the AS
+func (as *SCIONLabAS) InternalMTU() uint16 {
+	return MTUOrDefault(as.MTU)
+}
+
+// ServicePortBase returns the first port used by the internal SCION services of the AS
+func (as *SCIONLabAS) ServicePortBase() uint16 {
+	if as.ServicePort == 0 {
+		return config.ServiceStartPort
+	}
+	return as.ServicePort
+}
+
+// HasVPNType tells whether the AP runs a VPN server of the given type
+func (ap *AttachmentPoint) HasVPNType(vpnType string) bool {
+	return ap.HasVPN && VPNTypeOrDefault(ap.VPNType) == vpnType
+}
+
+// LinkMTULimit returns the largest link MTU this AP accepts for connections
+func (ap *AttachmentPoint) LinkMTULimit() uint16 {
+	return MTUOrDefault(ap.MaxMTU)
+}
+
+// BandwidthLimit returns the largest link bandwidth this AP accepts for connections
+func (ap *AttachmentPoint) BandwidthLimit() uint64 {
+	return BandwidthOrDefault(ap.MaxBandwidth)
+}
+
 // This function determines the BindIP address used for the border router of a given connection
 // TODO(mlegner): This should be replaced by an iptables rule and simply the ServerIP here
 func (as *SCIONLabAS) BindIP(isVPN bool, connectionIP string) string {
@@ -197,6 +270,12 @@ func (ap *AttachmentPoint) Update() error {
 }
 
 func (as *SCIONLabAS) Insert() error {
+	if as.Account == nil {
+		// owned by the account of the contact
+		if u, err := FindUserByEmail(as.UserEmail); err == nil {
+			as.Account = u.Account
+		}
+	}
 	as.Created = time.Now().UTC()
 	as.Updated = time.Now().UTC()
 	_, err := o.Insert(as)
@@ -252,23 +331,6 @@ func (as *SCIONLabAS) GetFreeBRID() (uint16, error) {
 	return uint16(id), err
 }
 
-// TODO(mlegner): Avoid signed/unsigned casting; could be problematic if huge IP ranges are used
-func (as *SCIONLabAS) GetFreeVPNIP() (string, error) {
-	cns, err := as.GetRespondConnections()
-	if err != nil {
-		return "", fmt.Errorf("Error finding connections of AP %v: %v", as.IAString(), err)
-	}
-	var vpnIPs []int
-	for _, cn := range cns {
-		if cn.IsVPN {
-			vpnIPs = append(vpnIPs, int(utility.IPToInt(cn.JoinIP)))
-		}
-	}
-	newIP, err := utility.GetAvailableID(vpnIPs, int(utility.IPToInt(as.AP.StartVPNIP)),
-		int(utility.IPToInt(as.AP.EndVPNIP)))
-	return utility.IntToIP(uint32(newIP)), err
-}
-
 // Only returns the connections of the AS in its function as the joining AS
 func (as *SCIONLabAS) GetJoinConnections() ([]*Connection, error) {
 	_, err := o.LoadRelated(as, "Connections")
@@ -370,7 +432,11 @@ func (as *SCIONLabAS) GetJoinConnectionInfo() ([]ConnectionInfo, error) {
 			LocalPort:            joinAS.GetPortNumberFromBRID(cn.JoinBRID),
 			Linktype:             cn.Linktype,
 			IsVPN:                cn.IsVPN,
+			VPNType:              cn.VPNType,
+			WGPublicKey:          cn.WGPublicKey,
 			Status:               cn.JoinStatus,
+			MTU:                  cn.MTU,
+			Bandwidth:            cn.Bandwidth,
 			KeepASStatusOnUpdate: cn.RespondStatus == Remove && cn.JoinStatus == Remove,
 			UpdatedOn:            cn.Updated,
 		}
@@ -412,7 +478,11 @@ func (as *SCIONLabAS) GetRespondConnectionInfo() ([]ConnectionInfo, error) {
 			LocalPort:            respondAS.GetPortNumberFromBRID(cn.RespondBRID),
 			Linktype:             linktype,
 			IsVPN:                cn.IsVPN,
+			VPNType:              cn.VPNType,
+			WGPublicKey:          cn.WGPublicKey,
 			Status:               cn.RespondStatus,
+			MTU:                  cn.MTU,
+			Bandwidth:            cn.Bandwidth,
 			KeepASStatusOnUpdate: cn.RespondStatus == Remove && cn.JoinStatus == Remove,
 			UpdatedOn:            cn.Updated,
 		}
@@ -483,8 +553,12 @@ func (as *SCIONLabAS) UpdateDBConnectionFromJoinConnInfo(cnInfo *ConnectionInfo)
 		return err
 	}
 	cn.IsVPN = cnInfo.IsVPN
+	cn.VPNType = cnInfo.VPNType
+	cn.WGPublicKey = cnInfo.WGPublicKey
 	cn.JoinIP = cnInfo.LocalIP
 	cn.RespondIP = cnInfo.NeighborIP
+	cn.MTU = cnInfo.MTU
+	cn.Bandwidth = cnInfo.Bandwidth
 
 	respondAS := cn.GetRespondAS()
 	joinAS := cn.GetJoinAS()
@@ -528,6 +602,13 @@ func GetAllAPs() ([]*SCIONLabAS, error) {
 	return ases, err
 }
 
+// FindAllAttachmentPoints returns all AttachmentPoints with their ASes
+func FindAllAttachmentPoints() ([]*AttachmentPoint, error) {
+	var aps []*AttachmentPoint
+	_, err := o.QueryTable(new(AttachmentPoint)).RelatedSel().All(&aps)
+	return aps, err
+}
+
 // Returns all Attachment Point ASes in the given ISD
 func FindAllAPsByISD(isd addr.ISD) ([]*SCIONLabAS, error) {
 	var aps []*AttachmentPoint
@@ -569,20 +650,20 @@ func FindSCIONLabASesByAccountID(accountID string) (asStrings []string, err erro
 	if err != nil {
 		return
 	}
-	o.LoadRelated(a, "Users")
-	for _, u := range a.Users {
-		var ases []SCIONLabAS
-		ases, err = FindSCIONLabASesByUserEmail(u.Email)
-		if err != nil {
-			return
-		}
-		for _, as := range ases {
-			asStrings = append(asStrings, as.IAString())
-		}
+	ases, err := FindSCIONLabASesByAccount(a)
+	for _, as := range ases {
+		asStrings = append(asStrings, as.IAString())
 	}
 	return
 }
 
+// Find the SCIONLabASes owned by the account
+func FindSCIONLabASesByAccount(a *Account) ([]SCIONLabAS, error) {
+	var ases []SCIONLabAS
+	_, err := o.QueryTable(new(SCIONLabAS)).Filter("Account__ID", a.ID).RelatedSel().All(&ases)
+	return ases, err
+}
+
 // Find SCIONLabAS by the IA string
 func FindSCIONLabASByIAString(ia string) (*SCIONLabAS, error) {
 	IA, err := addr.IAFromString(ia)
@@ -607,6 +688,25 @@ func FindSCIONLabASByASID(asID addr.AS) (*SCIONLabAS, error) {
 	return as, nil
 }
 
+// FindSCIONLabASesWithCertExpiringBefore returns the active user ASes (VM or dedicated)
+// whose newest certificate expires before the given time
+func FindSCIONLabASesWithCertExpiringBefore(t time.Time) ([]SCIONLabAS, error) {
+	var ases []SCIONLabAS
+	_, err := o.QueryTable(new(SCIONLabAS)).Filter("Type__in", VM, Dedicated).
+		Filter("Status", Active).Filter("CertVersion__gt", 0).
+		Filter("CertExpires__lt", t).OrderBy("CertExpires").All(&ases)
+	return ases, err
+}
+
+// FindSCIONLabASesWithCert returns all ASes with a known certificate, the ones expiring first
+// at the beginning
+func FindSCIONLabASesWithCert() ([]SCIONLabAS, error) {
+	var ases []SCIONLabAS
+	_, err := o.QueryTable(new(SCIONLabAS)).Filter("CertVersion__gt", 0).
+		OrderBy("CertExpires").All(&ases)
+	return ases, err
+}
+
 // Find SCIONLabAS by the Public IP
 // TODO(mlegner): The PublicIP field can be empty; we need to be careful with this function
 func FindSCIONLabASesByIP(ip string) ([]SCIONLabAS, error) {
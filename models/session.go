@@ -16,6 +16,7 @@ package models
 
 import (
 	"encoding/gob"
+	"time"
 )
 
 type Session struct {
@@ -28,6 +29,16 @@ type Session struct {
 	HasLoggedIn  bool
 	IsAdmin      bool
 	Error        string // errors to display while rendering the template
+	// set after a correct password while the second factor of the login is outstanding
+	TwoFactorPending  bool
+	TwoFactorStarted  time.Time
+	TwoFactorAttempts int
+	// set while the user is redirected to an OpenID Connect provider, see OIDCLogin
+	OIDCProvider string
+	OIDCState    string
+	OIDCNonce    string
+	OIDCVerifier string
+	OIDCStarted  time.Time
 }
 
 type M map[string]interface{}
@@ -0,0 +1,193 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/totp"
+)
+
+const (
+	// RecoveryCodeCount is the number of recovery codes generated at once
+	RecoveryCodeCount  = 10
+	recoveryCodeLength = 10 // characters, without the separator
+	// time steps of clock drift tolerated between the coordinator and the authenticator app
+	totpSkew = 1
+)
+
+var (
+	ErrTOTPEnabled      = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled   = errors.New("two-factor authentication is not enabled")
+	ErrTOTPNotEnrolled  = errors.New("no two-factor enrolment in progress")
+	ErrInvalidTOTPCode  = errors.New("invalid authentication code")
+	recoveryCodeEncoder = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// normalizeCode removes the separators and spaces users may type in codes
+func normalizeCode(code string) string {
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+	return strings.ToLower(code)
+}
+
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(normalizeCode(code)))
+	return hex.EncodeToString(h[:])
+}
+
+// generateRecoveryCodes returns new recovery codes, formatted as "xxxxx-xxxxx", and the comma
+// separated list of their hashes to store
+func generateRecoveryCodes() ([]string, string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	raw := make([]byte, recoveryCodeLength*5/8)
+	for i := range codes {
+		if _, err := rand.Read(raw); err != nil {
+			return nil, "", err
+		}
+		c := strings.ToLower(recoveryCodeEncoder.EncodeToString(raw))
+		codes[i] = c[:recoveryCodeLength/2] + "-" + c[recoveryCodeLength/2:]
+		hashes[i] = hashRecoveryCode(c)
+	}
+	return codes, strings.Join(hashes, ","), nil
+}
+
+// TwoFactorRequired returns whether the user has to enable two-factor authentication
+func (u *user) TwoFactorRequired() bool {
+	return u.IsAdmin && config.TwoFactorRequiredForAdmins
+}
+
+// HasAdminPrivileges returns whether the user obtains the admin privileges at login, which
+// requires two-factor authentication if configured
+func (u *user) HasAdminPrivileges() bool {
+	return u.IsAdmin && (u.TOTPEnabled || !u.TwoFactorRequired())
+}
+
+// BeginTOTPEnrolment generates a new TOTP secret for the user, which only takes effect once a
+// code generated from it is confirmed with EnableTOTP
+func (u *user) BeginTOTPEnrolment() (string, error) {
+	if u.TOTPEnabled {
+		return "", ErrTOTPEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	u.TOTPSecret = secret
+	u.TOTPLastStep = 0
+	u.Updated = time.Now().UTC()
+	_, err = o.Update(u, "TOTPSecret", "TOTPLastStep", "Updated")
+	return secret, err
+}
+
+// EnableTOTP enables two-factor authentication if the code matches the secret of the enrolment
+// at time now. It returns the recovery codes, which cannot be recovered later.
+func (u *user) EnableTOTP(code string, now time.Time) ([]string, error) {
+	if u.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+	if u.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	step, ok := totp.Verify(u.TOTPSecret, normalizeCode(code), now, totpSkew, u.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	u.TOTPEnabled = true
+	u.TOTPLastStep = step
+	u.RecoveryCodes = hashes
+	u.Updated = time.Now().UTC()
+	_, err = o.Update(u, "TOTPEnabled", "TOTPLastStep", "RecoveryCodes", "Updated")
+	return codes, err
+}
+
+// CheckSecondFactor verifies a TOTP code valid at time now or one of the recovery codes of the
+// user. Accepted codes cannot be used again.
+func (u *user) CheckSecondFactor(code string, now time.Time) error {
+	if !u.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	code = normalizeCode(code)
+	if len(code) == totp.Digits {
+		step, ok := totp.Verify(u.TOTPSecret, code, now, totpSkew, u.TOTPLastStep)
+		if !ok {
+			return ErrInvalidTOTPCode
+		}
+		u.TOTPLastStep = step
+		_, err := o.Update(u, "TOTPLastStep")
+		return err
+	}
+	hash := hashRecoveryCode(code)
+	hashes := u.recoveryCodeHashes()
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			u.RecoveryCodes = strings.Join(append(hashes[:i], hashes[i+1:]...), ",")
+			_, err := o.Update(u, "RecoveryCodes")
+			return err
+		}
+	}
+	return ErrInvalidTOTPCode
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user by new ones
+func (u *user) RegenerateRecoveryCodes() ([]string, error) {
+	if !u.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	u.RecoveryCodes = hashes
+	u.Updated = time.Now().UTC()
+	_, err = o.Update(u, "RecoveryCodes", "Updated")
+	return codes, err
+}
+
+// DisableTOTP disables two-factor authentication and removes the secret and recovery codes
+func (u *user) DisableTOTP() error {
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+	u.TOTPLastStep = 0
+	u.RecoveryCodes = ""
+	u.Updated = time.Now().UTC()
+	_, err := o.Update(u, "TOTPEnabled", "TOTPSecret", "TOTPLastStep", "RecoveryCodes", "Updated")
+	return err
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes
+func (u *user) RecoveryCodesLeft() int {
+	return len(u.recoveryCodeHashes())
+}
+
+func (u *user) recoveryCodeHashes() []string {
+	if u.RecoveryCodes == "" {
+		return nil
+	}
+	return strings.Split(u.RecoveryCodes, ",")
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwoFactor(t *testing.T) {
+	u, err := RegisterUser("two-factor", "Scion Test-Bed", "two.factor@example.com",
+		"some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+
+	clock := time.Unix(1500000000, 0)
+	assert.Equal(t, ErrTOTPNotEnabled, u.CheckSecondFactor("123456", clock))
+	_, err = u.EnableTOTP("123456", clock)
+	assert.Equal(t, ErrTOTPNotEnrolled, err)
+
+	secret, err := u.BeginTOTPEnrolment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totp.Code(secret, clock)
+	_, err = u.EnableTOTP(code, clock.Add(3*totp.Step))
+	assert.Equal(t, ErrInvalidTOTPCode, err, "expired code accepted")
+	recovery, err := u.EnableTOTP(code, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, recovery, RecoveryCodeCount)
+	assert.True(t, u.TOTPEnabled)
+
+	// the code used for the enrolment cannot be replayed for a login
+	assert.Equal(t, ErrInvalidTOTPCode, u.CheckSecondFactor(code, clock))
+	clock = clock.Add(totp.Step)
+	code, _ = totp.Code(secret, clock)
+	assert.NoError(t, u.CheckSecondFactor(code, clock))
+	assert.Equal(t, ErrInvalidTOTPCode, u.CheckSecondFactor(code, clock))
+
+	// recovery codes work once, with or without separator
+	assert.NoError(t, u.CheckSecondFactor(recovery[0], clock))
+	assert.Equal(t, ErrInvalidTOTPCode, u.CheckSecondFactor(recovery[0], clock))
+	assert.NoError(t, u.CheckSecondFactor(" "+recovery[1][:5]+recovery[1][6:], clock))
+	assert.Equal(t, RecoveryCodeCount-2, u.RecoveryCodesLeft())
+
+	// the state is persisted
+	stored, err := FindUserByEmail(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, stored.TOTPEnabled)
+	assert.Equal(t, RecoveryCodeCount-2, stored.RecoveryCodesLeft())
+	assert.Equal(t, ErrInvalidTOTPCode, stored.CheckSecondFactor(code, clock))
+
+	assert.NoError(t, u.DisableTOTP())
+	assert.Equal(t, ErrTOTPNotEnabled, u.CheckSecondFactor(recovery[2], clock))
+}
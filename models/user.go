@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -42,9 +43,12 @@ type Account struct {
 	Organisation string
 	AccountID    string `orm:"column(account_id)"`
 	Secret       string
-	Users        []*user `orm:"reverse(many);index"`
-	Created      time.Time
-	Updated      time.Time
+	// the secret replaced by the last rotation, still accepted until PreviousSecretExpires
+	PreviousSecret        string
+	PreviousSecretExpires time.Time `orm:"null"`
+	Users                 []*user   `orm:"reverse(many);index"`
+	Created               time.Time
+	Updated               time.Time
 }
 
 type user struct {
@@ -62,7 +66,23 @@ type user struct {
 	Account          *Account `orm:"rel(fk);index"`
 	Created          time.Time
 	Updated          time.Time
-	// TODO: add the 2 factor authentication
+	// two-factor authentication, see two_factor.go
+	TOTPSecret    string `orm:"column(totp_secret)"`    // base32 TOTP secret, set on enrolment
+	TOTPEnabled   bool   `orm:"column(totp_enabled)"`   // whether logins require a second factor
+	TOTPLastStep  int64  `orm:"column(totp_last_step)"` // time step of the last accepted code
+	RecoveryCodes string `orm:"type(text)"`             // comma separated hashes of the unused recovery codes
+	// failed logins, see login_throttle.go
+	FailedLogins    int
+	LastFailedLogin time.Time `orm:"null"`
+	LockedUntil     time.Time `orm:"null"`
+	// set when the user asked to delete the account, see user_data.go
+	DeletionRequested time.Time `orm:"null"`
+	// requested change of the email address, see email_change.go
+	NewEmail           string
+	EmailChangeUUID    string    `orm:"column(email_change_uuid)"`
+	EmailChangeExpires time.Time `orm:"null"`
+	// set by an admin, see user_admin.go
+	Disabled bool
 }
 
 func generateSalt() ([]byte, error) {
@@ -155,7 +175,6 @@ func RegisterUser(accountName, organisation, email, password, first, last string
 		//u.TwoFA = false // set it to false
 		u.Created = time.Now().UTC()
 		u.Updated = time.Now().UTC()
-		//u.LastLoginAttempt = time.Now().UTC()
 		// assign user
 		u.Account = a
 		u.Created = time.Now().UTC()
@@ -205,9 +224,30 @@ func FindUserByID(id string) (*user, error) {
 	return u, err
 }
 
+// FindAccountByAccountIDAndSecret returns the account if the secret is its current secret or
+// its previous one within the overlap window of the last rotation
 func FindAccountByAccountIDAndSecret(accID, secret string) (*Account, error) {
+	a, err := FindAccountByAccountID(accID)
+	if err != nil {
+		return nil, err
+	}
+	if !a.CheckSecret(secret) {
+		return nil, orm.ErrNoRows
+	}
+	disabled, err := a.LoginDisabled()
+	if err != nil {
+		return nil, err
+	}
+	if disabled {
+		return nil, ErrUserDisabled
+	}
+	return a, nil
+}
+
+// FindAccountByID returns the account with the database ID
+func FindAccountByID(id uint64) (*Account, error) {
 	a := new(Account)
-	err := o.QueryTable(a).Filter("AccountID", accID).Filter("Secret", secret).One(a)
+	err := o.QueryTable(a).Filter("ID", id).One(a)
 	return a, err
 }
 
@@ -225,6 +265,34 @@ func FindAccountByUserEmail(email string) (*Account, error) {
 	return user.Account, nil
 }
 
+// CheckSecret returns whether the secret authenticates the account
+func (a *Account) CheckSecret(secret string) bool {
+	if secret == "" {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(a.Secret)) == 1 {
+		return true
+	}
+	return a.PreviousSecret != "" && time.Now().Before(a.PreviousSecretExpires) &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(a.PreviousSecret)) == 1
+}
+
+// RotateSecret replaces the secret of the account by a new random one. The old secret stays valid
+// for the overlap, so that running ASes can fetch their new configuration.
+func (a *Account) RotateSecret(overlap time.Duration) error {
+	secret := make([]byte, SecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	a.PreviousSecret = a.Secret
+	a.PreviousSecretExpires = now.Add(overlap)
+	a.Secret = hex.EncodeToString(secret)
+	a.Updated = now
+	_, err := o.Update(a, "Secret", "PreviousSecret", "PreviousSecretExpires", "Updated")
+	return err
+}
+
 func (u *user) Delete() error {
 	_, err := o.Delete(u)
 	return err
@@ -235,15 +303,20 @@ func (a *Account) Delete() error {
 	return err
 }
 
+// Authenticate checks the password and whether the email is verified. Failed attempts are
+// recorded; while the user has to wait before the next attempt, a *LoginThrottledError is
+// returned without checking the password. The failed attempts are not reset, as the login may
+// still require a second factor; see ResetFailedLogins.
 func (u *user) Authenticate(password string) error {
+	now := time.Now()
+	if err := u.CheckLoginThrottle(now); err != nil {
+		return err
+	}
 
-	// if u.Locked {
-	// 	return errors.New("User locked.")
-	// }
-
-	// the user did less than N login attempts
-	//if u.FailedAttempts <= MAX_LOGIN_ATTEMPTS {
 	if err := u.CheckPassword(password); err != nil {
+		if lockErr := u.RecordFailedLogin(now); lockErr != nil {
+			return lockErr
+		}
 		return err
 	}
 
@@ -251,36 +324,23 @@ func (u *user) Authenticate(password string) error {
 		return err
 	}
 
-	return nil
-	//}
+	if u.Disabled {
+		return ErrUserDisabled
+	}
 
-	// this means the user tried to log in more than 15 minutes ago
-	// if validLockDownWindow(u.LastLoginAttempt) {
-	// 	return u.CheckPassword(password)
-	// }
+	if u.DeletionPending() {
+		return ErrDeletionPending
+	}
 
-	// return errors.New("Too many login attempts. Account locked.")
+	return nil
 }
 
 func (u *user) CheckPassword(password string) error {
-	// update time of attempts
-	// if err := u.UpdateLastLoginAttempt(); err != nil {
-	// 	return err
-	// }
-
 	valid := validUserPassword(u.Password, u.Salt, password)
 	if valid {
-		// if err := u.ResetFailedAttempts(); err != nil {
-		// 	return err
-		// }
 		return nil // means the user is successfully authenticated !
 	}
 
-	// // update amount of attempts
-	// if err := u.UpdateFailedAttempts(); err != nil {
-	// 	return err
-	// }
-
 	return errors.New("password invalid")
 }
 
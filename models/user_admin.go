@@ -0,0 +1,163 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// ErrUserDisabled is returned when logging in as a user disabled by an admin, or with the
+// secret of an account whose users are all disabled
+var ErrUserDisabled = errors.New("the login is disabled")
+
+// Actions of admins on users and accounts, recorded as AdminAction
+const (
+	ActionSetAdmin           = "set_admin"
+	ActionUnsetAdmin         = "unset_admin"
+	ActionDisable            = "disable"
+	ActionEnable             = "enable"
+	ActionResetPassword      = "reset_password"
+	ActionResendVerification = "resend_verification"
+	ActionSetQuota           = "set_quota"
+	ActionResetQuota         = "reset_quota"
+)
+
+// AdminAction records an action of an admin on a user or an account. The user is referred to by
+// ID, so that the record survives a change of the email address; the addresses are kept as they
+// were. Actions on an account have UserID 0 and refer to the account by its ID instead.
+type AdminAction struct {
+	ID          uint64 `orm:"column(id);auto;pk"`
+	Admin       string // email address of the acting admin
+	UserID      uint64 `orm:"column(user_id);index"`
+	UserEmail   string // email address of the user at the time of the action
+	AccountID   uint64 `orm:"column(account_id);index;default(0)"`
+	AccountName string // name of the account at the time of the action
+	Action      string
+	Details     string
+	Created     time.Time
+}
+
+// RecordAdminAction records the action of the admin on the user
+func RecordAdminAction(admin string, u *user, action, details string) error {
+	a := &AdminAction{
+		Admin:     admin,
+		UserID:    u.ID,
+		UserEmail: u.Email,
+		Action:    action,
+		Details:   details,
+		Created:   time.Now().UTC(),
+	}
+	_, err := o.Insert(a)
+	return err
+}
+
+// RecordAdminAccountAction records the action of the admin on the account
+func RecordAdminAccountAction(admin string, a *Account, action, details string) error {
+	r := &AdminAction{
+		Admin:       admin,
+		AccountID:   a.ID,
+		AccountName: a.Name,
+		Action:      action,
+		Details:     details,
+		Created:     time.Now().UTC(),
+	}
+	_, err := o.Insert(r)
+	return err
+}
+
+// FindAdminActions returns the recorded actions on the user with the ID, or on all users and
+// accounts if it is 0, the newest first. It returns at most limit actions starting at offset, and the total
+// number of actions.
+func FindAdminActions(userID uint64, offset, limit int) ([]AdminAction, int64, error) {
+	qs := o.QueryTable(new(AdminAction))
+	if userID != 0 {
+		qs = qs.Filter("UserID", userID)
+	}
+	total, err := qs.Count()
+	if err != nil {
+		return nil, 0, err
+	}
+	var actions []AdminAction
+	_, err = qs.OrderBy("-Created", "-ID").Limit(limit, offset).All(&actions)
+	return actions, total, err
+}
+
+// FindUsers returns the users whose email address, name or organisation contains the query,
+// ignoring case, ordered by email address. It returns at most limit users starting at offset,
+// and the total number of matching users.
+func FindUsers(query string, offset, limit int) ([]*user, int64, error) {
+	qs := o.QueryTable(new(user))
+	if query != "" {
+		cond := orm.NewCondition().Or("Email__icontains", query).
+			Or("FirstName__icontains", query).Or("LastName__icontains", query).
+			Or("Account__Organisation__icontains", query)
+		qs = qs.SetCond(cond)
+	}
+	total, err := qs.Count()
+	if err != nil {
+		return nil, 0, err
+	}
+	var users []*user
+	_, err = qs.RelatedSel().OrderBy("Email").Limit(limit, offset).All(&users)
+	return users, total, err
+}
+
+// SetDisabled disables or re-enables the login of the user. The sessions of a disabled user
+// have to be revoked separately.
+func (u *user) SetDisabled(disabled bool) error {
+	u.Disabled = disabled
+	u.Updated = time.Now().UTC()
+	_, err := o.Update(u, "Disabled", "Updated")
+	return err
+}
+
+// SetAdmin assigns the admin role to the user, not limited to an ISD, or revokes all assignments
+// of the admin role
+func (u *user) SetAdmin(admin bool) error {
+	if admin {
+		_, err := AssignRole(u.Email, RoleAdmin, AllISDs)
+		if err != nil && err != ErrRoleAssigned {
+			return err
+		}
+		u.IsAdmin = true
+		return nil
+	}
+	role := &Role{Name: RoleAdmin}
+	if err := o.Read(role, "Name"); err != nil {
+		return err
+	}
+	if _, err := o.QueryTable(new(RoleAssignment)).Filter("User__ID", u.ID).Filter("Role", role).
+		Delete(); err != nil {
+		return err
+	}
+	u.IsAdmin = false
+	_, err := o.Update(u, "IsAdmin")
+	return err
+}
+
+// LoginDisabled returns whether the account has users and all of them are disabled, in which
+// case the secret of the account is rejected
+func (a *Account) LoginDisabled() (bool, error) {
+	qs := o.QueryTable(new(user)).Filter("Account__ID", a.ID)
+	enabled, err := qs.Filter("Disabled", false).Count()
+	if err != nil || enabled > 0 {
+		return false, err
+	}
+	disabled, err := qs.Filter("Disabled", true).Count()
+	return disabled > 0, err
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAdmin(t *testing.T) {
+	if err := InitializeRoles(); err != nil {
+		t.Fatal(err)
+	}
+	u, err := RegisterUser("useradmin", "Admin Test Organisation", "useradmin@example.com",
+		"some password", "Jane", "Roe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+	assert.NoError(t, u.UpdateVerified(true))
+
+	// the organisation is searched as well as the email address and the names
+	users, total, err := FindUsers("admin test org", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(1), total)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, u.Email, users[0].Email)
+	}
+	_, total, err = FindUsers("ROE", 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+
+	_, token, err := NewAccessToken(u.Email, "test", []string{ScopeAS}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a disabled user can neither log in nor use the account secret or an access token
+	assert.NoError(t, u.SetDisabled(true))
+	stored, err := FindUserByEmail(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrUserDisabled, stored.Authenticate("some password"))
+	_, err = FindAccountByAccountIDAndSecret(u.Account.AccountID, u.Account.Secret)
+	assert.Equal(t, ErrUserDisabled, err)
+	_, err = FindAccessToken(token)
+	assert.Equal(t, ErrUserDisabled, err)
+	grants, err := stored.Grants()
+	assert.NoError(t, err)
+	assert.Empty(t, grants.Permissions())
+
+	assert.NoError(t, u.SetDisabled(false))
+	stored, err = FindUserByEmail(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, stored.Authenticate("some password"))
+	_, err = FindAccountByAccountIDAndSecret(u.Account.AccountID, u.Account.Secret)
+	assert.NoError(t, err)
+
+	// promoting twice is not an error and demoting revokes the role
+	assert.NoError(t, u.SetAdmin(true))
+	assert.NoError(t, u.SetAdmin(true))
+	stored, err = FindUserByEmail(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, stored.IsAdmin)
+	assert.NoError(t, u.SetAdmin(false))
+	stored, err = FindUserByEmail(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, stored.IsAdmin)
+	assignments, err := FindRoleAssignmentsByUserEmail(u.Email)
+	assert.NoError(t, err)
+	assert.Empty(t, assignments)
+
+	// the actions are listed newest first
+	assert.NoError(t, RecordAdminAction("admin@example.com", u, ActionDisable, ""))
+	assert.NoError(t, RecordAdminAction("admin@example.com", u, ActionEnable, ""))
+	actions, total, err := FindAdminActions(u.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), total)
+	if assert.Len(t, actions, 2) {
+		assert.Equal(t, ActionEnable, actions[0].Action)
+		assert.Equal(t, u.Email, actions[0].UserEmail)
+	}
+	_, err = o.QueryTable(new(AdminAction)).Filter("UserID", u.ID).Delete()
+	assert.NoError(t, err)
+
+	// actions on an account are not listed for its users
+	assert.NoError(t, RecordAdminAccountAction("admin@example.com", u.Account, ActionSetQuota,
+		"2 ASes"))
+	actions, total, err = FindAdminActions(u.ID, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+	var recorded AdminAction
+	if assert.NoError(t, o.QueryTable(new(AdminAction)).Filter("AccountID",
+		u.Account.ID).One(&recorded)) {
+		assert.Equal(t, uint64(0), recorded.UserID)
+		assert.Equal(t, u.Account.Name, recorded.AccountName)
+	}
+	_, err = o.QueryTable(new(AdminAction)).Filter("AccountID", u.Account.ID).Delete()
+	assert.NoError(t, err)
+}
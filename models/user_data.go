@@ -0,0 +1,112 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrDeletionPending is returned when logging in as a user whose account is being deleted
+var ErrDeletionPending = errors.New("the account is being deleted")
+
+// DeletionPending returns whether the user asked to delete the account. The user is deleted
+// with DeleteWithData once the ASes of the user are removed from their attachment points.
+func (u *user) DeletionPending() bool {
+	return !u.DeletionRequested.IsZero()
+}
+
+// RequestDeletion marks the user for deletion
+func (u *user) RequestDeletion() error {
+	u.DeletionRequested = time.Now().UTC()
+	_, err := o.Update(u, "DeletionRequested")
+	return err
+}
+
+// FindUsersPendingDeletion returns the users who asked to delete their account
+func FindUsersPendingDeletion() ([]*user, error) {
+	var users []*user
+	_, err := o.QueryTable(new(user)).Filter("DeletionRequested__isnull", false).
+		RelatedSel().All(&users)
+	return users, err
+}
+
+// DeleteWithData deletes the user together with the ASes and SCION box the user is the contact
+// of and the invitations the user sent. The sessions, access tokens, roles, memberships and
+// linked identities are deleted with the user. The account is deleted as well if no other user
+// is registered with it; the returned bool tells whether it was. Its remaining ASes are then
+// owned by the accounts of their contacts.
+// The ASes have to be removed from their attachment points before, and the ASes shared with
+// other owners of their account handed over to them.
+func (u *user) DeleteWithData() (bool, error) {
+	ases, err := FindSCIONLabASesByUserEmail(u.Email)
+	if err != nil {
+		return false, err
+	}
+	for i := range ases {
+		// the connections of the AS are deleted with it
+		if err := ases[i].Delete(); err != nil {
+			return false, err
+		}
+	}
+	if _, err := o.QueryTable(new(SCIONBox)).Filter("UserEmail", u.Email).Delete(); err != nil {
+		return false, err
+	}
+	if _, err := o.QueryTable(new(AccountInvitation)).Filter("InvitedBy", u.Email).
+		Delete(); err != nil {
+		return false, err
+	}
+	if err := u.Delete(); err != nil {
+		return false, err
+	}
+
+	if u.Account == nil {
+		return false, nil
+	}
+	a, err := FindAccountByID(u.Account.ID)
+	if err != nil {
+		return false, err
+	}
+	remaining, err := o.QueryTable(new(user)).Filter("Account__ID", a.ID).Count()
+	if err != nil || remaining > 0 {
+		return false, err
+	}
+	// ASes handed over to members of the account move to the account of their new contact
+	var handed []SCIONLabAS
+	if _, err := o.QueryTable(new(SCIONLabAS)).Filter("Account__ID", a.ID).All(&handed); err != nil {
+		return false, err
+	}
+	for i := range handed {
+		contact, err := FindUserByEmail(handed[i].UserEmail)
+		if err != nil {
+			return false, err
+		}
+		handed[i].Account = contact.Account
+		if _, err := o.Update(&handed[i], "Account"); err != nil {
+			return false, err
+		}
+	}
+	// the join requests and replies refer to the account by its account ID, the connection
+	// requests and replies are deleted with the account
+	for _, m := range []interface{}{new(JoinRequest), new(JoinReply)} {
+		if _, err := o.QueryTable(m).Filter("RequesterID", a.AccountID).Delete(); err != nil {
+			return false, err
+		}
+	}
+	if err := a.Delete(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -16,6 +16,7 @@ package models
 
 import (
 	"testing"
+	"time"
 )
 
 func TestCreateUser(t *testing.T) {
@@ -95,3 +96,115 @@ func TestCreateUser(t *testing.T) {
 	}
 
 }
+
+func TestRotateSecret(t *testing.T) {
+	email := "rotate.secret@example.com"
+	u, err := RegisterUser("rotate", "Scion Test-Bed", email, "some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+
+	a := u.Account
+	old := a.Secret
+	if err := a.RotateSecret(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if a.Secret == old || len(a.Secret) != 2*SecretLength {
+		t.Errorf("invalid new secret %q", a.Secret)
+	}
+	for secret, valid := range map[string]bool{a.Secret: true, old: true, "": false, "x": false} {
+		if _, err := FindAccountByAccountIDAndSecret(a.AccountID, secret); (err == nil) != valid {
+			t.Errorf("secret %q accepted: %v, expected %v", secret, err == nil, valid)
+		}
+	}
+
+	// without overlap the old secret is rejected immediately
+	previous := a.Secret
+	if err := a.RotateSecret(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindAccountByAccountIDAndSecret(a.AccountID, previous); err == nil {
+		t.Error("the previous secret is still accepted after the overlap")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	password := "some password"
+	u, err := RegisterUser("lockout", "Scion Test-Bed", "lockout@example.com", password, "Jon",
+		"Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+	u.UpdateVerified(true)
+
+	now := time.Now()
+	var lockErr error
+	for i := 0; i < UserLoginPolicy.LockoutAttempts && lockErr == nil; i++ {
+		lockErr = u.RecordFailedLogin(now)
+	}
+	throttled, ok := lockErr.(*LoginThrottledError)
+	if !ok || !throttled.NewLock {
+		t.Fatalf("no lockout after %d failures: %v", UserLoginPolicy.LockoutAttempts, lockErr)
+	}
+
+	// the correct password is rejected during the lockout, which is persisted
+	stored, err := FindUserByEmail(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.Authenticate(password).(*LoginThrottledError); !ok {
+		t.Error("login accepted during the lockout")
+	}
+	if !stored.IsLocked(now) || stored.IsLocked(now.Add(UserLoginPolicy.Lockout)) {
+		t.Errorf("wrong lockout end %v", stored.LockedUntil)
+	}
+	locked, err := FindLockedUsers(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := locked[u.Email]; !ok {
+		t.Error("locked user not listed")
+	}
+
+	if err := stored.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stored.Authenticate(password); err != nil {
+		t.Errorf("login rejected after unlocking: %v", err)
+	}
+}
+
+func TestLoginLockoutSecondFactor(t *testing.T) {
+	password := "some password"
+	u, err := RegisterUser("lockout2fa", "Scion Test-Bed", "lockout2fa@example.com", password,
+		"Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+	u.UpdateVerified(true)
+
+	// the correct password followed by a wrong second factor, as by LoginSecondFactor; the
+	// failures are recorded in the past so the back-off does not delay the next password check
+	past := time.Now().Add(-2 * UserLoginPolicy.MaxDelay)
+	var lockErr error
+	for i := 0; i < UserLoginPolicy.LockoutAttempts && lockErr == nil; i++ {
+		if err := u.Authenticate(password); err != nil {
+			t.Fatalf("password rejected after %d wrong second factors: %v", i, err)
+		}
+		lockErr = u.RecordFailedLogin(past)
+	}
+	throttled, ok := lockErr.(*LoginThrottledError)
+	if !ok || !throttled.NewLock {
+		t.Fatalf("no lockout after %d wrong second factors: %v",
+			UserLoginPolicy.LockoutAttempts, lockErr)
+	}
+	if _, ok := u.Authenticate(password).(*LoginThrottledError); !ok {
+		t.Error("login accepted during the lockout")
+	}
+}
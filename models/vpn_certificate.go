@@ -0,0 +1,64 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"time"
+)
+
+// VPNCertificate records a VPN client certificate issued by the coordinator's VPN CA
+type VPNCertificate struct {
+	ID         uint64 `orm:"column(id);auto;pk"`
+	Serial     string `orm:"unique"` // upper case hex
+	CommonName string `orm:"index"`  // the VPN user ID of the AS
+	Issuer     string `orm:"index"`  // identifies the CA, see vpnca.IssuerID
+	Issued     time.Time
+	Expires    time.Time
+	Revoked    bool
+	RevokedAt  time.Time `orm:"null"`
+}
+
+func FindVPNCertificateBySerial(serial string) (*VPNCertificate, error) {
+	v := new(VPNCertificate)
+	err := o.QueryTable(v).Filter("Serial", serial).One(v)
+	return v, err
+}
+
+// FindVPNCertificatesByCommonName returns the certificates issued for the common name, the
+// newest first
+func FindVPNCertificatesByCommonName(commonName string) ([]VPNCertificate, error) {
+	var v []VPNCertificate
+	_, err := o.QueryTable(new(VPNCertificate)).Filter("CommonName", commonName).
+		OrderBy("-ID").All(&v)
+	return v, err
+}
+
+// FindRevokedVPNCertificatesByIssuer returns the revoked certificates issued by the CA
+func FindRevokedVPNCertificatesByIssuer(issuer string) ([]VPNCertificate, error) {
+	var v []VPNCertificate
+	_, err := o.QueryTable(new(VPNCertificate)).Filter("Issuer", issuer).
+		Filter("Revoked", true).OrderBy("ID").All(&v)
+	return v, err
+}
+
+func (vc *VPNCertificate) Insert() error {
+	_, err := o.Insert(vc)
+	return err
+}
+
+func (vc *VPNCertificate) Update() error {
+	_, err := o.Update(vc)
+	return err
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/config"
+	"github.com/netsec-ethz/scion-coord/vpnpool"
+)
+
+// VPNAddressHold keeps a VPN address released by an AS for it until the hold expires
+type VPNAddressHold struct {
+	ID    uint64           `orm:"column(id);auto;pk"`
+	AP    *AttachmentPoint `orm:"column(ap_id);rel(fk);on_delete(cascade)"`
+	IP    string           `orm:"column(ip)"`
+	Owner string           // AS ID of the AS the address is held for
+	Until time.Time
+}
+
+// VPNOwner returns the owner of the VPN addresses of the AS in the VPN pools of the APs. The AS
+// ID is used as it does not change when the AS moves to another ISD.
+func VPNOwner(as *SCIONLabAS) string {
+	return as.ASID.String()
+}
+
+// VPNPool returns the VPN address pool of the AP with the addresses used by its VPN connections
+// and the addresses on hold. Expired holds are deleted from the DB. Addresses of connections
+// which are not part of the pool, e.g. after the subnet of the AP was changed, are ignored.
+func (ap *AttachmentPoint) VPNPool() (*vpnpool.Pool, error) {
+	excluded := []string{ap.VPNIP}
+	for _, ip := range strings.Split(ap.VPNExcluded, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			excluded = append(excluded, ip)
+		}
+	}
+	var pool *vpnpool.Pool
+	var err error
+	if ap.VPNSubnet != "" {
+		pool, err = vpnpool.New(ap.VPNSubnet, excluded...)
+	} else {
+		pool, err = vpnpool.NewRange(ap.StartVPNIP, ap.EndVPNIP, excluded...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if _, err = o.QueryTable(new(VPNAddressHold)).Filter("AP", ap.ID).Filter("Until__lte", now).
+		Delete(); err != nil {
+		return nil, err
+	}
+	var holds []VPNAddressHold
+	if _, err = o.QueryTable(new(VPNAddressHold)).Filter("AP", ap.ID).OrderBy("ID").
+		All(&holds); err != nil {
+		return nil, err
+	}
+	for _, h := range holds {
+		pool.Hold(h.IP, h.Owner, h.Until)
+	}
+
+	// connections which are being removed still use their address
+	var cns []*Connection
+	if _, err = o.QueryTable(new(Connection)).Filter("RespondAP", ap.ID).Filter("IsVPN", true).
+		Exclude("RespondStatus__in", Inactive, Removed).RelatedSel("JoinAS").All(&cns); err != nil {
+		return nil, err
+	}
+	for _, cn := range cns {
+		// taking over a hold is intended: the address is in use
+		pool.MarkAllocated(cn.JoinIP, VPNOwner(cn.JoinAS))
+	}
+	return pool, nil
+}
+
+// AllocateVPNIP returns the VPN address to be used by the AS at the AP: previous if it is still
+// available for the AS, a newly allocated address otherwise
+func (ap *AttachmentPoint) AllocateVPNIP(as *SCIONLabAS, previous string) (string, error) {
+	pool, err := ap.VPNPool()
+	if err != nil {
+		return "", fmt.Errorf("Error loading the VPN pool of AP %v: %v", ap.ID, err)
+	}
+	now := time.Now().UTC()
+	if previous != "" && pool.AllocateAddress(previous, VPNOwner(as), now) == nil {
+		return previous, nil
+	}
+	return pool.Allocate(VPNOwner(as), now)
+}
+
+// HoldVPNIP keeps the VPN address released by the AS for it during config.VPNAddressHold hours,
+// so that no other AS gets an address possibly still configured on the AS
+func (ap *AttachmentPoint) HoldVPNIP(ip string, as *SCIONLabAS) error {
+	if _, err := o.QueryTable(new(VPNAddressHold)).Filter("AP", ap.ID).Filter("IP", ip).
+		Delete(); err != nil {
+		return err
+	}
+	if config.VPNAddressHold <= 0 {
+		return nil
+	}
+	_, err := o.Insert(&VPNAddressHold{
+		AP:    ap,
+		IP:    ip,
+		Owner: VPNOwner(as),
+		Until: time.Now().UTC().Add(time.Duration(config.VPNAddressHold) * time.Hour),
+	})
+	return err
+}
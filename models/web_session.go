@@ -0,0 +1,126 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// the last activity is only recorded with this precision to avoid a DB write for every request
+const lastSeenPrecision = time.Minute
+
+// WebSession is a session of the web interface stored in the database. The session cookie only
+// holds the key of the session, so that the sessions of a user can be listed and revoked.
+type WebSession struct {
+	ID        uint64 `orm:"column(id);auto;pk"`
+	Key       string `orm:"unique;size(64)"`
+	User      *user  `orm:"rel(fk);null;index;on_delete(cascade)"` // nil before the login
+	Data      string `orm:"type(text)"`                            // encoded values of the session
+	IP        string
+	UserAgent string `orm:"size(512)"`
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time `orm:"index"`
+}
+
+// FindWebSession returns the unexpired session with the key
+func FindWebSession(key string) (*WebSession, error) {
+	s := new(WebSession)
+	err := o.QueryTable(s).Filter("Key", key).Filter("Expires__gt", time.Now().UTC()).One(s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FindWebSessionsByUserEmail returns the unexpired sessions of the user, the most recently used
+// first
+func FindWebSessionsByUserEmail(email string) ([]WebSession, error) {
+	var s []WebSession
+	_, err := o.QueryTable(new(WebSession)).Filter("User__Email", email).
+		Filter("Expires__gt", time.Now().UTC()).OrderBy("-LastSeen").All(&s)
+	return s, err
+}
+
+// FindWebSessionByIDAndUserEmail returns the session if it belongs to the user
+func FindWebSessionByIDAndUserEmail(id uint64, email string) (*WebSession, error) {
+	s := new(WebSession)
+	err := o.QueryTable(s).Filter("ID", id).Filter("User__Email", email).One(s)
+	return s, err
+}
+
+// SetUserID links the session to the user with the ID, or to no user for 0
+func (s *WebSession) SetUserID(id uint64) {
+	if id == 0 {
+		s.User = nil
+		return
+	}
+	s.User = &user{ID: id}
+}
+
+// Save stores the session, inserting it if its key is new
+func (s *WebSession) Save() error {
+	var existing WebSession
+	err := o.QueryTable(s).Filter("Key", s.Key).One(&existing, "ID", "Created")
+	switch err {
+	case orm.ErrNoRows:
+		s.Created = s.LastSeen
+		_, err = o.Insert(s)
+	case nil:
+		s.ID = existing.ID
+		s.Created = existing.Created
+		_, err = o.Update(s)
+	}
+	return err
+}
+
+// Touch records activity in the session at time now
+func (s *WebSession) Touch(now time.Time) error {
+	if now.Sub(s.LastSeen) < lastSeenPrecision {
+		return nil
+	}
+	s.LastSeen = now.UTC()
+	_, err := o.Update(s, "LastSeen")
+	return err
+}
+
+// Delete revokes the session
+func (s *WebSession) Delete() error {
+	_, err := o.Delete(s)
+	return err
+}
+
+// DeleteWebSession revokes the session with the key, if it exists
+func DeleteWebSession(key string) error {
+	_, err := o.QueryTable(new(WebSession)).Filter("Key", key).Delete()
+	return err
+}
+
+// DeleteWebSessionsByUserEmail revokes all sessions of the user except the one with the key
+// exceptKey, which may be empty. It returns the number of revoked sessions.
+func DeleteWebSessionsByUserEmail(email, exceptKey string) (int64, error) {
+	qs := o.QueryTable(new(WebSession)).Filter("User__Email", email)
+	if exceptKey != "" {
+		qs = qs.Exclude("Key", exceptKey)
+	}
+	return qs.Delete()
+}
+
+// DeleteExpiredWebSessions removes the sessions expired at time now
+func DeleteExpiredWebSessions(now time.Time) (int64, error) {
+	return o.QueryTable(new(WebSession)).Filter("Expires__lte", now.UTC()).Delete()
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSessions(t *testing.T) {
+	u, err := RegisterUser("web-session", "Scion Test-Bed", "web.session@example.com",
+		"some password", "Jon", "Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Delete()
+	defer u.Account.Delete()
+
+	now := time.Now().UTC()
+	for _, key := range []string{"session-a", "session-b", "session-c"} {
+		s := &WebSession{Key: key, Data: "data", LastSeen: now, Expires: now.Add(time.Hour)}
+		s.SetUserID(u.ID)
+		if err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	anonymous := &WebSession{Key: "session-anonymous", LastSeen: now, Expires: now.Add(time.Hour)}
+	if err := anonymous.Save(); err != nil {
+		t.Fatal(err)
+	}
+	defer anonymous.Delete()
+
+	// saving an existing key updates the session
+	s, err := FindWebSession("session-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := &WebSession{Key: "session-a", Data: "new data", LastSeen: now.Add(time.Minute),
+		Expires: now.Add(time.Hour)}
+	updated.SetUserID(u.ID)
+	assert.NoError(t, updated.Save())
+	assert.Equal(t, s.ID, updated.ID)
+	s, err = FindWebSession("session-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "new data", s.Data)
+
+	sessions, err := FindWebSessionsByUserEmail(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, sessions, 3)
+	assert.Equal(t, "session-a", sessions[0].Key, "not ordered by the last activity")
+
+	// log out everywhere except in the current session
+	n, err := DeleteWebSessionsByUserEmail(u.Email, "session-b")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	_, err = FindWebSession("session-a")
+	assert.Equal(t, orm.ErrNoRows, err)
+	_, err = FindWebSession("session-b")
+	assert.NoError(t, err)
+	_, err = FindWebSession("session-anonymous")
+	assert.NoError(t, err, "session of another user revoked")
+
+	// expired sessions are not found and removed
+	n, err = DeleteExpiredWebSessions(now.Add(2 * time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, n >= 2)
+	_, err = FindWebSession("session-b")
+	assert.Equal(t, orm.ErrNoRows, err)
+}
@@ -0,0 +1,302 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "scionlab"
+	testClientSecret = "client secret"
+	testRedirectURI  = "http://localhost:8080/api/login/oidc/test/callback"
+)
+
+// testProvider is a local stand-in for an OpenID Connect provider. It authorizes every request
+// for the user in claims and only issues tokens for codes redeemed with the right PKCE verifier.
+type testProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	claims map[string]interface{}
+
+	mu     sync.Mutex
+	grants map[string]testGrant
+}
+
+type testGrant struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	tp := &testProvider{
+		kid: "key-1",
+		claims: map[string]interface{}{
+			"sub":            "248289761001",
+			"email":          "jane.doe@example.org",
+			"email_verified": "true",
+			"given_name":     "Jane",
+			"family_name":    "Doe",
+		},
+		grants: make(map[string]testGrant),
+	}
+	tp.rotateKey(t, tp.kid)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter,
+		r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 tp.server.URL,
+			"authorization_endpoint": tp.server.URL + "/authorize",
+			"token_endpoint":         tp.server.URL + "/token",
+			"jwks_uri":               tp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", tp.jwks)
+	mux.HandleFunc("/authorize", tp.authorize)
+	mux.HandleFunc("/token", tp.token)
+	tp.server = httptest.NewServer(mux)
+	return tp
+}
+
+func (tp *testProvider) rotateKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.mu.Lock()
+	tp.key = key
+	tp.kid = kid
+	tp.mu.Unlock()
+}
+
+func (tp *testProvider) provider() *Provider {
+	return &Provider{
+		ID:           "test",
+		Issuer:       tp.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		Scopes:       []string{"email", "profile"},
+	}
+}
+
+func (tp *testProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"kid": tp.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(tp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(tp.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (tp *testProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != testClientID ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" ||
+		!strings.Contains(q.Get("scope"), "openid") {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	code, _ := RandomString(16)
+	tp.mu.Lock()
+	tp.grants[code] = testGrant{
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	tp.mu.Unlock()
+	redirect := q.Get("redirect_uri") + "?" + url.Values{
+		"code":  {code},
+		"state": {q.Get("state")},
+	}.Encode()
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (tp *testProvider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientID || secret != url.QueryEscape(testClientSecret) {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	code := r.PostFormValue("code")
+	tp.mu.Lock()
+	grant, ok := tp.grants[code]
+	delete(tp.grants, code) // codes are single use
+	tp.mu.Unlock()
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != grant.redirectURI ||
+		CodeChallenge(r.PostFormValue("code_verifier")) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     tp.sign(tp.idTokenClaims(grant.nonce, time.Now())),
+	})
+}
+
+func (tp *testProvider) idTokenClaims(nonce string, now time.Time) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":   tp.server.URL,
+		"aud":   []string{testClientID, "other-client"},
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range tp.claims {
+		claims[k] = v
+	}
+	return claims
+}
+
+func (tp *testProvider) sign(claims map[string]interface{}) string {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": tp.kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, tp.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// login runs the authorization request against the stand-in and returns the code and state of
+// the redirect back to the coordinator
+func login(t *testing.T, p *Provider, state, nonce, verifier string) (string, string) {
+	authURL, err := p.AuthCodeURL(testRedirectURI, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization request rejected: %v", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.server.Close()
+	p := tp.provider()
+
+	verifier, _ := RandomString(32)
+	code, state := login(t, p, "some state", "some nonce", verifier)
+	if state != "some state" {
+		t.Errorf("state not returned: %q", state)
+	}
+	claims, err := p.Exchange(code, testRedirectURI, verifier, "some nonce", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "248289761001" || claims.Email != "jane.doe@example.org" ||
+		!bool(claims.EmailVerified) || claims.GivenName != "Jane" || claims.FamilyName != "Doe" {
+		t.Errorf("wrong claims: %+v", claims)
+	}
+
+	// codes can only be redeemed once
+	if _, err := p.Exchange(code, testRedirectURI, verifier, "some nonce", time.Now()); err == nil {
+		t.Error("code redeemed twice")
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.server.Close()
+	p := tp.provider()
+
+	verifier, _ := RandomString(32)
+	code, _ := login(t, p, "state", "nonce", verifier)
+	other, _ := RandomString(32)
+	if _, err := p.Exchange(code, testRedirectURI, other, "nonce", time.Now()); err == nil {
+		t.Error("code redeemed without the code verifier")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.server.Close()
+	p := tp.provider()
+	now := time.Now()
+
+	valid := tp.sign(tp.idTokenClaims("nonce", now))
+	if _, err := p.Verify(valid, "nonce", now); err != nil {
+		t.Fatal(err)
+	}
+
+	modified := func(k string, v interface{}) string {
+		claims := tp.idTokenClaims("nonce", now)
+		claims[k] = v
+		return tp.sign(claims)
+	}
+	parts := strings.Split(valid, ".")
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		parts[1] + "."
+	tampered := parts[0] + "." + strings.Split(modified("sub", "admin"), ".")[1] + "." + parts[2]
+	for name, token := range map[string]string{
+		"wrong nonce":    modified("nonce", "other"),
+		"expired":        modified("exp", now.Add(-time.Hour).Unix()),
+		"future":         modified("iat", now.Add(time.Hour).Unix()),
+		"wrong audience": modified("aud", "other-client"),
+		"wrong issuer":   modified("iss", "https://attacker.example.org"),
+		"tampered":       tampered,
+		"unsigned":       unsigned,
+		"malformed":      "not a token",
+	} {
+		if _, err := p.Verify(token, "nonce", now); err == nil {
+			t.Errorf("%v token accepted", name)
+		}
+	}
+
+	// the keys are fetched again after the provider rotated them
+	tp.rotateKey(t, "key-2")
+	if _, err := p.Verify(tp.sign(tp.idTokenClaims("nonce", now)), "nonce", now); err != nil {
+		t.Errorf("token signed with the rotated key rejected: %v", err)
+	}
+	if _, err := p.Verify(valid, "nonce", now); err == nil {
+		t.Error("token signed with the retired key accepted")
+	}
+}
@@ -1,5 +1,5 @@
 scionApp
-    .controller('accountCtrl', ['$scope', 'accountService', function ($scope, accountService) {
+    .controller('accountCtrl', ['$scope', '$window', 'accountService', 'userService', function ($scope, $window, accountService, userService) {
 
             $scope.message = "";
             $scope.error = "";
@@ -25,6 +25,317 @@ scionApp
                 }
             };
 
+            $scope.loadTwoFactor = function () {
+                accountService.twoFactorStatus().then(
+                    function (data) {
+                        $scope.twoFactor = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.twoFactorError = response.data;
+                    }
+                );
+            };
+
+            $scope.enrolTwoFactor = function () {
+                accountService.enrolTwoFactor().then(
+                    function (data) {
+                        var qr = qrcode(0, 'M');
+                        qr.addData(data.URI);
+                        qr.make();
+                        data.QR = qr.createDataURL(4);
+                        $scope.twoFactorError = "";
+                        $scope.enrolment = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.twoFactorError = response.data;
+                    }
+                );
+            };
+
+            $scope.enableTwoFactor = function (code) {
+                accountService.enableTwoFactor(code).then(
+                    function (data) {
+                        $scope.twoFactorError = "";
+                        $scope.enrolment = null;
+                        $scope.twoFactorCode = "";
+                        $scope.recoveryCodes = data.RecoveryCodes;
+                        $scope.loadTwoFactor();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.twoFactorError = response.data;
+                    }
+                );
+            };
+
+            $scope.regenerateRecoveryCodes = function (manage) {
+                accountService.regenerateRecoveryCodes(manage.Code).then(
+                    function (data) {
+                        $scope.twoFactorError = "";
+                        $scope.manage = {};
+                        $scope.recoveryCodes = data.RecoveryCodes;
+                        $scope.loadTwoFactor();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.twoFactorError = response.data;
+                    }
+                );
+            };
+
+            $scope.disableTwoFactor = function (manage) {
+                if (!confirm("Disable two-factor authentication?")) {
+                    return;
+                }
+                accountService.disableTwoFactor(manage).then(
+                    function () {
+                        $scope.twoFactorError = "";
+                        $scope.manage = {};
+                        $scope.recoveryCodes = null;
+                        $scope.loadTwoFactor();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.twoFactorError = response.data;
+                    }
+                );
+            };
+
+            $scope.manage = {};
+            $scope.loadTwoFactor();
+
+            $scope.rotateSecret = function () {
+                if (!confirm("Replace the secret of your account?")) {
+                    return;
+                }
+                accountService.rotateSecret().then(
+                    function (data) {
+                        $scope.rotationError = "";
+                        $scope.rotation = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.rotation = null;
+                        $scope.rotationError = response.data;
+                    }
+                );
+            };
+
+            $scope.loadTokens = function () {
+                accountService.accessTokens().then(
+                    function (data) {
+                        $scope.tokens = data.tokens;
+                        $scope.tokenScopes = data.scopes;
+                        $scope.maxValidityDays = data.maxValidityDays;
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.tokenError = response.data;
+                    }
+                );
+            };
+
+            $scope.resetTokenRequest = function () {
+                $scope.tokenRequest = {ValidityDays: 90, scopeSelection: {}};
+            };
+
+            $scope.createToken = function (tokenRequest) {
+                var scopes = Object.keys(tokenRequest.scopeSelection).filter(function (scope) {
+                    return tokenRequest.scopeSelection[scope];
+                });
+                var request = {
+                    Name: tokenRequest.Name,
+                    Scopes: scopes,
+                    ValidityDays: tokenRequest.ValidityDays
+                };
+                accountService.createAccessToken(request).then(
+                    function (data) {
+                        $scope.tokenError = "";
+                        $scope.newToken = data;
+                        $scope.resetTokenRequest();
+                        $scope.tokenForm.$setPristine(true);
+                        $scope.loadTokens();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.tokenError = response.data;
+                    }
+                );
+            };
+
+            $scope.revokeToken = function (token) {
+                if (!confirm("Revoke the access token " + token.Name + "?")) {
+                    return;
+                }
+                accountService.revokeAccessToken(token.ID).then(
+                    function () {
+                        $scope.tokenError = "";
+                        $scope.loadTokens();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.tokenError = response.data;
+                    }
+                );
+            };
+
+            $scope.resetTokenRequest();
+            $scope.loadTokens();
+
+            $scope.loadSessions = function () {
+                accountService.sessions().then(
+                    function (data) {
+                        $scope.sessions = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.sessionError = response.data;
+                    }
+                );
+            };
+
+            $scope.revokeSession = function (session) {
+                accountService.revokeSession(session.ID).then(
+                    function () {
+                        $scope.sessionError = "";
+                        if (session.Current) {
+                            $window.location.href = '/';
+                            return;
+                        }
+                        $scope.loadSessions();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.sessionError = response.data;
+                    }
+                );
+            };
+
+            $scope.logoutEverywhere = function () {
+                if (!confirm("Log out of all your sessions, including this one?")) {
+                    return;
+                }
+                accountService.logoutEverywhere().then(
+                    function () {
+                        $window.location.href = '/';
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.sessionError = response.data;
+                    }
+                );
+            };
+
+            $scope.loadSessions();
+
+            $scope.memberRoles = ['viewer', 'maintainer', 'owner'];
+
+            $scope.loadAccounts = function () {
+                accountService.accounts().then(
+                    function (data) {
+                        $scope.memberEmail = data.Email;
+                        $scope.accounts = data.Accounts;
+                        $scope.invitations = data.Invitations;
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.memberError = response.data;
+                    }
+                );
+            };
+
+            // runs an action of the members box and reloads the accounts on success
+            var memberAction = function (promise) {
+                promise.then(
+                    function () {
+                        $scope.memberError = "";
+                        $scope.loadAccounts();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.memberError = response.data;
+                    }
+                );
+            };
+
+            $scope.inviteMember = function (account) {
+                memberAction(accountService.inviteMember(account.ID, account.invite));
+                account.invite = {Role: 'viewer'};
+            };
+
+            $scope.setMemberRole = function (account, member) {
+                memberAction(accountService.setMemberRole(account.ID, member.Email, member.Role));
+            };
+
+            $scope.removeMember = function (account, email) {
+                if (!confirm("Remove " + email + " from the account " + account.Name + "?")) {
+                    return;
+                }
+                memberAction(accountService.removeMember(account.ID, email));
+            };
+
+            $scope.acceptInvitation = function (invitation) {
+                memberAction(accountService.acceptInvitation(invitation.ID));
+            };
+
+            $scope.deleteInvitation = function (invitation) {
+                memberAction(accountService.deleteInvitation(invitation.ID));
+            };
+
+            $scope.loadAccounts();
+
+            $scope.emailRequest = {};
+
+            $scope.loadEmail = function () {
+                userService.userPageData().then(
+                    function (data) {
+                        $scope.email = data.User.Email;
+                        $scope.pendingEmail = data.User.PendingEmail;
+                    },
+                    function (response) {
+                        console.log(response);
+                    }
+                );
+            };
+
+            $scope.changeEmail = function () {
+                accountService.changeEmail($scope.emailRequest).then(
+                    function (data) {
+                        $scope.emailError = "";
+                        $scope.emailMessage = data;
+                        $scope.emailRequest = {};
+                        $scope.loadEmail();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.emailError = response.data;
+                        $scope.emailMessage = "";
+                    }
+                );
+            };
+
+            $scope.loadEmail();
+
+            $scope.deleteRequest = {};
+
+            $scope.deleteAccount = function () {
+                if (!confirm("Delete your account and all your ASes? This cannot be undone.")) {
+                    return;
+                }
+                accountService.deleteAccount($scope.deleteRequest).then(
+                    function (data) {
+                        alert(data);
+                        $window.location.href = '/';
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.deleteError = response.data;
+                    }
+                );
+            };
+
             $scope.dismissSuccess = function () {
                 $scope.message = "";
             };
@@ -2,7 +2,7 @@ angular.module('scionApp')
     .controller('adminCtrl', ['$rootScope', '$scope', 'adminService', '$location',
         function ($rootScope, $scope, adminService, $location) {
             $scope.redirectIfNotAdmin = function () {
-                if (!$rootScope.user["IsAdmin"]) {
+                if (!($rootScope.user["Permissions"] || []).length) {
                     $location.path('/user');
                 }
             };
@@ -19,6 +19,7 @@ angular.module('scionApp')
                         $scope.emailMessage = data["EmailMessage"];
 
                         $scope.redirectIfNotAdmin();
+                        $scope.loadSections();
                         $scope.defaultInvitation = function () {
                             return {
                                 Organisation: $scope.organisation,
@@ -38,6 +39,259 @@ angular.module('scionApp')
                     });
             };
 
+            $scope.loadCertExpirations = function () {
+                adminService.certExpirations().then(
+                    function (data) {
+                        $scope.certExpirations = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                    });
+            };
+
+            $scope.loadVPNPools = function () {
+                adminService.vpnPools().then(
+                    function (data) {
+                        $scope.vpnPools = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                    });
+            };
+
+            $scope.searchUsers = function (query, page) {
+                adminService.users(query || "", page).then(
+                    function (data) {
+                        $scope.userError = "";
+                        data.query = query;
+                        $scope.users = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.users = null;
+                        $scope.userError = response.data;
+                    });
+            };
+
+            $scope.loadAdminActions = function () {
+                adminService.adminActions().then(
+                    function (data) {
+                        $scope.adminActions = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                    });
+            };
+
+            // reloads the current page of users and the admin actions after an action on a user
+            var userActionDone = function () {
+                $scope.userError = "";
+                if ($scope.users) {
+                    $scope.searchUsers($scope.users.query, $scope.users.Page);
+                }
+                $scope.loadAdminActions();
+            };
+
+            var userActionFailed = function (response) {
+                console.log(response);
+                $scope.userError = response.data;
+            };
+
+            $scope.setUserDisabled = function (email, disabled) {
+                if (disabled && !confirm("Disable " + email + " and log the user out everywhere?")) {
+                    return;
+                }
+                adminService.setUserDisabled(email, disabled).then(userActionDone, userActionFailed);
+            };
+
+            $scope.setUserAdmin = function (email, admin) {
+                if (!confirm((admin ? "Make " + email + " an admin" : "Revoke the admin role of " + email) + "?")) {
+                    return;
+                }
+                adminService.setUserAdmin(email, admin).then(userActionDone, userActionFailed);
+            };
+
+            $scope.resetUserPassword = function (email) {
+                if (!confirm("Reset the password of " + email + " and log the user out everywhere?")) {
+                    return;
+                }
+                adminService.resetUserPassword(email).then(userActionDone, userActionFailed);
+            };
+
+            $scope.resendUserVerification = function (email) {
+                adminService.resendUserVerification(email).then(userActionDone, userActionFailed);
+            };
+
+            $scope.loadQuotas = function () {
+                adminService.quotas().then(
+                    function (data) {
+                        $scope.quotas = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                    });
+            };
+
+            var quotaDone = function () {
+                $scope.quotaError = "";
+                $scope.loadQuotas();
+                $scope.loadAdminActions();
+            };
+
+            var quotaFailed = function (response) {
+                console.log(response);
+                $scope.quotaError = response.data;
+            };
+
+            $scope.setQuota = function (quota) {
+                var request = quota.kind === 'account' ?
+                    adminService.setAccountQuota(quota.target, quota.maxASes) :
+                    adminService.setUserQuota(quota.target, quota.maxASes);
+                request.then(function () {
+                    $scope.newQuota = {kind: quota.kind};
+                    quotaDone();
+                }, quotaFailed);
+            };
+
+            $scope.resetQuota = function (quota) {
+                var request = quota.User ?
+                    adminService.resetUserQuota(quota.User) :
+                    adminService.resetAccountQuota(quota.AccountID);
+                request.then(quotaDone, quotaFailed);
+            };
+
+            $scope.loadLockedUsers = function () {
+                adminService.lockedUsers().then(
+                    function (data) {
+                        $scope.lockedUsers = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                    });
+            };
+
+            $scope.unlock = function (req) {
+                adminService.unlock(req).then(
+                    function () {
+                        $scope.unlockError = "";
+                        $scope.unlockIP = "";
+                        $scope.loadLockedUsers();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.unlockError = response.data;
+                    });
+            };
+
+            $scope.loadUserSessions = function (email) {
+                adminService.userSessions(email).then(
+                    function (data) {
+                        $scope.sessionError = "";
+                        $scope.userSessions = {email: email, sessions: data};
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.userSessions = null;
+                        $scope.sessionError = response.data;
+                    });
+            };
+
+            $scope.revokeUserSessions = function (email) {
+                if (!confirm("Log " + email + " out of all sessions?")) {
+                    return;
+                }
+                adminService.revokeUserSessions(email).then(
+                    function () {
+                        $scope.loadUserSessions(email);
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.sessionError = response.data;
+                    });
+            };
+
+            $scope.rotateSecret = function (accountID) {
+                if (!confirm("Rotate the secret of account " + accountID + "?")) {
+                    return;
+                }
+                adminService.rotateSecret(accountID).then(
+                    function (data) {
+                        $scope.rotationError = "";
+                        $scope.rotation = data;
+                        $scope.rotationAccountID = "";
+                        if (data.Account.AccountID === $scope.account.AccountID) {
+                            $scope.account = data.Account;
+                        }
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.rotation = null;
+                        $scope.rotationError = response.data;
+                    });
+            };
+
+            // whether the user has the permission, see models.AllPermissions
+            $scope.can = function (permission) {
+                return ($rootScope.user["Permissions"] || []).indexOf(permission) >= 0;
+            };
+
+            // loads the sections of the admin page the user has the permissions for
+            $scope.loadSections = function () {
+                if ($scope.can('as.read')) {
+                    $scope.loadCertExpirations();
+                    $scope.loadVPNPools();
+                }
+                if ($scope.can('users.manage')) {
+                    $scope.loadLockedUsers();
+                    $scope.loadAdminActions();
+                    $scope.loadQuotas();
+                }
+                if ($scope.can('roles.manage')) {
+                    $scope.loadRoles();
+                }
+            };
+
+            $scope.loadRoles = function () {
+                adminService.roles().then(
+                    function (data) {
+                        $scope.roles = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.roleError = response.data;
+                    });
+            };
+
+            $scope.roleRequest = {ISD: 0};
+
+            $scope.assignRole = function (roleRequest) {
+                adminService.assignRole(roleRequest).then(
+                    function () {
+                        $scope.roleError = "";
+                        $scope.roleRequest = {ISD: 0};
+                        $scope.loadRoles();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.roleError = response.data;
+                    });
+            };
+
+            $scope.revokeRole = function (assignment) {
+                if (!confirm("Revoke the role " + assignment.Role + " of " + assignment.Email + "?")) {
+                    return;
+                }
+                adminService.revokeRole(assignment.ID).then(
+                    function () {
+                        $scope.roleError = "";
+                        $scope.loadRoles();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.roleError = response.data;
+                    });
+            };
+
             $scope.adminPageData();
             $scope.error = "";
             $scope.message = "";
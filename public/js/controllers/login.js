@@ -2,6 +2,24 @@ scionApp
     .controller('loginCtrl', ['$rootScope', '$scope', 'loginService', '$location',
         function ($rootScope, $scope, loginService, $location) {
 
+            // logins with an OpenID Connect provider return to this page on errors and when
+            // a second factor is required
+            let search = $location.search();
+            if (search.error) {
+                $scope.error = search.error;
+            }
+            if (search.twoFactor) {
+                $scope.twoFactor = true;
+            }
+
+            loginService.oidcProviders().then(
+                function (data) {
+                    $scope.oidcProviders = data;
+                },
+                function (response) {
+                    console.log(response);
+                });
+
             // refresh the list of processes
             $scope.login = function (user) {
                 if (!$scope.loginForm.$valid) {
@@ -9,10 +27,19 @@ scionApp
                 } else {
                     loginService.login(user).then(
                         function (data) {
-                            $location.path('/user');
+                            if (data.TwoFactorRequired) {
+                                $scope.error = "";
+                                $scope.twoFactor = true;
+                                return;
+                            }
+                            $scope.loggedIn(data);
                         },
                         function (response) {
                             console.log(response);
+                            if (response.status === 429) {
+                                $scope.error = response.data;
+                                return;
+                            }
                             $scope.error = "Failed to log you in: Make sure your email address and " +
                                 "password are correct and your email address is verified.";
                             $scope.showReset = true;
@@ -22,6 +49,31 @@ scionApp
                 }
             };
 
+            // second step of logins with two-factor authentication
+            $scope.loginSecondFactor = function (code) {
+                loginService.loginSecondFactor(code).then(
+                    function (data) {
+                        $scope.loggedIn(data);
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.error = response.data;
+                        $scope.code = "";
+                        if (response.data.indexOf("expired") >= 0) {
+                            $scope.twoFactor = false;
+                        }
+                    });
+            };
+
+            $scope.loggedIn = function (data) {
+                if (data.TwoFactorEnrolmentRequired) {
+                    // admins have to enable two-factor authentication first
+                    $location.path('/account');
+                } else {
+                    $location.path('/user');
+                }
+            };
+
             // reset password
             $scope.resetPassword = function (email) {
                 if (!$scope.loginForm.$valid) {
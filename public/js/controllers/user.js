@@ -14,6 +14,7 @@ scionApp
                         console.log(data);
                         $rootScope.user = data["User"];
                         $scope.maxASes = data["MaxASes"];
+                        $scope.asQuota = data["ASQuota"];
                         $scope.aps = data["APs"];
                         $scope.asInfos = data["ASInfos"];
                         if ($scope.currentIndex === undefined) {
@@ -128,6 +129,11 @@ scionApp
                             $scope.error2 = "Please enter a correct port in the range 1024-65535.";
                         } else if (!$scope.scionLabASForm.AP.$valid) {
                             $scope.error2 = "Please select an Attachment Point.";
+                        } else if (!$scope.scionLabASForm.MTU.$valid ||
+                            !$scope.scionLabASForm.LinkMTU.$valid ||
+                            !$scope.scionLabASForm.Bandwidth.$valid ||
+                            !$scope.scionLabASForm.SvcPort.$valid) {
+                            $scope.error2 = "Please check the advanced link and service settings.";
                         } else {
                             $scope.configureSCIONLabAS(user, asInfo);
                         }
@@ -204,6 +210,37 @@ scionApp
                     });
             };
 
+            $scope.loadPackageVersions = function (asInfo) {
+                $scope.error2 = "";
+                userService.packageVersions(asInfo.ASID).then(
+                    function (data) {
+                        console.log(data);
+                        $scope.packageVersions = data;
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.error2 = response.data;
+                    });
+            };
+
+            $scope.rollbackSCIONLabAS = function (asInfo, version) {
+                setCurrentIndex();
+                $scope.error2 = "";
+                $scope.message2 = "";
+
+                userService.rollbackSCIONLabAS(asInfo.ASID, version).then(
+                    function (data) {
+                        console.log(data);
+                        $scope.message2 = data;
+                        $scope.packageVersions = [];
+                        $scope.userPageData();
+                    },
+                    function (response) {
+                        console.log(response);
+                        $scope.error2 = response.data;
+                    });
+            };
+
             $scope.dismissSuccess = function (i) {
                 switch (i) {
                     case 1:
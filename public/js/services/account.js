@@ -6,6 +6,106 @@ scionApp
                 console.log(response);
                 return response.data;
             });
+        },
+        twoFactorStatus: function () {
+            return $http.get('/api/twoFactor').then(function (response) {
+                return response.data;
+            });
+        },
+        enrolTwoFactor: function () {
+            return $http.post('/api/twoFactor/enrol', {}).then(function (response) {
+                return response.data;
+            });
+        },
+        enableTwoFactor: function (code) {
+            return $http.post('/api/twoFactor/enable', {Code: code}).then(function (response) {
+                return response.data;
+            });
+        },
+        regenerateRecoveryCodes: function (code) {
+            return $http.post('/api/twoFactor/recoveryCodes', {Code: code}).then(function (response) {
+                return response.data;
+            });
+        },
+        disableTwoFactor: function (req) {
+            return $http.post('/api/twoFactor/disable', req).then(function (response) {
+                return response.data;
+            });
+        },
+        rotateSecret: function () {
+            return $http.post('/api/rotateSecret').then(function (response) {
+                return response.data;
+            });
+        },
+        accessTokens: function () {
+            return $http.get('/api/accessTokens').then(function (response) {
+                return response.data;
+            });
+        },
+        createAccessToken: function (tokenRequest) {
+            return $http.post('/api/accessTokens', tokenRequest).then(function (response) {
+                return response.data;
+            });
+        },
+        revokeAccessToken: function (id) {
+            return $http.delete('/api/accessTokens/' + id).then(function (response) {
+                return response.data;
+            });
+        },
+        sessions: function () {
+            return $http.get('/api/sessions').then(function (response) {
+                return response.data;
+            });
+        },
+        revokeSession: function (id) {
+            return $http.delete('/api/sessions/' + id).then(function (response) {
+                return response.data;
+            });
+        },
+        logoutEverywhere: function () {
+            return $http.post('/api/logoutEverywhere').then(function (response) {
+                return response.data;
+            });
+        },
+        accounts: function () {
+            return $http.get('/api/accounts').then(function (response) {
+                return response.data;
+            });
+        },
+        inviteMember: function (accountID, req) {
+            return $http.post('/api/accounts/' + accountID + '/invitations', req).then(function (response) {
+                return response.data;
+            });
+        },
+        setMemberRole: function (accountID, email, role) {
+            return $http.put('/api/accounts/' + accountID + '/members/' + encodeURIComponent(email), {Role: role}).then(function (response) {
+                return response.data;
+            });
+        },
+        removeMember: function (accountID, email) {
+            return $http.delete('/api/accounts/' + accountID + '/members/' + encodeURIComponent(email)).then(function (response) {
+                return response.data;
+            });
+        },
+        acceptInvitation: function (id) {
+            return $http.post('/api/invitations/' + id + '/accept').then(function (response) {
+                return response.data;
+            });
+        },
+        deleteInvitation: function (id) {
+            return $http.delete('/api/invitations/' + id).then(function (response) {
+                return response.data;
+            });
+        },
+        deleteAccount: function (req) {
+            return $http.post('/api/user/delete', req).then(function (response) {
+                return response.data;
+            });
+        },
+        changeEmail: function (req) {
+            return $http.post('/api/user/email', req).then(function (response) {
+                return response.data;
+            });
         }
     };
 }]);
@@ -7,6 +7,120 @@ angular.module('scionApp')
                     return response.data;
                 });
             },
+            certExpirations: function () {
+                return $http.get('/api/admin/certExpirations').then(function (response) {
+                    return response.data;
+                });
+            },
+            vpnPools: function () {
+                return $http.get('/api/admin/vpnPools').then(function (response) {
+                    return response.data;
+                });
+            },
+            users: function (query, page) {
+                return $http.get('/api/admin/users', {params: {q: query, page: page}}).then(function (response) {
+                    return response.data;
+                });
+            },
+            setUserDisabled: function (email, disabled) {
+                return $http.put('/api/admin/users/' + encodeURIComponent(email) + '/disabled', {Disabled: disabled}).then(
+                    function (response) {
+                        return response.data;
+                    });
+            },
+            setUserAdmin: function (email, admin) {
+                return $http.put('/api/admin/users/' + encodeURIComponent(email) + '/admin', {Admin: admin}).then(
+                    function (response) {
+                        return response.data;
+                    });
+            },
+            resetUserPassword: function (email) {
+                return $http.post('/api/admin/users/' + encodeURIComponent(email) + '/resetPassword').then(
+                    function (response) {
+                        return response.data;
+                    });
+            },
+            resendUserVerification: function (email) {
+                return $http.post('/api/admin/users/' + encodeURIComponent(email) + '/resendVerification').then(
+                    function (response) {
+                        return response.data;
+                    });
+            },
+            quotas: function () {
+                return $http.get('/api/admin/quotas').then(function (response) {
+                    return response.data;
+                });
+            },
+            setUserQuota: function (email, maxASes) {
+                return $http.put('/api/admin/users/' + encodeURIComponent(email) + '/quota', {MaxASes: maxASes}).then(
+                    function (response) {
+                        return response.data;
+                    });
+            },
+            resetUserQuota: function (email) {
+                return $http.delete('/api/admin/users/' + encodeURIComponent(email) + '/quota').then(
+                    function (response) {
+                        return response.data;
+                    });
+            },
+            setAccountQuota: function (accountID, maxASes) {
+                return $http.put('/api/admin/accounts/' + encodeURIComponent(accountID) + '/quota', {MaxASes: maxASes}).then(
+                    function (response) {
+                        return response.data;
+                    });
+            },
+            resetAccountQuota: function (accountID) {
+                return $http.delete('/api/admin/accounts/' + encodeURIComponent(accountID) + '/quota').then(
+                    function (response) {
+                        return response.data;
+                    });
+            },
+            adminActions: function () {
+                return $http.get('/api/admin/actions').then(function (response) {
+                    return response.data;
+                });
+            },
+            lockedUsers: function () {
+                return $http.get('/api/admin/lockedUsers').then(function (response) {
+                    return response.data;
+                });
+            },
+            unlock: function (req) {
+                return $http.post('/api/admin/unlockUser', req).then(function (response) {
+                    return response.data;
+                });
+            },
+            userSessions: function (email) {
+                return $http.get('/api/admin/sessions/' + encodeURIComponent(email)).then(function (response) {
+                    return response.data;
+                });
+            },
+            revokeUserSessions: function (email) {
+                return $http.delete('/api/admin/sessions/' + encodeURIComponent(email)).then(function (response) {
+                    return response.data;
+                });
+            },
+            roles: function () {
+                return $http.get('/api/admin/roles').then(function (response) {
+                    return response.data;
+                });
+            },
+            assignRole: function (req) {
+                return $http.post('/api/admin/roles', req).then(function (response) {
+                    return response.data;
+                });
+            },
+            revokeRole: function (id) {
+                return $http.delete('/api/admin/roles/' + id).then(function (response) {
+                    return response.data;
+                });
+            },
+            rotateSecret: function (accountID) {
+                return $http.post('/api/admin/rotateSecret/' + encodeURIComponent(accountID)).then(
+                    function (response) {
+                        return response.data;
+                    });
+            },
             sendInvitations: function (invitations) {
                 console.log(angular.toJson(invitations));
                 return $http.post('/api/sendInvitations', angular.toJson(invitations)).then(function (response) {
@@ -8,6 +8,17 @@ scionApp
                     return response.data;
                 });
             },
+            loginSecondFactor: function (code) {
+                return $http.post('/api/login/twoFactor', {Code: code}).then(function (response) {
+                    return response.data;
+                });
+            },
+            // OpenID Connect providers shown on the login page
+            oidcProviders: function () {
+                return $http.get('/api/login/oidc').then(function (response) {
+                    return response.data;
+                });
+            },
             logout: function () {
                 return $http.post('/api/logout').then(function (response) {
                     console.log(response);
@@ -26,6 +26,11 @@ scionApp
                 label: asInfo.Label,
                 type: asInfo.Type == "2" ? 2 : 1,
                 port: asInfo.Port,
+                mtu: asInfo.MTU || 0,
+                linkMTU: asInfo.LinkMTU || 0,
+                bandwidth: asInfo.Bandwidth || 0,
+                servicePort: asInfo.SvcPort || 0,
+                packageFormat: asInfo.Type == "2" ? (asInfo.Package || "") : "",
             };
             console.log(request);
             return $http.post('/api/as/configureAS', request).then(function (response) {
@@ -33,6 +38,20 @@ scionApp
                 return response.data;
             });
         },
+        // List the configuration versions of the SCIONLab AS
+        packageVersions: function (asID) {
+            return $http.get('/api/as/packageVersions/' + asID).then(function (response) {
+                console.log(response);
+                return response.data;
+            });
+        },
+        // Roll back SCIONLab AS to a previous configuration version
+        rollbackSCIONLabAS: function (asID, version) {
+            return $http.post('/api/as/rollbackAS/' + asID + '/' + version).then(function (response) {
+                console.log(response);
+                return response.data;
+            });
+        },
         // Remove SCIONLab AS
         removeSCIONLabAS: function (asID) {
             return $http.post('/api/as/removeAS/' + asID).then(function (response) {
@@ -91,7 +91,8 @@ def create_scionlab_as_local_gen(args, tp):
     except:
         pass
     as_obj = generate_certificate(
-        new_ia, core_ia, args.core_sign_priv_key_file, args.core_cert_file, args.trc_file)
+        new_ia, core_ia, args.core_sign_priv_key_file, args.core_cert_file, args.trc_file,
+        args.cert_version)
     write_dispatcher_config(local_gen_path)
     write_toml_files(tp, new_ia)
     for service_type, type_key in TYPES_TO_KEYS.items():
@@ -111,7 +112,8 @@ def create_scionlab_as_local_gen(args, tp):
     if not args.no_prometheus:
         generate_prom_config(new_ia, tp, local_gen_path)
 
-def generate_certificate(joining_ia, core_ia, core_sign_priv_key_file, core_cert_file, trc_file):
+def generate_certificate(joining_ia, core_ia, core_sign_priv_key_file, core_cert_file, trc_file,
+                         cert_version=None):
     """:returns an ASCredential object with every key and certificate for this AS"""
     core_ia_chain = CertificateChain.from_raw(read_file(core_cert_file))
     # AS cert is always expired one second before the expiration of the Core AS cert
@@ -121,8 +123,11 @@ def generate_certificate(joining_ia, core_ia, core_sign_priv_key_file, core_cert
     public_key_sign, private_key_sign = generate_sign_keypair()
     public_key_encr, private_key_encr = generate_enc_keypair()
     # using INITIAL_CERT_VERSION + 1 from 2019.01. update to always generate a new version of the certificate
+    # unless a specific version is requested (certificate renewal)
+    if cert_version is None:
+        cert_version = INITIAL_CERT_VERSION + 1
     cert = Certificate.from_values(
-        str(joining_ia), str(core_ia), INITIAL_TRC_VERSION, INITIAL_CERT_VERSION + 1, comment,
+        str(joining_ia), str(core_ia), INITIAL_TRC_VERSION, cert_version, comment,
         False, validity, public_key_encr, public_key_sign, core_ia_sig_priv_key)
     sig_priv_key = base64.b64encode(private_key_sign).decode()
     enc_priv_key = base64.b64encode(private_key_encr).decode()
@@ -168,6 +173,9 @@ def main():
                         default=DEFAULT_PACKAGE_PATH)
     parser.add_argument("--user_id",
                         help='User Identifier (email + IA)')
+    parser.add_argument("--cert_version",
+                        help='Version of the generated AS certificate',
+                        type=int)
     parser.add_argument("--no-prometheus",
                         help='Don\'t generate prometheus configuration',
                         action='store_true',)
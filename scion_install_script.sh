@@ -6,7 +6,7 @@ shopt -s nullglob
 
 UPGRADE_SCRIPT_LOCATION="/usr/bin/scionupgrade.sh"
 
-usage="$(basename "$0") [-p PATCH_DIR] [-g GEN_DIR] [-v VPN_CONF_PATH] \
+usage="$(basename "$0") [-p PATCH_DIR] [-g GEN_DIR] [-v VPN_CONF_PATH] [-w WG_CONF_PATH] \
 [-s SCION_SERVICE] [-z SCION_VI_SERVICE] [-a ALIASES_FILE] [-c] \
 [-u UPGRADE_SCRIPT] [-t TIMER_SERVICE]
 
@@ -14,6 +14,7 @@ where:
     -p PATCH_DIR        apply patches from PATCH_DIR on cloned repo
     -g GEN_DIR          path to gen directory to be used
     -v VPN_CONF_PATH    path to OpenVPN configuration file
+    -w WG_CONF_PATH     path to WireGuard configuration file
     -s SCION_SERVICE    path to SCION service file
     -z SCION_VI_SERVICE path to SCION-viz service file
     -a ALIASES_FILE     adds useful command aliases in specified file
@@ -22,7 +23,7 @@ where:
                         path ${UPGRADE_SCRIPT_LOCATION})
     -t TIMER_UPG_SERV   name of sysd timer and system name for upgrades"
 
-while getopts ":p:g:v:s:z:ha:cu:t:" opt; do
+while getopts ":p:g:v:w:s:z:ha:cu:t:" opt; do
   case $opt in
     p)
       patch_dir=$OPTARG
@@ -33,6 +34,9 @@ while getopts ":p:g:v:s:z:ha:cu:t:" opt; do
     v)
       vpn_config_file=$OPTARG
       ;;
+    w)
+      wg_config_file=$OPTARG
+      ;;
     s)
       scion_service_path=$OPTARG
       ;;
@@ -231,6 +235,20 @@ then
     sudo systemctl enable openvpn@client
 fi
 
+if  [[ ( ! -z ${wg_config_file+x} ) && -r ${wg_config_file} ]]
+then
+    echo "WireGuard configuration specified! Configuring it!"
+
+    sudo add-apt-repository -y ppa:wireguard/wireguard
+    sudo apt-get update
+    sudo apt-get -y install wireguard
+
+    sudo cp "$wg_config_file" /etc/wireguard/wg0.conf
+    sudo chmod 600 /etc/wireguard/wg0.conf
+    sudo systemctl start wg-quick@wg0
+    sudo systemctl enable wg-quick@wg0
+fi
+
 tempfile=$(mktemp)
 if  [[ ( ! -z ${scion_service_path+x} ) && -r ${scion_service_path} ]]
 then
@@ -130,6 +130,18 @@ if [ -f $DIR/client.conf ]; then
 else
     echo "Not using VPN in this AS configuration. Step skipped."
 fi
+# copy WireGuard configuration
+if [[ -f /etc/wireguard/wg0.conf && (-f $DIR/wg0.conf || $HTTP_CODE -eq 205) ]]; then
+    echo "Saving a backup copy of /etc/wireguard/wg0.conf"
+    sudo systemctl stop "wg-quick@wg0.service" || true
+    sudo mv /etc/wireguard/wg0.conf "/etc/wireguard/wg0.conf.bak-$TIMESTAMP"
+fi
+if [ -f $DIR/wg0.conf ]; then
+    echo "Using WireGuard in this AS configuration"
+    sudo mv $DIR/wg0.conf /etc/wireguard/
+    sudo chmod 600 /etc/wireguard/wg0.conf
+    sudo systemctl start "wg-quick@wg0.service" && sleep 2 || echo "Failed to start WireGuard. Please start it manually. Your AS may fail to start correctly."
+fi
 # now reload SCION
 echo "Reloading AS configuration"
 ./supervisor/supervisor.sh reload
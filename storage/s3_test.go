@@ -0,0 +1,125 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory stand-in for an S3 bucket. It lists at most two keys per response
+// to exercise the pagination.
+type fakeS3 struct {
+	t       *testing.T
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(auth, "/us-east-1/s3/aws4_request, SignedHeaders="+
+			"host;x-amz-content-sha256;x-amz-date, Signature=") {
+		f.t.Errorf("unexpected Authorization header: %v", auth)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+		f.t.Errorf("wrong payload hash in %v %v", r.Method, r.URL)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/"+f.bucket)
+	if path == "" && r.Method == http.MethodGet {
+		f.list(w, r)
+		return
+	}
+	key := strings.TrimPrefix(path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = body
+	case http.MethodGet:
+		content, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	var result listBucketResult
+	for i := start; i < len(keys) && i < start+2; i++ {
+		result.Contents = append(result.Contents, struct{ Key string }{keys[i]})
+	}
+	if start+2 < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(start + 2)
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3(t *testing.T) {
+	fake := &fakeS3{t: t, bucket: "scion-coord", objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	testStorage(t, NewS3(server.URL+"/", "us-east-1", "scion-coord", "AKID", "SECRET"))
+	if string(fake.objects["user@example.com_1-ffaa_1_1.tar.gz"]) != "new package" {
+		t.Errorf("unexpected objects in the bucket: %v", fake.objects)
+	}
+}
+
+func TestS3Signature(t *testing.T) {
+	s := NewS3("http://127.0.0.1:9000", "us-east-1", "scion-coord", "AKID", "SECRET")
+	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1:9000/scion-coord/"+
+		uriEncode("certs/user@example.com_1-ffaa_1_1/V1/certs/a.crt", false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sign(req, nil, time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC))
+	expected := "AWS4-HMAC-SHA256 Credential=AKID/20180501/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, " +
+		"Signature=f85ffabf99d505daa96d0c9dbe6eac4e95e7b2763cbc5083749f1705336c1930"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("wrong Authorization header:\n%v\nexpected:\n%v", auth, expected)
+	}
+}
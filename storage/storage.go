@@ -0,0 +1,209 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage keeps the artifacts generated by the coordinator, i.e. the configuration
+// packages, certificate caches and VPN keys, either on the local disk or in an S3-compatible
+// object store shared by several coordinator instances.
+//
+// Artifacts are addressed by keys: slash separated names, each consisting only of letters,
+// digits and the characters "._@+-". Keys are built with Join, which sanitizes the names.
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotExist is returned when reading an artifact which does not exist
+var ErrNotExist = errors.New("artifact does not exist")
+
+// Storage stores artifacts by key
+type Storage interface {
+	// Put stores the content read from r under the key, replacing an existing artifact
+	Put(key string, r io.Reader) error
+	// Get returns the content of the artifact; it fails with ErrNotExist if there is none
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the artifact; removing an artifact which does not exist is not an error
+	Delete(key string) error
+	// List returns the sorted keys of all artifacts below the directory key dir
+	List(dir string) ([]string, error)
+}
+
+func validChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.ContainsRune("._@+-", c)
+}
+
+// SanitizeName replaces all characters which are not allowed in a key name by "_". Names
+// which would address a parent or the current directory are replaced as well.
+func SanitizeName(name string) string {
+	name = strings.Map(func(c rune) rune {
+		if validChar(c) {
+			return c
+		}
+		return '_'
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return strings.Repeat("_", len(name)+1)
+	}
+	return name
+}
+
+// Join builds a key from the names, sanitizing each of them
+func Join(names ...string) string {
+	sanitized := make([]string, len(names))
+	for i, name := range names {
+		sanitized[i] = SanitizeName(name)
+	}
+	return strings.Join(sanitized, "/")
+}
+
+// CheckKey fails if the key contains an empty name, characters which are not allowed or a
+// name addressing a parent or the current directory
+func CheckKey(key string) error {
+	for _, name := range strings.Split(key, "/") {
+		if name == "" || name == "." || name == ".." {
+			return fmt.Errorf("invalid storage key \"%v\"", key)
+		}
+		for _, c := range name {
+			if !validChar(c) {
+				return fmt.Errorf("invalid character %q in storage key \"%v\"", c, key)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadAll returns the content of the artifact
+func ReadAll(s Storage, key string) ([]byte, error) {
+	r, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// PutBytes stores the content under the key
+func PutBytes(s Storage, key string, content []byte) error {
+	return s.Put(key, bytes.NewReader(content))
+}
+
+// Exists tells whether there is an artifact with the key
+func Exists(s Storage, key string) (bool, error) {
+	r, err := s.Get(key)
+	if err == ErrNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r.Close()
+	return true, nil
+}
+
+// DeleteAll removes all artifacts below the directory key dir
+func DeleteAll(s Storage, dir string) error {
+	keys, err := s.List(dir)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = s.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutDir stores the regular files in the local directory src below the directory key dst.
+// The names of the files are sanitized.
+func PutDir(s Storage, src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return s.Put(dst+"/"+Join(strings.Split(filepath.ToSlash(rel), "/")...), f)
+	})
+}
+
+// GetDir writes the artifacts below the directory key src to the local directory dst,
+// replacing existing files. It fails with ErrNotExist if there are no such artifacts.
+func GetDir(s Storage, src, dst string) error {
+	keys, err := s.List(src)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrNotExist
+	}
+	for _, key := range keys {
+		path := filepath.Join(dst, filepath.FromSlash(strings.TrimPrefix(key, src+"/")))
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		content, err := ReadAll(s, key)
+		if err != nil {
+			return fmt.Errorf("failed to read %v: %v", key, err)
+		}
+		if err = ioutil.WriteFile(path, content, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy stores the content of the artifact src under the key dst. It fails with ErrNotExist if
+// there is no artifact src.
+func Copy(s Storage, src, dst string) error {
+	r, err := s.Get(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return s.Put(dst, r)
+}
+
+// CopyAll copies the artifacts below the directory key src below the directory key dst. It
+// returns the keys of the copies.
+func CopyAll(s Storage, src, dst string) ([]string, error) {
+	keys, err := s.List(src)
+	if err != nil {
+		return nil, err
+	}
+	var copied []string
+	for _, key := range keys {
+		target := dst + strings.TrimPrefix(key, src)
+		if err = Copy(s, key, target); err != nil {
+			return copied, err
+		}
+		copied = append(copied, target)
+	}
+	return copied, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	cases := []struct {
+		names    []string
+		expected string
+	}{
+		{[]string{"versions", "user@example.com_1-ffaa_1_1", "V1.tar.gz"},
+			"versions/user@example.com_1-ffaa_1_1/V1.tar.gz"},
+		{[]string{"certs", "../../etc/passwd"}, "certs/.._.._etc_passwd"},
+		{[]string{"a b", "c\\d", "ä"}, "a_b/c_d/_"},
+		{[]string{"..", ".", ""}, "___/__/_"},
+	}
+	for _, c := range cases {
+		if key := Join(c.names...); key != c.expected {
+			t.Errorf("Join(%q) returned %q, expected %q", c.names, key, c.expected)
+		}
+		if err := CheckKey(Join(c.names...)); err != nil {
+			t.Errorf("Join(%q) returned an invalid key: %v", c.names, err)
+		}
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	cases := []struct {
+		key   string
+		valid bool
+	}{
+		{"user@example.com_1-ffaa_1_1.tar.gz", true},
+		{"certs/user+tag@example.com_1-ffaa_1_1/V2/keys/as-sig.seed", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"../secret", false},
+		{"certs/../../secret", false},
+		{"certs/./V1", false},
+		{"certs//V1", false},
+		{"certs/V1/", false},
+		{"certs\\..\\secret", false},
+		{"a b", false},
+		{"a\x00b", false},
+	}
+	for _, c := range cases {
+		if err := CheckKey(c.key); (err == nil) != c.valid {
+			t.Errorf("CheckKey(%q) returned %v, expected valid: %v", c.key, err, c.valid)
+		}
+	}
+}
+
+// testStorage checks the behavior common to all implementations
+func testStorage(t *testing.T, s Storage) {
+	if _, err := s.Get("missing"); err != ErrNotExist {
+		t.Errorf("Get of a missing artifact returned %v, expected ErrNotExist", err)
+	}
+	if err := s.Delete("missing"); err != nil {
+		t.Errorf("Delete of a missing artifact failed: %v", err)
+	}
+	for _, key := range []string{"../outside", "a/../../outside", "/abs"} {
+		if err := PutBytes(s, key, []byte("x")); err == nil {
+			t.Errorf("Put(%q) should fail", key)
+		}
+		if _, err := s.Get(key); err == nil || err == ErrNotExist {
+			t.Errorf("Get(%q) should fail with an invalid key, got %v", key, err)
+		}
+	}
+	files := map[string]string{
+		"certs/user@example.com_1-ffaa_1_1/V1/certs/a.crt": "cert1",
+		"certs/user@example.com_1-ffaa_1_1/V1/keys/a.key":  "key1",
+		"certs/user@example.com_1-ffaa_1_2/V1/certs/a.crt": "other",
+		"user@example.com_1-ffaa_1_1.tar.gz":               "package",
+	}
+	for key, content := range files {
+		if err := PutBytes(s, key, []byte(content)); err != nil {
+			t.Fatalf("Put(%q) failed: %v", key, err)
+		}
+	}
+	if err := PutBytes(s, "user@example.com_1-ffaa_1_1.tar.gz", []byte("new package")); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ReadAll(s, "user@example.com_1-ffaa_1_1.tar.gz")
+	if err != nil || string(content) != "new package" {
+		t.Errorf("ReadAll returned %q, %v, expected the replaced content", content, err)
+	}
+	keys, err := s.List("certs/user@example.com_1-ffaa_1_1")
+	expected := []string{"certs/user@example.com_1-ffaa_1_1/V1/certs/a.crt",
+		"certs/user@example.com_1-ffaa_1_1/V1/keys/a.key"}
+	if err != nil || !reflect.DeepEqual(keys, expected) {
+		t.Errorf("List returned %v, %v, expected %v", keys, err, expected)
+	}
+	if keys, err = s.List("versions"); err != nil || len(keys) != 0 {
+		t.Errorf("List of a missing directory returned %v, %v", keys, err)
+	}
+	if err = DeleteAll(s, "certs/user@example.com_1-ffaa_1_1"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := Exists(s, "certs/user@example.com_1-ffaa_1_1/V1/keys/a.key"); exists || err != nil {
+		t.Errorf("the artifact should be deleted: %v, %v", exists, err)
+	}
+	if exists, err := Exists(s, "certs/user@example.com_1-ffaa_1_2/V1/certs/a.crt"); !exists || err != nil {
+		t.Errorf("the artifact of another AS should be kept: %v, %v", exists, err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "root")
+	testStorage(t, NewLocal(root))
+	// nothing was written outside of the root
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "root" {
+		t.Errorf("files were written outside of the root: %v", entries)
+	}
+	// the layout on disk follows the keys
+	content, err := ioutil.ReadFile(filepath.Join(root, "user@example.com_1-ffaa_1_1.tar.gz"))
+	if err != nil || string(content) != "new package" {
+		t.Errorf("unexpected file content %q: %v", content, err)
+	}
+}
+
+func TestPutGetDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	s := NewLocal(filepath.Join(tmp, "root"))
+	src := filepath.Join(tmp, "src")
+	if err = os.MkdirAll(filepath.Join(src, "certs"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(src, "certs", "a.crt"), []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = PutDir(s, src, "certs/as/V1"); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(tmp, "dst")
+	if err = GetDir(s, "certs/as/V1", dst); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dst, "certs", "a.crt"))
+	if err != nil || strings.TrimSpace(string(content)) != "cert" {
+		t.Errorf("GetDir wrote %q, %v", content, err)
+	}
+}
+
+func TestCopyAll(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	s := NewLocal(filepath.Join(tmp, "root"))
+	for _, key := range []string{"versions/old_1-ffaa_1_1/V1.tar.gz",
+		"versions/old_1-ffaa_1_1/V2.tar.gz", "versions/old_1-ffaa_1_10/V1.tar.gz"} {
+		if err = PutBytes(s, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copied, err := CopyAll(s, "versions/old_1-ffaa_1_1", "versions/new_1-ffaa_1_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"versions/new_1-ffaa_1_1/V1.tar.gz", "versions/new_1-ffaa_1_1/V2.tar.gz"}
+	if strings.Join(copied, " ") != strings.Join(expected, " ") {
+		t.Errorf("CopyAll copied %v, expected %v", copied, expected)
+	}
+	content, err := ReadAll(s, "versions/new_1-ffaa_1_1/V2.tar.gz")
+	if err != nil || string(content) != "versions/old_1-ffaa_1_1/V2.tar.gz" {
+		t.Errorf("unexpected content %q: %v", content, err)
+	}
+	// the originals are kept
+	if exists, err := Exists(s, "versions/old_1-ffaa_1_1/V1.tar.gz"); !exists || err != nil {
+		t.Errorf("the original should be kept: %v, %v", exists, err)
+	}
+	if err = Copy(s, "versions/missing", "versions/copy"); err != ErrNotExist {
+		t.Errorf("copying a missing artifact returned %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package throttle slows down repeated failed attempts, e.g. password guessing, with an
+// exponential back-off and a temporary lockout. All functions take the current time, so that the
+// windows can be tested with a controllable clock.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy describes the back-off applied after failed attempts
+type Policy struct {
+	FreeAttempts    int           // failures allowed without delay
+	BaseDelay       time.Duration // delay after the first further failure, doubled for each next one
+	MaxDelay        time.Duration // longest delay between attempts
+	LockoutAttempts int           // failures after which the subject is locked out; 0 disables it
+	Lockout         time.Duration // duration of the lockout
+	Window          time.Duration // failures are forgotten after this time without further failures
+}
+
+// State is the record of failed attempts of a subject
+type State struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// delay returns the time to wait after the number of consecutive failures
+func (p Policy) delay(failures int) time.Duration {
+	n := failures - p.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// expired returns whether the failures of the state are older than the window
+func (p Policy) expired(s State, now time.Time) bool {
+	return p.Window > 0 && now.Sub(s.LastFailure) >= p.Window
+}
+
+// Wait returns how long the subject has to wait before its next attempt, 0 if it may try now,
+// and whether it is locked out
+func (p Policy) Wait(s State, now time.Time) (time.Duration, bool) {
+	if now.Before(s.LockedUntil) {
+		return s.LockedUntil.Sub(now), true
+	}
+	if s.Failures == 0 || p.expired(s, now) {
+		return 0, false
+	}
+	if next := s.LastFailure.Add(p.delay(s.Failures)); now.Before(next) {
+		return next.Sub(now), false
+	}
+	return 0, false
+}
+
+// Fail records a failed attempt at time now. It returns the new state and whether this failure
+// caused a lockout.
+func (p Policy) Fail(s State, now time.Time) (State, bool) {
+	if p.expired(s, now) {
+		s.Failures = 0
+	}
+	s.Failures++
+	s.LastFailure = now
+	if p.LockoutAttempts > 0 && s.Failures >= p.LockoutAttempts {
+		s.Failures = 0
+		s.LockedUntil = now.Add(p.Lockout)
+		return s, true
+	}
+	return s, false
+}
+
+// Tracker keeps the states of subjects in memory, e.g. of source addresses
+type Tracker struct {
+	policy  Policy
+	mu      sync.Mutex
+	states  map[string]State
+	cleaned time.Time // last removal of states without effect
+}
+
+// NewTracker returns an empty tracker applying the policy
+func NewTracker(policy Policy) *Tracker {
+	return &Tracker{policy: policy, states: make(map[string]State)}
+}
+
+// Wait returns how long the subject has to wait and whether it is locked out, see Policy.Wait
+func (t *Tracker) Wait(key string, now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.policy.Wait(t.states[key], now)
+}
+
+// Fail records a failed attempt of the subject, see Policy.Fail. States that no longer have an
+// effect are removed once per window.
+func (t *Tracker) Fail(key string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, locked := t.policy.Fail(t.states[key], now)
+	t.states[key] = s
+	if now.Sub(t.cleaned) >= t.policy.Window {
+		for k, s := range t.states {
+			if !now.Before(s.LockedUntil) && t.policy.expired(s, now) {
+				delete(t.states, k)
+			}
+		}
+		t.cleaned = now
+	}
+	return locked
+}
+
+// Len returns the number of subjects with recorded failures
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.states)
+}
+
+// Reset forgets the failed attempts of the subject
+func (t *Tracker) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, key)
+}
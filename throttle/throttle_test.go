@@ -0,0 +1,127 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+var policy = Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        10 * time.Second,
+	LockoutAttempts: 8,
+	Lockout:         30 * time.Minute,
+	Window:          time.Hour,
+}
+
+var start = time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestDelay(t *testing.T) {
+	for failures, expected := range map[int]time.Duration{
+		0: 0, 1: 0, 2: 0,
+		3: time.Second, 4: 2 * time.Second, 5: 4 * time.Second, 6: 8 * time.Second,
+		7: 10 * time.Second, 100: 10 * time.Second,
+	} {
+		if d := policy.delay(failures); d != expected {
+			t.Errorf("delay after %d failures: %v, expected %v", failures, d, expected)
+		}
+	}
+}
+
+func TestBackOffBoundaries(t *testing.T) {
+	var s State
+	now := start
+	for i := 0; i < 3; i++ {
+		s, _ = policy.Fail(s, now)
+	}
+	// third failure: one second back-off, ending exactly one second later
+	if wait, locked := policy.Wait(s, now); wait != time.Second || locked {
+		t.Errorf("wait %v locked %v, expected 1s unlocked", wait, locked)
+	}
+	if wait, _ := policy.Wait(s, now.Add(time.Second-time.Nanosecond)); wait != time.Nanosecond {
+		t.Errorf("wait %v just before the end of the back-off", wait)
+	}
+	if wait, _ := policy.Wait(s, now.Add(time.Second)); wait != 0 {
+		t.Errorf("wait %v at the end of the back-off", wait)
+	}
+
+	// the failures are forgotten exactly after the window
+	s, _ = policy.Fail(s, now)
+	if wait, _ := policy.Wait(s, now.Add(policy.Window-time.Nanosecond)); wait != 0 {
+		t.Errorf("back-off of %v still in effect after its delay", wait)
+	}
+	s, _ = policy.Fail(s, now.Add(policy.Window-time.Nanosecond))
+	if s.Failures != 5 {
+		t.Errorf("%d failures within the window, expected 5", s.Failures)
+	}
+	s, _ = policy.Fail(s, now.Add(2*policy.Window-time.Nanosecond))
+	if s.Failures != 1 {
+		t.Errorf("%d failures after the window, expected 1", s.Failures)
+	}
+}
+
+func TestLockoutBoundaries(t *testing.T) {
+	var s State
+	now := start
+	var locked bool
+	for i := 1; i <= policy.LockoutAttempts; i++ {
+		s, locked = policy.Fail(s, now)
+		if locked != (i == policy.LockoutAttempts) {
+			t.Fatalf("failure %d: locked %v", i, locked)
+		}
+	}
+	if wait, locked := policy.Wait(s, now); wait != policy.Lockout || !locked {
+		t.Errorf("wait %v locked %v, expected the lockout", wait, locked)
+	}
+	end := now.Add(policy.Lockout)
+	if wait, locked := policy.Wait(s, end.Add(-time.Nanosecond)); wait != time.Nanosecond ||
+		!locked {
+		t.Errorf("wait %v locked %v just before the end of the lockout", wait, locked)
+	}
+	if wait, locked := policy.Wait(s, end); wait != 0 || locked {
+		t.Errorf("wait %v locked %v at the end of the lockout", wait, locked)
+	}
+	// after the lockout, the free attempts start over
+	s, _ = policy.Fail(s, end)
+	if wait, _ := policy.Wait(s, end); wait != 0 {
+		t.Errorf("back-off of %v after the first failure following the lockout", wait)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker(policy)
+	for i := 0; i < 3; i++ {
+		tr.Fail("192.0.2.1", start)
+	}
+	if wait, _ := tr.Wait("192.0.2.1", start); wait != time.Second {
+		t.Errorf("wait %v, expected 1s", wait)
+	}
+	if wait, _ := tr.Wait("192.0.2.2", start); wait != 0 {
+		t.Errorf("other subject has to wait %v", wait)
+	}
+	tr.Reset("192.0.2.1")
+	if wait, _ := tr.Wait("192.0.2.1", start); wait != 0 {
+		t.Errorf("wait %v after reset", wait)
+	}
+
+	// expired states are removed
+	tr.Fail("192.0.2.1", start)
+	tr.Fail("192.0.2.2", start.Add(2*policy.Window))
+	if n := tr.Len(); n != 1 {
+		t.Errorf("%d states kept, expected 1", n)
+	}
+}
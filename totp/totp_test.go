@@ -0,0 +1,109 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA-1 seed of the test vectors in RFC 6238, appendix B
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// the last 6 digits of the 8 digit codes of the RFC
+	for unix, expected := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		code, err := Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("wrong code at %v: %v, expected %v", unix, code, expected)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Unix(1500000000, 0)
+	code, _ := Code(secret, clock)
+
+	step, ok := Verify(secret, code, clock, 1, 0)
+	if !ok {
+		t.Fatal("valid code rejected")
+	}
+	if _, ok := Verify(secret, code, clock, 1, step); ok {
+		t.Error("replayed code accepted")
+	}
+	// one step of drift is tolerated, two are not
+	if _, ok := Verify(secret, code, clock.Add(Step), 1, 0); !ok {
+		t.Error("code of the previous time step rejected")
+	}
+	if _, ok := Verify(secret, code, clock.Add(-Step), 1, 0); !ok {
+		t.Error("code of the next time step rejected")
+	}
+	if _, ok := Verify(secret, code, clock.Add(2*Step), 1, 0); ok {
+		t.Error("expired code accepted")
+	}
+	if _, ok := Verify(secret, code, clock.Add(Step), 0, 0); ok {
+		t.Error("code of the previous time step accepted without skew")
+	}
+	for _, invalid := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Verify(secret, invalid, clock, 1, 0); ok {
+			t.Errorf("invalid code %q accepted", invalid)
+		}
+	}
+	if _, ok := Verify("not base32!", code, clock, 1, 0); ok {
+		t.Error("code accepted with an invalid secret")
+	}
+}
+
+func TestSecretFormat(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(secret, "=") || len(secret) != 32 {
+		t.Errorf("unexpected secret %q", secret)
+	}
+	// authenticator apps may show the secret in groups and lower case
+	spaced := strings.ToLower(secret[:4] + " " + secret[4:])
+	a, _ := Code(secret, time.Unix(0, 0))
+	b, err := Code(spaced, time.Unix(0, 0))
+	if err != nil || a != b {
+		t.Errorf("secret %q not accepted: %v", spaced, err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("JBSWY3DPEHPK3PXP", "SCIONLab", "user@example.com")
+	expected := "otpauth://totp/SCIONLab:user@example.com?algorithm=SHA1&digits=6" +
+		"&issuer=SCIONLab&period=30&secret=JBSWY3DPEHPK3PXP"
+	if uri != expected {
+		t.Errorf("wrong URI %v, expected %v", uri, expected)
+	}
+}
@@ -15,10 +15,10 @@
 package geolocation
 
 import (
-	"log"
 	"net"
 
 	"github.com/astaxie/beego/orm"
+	"github.com/netsec-ethz/scion-coord/logger"
 	"github.com/netsec-ethz/scion-coord/models"
 	"github.com/oschwald/geoip2-golang"
 	"github.com/scionproto/scion/go/lib/addr"
@@ -28,7 +28,7 @@ import (
 func IPGeolocation(ipAddress string) (country string, continent string, err error) {
 	db, err := geoip2.Open("utility/geolocation/GeoLite2-City.mmdb")
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatalf("%v", err)
 	}
 	defer db.Close()
 	// If you are using strings that may be invalid, check that ip is not nil
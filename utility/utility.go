@@ -15,12 +15,13 @@
 package utility
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/big"
 	"net"
 	"net/http"
 	"os"
@@ -32,6 +33,8 @@ import (
 	"time"
 
 	"github.com/scionproto/scion/go/lib/addr"
+
+	"github.com/netsec-ethz/scion-coord/logger"
 )
 
 const (
@@ -99,6 +102,9 @@ func CopyPath(src, dst string) error {
 }
 
 // Some helper functions for IP addresses
+
+// IPToInt converts an IPv4 address to its integer representation. Use IPToBigInt for addresses
+// that may be IPv6.
 func IPToInt(ip string) uint32 {
 	return binary.BigEndian.Uint32(net.ParseIP(ip)[12:])
 }
@@ -108,25 +114,120 @@ func IntToIP(ipInt uint32) string {
 		byte(ipInt>>24), byte(ipInt>>16), byte(ipInt>>8), byte(ipInt))
 }
 
+// IPFamily returns "IPv4" or "IPv6" depending on the family of the address, or an empty string
+// if ip is not a valid IP address. IPv4-mapped IPv6 addresses are considered IPv4.
+func IPFamily(ip string) string {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return "IPv4"
+	default:
+		return "IPv6"
+	}
+}
+
+// IsIPv6 returns true iff ip is a valid IPv6 (and not IPv4-mapped) address
+func IsIPv6(ip string) bool {
+	return IPFamily(ip) == "IPv6"
+}
+
+// OverlayType returns the SCION overlay to be used for links with the given address.
+// Invalid or empty addresses default to the IPv4 overlay.
+func OverlayType(ip string) string {
+	if IsIPv6(ip) {
+		return "UDP/IPv6"
+	}
+	return "UDP/IPv4"
+}
+
+// NormalizeIP returns the canonical textual representation of ip,
+// e.g. 2001:DB8:0::1 becomes 2001:db8::1
+func NormalizeIP(ip string) (string, error) {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return "", fmt.Errorf("%v is not a valid IP address", ip)
+	}
+	return parsed.String(), nil
+}
+
+// IPToBigInt converts an IPv4 or IPv6 address to its integer representation.
+// IPv4 addresses are mapped to the range [0, 2^32).
+func IPToBigInt(ip string) *big.Int {
+	parsed := net.ParseIP(ip)
+	if v4 := parsed.To4(); v4 != nil {
+		return new(big.Int).SetBytes(v4)
+	}
+	return new(big.Int).SetBytes(parsed.To16())
+}
+
+// BigIntToIP is the inverse of IPToBigInt. The family of the address has to be specified
+// as the integer alone is ambiguous.
+func BigIntToIP(ipInt *big.Int, isIPv6 bool) string {
+	size := net.IPv4len
+	if isIPv6 {
+		size = net.IPv6len
+	}
+	b := ipInt.Bytes()
+	if len(b) > size {
+		b = b[len(b)-size:]
+	}
+	ip := make(net.IP, size)
+	copy(ip[size-len(b):], b)
+	return ip.String()
+}
+
+// IPIncrement adds diff to the address, wrapping around within the address family
 func IPIncrement(ip string, diff int32) string {
-	temp := IPToInt(ip)
-	if diff > 0 {
-		temp += uint32(diff)
-	} else {
-		temp -= uint32(-diff)
+	isIPv6 := IsIPv6(ip)
+	bits := uint(32)
+	if isIPv6 {
+		bits = 128
 	}
-	return IntToIP(temp)
+	temp := IPToBigInt(ip)
+	temp.Add(temp, big.NewInt(int64(diff)))
+	modulus := new(big.Int).Lsh(big.NewInt(1), bits)
+	temp.Mod(temp, modulus)
+	return BigIntToIP(temp, isIPv6)
 }
 
 // Returns -1, if ip1 < ip2, 0, if ip1 == ip2, +1, if ip1 > ip2
+// IPv4 addresses compare as smaller than any IPv6 address outside of the IPv4-mapped range.
 func IPCompare(ip1, ip2 string) int8 {
-	if diff := int(IPToInt(ip1)) - int(IPToInt(ip2)); diff > 0 {
-		return 1
-	} else if diff == 0 {
-		return 0
-	} else {
-		return -1
+	return int8(bytes.Compare(net.ParseIP(ip1).To16(), net.ParseIP(ip2).To16()))
+}
+
+// GetAvailableIP returns the smallest IP address in the range [start, end] not present in used.
+// start and end must belong to the same address family; used may contain addresses of any family.
+func GetAvailableIP(used []string, start, end string) (string, error) {
+	family := IPFamily(start)
+	if family == "" || family != IPFamily(end) {
+		return "", fmt.Errorf("invalid IP range %v - %v", start, end)
+	}
+	isIPv6 := family == "IPv6"
+	min, max := IPToBigInt(start), IPToBigInt(end)
+	var ids []*big.Int
+	for _, ip := range used {
+		if IPFamily(ip) != family {
+			continue
+		}
+		ids = append(ids, IPToBigInt(ip))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Cmp(ids[j]) < 0 })
+	res := new(big.Int).Set(min)
+	for _, x := range ids {
+		if res.Cmp(x) < 0 {
+			break
+		}
+		if res.Cmp(x) == 0 {
+			res.Add(res, big.NewInt(1))
+		}
+	}
+	if res.Cmp(max) > 0 {
+		return "", errors.New("no free IP address found")
 	}
+	return BigIntToIP(res, isIPv6), nil
 }
 
 // Create IA string from ISD and AS IDs
@@ -283,7 +384,7 @@ func GetTimeCutoff(r *http.Request) int64 {
 	if err != nil {
 		cutoff = now
 	}
-	log.Printf("[DEBUG] Using UTC time delta: %d (now is %d)", cutoff, now)
+	logger.FromContext(r.Context()).Debugf("Using UTC time delta: %d (now is %d)", cutoff, now)
 	return cutoff
 }
 
@@ -54,6 +54,9 @@ func TestIPFunctions(t *testing.T) {
 		{"0.0.0.0", "0.0.0.0", 0},
 		{"0.0.0.1", "0.0.0.0", 1},
 		{"0.1.0.0", "0.0.0.0", 1},
+		{"2001:db8::1", "2001:db8::2", -1},
+		{"2001:db8::1", "2001:DB8:0::1", 0},
+		{"255.255.255.255", "2001:db8::", -1},
 	}
 
 	for _, ipComp := range ipComparisonTests {
@@ -80,6 +83,9 @@ func TestIPFunctions(t *testing.T) {
 	ipIncrementTests := []ipIncrementTest{
 		{"192.168.1.1", "192.168.1.3", 2},
 		{"255.255.255.255", "0.0.0.0", 1},
+		{"2001:db8::1", "2001:db8::3", 2},
+		{"2001:db8::ffff", "2001:db8::1:0", 1},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::", 1},
 	}
 
 	for _, ipInc := range ipIncrementTests {
@@ -89,6 +95,55 @@ func TestIPFunctions(t *testing.T) {
 		}
 	}
 
+	ipFamilyTests := []struct {
+		ip      string
+		family  string
+		overlay string
+	}{
+		{"192.168.1.1", "IPv4", "UDP/IPv4"},
+		{"::ffff:192.168.1.1", "IPv4", "UDP/IPv4"},
+		{"2001:db8::1", "IPv6", "UDP/IPv6"},
+		{"not an IP", "", "UDP/IPv4"},
+		{"", "", "UDP/IPv4"},
+	}
+
+	for _, ipFam := range ipFamilyTests {
+		if IPFamily(ipFam.ip) != ipFam.family || OverlayType(ipFam.ip) != ipFam.overlay {
+			t.Errorf("IP family detection failed for %v", ipFam.ip)
+		}
+	}
+}
+
+var getAvailableIPtests = []struct {
+	start    string
+	end      string
+	used     []string
+	expected string
+	err      bool
+}{
+	{"10.0.8.2", "10.0.8.10", []string{}, "10.0.8.2", false},
+	{"10.0.8.2", "10.0.8.10", []string{"10.0.8.2", "10.0.8.4"}, "10.0.8.3", false},
+	{"10.0.8.2", "10.0.8.3", []string{"10.0.8.3", "10.0.8.2"}, "", true},
+	{"10.0.8.255", "10.0.9.1", []string{"10.0.8.255"}, "10.0.9.0", false},
+	{"fd00::2", "fd00::ff", []string{"fd00::2", "fd00::3", "10.0.8.4"}, "fd00::4", false},
+	{"fd00::ffff", "fd00::1:1", []string{"fd00:0::ffff"}, "fd00::1:0", false},
+	{"fd00::2", "fd00::2", []string{"fd00::2"}, "", true},
+	{"10.0.8.2", "fd00::2", []string{}, "", true},
+	{"10.0.8.2", "bad", []string{}, "", true},
+}
+
+func TestGetAvailableIP(t *testing.T) {
+	for i, tt := range getAvailableIPtests {
+		actual, err := GetAvailableIP(tt.used, tt.start, tt.end)
+		if (err != nil) != tt.err {
+			t.Errorf("Expected error? %v, but error is: %v", tt.err, err)
+			t.Errorf("Test table index %d, content:\n%v", i, tt)
+		}
+		if actual != tt.expected {
+			t.Errorf("Expected %v, got %v", tt.expected, actual)
+			t.Errorf("Test table index %d, content:\n%v", i, tt)
+		}
+	}
 }
 
 func TestBRIDFromString(t *testing.T) {
@@ -0,0 +1,212 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vpnca implements the certificate authority issuing the client certificates of the
+// OpenVPN servers of the attachment points. It uses the CA certificate and key created with
+// easy-rsa, so the client certificates issued before keep working.
+package vpnca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// CA signs client certificates with the CA key
+type CA struct {
+	Cert *x509.Certificate
+	Key  *rsa.PrivateKey
+}
+
+// Issued is a newly issued client certificate with its private key
+type Issued struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	KeyPEM  []byte // PKCS#1
+}
+
+// Load reads the PEM encoded CA certificate and key, e.g. ca.crt and ca.key of easy-rsa
+func Load(certFile, keyFile string) (*CA, error) {
+	rawCert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the CA certificate: %v", err)
+	}
+	cert, err := ParseCertificatePEM(rawCert)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the CA certificate %v: %v", certFile, err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("the certificate %v is not a CA certificate", certFile)
+	}
+	rawKey, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the CA key: %v", err)
+	}
+	key, err := parsePrivateKeyPEM(rawKey)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the CA key %v: %v", keyFile, err)
+	}
+	certPub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || key.N.Cmp(certPub.N) != 0 || key.E != certPub.E {
+		return nil, fmt.Errorf("the CA key %v does not match the certificate %v", keyFile,
+			certFile)
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// ParseCertificatePEM parses the first certificate in the PEM data. Text before the PEM block,
+// as written by easy-rsa, is skipped.
+func ParseCertificatePEM(raw []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return nil, errors.New("no PEM encoded certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// parsePrivateKeyPEM parses an RSA key in PKCS#1 or, as written by easy-rsa, PKCS#8 format
+func parsePrivateKeyPEM(raw []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			return rsaKey, nil
+		}
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %v", block.Type)
+}
+
+// ID identifies the CA by its subject; it equals the IssuerID of the certificates it issues
+func (ca *CA) ID() string {
+	return nameID(ca.Cert.RawSubject)
+}
+
+// IssuerID identifies the CA which issued the certificate
+func IssuerID(cert *x509.Certificate) string {
+	return nameID(cert.RawIssuer)
+}
+
+func nameID(rawName []byte) string {
+	sum := sha256.Sum256(rawName)
+	return hex.EncodeToString(sum[:])
+}
+
+// SerialString formats the serial number as upper case hex, like the easy-rsa index
+func SerialString(serial *big.Int) string {
+	return strings.ToUpper(serial.Text(16))
+}
+
+// randomSerial returns a random positive 128 bit serial number, which cannot collide with the
+// small sequential serial numbers assigned by easy-rsa
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	return serial.Add(serial, new(big.Int).Lsh(big.NewInt(1), 64)), nil
+}
+
+// Issue creates a key pair and a client certificate for the common name, valid from now on for
+// the given duration. The subject, apart from the common name, is taken from the CA.
+func (ca *CA) Issue(commonName string, validity time.Duration, keyBits int) (*Issued, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, fmt.Errorf("error generating the key for %v: %v", commonName, err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	subjectKeyID := sha1.Sum(pubKey)
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Country:            ca.Cert.Subject.Country,
+			Province:           ca.Cert.Subject.Province,
+			Locality:           ca.Cert.Subject.Locality,
+			Organization:       ca.Cert.Subject.Organization,
+			OrganizationalUnit: ca.Cert.Subject.OrganizationalUnit,
+			CommonName:         commonName,
+		},
+		NotBefore:             now.Add(-5 * time.Minute), // tolerate clock skew
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		SubjectKeyId:          subjectKeyID[:],
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error signing the certificate for %v: %v", commonName, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Issued{
+		Cert:    cert,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}, nil
+}
+
+// CRL returns the PEM encoded certificate revocation list of the CA, listing the revoked
+// certificates. It has to be renewed within the given duration.
+func (ca *CA) CRL(revoked []pkix.RevokedCertificate, validity time.Duration) ([]byte, error) {
+	now := time.Now()
+	return ca.CRLAt(revoked, now, now.Add(validity))
+}
+
+// CRLAt returns the PEM encoded certificate revocation list of the CA issued at thisUpdate,
+// which has to be renewed before nextUpdate.
+func (ca *CA) CRLAt(revoked []pkix.RevokedCertificate, thisUpdate,
+	nextUpdate time.Time) ([]byte, error) {
+	der, err := ca.Cert.CreateCRL(rand.Reader, ca.Key, revoked, thisUpdate, nextUpdate)
+	if err != nil {
+		return nil, fmt.Errorf("error signing the CRL: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpnca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCA writes a CA certificate and key like the ones created by easy-rsa's build-ca,
+// with some text before the certificate. It returns the paths of both files.
+func writeTestCA(t *testing.T, dir string) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"CH"},
+			Province:           []string{"ZH"},
+			Locality:           []string{"Zurich"},
+			Organization:       []string{"ETH"},
+			OrganizationalUnit: []string{"NetSec"},
+			CommonName:         "ETH CA",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "ca.crt")
+	keyFile := filepath.Join(dir, "ca.key")
+	rawCert := append([]byte("Certificate:\n    Data:\n        Version: 3 (0x2)\n"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	if err = ioutil.WriteFile(certFile, rawCert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rawKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err = ioutil.WriteFile(keyFile, rawKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestIssue(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "vpnca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	ca, err := Load(writeTestCA(t, tmp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := ca.Issue("user@example.com_ffaa_1_1", time.Hour, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ParseCertificatePEM(issued.CertPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Errorf("the client certificate is not valid: %v", err)
+	}
+	if cert.Subject.CommonName != "user@example.com_ffaa_1_1" || cert.Subject.Organization[0] != "ETH" {
+		t.Errorf("wrong subject %v", cert.Subject)
+	}
+	if cert.IsCA {
+		t.Error("the client certificate must not be a CA certificate")
+	}
+	key, err := parsePrivateKeyPEM(issued.KeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.N.Cmp(cert.PublicKey.(*rsa.PublicKey).N) != 0 {
+		t.Error("the key does not match the certificate")
+	}
+	other, err := ca.Issue("user@example.com_ffaa_1_1", time.Hour, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Cert.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+		t.Errorf("two certificates have the serial number %v", SerialString(cert.SerialNumber))
+	}
+}
+
+func TestLoadMismatchingKey(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "vpnca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	certFile, _ := writeTestCA(t, tmp)
+	other := filepath.Join(tmp, "other")
+	if err = os.Mkdir(other, 0700); err != nil {
+		t.Fatal(err)
+	}
+	_, keyFile := writeTestCA(t, other)
+	if _, err = Load(certFile, keyFile); err == nil {
+		t.Error("loading a CA with a key not matching the certificate should fail")
+	}
+}
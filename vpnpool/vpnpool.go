@@ -0,0 +1,335 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vpnpool manages the addresses an attachment point assigns to the ASes connecting to
+// it over VPN.
+//
+// A pool is defined by a subnet (or, for older APs, a range of addresses) minus a set of
+// excluded addresses. Each address of the pool is either free, allocated to an owner or held
+// for an owner until some time: a released address is held for a grace period, so that the AS
+// gets the same address back when it reconnects and no other AS receives an address that may
+// still be configured on the old one. Pools work the same for IPv4 and IPv6.
+package vpnpool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/netsec-ethz/scion-coord/utility"
+)
+
+// ErrExhausted is returned by Allocate if the pool has no free address left
+var ErrExhausted = errors.New("no free address left in the VPN pool")
+
+// Pool is the set of VPN addresses of an attachment point. It is not safe for concurrent use.
+type Pool struct {
+	name        string
+	ipv6        bool
+	first, last *big.Int
+	excluded    map[string]bool
+	allocated   map[string]string
+	held        map[string]hold
+}
+
+type hold struct {
+	owner string
+	until time.Time
+}
+
+// New returns an empty pool containing the addresses of the subnet given in CIDR notation,
+// except the excluded ones. For IPv4 subnets the network and broadcast addresses are excluded
+// as well, for IPv6 subnets the subnet-router anycast address.
+func New(cidr string, excluded ...string) (*Pool, error) {
+	ip, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VPN subnet: %v", err)
+	}
+	ipv6 := ip.To4() == nil
+	ones, bits := subnet.Mask.Size()
+	first := utility.IPToBigInt(subnet.IP.String())
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	last := new(big.Int).Add(first, size)
+	last.Sub(last, big.NewInt(1))
+	p := newPool(subnet.String(), ipv6, first, last)
+	// /31 and /32 subnets (RFC 3021) and /127 and /128 subnets (RFC 6164) have no such addresses
+	if bits-ones > 1 {
+		p.excluded[utility.BigIntToIP(first, ipv6)] = true
+		if !ipv6 {
+			p.excluded[utility.BigIntToIP(last, ipv6)] = true
+		}
+	}
+	return p, p.exclude(excluded)
+}
+
+// NewRange returns an empty pool containing the addresses from start to end, both included,
+// except the excluded ones.
+func NewRange(start, end string, excluded ...string) (*Pool, error) {
+	family := utility.IPFamily(start)
+	if family == "" || family != utility.IPFamily(end) {
+		return nil, fmt.Errorf("invalid VPN range %v - %v", start, end)
+	}
+	first, last := utility.IPToBigInt(start), utility.IPToBigInt(end)
+	if first.Cmp(last) > 0 {
+		return nil, fmt.Errorf("invalid VPN range %v - %v", start, end)
+	}
+	p := newPool(start+" - "+end, family == "IPv6", first, last)
+	return p, p.exclude(excluded)
+}
+
+func newPool(name string, ipv6 bool, first, last *big.Int) *Pool {
+	return &Pool{
+		name:      name,
+		ipv6:      ipv6,
+		first:     first,
+		last:      last,
+		excluded:  make(map[string]bool),
+		allocated: make(map[string]string),
+		held:      make(map[string]hold),
+	}
+}
+
+// exclude removes the addresses from the pool; addresses outside of the pool are ignored
+func (p *Pool) exclude(ips []string) error {
+	for _, ip := range ips {
+		key, err := p.key(ip)
+		if err == errOutside {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		p.excluded[key] = true
+	}
+	return nil
+}
+
+var errOutside = errors.New("address outside of the pool")
+
+// key returns the normalized form of the address, which must be part of the pool
+func (p *Pool) key(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+	if (parsed.To4() == nil) != p.ipv6 {
+		return "", errOutside
+	}
+	x := utility.IPToBigInt(ip)
+	if x.Cmp(p.first) < 0 || x.Cmp(p.last) > 0 {
+		return "", errOutside
+	}
+	return parsed.String(), nil
+}
+
+// String returns the subnet or range of the pool
+func (p *Pool) String() string {
+	return p.name
+}
+
+// Prefix returns the subnet of the pool. For pools defined by a range it is the smallest subnet
+// containing the range.
+func (p *Pool) Prefix() *net.IPNet {
+	bits := 8 * net.IPv4len
+	if p.ipv6 {
+		bits = 8 * net.IPv6len
+	}
+	hostBits := new(big.Int).Xor(p.first, p.last).BitLen()
+	mask := net.CIDRMask(bits-hostBits, bits)
+	ip := net.ParseIP(utility.BigIntToIP(p.first, p.ipv6)).Mask(mask)
+	return &net.IPNet{IP: ip, Mask: mask}
+}
+
+// Contains returns whether the address belongs to the pool and is not excluded
+func (p *Pool) Contains(ip string) bool {
+	key, err := p.key(ip)
+	return err == nil && !p.excluded[key]
+}
+
+// MarkAllocated records that the address is allocated to the owner, e.g. when the pool is
+// loaded from the existing connections. An address held for another owner is taken over.
+func (p *Pool) MarkAllocated(ip, owner string) error {
+	key, err := p.checkedKey(ip)
+	if err != nil {
+		return err
+	}
+	if o, ok := p.allocated[key]; ok && o != owner {
+		return fmt.Errorf("address %v is already allocated to %v", ip, o)
+	}
+	delete(p.held, key)
+	p.allocated[key] = owner
+	return nil
+}
+
+// Hold records that the address is held for the owner until the given time. Holding an
+// address releases its allocation.
+func (p *Pool) Hold(ip, owner string, until time.Time) error {
+	key, err := p.checkedKey(ip)
+	if err != nil {
+		return err
+	}
+	delete(p.allocated, key)
+	p.held[key] = hold{owner: owner, until: until}
+	return nil
+}
+
+// Release frees the address immediately, dropping its allocation or hold
+func (p *Pool) Release(ip string) {
+	if key, err := p.key(ip); err == nil {
+		delete(p.allocated, key)
+		delete(p.held, key)
+	}
+}
+
+func (p *Pool) checkedKey(ip string) (string, error) {
+	key, err := p.key(ip)
+	if err == errOutside {
+		return "", fmt.Errorf("address %v is not part of the VPN pool %v", ip, p)
+	}
+	if err != nil {
+		return "", err
+	}
+	if p.excluded[key] {
+		return "", fmt.Errorf("address %v is excluded from the VPN pool %v", ip, p)
+	}
+	return key, nil
+}
+
+// IsFree returns whether the owner can be given the address at the time now: it belongs to the
+// pool and it is neither allocated nor held for somebody else
+func (p *Pool) IsFree(ip, owner string, now time.Time) bool {
+	key, err := p.checkedKey(ip)
+	if err != nil {
+		return false
+	}
+	if o, ok := p.allocated[key]; ok {
+		return o == owner
+	}
+	if h, ok := p.held[key]; ok && now.Before(h.until) {
+		return h.owner == owner
+	}
+	return true
+}
+
+// AllocateAddress allocates the address to the owner if it is free for the owner at the time now
+func (p *Pool) AllocateAddress(ip, owner string, now time.Time) error {
+	if !p.IsFree(ip, owner, now) {
+		return fmt.Errorf("address %v is not available in the VPN pool %v", ip, p)
+	}
+	return p.MarkAllocated(ip, owner)
+}
+
+// Allocate allocates the smallest free address of the pool to the owner. An address still held
+// for the owner is preferred.
+func (p *Pool) Allocate(owner string, now time.Time) (string, error) {
+	var held []string
+	for ip, h := range p.held {
+		if h.owner == owner && now.Before(h.until) {
+			held = append(held, ip)
+		}
+	}
+	if len(held) > 0 {
+		sort.Slice(held, func(i, j int) bool {
+			return utility.IPToBigInt(held[i]).Cmp(utility.IPToBigInt(held[j])) < 0
+		})
+		return held[0], p.MarkAllocated(held[0], owner)
+	}
+	var taken []*big.Int
+	for ip := range p.excluded {
+		taken = append(taken, utility.IPToBigInt(ip))
+	}
+	for ip := range p.allocated {
+		taken = append(taken, utility.IPToBigInt(ip))
+	}
+	for ip, h := range p.held {
+		if now.Before(h.until) {
+			taken = append(taken, utility.IPToBigInt(ip))
+		}
+	}
+	sort.Slice(taken, func(i, j int) bool { return taken[i].Cmp(taken[j]) < 0 })
+	res := new(big.Int).Set(p.first)
+	for _, x := range taken {
+		if res.Cmp(x) < 0 {
+			break
+		}
+		if res.Cmp(x) == 0 {
+			res.Add(res, big.NewInt(1))
+		}
+	}
+	if res.Cmp(p.last) > 0 {
+		return "", ErrExhausted
+	}
+	ip := utility.BigIntToIP(res, p.ipv6)
+	return ip, p.MarkAllocated(ip, owner)
+}
+
+// Entry describes an address of the pool which is allocated or held
+type Entry struct {
+	IP        string
+	Owner     string
+	HeldUntil *time.Time `json:",omitempty"` // only set for held addresses
+}
+
+// Usage summarizes the state of a pool. The sizes are big integers as IPv6 pools can contain
+// more than 2^64 addresses; they are encoded as strings in JSON, as JavaScript numbers cannot
+// represent them exactly.
+type Usage struct {
+	Pool      string
+	Size      *big.Int // number of addresses of the subnet or range
+	Excluded  int
+	Allocated int
+	Held      int
+	Free      *big.Int
+	Entries   []Entry // allocated and held addresses in ascending order
+}
+
+// MarshalJSON encodes the sizes of the usage as decimal strings
+func (u Usage) MarshalJSON() ([]byte, error) {
+	type usage Usage
+	return json.Marshal(struct {
+		usage
+		Size string
+		Free string
+	}{usage(u), u.Size.String(), u.Free.String()})
+}
+
+// Usage returns the usage of the pool at the time now; expired holds count as free
+func (p *Pool) Usage(now time.Time) Usage {
+	u := Usage{
+		Pool:      p.String(),
+		Size:      new(big.Int).Add(new(big.Int).Sub(p.last, p.first), big.NewInt(1)),
+		Excluded:  len(p.excluded),
+		Allocated: len(p.allocated),
+		Entries:   []Entry{},
+	}
+	for ip, owner := range p.allocated {
+		u.Entries = append(u.Entries, Entry{IP: ip, Owner: owner})
+	}
+	for ip, h := range p.held {
+		if now.Before(h.until) {
+			until := h.until
+			u.Entries = append(u.Entries, Entry{IP: ip, Owner: h.owner, HeldUntil: &until})
+			u.Held++
+		}
+	}
+	sort.Slice(u.Entries, func(i, j int) bool {
+		return utility.IPToBigInt(u.Entries[i].IP).Cmp(utility.IPToBigInt(u.Entries[j].IP)) < 0
+	})
+	u.Free = new(big.Int).Sub(u.Size, big.NewInt(int64(u.Excluded+u.Allocated+u.Held)))
+	return u
+}
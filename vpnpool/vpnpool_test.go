@@ -0,0 +1,166 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpnpool
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func allocate(t *testing.T, p *Pool, owner, expected string) {
+	ip, err := p.Allocate(owner, now)
+	if err != nil {
+		t.Fatalf("allocating an address for %v failed: %v", owner, err)
+	}
+	if ip != expected {
+		t.Errorf("allocated %v for %v, expected %v", ip, owner, expected)
+	}
+}
+
+func TestAllocateIPv4(t *testing.T) {
+	p, err := New("10.0.8.0/29", "10.0.8.1", "10.0.8.3", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the network address, 10.0.8.1 and 10.0.8.3 are excluded
+	allocate(t, p, "a", "10.0.8.2")
+	allocate(t, p, "b", "10.0.8.4")
+	allocate(t, p, "c", "10.0.8.5")
+	allocate(t, p, "d", "10.0.8.6")
+	// the broadcast address is excluded
+	if _, err = p.Allocate("e", now); err != ErrExhausted {
+		t.Errorf("allocating from a full pool returned %v", err)
+	}
+	p.Release("10.0.8.4")
+	allocate(t, p, "e", "10.0.8.4")
+}
+
+func TestAllocateIPv6(t *testing.T) {
+	p, err := New("fd00:8::/64", "fd00:8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocate(t, p, "a", "fd00:8::2")
+	if err = p.MarkAllocated("FD00:8:0::3", "b"); err != nil {
+		t.Fatal(err)
+	}
+	allocate(t, p, "c", "fd00:8::4")
+	u := p.Usage(now)
+	if u.Size.String() != "18446744073709551616" || u.Free.String() != "18446744073709551611" {
+		t.Errorf("wrong size %v or number of free addresses %v", u.Size, u.Free)
+	}
+	if len(u.Entries) != 3 || u.Entries[1].IP != "fd00:8::3" || u.Entries[1].Owner != "b" {
+		t.Errorf("wrong entries %+v", u.Entries)
+	}
+	b, err := json.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"Size":"18446744073709551616"`) ||
+		!strings.Contains(string(b), `"Free":"18446744073709551611"`) ||
+		!strings.Contains(string(b), `"Allocated":3`) {
+		t.Errorf("wrong JSON encoding of the usage %s", b)
+	}
+	if p.Contains("10.0.8.2") || p.Contains("fd00:9::2") || p.Contains("fd00:8::1") {
+		t.Error("the pool contains addresses outside of the subnet or excluded ones")
+	}
+}
+
+func TestHold(t *testing.T) {
+	p, err := NewRange("10.0.8.2", "10.0.8.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocate(t, p, "a", "10.0.8.2")
+	allocate(t, p, "b", "10.0.8.3")
+	if err = p.Hold("10.0.8.2", "a", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if p.IsFree("10.0.8.2", "b", now) || !p.IsFree("10.0.8.2", "a", now) {
+		t.Error("a held address must only be free for its owner")
+	}
+	if !p.IsFree("10.0.8.2", "b", now.Add(2*time.Hour)) {
+		t.Error("an address is free once its hold expired")
+	}
+	// other owners do not get the held address
+	allocate(t, p, "c", "10.0.8.4")
+	if err = p.AllocateAddress("10.0.8.2", "c", now); err == nil {
+		t.Error("allocating an address held for another owner should fail")
+	}
+	u := p.Usage(now)
+	if u.Allocated != 2 || u.Held != 1 || u.Free.Sign() != 0 || u.Entries[0].HeldUntil == nil {
+		t.Errorf("wrong usage %+v", u)
+	}
+	// the owner gets the held address back
+	allocate(t, p, "a", "10.0.8.2")
+	if u = p.Usage(now); u.Allocated != 3 || u.Held != 0 {
+		t.Errorf("wrong usage %+v", u)
+	}
+}
+
+func TestInvalidPools(t *testing.T) {
+	if _, err := New("10.0.8.0"); err == nil {
+		t.Error("a subnet without prefix length should be rejected")
+	}
+	if _, err := NewRange("10.0.8.10", "10.0.8.2"); err == nil {
+		t.Error("a range ending before its start should be rejected")
+	}
+	if _, err := NewRange("10.0.8.2", "fd00::2"); err == nil {
+		t.Error("a range mixing address families should be rejected")
+	}
+	if _, err := New("10.0.8.0/24", "not an address"); err == nil {
+		t.Error("invalid excluded addresses should be rejected")
+	}
+	p, err := New("10.0.8.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.MarkAllocated("10.0.9.2", "a"); err == nil {
+		t.Error("allocating an address outside of the subnet should fail")
+	}
+	if err = p.MarkAllocated("10.0.8.255", "a"); err == nil {
+		t.Error("allocating the broadcast address should fail")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	for _, c := range []struct {
+		start, end, expected string
+	}{
+		{"10.0.8.2", "10.0.8.254", "10.0.8.0/24"},
+		{"10.0.8.2", "10.0.9.1", "10.0.8.0/23"},
+		{"10.0.8.5", "10.0.8.5", "10.0.8.5/32"},
+		{"fd00:8::2", "fd00:8::ff", "fd00:8::/120"},
+	} {
+		p, err := NewRange(c.start, c.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prefix := p.Prefix().String(); prefix != c.expected {
+			t.Errorf("prefix of %v is %v, expected %v", p, prefix, c.expected)
+		}
+	}
+	p, err := New("fd00:8::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix := p.Prefix().String(); prefix != "fd00:8::/64" {
+		t.Errorf("prefix of %v is %v", p, prefix)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wireguard generates the Curve25519 key pairs used by WireGuard peers.
+//
+// The keys are derived from an Ed25519 key pair: the Curve25519 private key is the clamped
+// scalar of the Ed25519 key and the public key is the Montgomery form of the Ed25519 public
+// point. This way the scalar multiplication is done by the vendored ed25519 package.
+package wireguard
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// KeySize is the size of WireGuard private and public keys in bytes
+const KeySize = 32
+
+// Key is a WireGuard private or public key
+type Key [KeySize]byte
+
+// String returns the key in base64, as used in WireGuard configuration files
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// ParseKey parses a base64 encoded key
+func ParseKey(s string) (Key, error) {
+	var k Key
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return k, fmt.Errorf("invalid WireGuard key: %v", err)
+	}
+	if len(raw) != KeySize {
+		return k, fmt.Errorf("invalid WireGuard key: %v bytes instead of %v", len(raw), KeySize)
+	}
+	copy(k[:], raw)
+	return k, nil
+}
+
+// GenerateKeyPair returns a new private key and the corresponding public key
+func GenerateKeyPair() (Key, Key, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := io.ReadFull(rand.Reader, seed); err != nil {
+		return Key{}, Key{}, err
+	}
+	private, public := keyPairFromSeed(seed)
+	return private, public, nil
+}
+
+// curve25519P is the prime 2^255 - 19
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+func keyPairFromSeed(seed []byte) (Key, Key) {
+	var private, public Key
+	h := sha512.Sum512(seed)
+	copy(private[:], h[:KeySize])
+	private[0] &= 248
+	private[31] &= 127
+	private[31] |= 64
+
+	edPublic := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	// the public key encodes y in little endian, the highest bit being the sign of x
+	y := new(big.Int).SetBytes(reverse(edPublic))
+	y.SetBit(y, 255, 0)
+	// u = (1 + y) / (1 - y)
+	num := new(big.Int).Add(big.NewInt(1), y)
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, curve25519P)
+	u := num.Mul(num, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+	b := u.Bytes()
+	copy(public[KeySize-len(b):], b)
+	copy(public[:], reverse(public[:]))
+	return private, public
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"testing"
+)
+
+func TestKeyPairFromSeed(t *testing.T) {
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	// computed with an independent implementation of the X25519 function of RFC 7748
+	expectedPrivate := "OJTupJxYCu+BaTV2K+BJVZ1tFEDe3hLmoSXxhB//jm8="
+	expectedPublic := "RwHQhIhFH1RaQJ+1iuPlhYHKQKw/fxFGmM1x3qxzygE="
+	private, public := keyPairFromSeed(seed)
+	if private.String() != expectedPrivate {
+		t.Errorf("wrong private key %v, expected %v", private, expectedPrivate)
+	}
+	if public.String() != expectedPublic {
+		t.Errorf("wrong public key %v, expected %v", public, expectedPublic)
+	}
+}
+
+func TestGenerateKeyPair(t *testing.T) {
+	private, public, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPrivate, otherPublic, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if private == otherPrivate || public == otherPublic {
+		t.Error("two generated key pairs are equal")
+	}
+	if private[0]&7 != 0 || private[31]&128 != 0 || private[31]&64 == 0 {
+		t.Errorf("the private key %v is not clamped", private)
+	}
+	parsed, err := ParseKey(public.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != public {
+		t.Errorf("parsing %v returned %v", public, parsed)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for _, s := range []string{"", "not base64", "AAAA", "RwHQhIhFH1RaQJ+1iuPlhYHKQKw/fxFGmM1x3qxzygEA"} {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("parsing %q should fail", s)
+		}
+	}
+}